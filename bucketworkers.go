@@ -0,0 +1,282 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/chadnetzer/hardlinkable/internal/inode"
+)
+
+// bucketWork is a walked file, to be compared against the other files in its
+// inode hash bucket.
+type bucketWork struct {
+	di       inode.DevStatInfo
+	pathname string
+}
+
+// bucketWorkers compares files concurrently, with each worker owning a
+// disjoint subset of the inode hash buckets.  Since an inode (and all its
+// pathnames) always hashes to the same bucket, each worker can keep its own
+// fsDev state, which is merged once all the files have been processed.  The
+// files of a bucket are processed in walk order, so the results are the same
+// as a serial run.
+type bucketWorkers struct {
+	opts   *Options
+	work   []chan bucketWork
+	shards []*linkableState
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+	errOnce   sync.Once
+	err       error
+	failed    chan struct{} // Closed when a worker fails with an error
+}
+
+func newBucketWorkers(opts *Options, n int) *bucketWorkers {
+	// The workers share a copy of the Options, with the callback
+	// serialized, since it may be called from any worker.  Each worker
+	// gets a share of the ExpectedFileCount, to presize its maps.
+	shardOpts := *opts
+	shardOpts.ExpectedFileCount /= int64(n)
+	if opts.OnExistingLink != nil {
+		var mu sync.Mutex
+		onExistingLink := opts.OnExistingLink
+		shardOpts.OnExistingLink = func(src, dst string, size uint64) {
+			mu.Lock()
+			defer mu.Unlock()
+			onExistingLink(src, dst, size)
+		}
+	}
+
+	b := &bucketWorkers{
+		opts:   &shardOpts,
+		work:   make([]chan bucketWork, n),
+		shards: make([]*linkableState, n),
+		failed: make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		b.work[i] = make(chan bucketWork, 64)
+		b.shards[i] = newLinkableState(b.opts)
+		b.shards[i].Progress = &disabledProgress{}
+		b.wg.Add(1)
+		go b.worker(b.work[i], b.shards[i])
+	}
+	return b
+}
+
+func (b *bucketWorkers) worker(c <-chan bucketWork, shard *linkableState) {
+	defer b.wg.Done()
+	for w := range c {
+		if b.hasFailed() {
+			continue // Drain remaining work
+		}
+		fsdev := shard.dev(w.di, w.pathname)
+		if err := fsdev.FindIdenticalFiles(w.di, w.pathname); err != nil {
+			if b.opts.IgnoreWalkErrors {
+				shard.Results.SkippedFileErrCount++
+				if b.opts.DebugLevel > 0 {
+					log.Printf("\r%v  Skipping...", err)
+				}
+			} else {
+				b.errOnce.Do(func() {
+					b.err = err
+					close(b.failed)
+				})
+			}
+		}
+	}
+}
+
+func (b *bucketWorkers) hasFailed() bool {
+	select {
+	case <-b.failed:
+		return true
+	default:
+		return false
+	}
+}
+
+// add sends the file to the worker owning its inode hash bucket
+func (b *bucketWorkers) add(di inode.DevStatInfo, pathname string) {
+	o := b.opts
+	H := inode.HashIno(di.StatInfo, o.ignoresSize(), o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner)
+	b.work[uint64(H)%uint64(len(b.work))] <- bucketWork{di: di, pathname: pathname}
+}
+
+// wait stops the workers once they've finished the remaining work, and
+// returns the first error encountered by a worker.  It is safe to call wait
+// more than once.
+func (b *bucketWorkers) wait() error {
+	b.closeOnce.Do(func() {
+		for _, c := range b.work {
+			close(c)
+		}
+	})
+	b.wg.Wait()
+	return b.err
+}
+
+// mergeInto combines the fsDev state and Results of the workers into ls
+func (b *bucketWorkers) mergeInto(ls *linkableState) {
+	for _, shard := range b.shards {
+		for dev, s := range shard.fsDevs {
+			f, ok := ls.fsDevs[dev]
+			if !ok {
+				f = ls.newDev(dev, s.MaxNLinks)
+			}
+			for H, set := range s.inoHashes {
+				f.inoHashes[H] = set
+			}
+			for ino, si := range s.inoStatInfo {
+				f.inoStatInfo[ino] = si
+			}
+			for ino, fp := range s.InoPaths {
+				f.InoPaths[ino] = fp
+			}
+			for ino, set := range s.LinkableInos {
+				f.LinkableInos[ino] = set
+			}
+			for d, set := range s.InoDigests.InoSets {
+				for ino := range set {
+					if _, ok := f.InoDigests.InoSets[d]; !ok {
+						f.InoDigests.InoSets[d] = inode.NewSet()
+					}
+					f.InoDigests.InoSets[d].Add(ino)
+				}
+			}
+			for ino := range s.InoDigests.InosWithDigest {
+				f.InoDigests.InosWithDigest.Add(ino)
+			}
+			for ino, denied := range s.deniedInos {
+				f.deniedInos[ino] = denied
+			}
+			for size := range s.seenSizes {
+				f.seenSizes[size] = true
+			}
+		}
+		shard.Results.addPoolStats(shard.pool)
+		ls.Results.mergeShard(shard.Results)
+	}
+
+	// A held back file is only of a unique size if no other worker saw a
+	// file of its size.  (The others needn't be searched, since files
+	// with unequal inode hashes can't be linked.)
+	for _, shard := range b.shards {
+		for dev, s := range shard.fsDevs {
+			f := ls.fsDevs[dev]
+			for size, u := range s.uniqueSizes {
+				if held, ok := f.uniqueSizes[size]; ok {
+					delete(f.uniqueSizes, size)
+					f.seenSizes[size] = true
+					f.addUnsearchedFile(held)
+				}
+				if f.seenSizes[size] {
+					f.addUnsearchedFile(u)
+				} else {
+					f.uniqueSizes[size] = u
+				}
+			}
+		}
+	}
+}
+
+// addRunStats adds the (int64 and uint64) counts of the given RunStats.  Only
+// the nonzero counts are touched, so the counts of a walk worker can be added
+// while the receiver of the walked pathnames updates the others.
+func (r *Results) addRunStats(s *RunStats) {
+	dst := reflect.ValueOf(&r.RunStats).Elem()
+	src := reflect.ValueOf(s).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		switch f := dst.Field(i); f.Kind() {
+		case reflect.Int64:
+			if n := src.Field(i).Int(); n != 0 {
+				f.SetInt(f.Int() + n)
+			}
+		case reflect.Uint64:
+			if n := src.Field(i).Uint(); n != 0 {
+				f.SetUint(f.Uint() + n)
+			}
+		}
+	}
+}
+
+// mergeShard adds the counts and stored links from the Results of a bucket
+// worker.  The walk related counts are not gathered by the workers.
+func (r *Results) mergeShard(s *Results) {
+	maxCmpBytes := r.MaxComparisonBytes
+	r.addRunStats(&s.RunStats)
+	// The max isn't summed
+	r.MaxComparisonBytes = maxCmpBytes
+	r.foundComparisonBytes(s.MaxComparisonBytes)
+	r.ioTime += s.ioTime
+	for src, dsts := range s.ExistingLinks {
+		r.ExistingLinks[src] = dsts
+		r.ExistingLinkSizes[src] = s.ExistingLinkSizes[src]
+	}
+	for src, sum := range s.ExistingLinkSummaries {
+		if r.ExistingLinkSummaries == nil {
+			r.ExistingLinkSummaries = make(map[string]ExistingLinkSummary)
+		}
+		r.ExistingLinkSummaries[src] = sum
+	}
+	r.ExistingClusterAnomalies = append(r.ExistingClusterAnomalies, s.ExistingClusterAnomalies...)
+	for n, count := range s.groupComparisons {
+		if r.groupComparisons == nil {
+			r.groupComparisons = make(map[int64]int64)
+		}
+		r.groupComparisons[n] += count
+	}
+	r.foundInoBucket(s.largestInoBucket)
+	if s.largestNlinkRatio > r.largestNlinkRatio {
+		r.largestNlinkRatio = s.largestNlinkRatio
+	}
+	r.FragmentationRiskPaths = append(r.FragmentationRiskPaths, s.FragmentationRiskPaths...)
+	for dev, sd := range s.DeviceResults {
+		d := r.deviceResults(dev)
+		d.ExistingLinkCount += sd.ExistingLinkCount
+		d.ExistingLinkByteAmount += sd.ExistingLinkByteAmount
+		d.NewLinkCount += sd.NewLinkCount
+		d.InodeRemovedCount += sd.InodeRemovedCount
+		d.InodeRemovedByteAmount += sd.InodeRemovedByteAmount
+		for src, dsts := range sd.ExistingLinks {
+			d.ExistingLinks[src] = append(d.ExistingLinks[src], dsts...)
+		}
+		d.LinkPaths = append(d.LinkPaths, sd.LinkPaths...)
+	}
+	for di, u := range s.unlinkedInodes {
+		if r.unlinkedInodes == nil {
+			r.unlinkedInodes = make(map[devIno]UnlinkedInode)
+		}
+		if _, ok := r.unlinkedInodes[di]; !ok {
+			r.unlinkedInodes[di] = u
+		}
+	}
+	for _, g := range s.LinkGroups {
+		if r.linkGroupIndex == nil {
+			r.linkGroupIndex = make(map[devIno]int)
+		}
+		r.linkGroupIndex[devIno{g.Dev, g.SrcIno}] = len(r.LinkGroups)
+		r.LinkGroups = append(r.LinkGroups, g)
+	}
+}
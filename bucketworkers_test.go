@@ -0,0 +1,115 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunBucketWorkers(t *testing.T) {
+	topdir := setUp("BucketWorkers", t)
+	defer os.RemoveAll(topdir)
+
+	// Several buckets (by size), each with a few content groups
+	m := pathContents{}
+	for i := 0; i < 60; i++ {
+		size := i%6 + 1
+		content := strings.Repeat(string("ABC"[i%3]), size)
+		m[fmt.Sprintf("d%v/f%v", i%4, i)] = content
+	}
+	simpleFileMaker(t, m)
+	if err := os.Link("d0/f0", "d1/f0.link"); err != nil {
+		t.Fatalf("Couldn't create hardlink: %v", err)
+	}
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.StoreExistingLinkResults = true
+	serial, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Serial Run() returned error: %v", err)
+	}
+
+	opts.BucketWorkers = 4
+	existingLinkCalls := 0
+	opts.OnExistingLink = func(src, dst string, size uint64) { existingLinkCalls++ }
+	parallel, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Parallel Run() returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(sortedLinkGroups(&serial), sortedLinkGroups(&parallel)) {
+		t.Errorf("LinkPaths differ: %v vs %v", serial.LinkPaths, parallel.LinkPaths)
+	}
+	if !reflect.DeepEqual(serial.ExistingLinks, parallel.ExistingLinks) {
+		t.Errorf("ExistingLinks differ: %v vs %v", serial.ExistingLinks, parallel.ExistingLinks)
+	}
+	verifySameRunStats("Bucket Workers", t, &serial, &parallel)
+	s, p := serial.RunStats, parallel.RunStats
+	if s.FoundHashCount != p.FoundHashCount || s.MissedHashCount != p.MissedHashCount {
+		t.Errorf("Hash counts differ: %v/%v vs %v/%v",
+			s.FoundHashCount, s.MissedHashCount, p.FoundHashCount, p.MissedHashCount)
+	}
+	if int64(existingLinkCalls) != p.ExistingLinkCount {
+		t.Errorf("Expected %v OnExistingLink calls, got: %v", p.ExistingLinkCount, existingLinkCalls)
+	}
+}
+
+func TestRunBucketWorkersDenyDigests(t *testing.T) {
+	topdir := setUp("BucketWorkers", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 12; i++ {
+		m[fmt.Sprintf("d%v/f%v", i%3, i)] = "secret"
+		m[fmt.Sprintf("d%v/g%v", i%3, i)] = "public"
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Bucket Workers Deny Digests'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.BucketWorkers = 4
+	opts.StoreUnlinkedReasons = true
+	opts.DenyDigests = []string{fmt.Sprintf("%x", sha256.Sum256([]byte("secret")))}
+	result := simpleRun(name, t, opts, 1, ".")
+	if result.DeniedDigestSkipCount != 12 {
+		t.Errorf("%v: Expected 12 denied digest inodes, got: %v", name, result.DeniedDigestSkipCount)
+	}
+	unlinked := result.UnlinkedInodes()
+	if len(unlinked) != 12 {
+		t.Errorf("%v: Expected 12 unlinked inodes, got: %+v", name, unlinked)
+	}
+	for _, u := range unlinked {
+		if u.Reason != UnlinkedDeniedDigest || !strings.Contains(u.Path, "/f") {
+			t.Errorf("%v: Expected denied digest reason for '%v', got: %v", name, u.Path, u.Reason)
+		}
+	}
+	for i := 0; i < 12; i++ {
+		if nlinkVal(fmt.Sprintf("d%v/f%v", i%3, i)) != 1 {
+			t.Errorf("%v: Expected denied 'f%v' to not be linked", name, i)
+		}
+	}
+	verifyContents(name, t, m)
+}
@@ -0,0 +1,124 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+// CandidateInfo holds the pathname and stat information of a file found by
+// WalkCandidates.  If Err is non-nil, the walk stopped early due to the error,
+// and the other fields are unset.
+type CandidateInfo struct {
+	Path  string
+	Size  uint64
+	Mode  os.FileMode
+	Mtime time.Time
+	Dev   uint64
+	Ino   uint64
+	Err   error
+}
+
+// WalkCandidates walks the given directories and files, and sends the files
+// that could be considered for linking (respecting the include/exclude, size,
+// and age Options) over the returned channel.  This allows callers to perform
+// their own selection of files, and pass the chosen pathnames to Run().  The
+// returned cancel func stops the walk, after which the channel will be closed.
+// It is safe to call cancel more than once.
+func WalkCandidates(dirsAndFiles []string, opts Options) (<-chan CandidateInfo, func()) {
+	out := make(chan CandidateInfo)
+	done := make(chan struct{})
+	var cancelOnce sync.Once
+	cancel := func() {
+		cancelOnce.Do(func() { close(done) })
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel() // Also stops the matchedPathnames goroutine
+
+		// send returns false if the walk was cancelled
+		send := func(ci CandidateInfo) bool {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+			select {
+			case out <- ci:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		dirs, files, err := ValidateDirsAndFiles(dirsAndFiles)
+		if err != nil {
+			send(CandidateInfo{Err: err})
+			return
+		}
+
+		// The Results are only used for the walk bookkeeping
+		r := newResults(&opts)
+		r.start()
+		var pool *P.StringPool
+		if !opts.DisablePathPool {
+			pool = P.NewPoolSize(opts.PathPoolHint)
+		}
+		c := matchedPathnames(opts, r, pool, done, dirs, files)
+		for pe := range c {
+			if pe.err != nil {
+				send(CandidateInfo{Err: pe.err})
+				return
+			}
+			di, err := I.LStatInfo(pe.pathname)
+			if err != nil {
+				if opts.IgnoreWalkErrors {
+					continue
+				}
+				send(CandidateInfo{Err: err})
+				return
+			}
+			bypass := pe.explicit && opts.ExplicitFilesBypassFilters
+			if !isLinkCandidate(di, pe.pathname, &opts, r, bypass) {
+				continue
+			}
+			ci := CandidateInfo{
+				Path:  pe.pathname,
+				Size:  di.Size,
+				Mode:  di.Mode,
+				Mtime: di.Mtim,
+				Dev:   di.Dev,
+				Ino:   uint64(di.Ino),
+			}
+			if !send(ci) {
+				return
+			}
+		}
+	}()
+
+	return out, cancel
+}
@@ -0,0 +1,118 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWalkCandidates(t *testing.T) {
+	topdir := setUp("Candidates", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 50; i++ {
+		m[fmt.Sprintf("d%v/f%v", i%5, i)] = strings.Repeat("X", i%3)
+	}
+	simpleFileMaker(t, m)
+
+	// Filter candidates with a predicate, and pass them to Run()
+	c, cancel := WalkCandidates([]string{"."}, SetupOptions())
+	var chosen []string
+	n := 0
+	for ci := range c {
+		if ci.Err != nil {
+			t.Fatalf("WalkCandidates returned error: %v", ci.Err)
+		}
+		n++
+		if ci.Size == 2 {
+			chosen = append(chosen, ci.Path)
+		}
+	}
+	cancel()
+	if n != 33 { // Zero length files are below the default MinFileSize
+		t.Errorf("Expected 33 candidates, got: %v", n)
+	}
+	if len(chosen) != 16 {
+		t.Errorf("Expected 16 chosen candidates, got: %v", len(chosen))
+	}
+	result, err := Run(chosen, SetupOptions(LinkingDisabled))
+	if err != nil || result.FileCount != 16 || result.NewLinkCount != 15 {
+		t.Errorf("Unexpected Run() results for chosen candidates: %v %v %v",
+			err, result.FileCount, result.NewLinkCount)
+	}
+
+	// Cancelling stops the walk early
+	c, cancel = WalkCandidates([]string{"."}, SetupOptions())
+	<-c
+	cancel()
+	cancel() // Safe to call repeatedly
+	n = 1
+	for range c {
+		n++
+	}
+	if n >= 33 {
+		t.Errorf("Cancelled walk returned all the candidates")
+	}
+}
+
+func TestWalkCandidatesCancelMidWalk(t *testing.T) {
+	topdir := setUp("CandidatesCancel", t)
+	defer os.RemoveAll(topdir)
+
+	const numDirs = 40
+	m := pathContents{}
+	for i := 0; i < numDirs; i++ {
+		m[fmt.Sprintf("d%v/f%v", i, i)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	// Count the walked dirs, to see if the walk continues after cancelling
+	var walkedDirs int64
+	opts := SetupOptions()
+	opts.IgnoreWalkErrors = true
+	opts.DirFilter = func(pathname string, info os.FileInfo) bool {
+		atomic.AddInt64(&walkedDirs, 1)
+		return true
+	}
+	c, cancel := WalkCandidates([]string{"."}, opts)
+	if ci := <-c; ci.Path == "" {
+		t.Fatalf("Expected a candidate before cancelling, got: %+v", ci)
+	}
+	cancel()
+	cancelledDirs := atomic.LoadInt64(&walkedDirs)
+	for ci := range c {
+		if ci.Err != nil {
+			t.Errorf("Cancelled walk returned error: %v", ci.Err)
+		}
+	}
+	// Give a walk that wasn't stopped the time to continue
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&walkedDirs); n > cancelledDirs+1 {
+		t.Errorf("Expected the walk to stop after cancelling at %v dirs, walked: %v",
+			cancelledDirs, n)
+	}
+}
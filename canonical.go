@@ -0,0 +1,214 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+// contentSHA256 returns the hex encoded SHA-256 digest of the file contents
+func contentSHA256(pathname string) (string, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeCanonical links the (linked) group of inodes into the
+// CanonicalStoreDir, named by the digest of their content, so that all the
+// pathnames of the group are links of the store file.  If the store file
+// already exists (ie. from a previous run) as a different inode, the
+// pathnames of the group are instead relinked to it (paced, audited and
+// checked as the links of generateLinks are).  Groups that are on a
+// different device than the store dir, or that can't be linked to the
+// existing store file, or whose inodes are all at MaxNLinks, are skipped and
+// counted.
+func (f *fsDev) storeCanonical(inoSet I.Set) error {
+	dir := f.Options.CanonicalStoreDir
+	dirInfo, err := I.LStatInfo(dir)
+	if err != nil {
+		return err
+	}
+	if dirInfo.Dev != f.Dev {
+		f.Results.skippedCanonical()
+		return nil
+	}
+
+	// The inode with the most links (ie. the src of the linking) holds
+	// the group content.  Inodes fully linked to it are no longer stored.
+	var srcIno I.Ino
+	var srcSI *I.StatInfo
+	for ino := range inoSet {
+		si, ok := f.inoStatInfo[ino]
+		if ok && (srcSI == nil || si.Nlink > srcSI.Nlink) {
+			srcIno, srcSI = ino, si
+		}
+	}
+	if srcSI == nil {
+		return nil
+	}
+	srcPath := f.InoPaths.ArbitraryPath(srcIno)
+	digest, err := contentSHA256(srcPath.Join())
+	if err != nil {
+		return err
+	}
+	storePathname := filepath.Join(dir, digest)
+
+	storeInfo, err := I.LStatInfo(storePathname)
+	if os.IsNotExist(err) {
+		// The store file is another link of the stored inode, so store
+		// the most linked inode of the group that is below MaxNLinks.
+		var storeIno I.Ino
+		var storeSI *I.StatInfo
+		for ino := range inoSet {
+			si, ok := f.inoStatInfo[ino]
+			if ok && uint64(si.Nlink) < f.MaxNLinks && (storeSI == nil || si.Nlink > storeSI.Nlink) {
+				storeIno, storeSI = ino, si
+			}
+		}
+		if storeSI == nil {
+			f.Results.skippedCanonical()
+			return nil
+		}
+		storedPath := f.InoPaths.ArbitraryPath(storeIno)
+		srcPI := I.PathInfo{Pathsplit: storedPath, StatInfo: *storeSI}
+		storePI := I.PathInfo{Pathsplit: P.Split(storePathname, nil), StatInfo: *storeSI}
+		f.pacer.wait()
+		linkErr := os.Link(storedPath.Join(), storePathname)
+		if err := f.auditLink(srcPI, storePI, linkErr); err != nil {
+			return err
+		}
+		if linkErr != nil {
+			return linkErr
+		}
+		f.Results.storedCanonical()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if storeInfo.Ino == srcIno {
+		return nil
+	}
+
+	// Relink the group pathnames to the existing store file, if allowed
+	if len(f.Options.SrcRoots) > 0 && !isInRoots(storePathname, f.Options.SrcRoots) {
+		f.Results.skippedCanonical()
+		return nil
+	}
+	var paths []inoPath
+	for ino := range inoSet {
+		if _, ok := f.inoStatInfo[ino]; ok {
+			for _, p := range f.InoPaths[ino].PathsAsSlice() {
+				paths = append(paths, inoPath{ino, p})
+			}
+		}
+	}
+	if uint64(storeInfo.Nlink)+uint64(len(paths)) > f.MaxNLinks {
+		f.Results.skippedCanonical()
+		return nil
+	}
+	storePI := I.PathInfo{Pathsplit: P.Split(storePathname, nil), StatInfo: storeInfo.StatInfo}
+	srcPI := I.PathInfo{Pathsplit: srcPath, StatInfo: *srcSI}
+	linkable, err := f.areFilesLinkable(storePI, srcPI, false)
+	if err != nil {
+		return err
+	}
+	if !linkable {
+		if f.Options.DebugLevel > 0 {
+			log.Printf("\rCanonical store file %v can't be linked to %v  Skipping...",
+				storePathname, srcPath.Join())
+		}
+		f.Results.skippedCanonical()
+		return nil
+	}
+	for _, ip := range paths {
+		if err := f.relinkToCanonical(storePI, ip.path, ip.ino); err != nil {
+			if !f.Options.IgnoreLinkErrors {
+				return err
+			} else if f.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping...", err)
+			}
+			f.Results.skippedCanonical()
+		}
+	}
+	return nil
+}
+
+// inoPath is a pathname of an inode
+type inoPath struct {
+	ino  I.Ino
+	path P.Pathsplit
+}
+
+// relinkToCanonical links the pathname of the given group inode to the
+// canonical store file, with the same checks as the links of generateLinks.
+// The pathname is statted again, and left as is if it no longer refers to
+// the inode.  The inode is counted as removed when its last name is
+// relinked.
+func (f *fsDev) relinkToCanonical(storePI I.PathInfo, p P.Pathsplit, ino I.Ino) error {
+	pathname := p.Join()
+	if len(f.Options.DstRoots) > 0 && !isInRoots(pathname, f.Options.DstRoots) {
+		return nil
+	}
+	dsi, err := I.LStatInfo(pathname)
+	if err != nil {
+		return err
+	}
+	if dsi.Dev != f.Dev || dsi.Ino != ino {
+		f.Results.skippedCanonical()
+		return nil
+	}
+	dstPI := I.PathInfo{Pathsplit: p, StatInfo: dsi.StatInfo}
+	if f.skipsUnlinkablePair(storePI, dstPI) {
+		return nil
+	}
+
+	f.pacer.wait()
+	linkErr := f.replaceWithLink(storePI.Join(), dstPI)
+	if err := f.auditLink(storePI, dstPI, linkErr); err != nil {
+		return err
+	}
+	if linkErr != nil {
+		return fmt.Errorf("Couldn't link %v to canonical store file: %v", pathname, linkErr)
+	}
+	f.Results.linkedCanonical()
+	if dsi.Nlink == 1 {
+		f.Results.foundRemovedInode(dsi.Size, p.Dirname, f.Dev, uint64(ino))
+	}
+	return nil
+}
@@ -24,11 +24,24 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"time"
 
 	I "github.com/chadnetzer/hardlinkable/internal/inode"
 )
 
 func areFileContentsEqual(s status, pathname1, pathname2 string) (bool, error) {
+	start := s.Results.BytesCompared
+	defer func() {
+		s.Results.foundComparisonBytes(s.Results.BytesCompared - start)
+	}()
+	if s.Options.ProfileTiming {
+		defer s.Results.addIOTime(time.Now())
+	}
+
+	if s.memContents != nil {
+		return memContentsEqual(s, s.memContents[pathname1], s.memContents[pathname2]), nil
+	}
+
 	f1, openErr := os.Open(pathname1)
 	if openErr != nil {
 		return false, openErr
@@ -41,10 +54,156 @@ func areFileContentsEqual(s status, pathname1, pathname2 string) (bool, error) {
 	}
 	defer f2.Close()
 
+	if s.Options.UseFadvise {
+		for _, f := range []*os.File{f1, f2} {
+			I.FadviseSequential(f)
+			defer I.FadviseDontNeed(f)
+		}
+	}
+
+	if s.Options.UseMmap {
+		eq, ok, err := mmapContentsEqual(s, f1, f2)
+		if ok {
+			return eq, err
+		}
+	}
+
+	if s.Options.IgnoreBOM {
+		eq, err := skipBOMs(f1, f2)
+		if err != nil || !eq {
+			return eq, err
+		}
+	}
+
+	// The tail of files can only be compared for equal size files
+	if s.Options.CompareTailFirst && !s.Options.ignoresSize() {
+		eq, err := tailContentsEqual(s, f1, f2)
+		if err != nil || !eq {
+			return eq, err
+		}
+	}
+
+	// The prefix comparison can leave the file offsets misaligned for
+	// files of unequal lengths, so skip it when trailing content is ignored.
+	if s.Options.QuickPrefixCompare && !s.Options.ignoresSize() {
+		eq, err := prefixContentsEqual(s, f1, f2)
+		if err != nil || !eq {
+			return eq, err
+		}
+	}
+
 	eq, err := fileContentsEqual(s, f1, f2)
 	return eq, err
 }
 
+// bomLen returns the length of the UTF-8 or UTF-16 byte-order mark at the start
+// of b, or zero if there is none.
+func bomLen(b []byte) int {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return 3
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}), bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return 2
+	}
+	return 0
+}
+
+// isBOMSizeDiff returns true if a file of size2 could be a file of size1
+// preceded by a byte-order mark.
+func isBOMSizeDiff(size1, size2 uint64) bool {
+	return size2 == size1+2 || size2 == size1+3
+}
+
+// skipBOMs leaves the file offsets of f1 and f2 just past their leading
+// byte-order marks (if any), for the IgnoreBOM option.  Returns false if both
+// files have differing byte-order marks, which can't be ignored.
+func skipBOMs(f1, f2 *os.File) (bool, error) {
+	var b1, b2 [3]byte
+	n1, err1 := I.ReadChunk(f1, b1[:])
+	if err1 != nil && err1 != io.EOF {
+		return false, err1
+	}
+	n2, err2 := I.ReadChunk(f2, b2[:])
+	if err2 != nil && err2 != io.EOF {
+		return false, err2
+	}
+	l1, l2 := bomLen(b1[:n1]), bomLen(b2[:n2])
+	if l1 > 0 && l2 > 0 && !bytes.Equal(b1[:l1], b2[:l2]) {
+		return false, nil
+	}
+	if _, err := f1.Seek(int64(l1), io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err := f2.Seek(int64(l2), io.SeekStart); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// prefixContentsEqual compares only the first quickPrefixSize bytes of files
+// larger than the minimum comparison buffer, leaving the file offsets just
+// past the prefix so that the full comparison can continue from there.
+// Returns true if the prefixes are equal, or if the files are too small for
+// the prefix comparison to be worthwhile.
+func prefixContentsEqual(s status, f1, f2 *os.File) (bool, error) {
+	fi, err := f1.Stat()
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() <= minCmpBufSize {
+		return true, nil
+	}
+
+	b1 := s.cmpBuf1[:quickPrefixSize]
+	b2 := s.cmpBuf2[:quickPrefixSize]
+	n1, err1 := I.ReadChunk(f1, b1)
+	n2, err2 := I.ReadChunk(f2, b2)
+	if err1 != nil && err1 != io.EOF {
+		return false, err1
+	}
+	if err2 != nil && err2 != io.EOF {
+		return false, err2
+	}
+	s.Results.addBytesCompared(uint64(n1 + n2))
+	if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
+		s.Results.quickPrefixRejected()
+		return false, nil
+	}
+	return true, nil
+}
+
+// tailContentsEqual compares only the last quickTailSize bytes of files larger
+// than the minimum comparison buffer, without changing the file offsets.
+// Returns true if the tails are equal, or if the files are too small for the
+// tail comparison to be worthwhile.
+func tailContentsEqual(s status, f1, f2 *os.File) (bool, error) {
+	fi, err := f1.Stat()
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() <= minCmpBufSize {
+		return true, nil
+	}
+
+	offset := fi.Size() - quickTailSize
+	b1 := s.cmpBuf1[:quickTailSize]
+	b2 := s.cmpBuf2[:quickTailSize]
+	n1, err1 := f1.ReadAt(b1, offset)
+	n2, err2 := f2.ReadAt(b2, offset)
+	if err1 != nil && err1 != io.EOF {
+		return false, err1
+	}
+	if err2 != nil && err2 != io.EOF {
+		return false, err2
+	}
+	s.Results.addBytesCompared(uint64(n1 + n2))
+	if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
+		s.Results.quickTailRejected()
+		return false, nil
+	}
+	return true, nil
+}
+
 // Return true if f1 and f2 have identical contents. Otherwise return false.
 func fileContentsEqual(s status, f1, f2 *os.File) (bool, error) {
 	var atEnd bool
@@ -55,6 +214,12 @@ func fileContentsEqual(s status, f1, f2 *os.File) (bool, error) {
 		n2, err2 := I.ReadChunk(f2, s.cmpBuf2)
 
 		if n1 != n2 {
+			if s.Options.IgnoreTrailingZeros {
+				return trailingZerosEqual(s, f1, f2, n1, n2, err1, err2)
+			}
+			if s.Options.IgnoreTrailingNewline {
+				return trailingNewlineEqual(s, f1, f2, n1, n2, err1, err2)
+			}
 			return false, nil
 		}
 
@@ -102,3 +267,95 @@ func fileContentsEqual(s status, f1, f2 *os.File) (bool, error) {
 		}
 	}
 }
+
+// trailingNewlineEqual is called when a chunk read from f1 and f2 had unequal
+// lengths (n1 and n2), which means the shorter file reached EOF.  It returns
+// true if the longer file's content is the shorter's plus a single trailing
+// newline.
+func trailingNewlineEqual(s status, f1, f2 *os.File, n1, n2 int, err1, err2 error) (bool, error) {
+	b1, b2 := s.cmpBuf1[:n1], s.cmpBuf2[:n2]
+	longF, longB, longErr := f1, b1, err1
+	shortB, shortErr := b2, err2
+	if n1 < n2 {
+		longF, longB, longErr = f2, b2, err2
+		shortB, shortErr = b1, err1
+	}
+	if shortErr != io.EOF {
+		return false, shortErr
+	}
+	if longErr != nil && longErr != io.EOF {
+		return false, longErr
+	}
+
+	s.Results.addBytesCompared(uint64(n1 + n2))
+	m := len(shortB)
+	if len(longB) != m+1 || longB[m] != '\n' || !bytes.Equal(shortB, longB[:m]) {
+		return false, nil
+	}
+	if longErr == io.EOF {
+		return true, nil
+	}
+
+	// The longer file must also be at its end
+	var buf [1]byte
+	n, err := I.ReadChunk(longF, buf[:])
+	if err == io.EOF {
+		return n == 0, nil
+	}
+	return false, err
+}
+
+// trailingZerosEqual is called when a chunk read from f1 and f2 had unequal
+// lengths (n1 and n2), which means the shorter file reached EOF.  It returns
+// true if the chunks are equal up to the shorter length, and the remainder of
+// the longer file is all zero bytes.
+func trailingZerosEqual(s status, f1, f2 *os.File, n1, n2 int, err1, err2 error) (bool, error) {
+	b1, b2 := s.cmpBuf1[:n1], s.cmpBuf2[:n2]
+	longF, longB, longErr := f1, b1, err1
+	shortB, shortErr := b2, err2
+	if n1 < n2 {
+		longF, longB, longErr = f2, b2, err2
+		shortB, shortErr = b1, err1
+	}
+	if shortErr != io.EOF {
+		return false, shortErr
+	}
+	if longErr != nil && longErr != io.EOF {
+		return false, longErr
+	}
+
+	s.Results.addBytesCompared(uint64(n1 + n2))
+	m := len(shortB)
+	if !bytes.Equal(shortB, longB[:m]) || !isZeros(longB[m:]) {
+		return false, nil
+	}
+	if longErr == io.EOF {
+		return true, nil
+	}
+
+	// Read the remainder of the longer file, which must be all zeros
+	buf := s.cmpBuf1[:cap(s.cmpBuf1)]
+	for {
+		n, err := I.ReadChunk(longF, buf)
+		s.Results.addBytesCompared(uint64(n))
+		s.Progress.Show()
+		if !isZeros(buf[:n]) {
+			return false, nil
+		}
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// isZeros returns true if b contains only zero bytes
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -22,7 +22,10 @@ package hardlinkable
 
 import (
 	"os"
+	"syscall"
 	"testing"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
 )
 
 func initDifferentBufs(t *testing.T, b1, b2 []byte) {
@@ -109,3 +112,202 @@ func TestFileContentComparison(t *testing.T) {
 		os.Remove("f2")
 	}
 }
+
+func TestQuickPrefixComparison(t *testing.T) {
+	topdir := setUp("Cmp", t)
+	defer os.RemoveAll(topdir)
+
+	ls := newLinkableState(&Options{QuickPrefixCompare: true})
+	s := ls.status
+	s.Progress = &disabledProgress{}
+
+	// Large files differing only in the first byte
+	content := makeString("X", 4*minCmpBufSize)
+	simpleFileMaker(t, pathContents{"f1": "A" + content, "f2": "B" + content})
+	got, err := areFileContentsEqual(s, "f1", "f2")
+	if got || err != nil {
+		t.Errorf("Unequal prefix files compared equal: %v %v", got, err)
+	}
+	if s.Results.BytesCompared != 2*quickPrefixSize {
+		t.Errorf("Incorrect BytesCompared. Expected %v, got %v", 2*quickPrefixSize, s.Results.BytesCompared)
+	}
+	if s.Results.QuickPrefixRejectCount != 1 {
+		t.Errorf("Expected QuickPrefixRejectCount 1, got %v", s.Results.QuickPrefixRejectCount)
+	}
+
+	// Equal large files must still compare all their bytes
+	s.Results.BytesCompared = 0
+	simpleFileMaker(t, pathContents{"f1": content, "f2": content})
+	got, err = areFileContentsEqual(s, "f1", "f2")
+	if !got || err != nil {
+		t.Errorf("Equal prefix files compared unequal: %v %v", got, err)
+	}
+	if s.Results.BytesCompared != uint64(2*len(content)) {
+		t.Errorf("Incorrect BytesCompared. Expected %v, got %v", 2*len(content), s.Results.BytesCompared)
+	}
+}
+
+func TestCompareTailFirst(t *testing.T) {
+	topdir := setUp("Cmp", t)
+	defer os.RemoveAll(topdir)
+
+	ls := newLinkableState(&Options{CompareTailFirst: true})
+	s := ls.status
+	s.Progress = &disabledProgress{}
+
+	// Large files differing only in the last byte
+	content := makeString("X", 4*minCmpBufSize)
+	simpleFileMaker(t, pathContents{"f1": content + "A", "f2": content + "B"})
+	got, err := areFileContentsEqual(s, "f1", "f2")
+	if got || err != nil {
+		t.Errorf("Unequal tail files compared equal: %v %v", got, err)
+	}
+	if s.Results.BytesCompared != 2*quickTailSize {
+		t.Errorf("Incorrect BytesCompared. Expected %v, got %v", 2*quickTailSize, s.Results.BytesCompared)
+	}
+	if s.Results.QuickTailRejectCount != 1 {
+		t.Errorf("Expected QuickTailRejectCount 1, got %v", s.Results.QuickTailRejectCount)
+	}
+
+	// Equal large files are still fully compared from the start
+	s.Results.BytesCompared = 0
+	simpleFileMaker(t, pathContents{"f1": content, "f2": content})
+	got, err = areFileContentsEqual(s, "f1", "f2")
+	if !got || err != nil {
+		t.Errorf("Equal tail files compared unequal: %v %v", got, err)
+	}
+	if s.Results.BytesCompared != uint64(2*(len(content)+quickTailSize)) {
+		t.Errorf("Incorrect BytesCompared. Expected %v, got %v",
+			2*(len(content)+quickTailSize), s.Results.BytesCompared)
+	}
+}
+
+func TestMmapComparison(t *testing.T) {
+	topdir := setUp("Cmp", t)
+	defer os.RemoveAll(topdir)
+
+	ls := newLinkableState(&Options{UseMmap: true})
+	s := ls.status
+	s.Progress = &disabledProgress{}
+
+	size := 3*minMmapFileSize + 17 // Not a multiple of the window size
+	content := makeString("0123456789", size)
+	var tests = []struct {
+		content [2]string
+		wants   bool
+		errStr  string
+	}{
+		{[2]string{content, content}, true, "Equal mmap compared files compared unequal"},
+		{[2]string{content, content[:size-1] + "X"}, false, "Unequal mmap compared files compared equal"},
+	}
+	for _, v := range tests {
+		s.Results.BytesCompared = 0
+		simpleFileMaker(t, pathContents{"f1": v.content[0], "f2": v.content[1]})
+		got, err := areFileContentsEqual(s, "f1", "f2")
+		if v.wants != got || err != nil {
+			t.Error(v.errStr)
+		}
+		if s.Results.BytesCompared != uint64(2*size) {
+			t.Errorf("Incorrect BytesCompared. Expected %v, got %v", 2*size, s.Results.BytesCompared)
+		}
+	}
+	if s.Results.MmapComparisonCount != int64(len(tests)) {
+		t.Errorf("Expected MmapComparisonCount %v, got %v", len(tests), s.Results.MmapComparisonCount)
+	}
+
+	// Small files fall back to read-based comparison
+	simpleFileMaker(t, pathContents{"f1": "X", "f2": "X"})
+	if got, err := areFileContentsEqual(s, "f1", "f2"); !got || err != nil {
+		t.Errorf("Equal small files compared unequal with UseMmap")
+	}
+	if s.Results.MmapComparisonCount != int64(len(tests)) {
+		t.Errorf("Small files unexpectedly used mmap comparison")
+	}
+}
+
+func TestMmapComparisonTruncated(t *testing.T) {
+	topdir := setUp("Cmp", t)
+	defer os.RemoveAll(topdir)
+
+	ls := newLinkableState(&Options{UseMmap: true})
+	s := ls.status
+	s.Progress = &disabledProgress{}
+
+	size := 2 * minMmapFileSize
+	simpleFileMaker(t, pathContents{"f1": makeString("X", size)})
+	f, err := os.Open("f1")
+	if err != nil {
+		t.Fatalf("Couldn't open 'f1': %v", err)
+	}
+	defer f.Close()
+	var maps [2][]byte
+	for i := range maps {
+		m, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			t.Skipf("Couldn't mmap 'f1': %v", err)
+		}
+		defer syscall.Munmap(m)
+		maps[i] = m
+	}
+
+	// Accessing the mapping of the truncated file faults (after the first
+	// window was compared), rather than crashing the comparison
+	if err := os.Truncate("f1", mmapCmpWindowSize); err != nil {
+		t.Fatalf("Couldn't truncate 'f1': %v", err)
+	}
+	if eq, faulted := mmapWindowsEqual(s, maps[0], maps[1]); eq || !faulted {
+		t.Errorf("Expected a faulted comparison of a truncated mapping, got: %v, %v", eq, faulted)
+	}
+	// The read-based fallback counts the compared bytes instead
+	if s.Results.BytesCompared != 0 {
+		t.Errorf("Expected no BytesCompared from the faulted comparison, got: %v", s.Results.BytesCompared)
+	}
+}
+
+func TestComparisonErrorsAreNonFatal(t *testing.T) {
+	topdir := setUp("ComparisonErrors", t)
+	defer os.RemoveAll(topdir)
+
+	for _, nonFatal := range []bool{false, true} {
+		simpleFileMaker(t, pathContents{"f1": "X", "f2": "X"})
+
+		opts := SetupOptions(IgnoreXAttr)
+		opts.ComparisonErrorsAreNonFatal = nonFatal
+		ls := newLinkableState(&opts)
+		ls.Progress = &disabledProgress{}
+
+		di1, err := I.LStatInfo("f1")
+		if err != nil {
+			t.Fatalf("Couldn't stat 'f1': %v", err)
+		}
+		di2, err := I.LStatInfo("f2")
+		if err != nil {
+			t.Fatalf("Couldn't stat 'f2': %v", err)
+		}
+		fs := ls.dev(di1, "f1")
+		if err := fs.FindIdenticalFiles(di1, "f1"); err != nil {
+			t.Fatalf("FindIdenticalFiles() returned error: %v", err)
+		}
+
+		// Remove f2 after it was stat'ed, so that its comparison fails
+		if err := os.Remove("f2"); err != nil {
+			t.Fatalf("Couldn't remove 'f2': %v", err)
+		}
+		err = fs.FindIdenticalFiles(di2, "f2")
+		if !nonFatal {
+			if err == nil {
+				t.Errorf("Expected comparison error for removed 'f2'")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error with ComparisonErrorsAreNonFatal, got: %v", err)
+		}
+		if ls.Results.ComparisonErrorCount != 1 {
+			t.Errorf("Expected 1 comparison error, got: %v", ls.Results.ComparisonErrorCount)
+		}
+		if n := len(fs.LinkableInos); n != 0 {
+			t.Errorf("Expected no linkable inodes, got: %v", n)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+)
+
+const minMmapFileSize = 1 << 20   // Smaller files use read-based comparison
+const mmapCmpWindowSize = 1 << 20 // Compare mmapped files in 1 MiB windows
+
+// mmapContentsEqual compares the contents of f1 and f2 by mmapping both files.
+// The returned 'ok' value is false if the mmap comparison could not be used
+// (small files, unequal sizes, or mmap failures), in which case the caller
+// should fall back to the read-based comparison.
+//
+// Note that if a file is truncated while it is mapped, accessing the mapping
+// causes a fault (SIGBUS), which is recovered from by also falling back to
+// the read-based comparison.
+func mmapContentsEqual(s status, f1, f2 *os.File) (eq bool, ok bool, err error) {
+	fi1, err := f1.Stat()
+	if err != nil {
+		return false, false, nil
+	}
+	fi2, err := f2.Stat()
+	if err != nil {
+		return false, false, nil
+	}
+	size := fi1.Size()
+	if size != fi2.Size() || size < minMmapFileSize || int64(int(size)) != size {
+		return false, false, nil
+	}
+
+	m1, err := syscall.Mmap(int(f1.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return false, false, nil
+	}
+	defer syscall.Munmap(m1)
+	m2, err := syscall.Mmap(int(f2.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return false, false, nil
+	}
+	defer syscall.Munmap(m2)
+
+	s.Results.usedMmapComparison()
+	eq, faulted := mmapWindowsEqual(s, m1, m2)
+	if faulted {
+		return false, false, nil
+	}
+	return eq, true, nil
+}
+
+// mmapWindowsEqual compares the equal length mmapped contents in windows.
+// A fault from accessing the mappings (ie. a file truncated while mapped) is
+// recovered from, and returned as faulted.  The bytes counted before the fault
+// are removed from BytesCompared, since the fallback comparison counts them.
+func mmapWindowsEqual(s status, m1, m2 []byte) (eq bool, faulted bool) {
+	var compared uint64
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); !ok {
+				panic(r)
+			}
+			s.Results.BytesCompared -= compared
+			eq, faulted = false, true
+		}
+	}()
+
+	for start := 0; start < len(m1); start += mmapCmpWindowSize {
+		end := start + mmapCmpWindowSize
+		if end > len(m1) {
+			end = len(m1)
+		}
+		n := uint64(2 * (end - start))
+		s.Results.addBytesCompared(n)
+		compared += n
+		s.Progress.Show()
+		if !bytes.Equal(m1[start:end], m2[start:end]) {
+			return false, false
+		}
+	}
+	return true, false
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+)
+
+// FileMetaContentAccessor gives an Options.CustomEqual func access to the
+// metadata of a file, and to its content (which is only read if requested).
+type FileMetaContentAccessor interface {
+	Path() string
+	Size() uint64
+	ModTime() time.Time
+	Mode() os.FileMode
+	Uid() uint32
+	Gid() uint32
+
+	// Open returns a reader of the file content, which the caller must
+	// close.
+	Open() (io.ReadCloser, error)
+}
+
+// fileAccessor is the FileMetaContentAccessor of a walked (or in-memory) file
+type fileAccessor struct {
+	pi  I.PathInfo
+	mem map[string][]byte // The in-memory file contents (if any)
+}
+
+func (a fileAccessor) Path() string       { return a.pi.Join() }
+func (a fileAccessor) Size() uint64       { return a.pi.Size }
+func (a fileAccessor) ModTime() time.Time { return a.pi.Mtim }
+func (a fileAccessor) Mode() os.FileMode  { return a.pi.Mode }
+func (a fileAccessor) Uid() uint32        { return a.pi.Uid }
+func (a fileAccessor) Gid() uint32        { return a.pi.Gid }
+
+func (a fileAccessor) Open() (io.ReadCloser, error) {
+	if a.mem != nil {
+		return ioutil.NopCloser(bytes.NewReader(a.mem[a.Path()])), nil
+	}
+	return os.Open(a.Path())
+}
+
+// contentsEqual returns true if the given files are equal, according to the
+// CustomEqual option when it is set, and otherwise by comparing their
+// contents.
+func contentsEqual(s status, pi1, pi2 I.PathInfo) (bool, error) {
+	if s.Options.CustomEqual != nil {
+		return s.Options.CustomEqual(fileAccessor{pi1, s.memContents}, fileAccessor{pi2, s.memContents})
+	}
+	return areFileContentsEqual(s, pi1.Join(), pi2.Join())
+}
@@ -0,0 +1,126 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ResultsDiff holds the differences between the Results of two runs, which
+// is useful for seeing the effect of changed Options (or of changes to the
+// tree over time) on the linking.
+type ResultsDiff struct {
+	// The RunStats of both runs, and the json names of the counts and
+	// amounts that differ between them.
+	Before       RunStats `json:"before"`
+	After        RunStats `json:"after"`
+	ChangedStats []string `json:"changedStats"`
+
+	// New link path groups found only in the 'after' Results, and those
+	// found only in the 'before' Results.
+	NewLinkGroups  [][]string `json:"newLinkGroups"`
+	GoneLinkGroups [][]string `json:"goneLinkGroups"`
+
+	// Existing link groups (the source pathname followed by its existing
+	// links) found only in the 'after' or 'before' Results.
+	NewExistingLinkGroups  [][]string `json:"newExistingLinkGroups"`
+	GoneExistingLinkGroups [][]string `json:"goneExistingLinkGroups"`
+}
+
+// DiffResults compares the Results of two runs over the same tree.  Link
+// groups are compared by their set of pathnames, so a group that merely has a
+// different source pathname (ie. from a different walk order) is unchanged.
+// The link groups are only available when the runs stored their link results.
+func DiffResults(before, after Results) ResultsDiff {
+	d := ResultsDiff{
+		Before: before.RunStats,
+		After:  after.RunStats,
+	}
+	b := reflect.ValueOf(before.RunStats)
+	a := reflect.ValueOf(after.RunStats)
+	t := b.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			d.ChangedStats = append(d.ChangedStats, name)
+		}
+	}
+
+	d.NewLinkGroups, d.GoneLinkGroups = diffGroups(before.LinkPaths, after.LinkPaths)
+	d.NewExistingLinkGroups, d.GoneExistingLinkGroups = diffGroups(
+		existingLinkGroups(before.ExistingLinks),
+		existingLinkGroups(after.ExistingLinks))
+	return d
+}
+
+// existingLinkGroups returns the ExistingLinks as groups of pathnames, with
+// the source pathname first.
+func existingLinkGroups(links map[string][]string) [][]string {
+	groups := make([][]string, 0, len(links))
+	for src, dsts := range links {
+		g := append([]string{src}, dsts...)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// diffGroups returns the groups only in 'after' and those only in 'before',
+// each sorted by their group key.
+func diffGroups(before, after [][]string) (added, removed [][]string) {
+	beforeKeys := groupKeys(before)
+	afterKeys := groupKeys(after)
+	for k, g := range afterKeys {
+		if _, ok := beforeKeys[k]; !ok {
+			added = append(added, g)
+		}
+	}
+	for k, g := range beforeKeys {
+		if _, ok := afterKeys[k]; !ok {
+			removed = append(removed, g)
+		}
+	}
+	sortGroups(added)
+	sortGroups(removed)
+	return added, removed
+}
+
+// groupKeys maps each group to a key made from its sorted pathnames
+func groupKeys(groups [][]string) map[string][]string {
+	m := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		m[groupKey(g)] = g
+	}
+	return m
+}
+
+func groupKey(g []string) string {
+	s := append([]string(nil), g...)
+	sort.Strings(s)
+	return strings.Join(s, "\x00")
+}
+
+func sortGroups(groups [][]string) {
+	sort.Slice(groups, func(i, j int) bool {
+		return groupKey(groups[i]) < groupKey(groups[j])
+	})
+}
@@ -22,9 +22,13 @@ package hardlinkable
 
 import (
 	"fmt"
-	"math/rand"
+	"io"
+	"log"
 	"os"
+	"path"
 	"strconv"
+	"syscall"
+	"time"
 
 	I "github.com/chadnetzer/hardlinkable/internal/inode"
 )
@@ -39,18 +43,158 @@ func (fs *fsDev) haveNotBeenModified(paths ...I.PathInfo) error {
 	return nil
 }
 
-// hardlinkFiles() will unconditionally attempt link dst (ie. target) to src
-func (fs *fsDev) hardlinkFiles(src, dst I.PathInfo) error {
+// skipsUnlinkablePair returns true (and counts the skipped link) if the dst
+// pathname can't be linked to the src pathname, due to either file being held
+// open by another process (with SkipOpenFiles), or either dir not being
+// writable (with CheckDirWritable).
+func (fs *fsDev) skipsUnlinkablePair(src, dst I.PathInfo) bool {
+	if len(fs.openInos) > 0 &&
+		(fs.openInos[devIno{fs.Dev, uint64(src.Ino)}] || fs.openInos[devIno{fs.Dev, uint64(dst.Ino)}]) {
+		fs.Results.skippedOpenFile()
+		fs.Results.skippedInode(fs.Dev, uint64(dst.Ino), dst.Join(), UnlinkedOpenFile)
+		return true
+	}
+	if fs.Options.CheckDirWritable &&
+		(!fs.isDirWritable(src.Dirname) || !fs.isDirWritable(dst.Dirname)) {
+		fs.Results.skippedReadonlyDir()
+		fs.Results.skippedInode(fs.Dev, uint64(dst.Ino), dst.Join(), UnlinkedReadonlyDir)
+		return true
+	}
+	return false
+}
+
+// skipsChangedContents returns true (and counts the skipped link) if the
+// contents of the src and dst files no longer match, when linking with the
+// RecompareBeforeLink option.  An error is returned if the comparison fails
+// (unless ignoring link errors, in which case the link is skipped).
+func (fs *fsDev) skipsChangedContents(src, dst I.PathInfo) (bool, error) {
+	if !fs.Options.RecompareBeforeLink || !fs.Options.LinkingEnabled {
+		return false, nil
+	}
+	startBytes, startMax := fs.Results.BytesCompared, fs.Results.MaxComparisonBytes
+	eq, err := contentsEqual(fs.status, src, dst)
+	fs.Results.recomparedBeforeLink(startBytes, startMax)
+	if err != nil {
+		if !fs.Options.IgnoreLinkErrors {
+			return false, err
+		} else if fs.Options.DebugLevel > 0 {
+			log.Printf("\r%v  Skipping...", err)
+		}
+		fs.Results.skippedNewLink(src.Pathsplit, dst.Pathsplit)
+		fs.Results.skippedInode(fs.Dev, uint64(dst.Ino), dst.Join(), UnlinkedLinkError)
+		return true, nil
+	}
+	if !eq {
+		fs.Results.contentChangedBeforeLink()
+		fs.Results.skippedInode(fs.Dev, uint64(dst.Ino), dst.Join(), UnlinkedContentChanged)
+		return true, nil
+	}
+	return false, nil
+}
+
+// tmpLinkName returns the temporary pathname to link to, before renaming to
+// the given dst pathname.
+func (fs *fsDev) tmpLinkName(dst I.PathInfo) string {
 	// Add some randomness to the tmpName to minimize chances of collisions
 	// with deliberately targeted matching names
-	tmpName := dst.Pathsplit.Join() + ".tmp" + strconv.FormatUint(rand.Uint64(), 36)
-	if err := os.Link(src.Pathsplit.Join(), tmpName); err != nil {
+	token := strconv.FormatUint(fs.rand.Uint64(), 36)
+	if fs.Options.TempLinkPattern != "" {
+		dir := path.Dir(dst.Pathsplit.Join())
+		return fmt.Sprintf(fs.Options.TempLinkPattern, dir, token)
+	}
+	return dst.Pathsplit.Join() + ".tmp" + token
+}
+
+// linkPacer spaces out the links by a minimum interval, to enforce the
+// LinkRateLimit option.
+type linkPacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// wait sleeps until the next link is allowed.  A nil linkPacer never waits.
+func (p *linkPacer) wait() {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if now.Before(p.next) {
+		time.Sleep(p.next.Sub(now))
+		now = p.next
+	}
+	p.next = now.Add(p.interval)
+}
+
+// isDirWritable returns true if the process has write access to the given
+// dirname (as needed to link into it).  The result is cached for each dirname.
+func (fs *fsDev) isDirWritable(dirname string) bool {
+	if dirname == "" {
+		dirname = "."
+	}
+	if w, ok := fs.writableDirs[dirname]; ok {
+		return w
+	}
+	const wOK = 0x2 // From unistd.h
+	w := syscall.Access(dirname, wOK) == nil
+	fs.writableDirs[dirname] = w
+	return w
+}
+
+// auditLink writes a line to the AuditLog (if any) recording the attempted
+// link of dst to src, and whether it failed.  An error is returned if the
+// AuditLog couldn't be written.
+func (fs *fsDev) auditLink(src, dst I.PathInfo, linkErr error) error {
+	w := fs.Options.AuditLog
+	if w == nil {
+		return nil
+	}
+	ts := fs.Options.now().Format(time.RFC3339)
+	var line string
+	if linkErr == nil {
+		line = fmt.Sprintf("%v LINK %v -> %v %v\n", ts, src.Join(), dst.Join(), src.Size)
+	} else {
+		line = fmt.Sprintf("%v FAILED %v -> %v %v (%v)\n", ts, src.Join(), dst.Join(), src.Size, linkErr)
+	}
+	if _, err := io.WriteString(w, line); err != nil {
+		return fmt.Errorf("Couldn't write to audit log: %v", err)
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("Couldn't flush audit log: %v", err)
+		}
+	}
+	return nil
+}
+
+// replaceWithLink links the dst pathname to the src pathname, by linking to a
+// temporary name and renaming it over dst.  With UnsafeDirectLink, dst is
+// instead removed and linked directly (which isn't atomic).
+func (fs *fsDev) replaceWithLink(src string, dst I.PathInfo) error {
+	if fs.Options.UnsafeDirectLink {
+		if err := os.Remove(dst.Pathsplit.Join()); err != nil {
+			return err
+		}
+		return os.Link(src, dst.Pathsplit.Join())
+	}
+	tmpName := fs.tmpLinkName(dst)
+	if err := os.Link(src, tmpName); err != nil {
 		return err
 	}
 	if err := os.Rename(tmpName, dst.Pathsplit.Join()); err != nil {
 		os.Remove(tmpName)
 		return err
 	}
+	return nil
+}
+
+// hardlinkFiles() will unconditionally attempt link dst (ie. target) to src
+func (fs *fsDev) hardlinkFiles(src, dst I.PathInfo) error {
+	if err := fs.replaceWithLink(src.Pathsplit.Join(), dst); err != nil {
+		return err
+	}
+	if fs.Options.PreserveAtime {
+		defer fs.restoreAtime(src)
+	}
 
 	if fs.Options.UseNewestLink {
 		// Use destination file times if it's most recently modified
@@ -82,6 +226,18 @@ func (fs *fsDev) hardlinkFiles(src, dst I.PathInfo) error {
 	return nil
 }
 
+// restoreAtime sets the access time of the src inode back to its walked value
+// (keeping the current, possibly updated, modification time).
+func (fs *fsDev) restoreAtime(src I.PathInfo) {
+	mtime := src.Mtim
+	if si, ok := fs.inoStatInfo[src.Ino]; ok {
+		mtime = si.Mtim
+	}
+	if err := os.Chtimes(src.Pathsplit.Join(), src.Atim, mtime); err != nil {
+		fs.Results.FailedAtimeRestoreCount++
+	}
+}
+
 func hasBeenModified(pi I.PathInfo, dev uint64) bool {
 	newDSI, err := I.LStatInfo(pi.Pathsplit.Join())
 	if err != nil {
@@ -21,8 +21,17 @@
 package hardlinkable
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -196,3 +205,361 @@ func TestHasBeenModified(t *testing.T) {
 		t.Errorf("Failed to detect Size modification to file: '%v'", filename)
 	}
 }
+
+func TestTempLinkPattern(t *testing.T) {
+	topdir := setUp("TempLinkPattern", t)
+	defer os.RemoveAll(topdir)
+
+	options := SetupOptions(func(o *Options) { o.TempLinkPattern = "%s/.hl-%s.tmp" })
+	if err := options.Validate(); err != nil {
+		t.Fatalf("Valid TempLinkPattern returned error: %v", err)
+	}
+	ls := newLinkableState(&options)
+	fs := newFSDev(ls.status, 10000, 10000) // Arbitrary args
+
+	simpleFileMaker(t, pathContents{"A/f1": "X", "A/f2/f3": "X"})
+
+	dsi1, err := I.LStatInfo("A/f1")
+	if err != nil {
+		t.Fatalf("Couldn't run LStatInfo(A/f1): %v", err)
+	}
+	dsi2, err := I.LStatInfo("A/f2")
+	if err != nil {
+		t.Fatalf("Couldn't run LStatInfo(A/f2): %v", err)
+	}
+	fs.inoStatInfo[dsi1.Ino] = &dsi1.StatInfo
+	ps1 := I.PathInfo{Pathsplit: P.Split("A/f1", nil), StatInfo: dsi1.StatInfo}
+	ps2 := I.PathInfo{Pathsplit: P.Split("A/f2", nil), StatInfo: dsi2.StatInfo}
+
+	tmpName := fs.tmpLinkName(ps2)
+	if matched, _ := regexp.MatchString(`^A/\.hl-[0-9a-z]+\.tmp$`, tmpName); !matched {
+		t.Errorf("Temp link name doesn't match custom pattern: %v", tmpName)
+	}
+
+	// Renaming the temp link over a non-empty directory fails, and the
+	// temp link should be removed.
+	if err := fs.hardlinkFiles(ps1, ps2); err == nil {
+		t.Errorf("Expected linking over a non-empty dir to fail")
+	}
+	entries, err := ioutil.ReadDir("A")
+	if err != nil {
+		t.Fatalf("Couldn't read dir A: %v", err)
+	}
+	for _, fi := range entries {
+		if strings.HasPrefix(fi.Name(), ".hl-") {
+			t.Errorf("Temp link wasn't cleaned up: %v", fi.Name())
+		}
+	}
+
+	for _, p := range []string{"%s-%s", "%s/sub/%s", "%s/%d", "%s/%s/%s"} {
+		o := SetupOptions(func(o *Options) { o.TempLinkPattern = p })
+		if err := o.Validate(); err == nil {
+			t.Errorf("Invalid TempLinkPattern '%v' didn't return error", p)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	topdir := setUp("AuditLog", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "X", "f2": "X", "f3": "X", "f4": "YY"})
+
+	var buf bytes.Buffer
+	opts := SetupOptions(LinkingEnabled)
+	opts.AuditLog = &buf
+	result, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if int64(len(lines)) != result.NewLinkCount || len(lines) != 2 {
+		t.Fatalf("Expected 2 audit log lines, got: %q", lines)
+	}
+	lineRE := regexp.MustCompile(`^(\S+) LINK (f[123]) -> (f[123]) 1$`)
+	for _, line := range lines {
+		m := lineRE.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("Unexpected audit log line: %q", line)
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, m[1]); err != nil {
+			t.Errorf("Couldn't parse audit log timestamp: %v", err)
+		}
+	}
+}
+
+func TestRecompareBeforeLink(t *testing.T) {
+	topdir := setUp("RecompareBeforeLink", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "XXXX", "f2": "XXXX"})
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.RecompareBeforeLink = true
+	ls := newLinkableState(&opts)
+	ls.Progress = &disabledProgress{}
+
+	// Gather the files, as in the walk phase of Run()
+	var fs fsDev
+	for _, pathname := range []string{"f1", "f2"} {
+		di, err := I.LStatInfo(pathname)
+		if err != nil {
+			t.Fatalf("Couldn't stat '%v': %v", pathname, err)
+		}
+		fs = ls.dev(di, pathname)
+		if err := fs.FindIdenticalFiles(di, pathname); err != nil {
+			t.Fatalf("FindIdenticalFiles() returned error: %v", err)
+		}
+	}
+
+	comparisons, compared := ls.Results.ComparisonCount, ls.Results.BytesCompared
+
+	// Change the contents of f2 between the phases, keeping the same size
+	// and mtime so that the quiescence check doesn't detect it.
+	fi, err := os.Lstat("f2")
+	if err != nil {
+		t.Fatalf("Couldn't stat 'f2': %v", err)
+	}
+	if err := ioutil.WriteFile("f2", []byte("YYYY"), 0644); err != nil {
+		t.Fatalf("Couldn't modify 'f2': %v", err)
+	}
+	if err := os.Chtimes("f2", fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("Couldn't Chtimes() on 'f2': %v", err)
+	}
+
+	if err := fs.generateLinks(); err != nil {
+		t.Fatalf("generateLinks() returned error: %v", err)
+	}
+	if ls.Results.ContentChangedBeforeLinkCount != 1 {
+		t.Errorf("Expected 1 changed content link, got: %v",
+			ls.Results.ContentChangedBeforeLinkCount)
+	}
+	if ls.Results.NewLinkCount != 0 || nlinkVal("f1") != 1 || nlinkVal("f2") != 1 {
+		t.Errorf("Expected no links made, got: %v", ls.Results.LinkPaths)
+	}
+	// The recompare is counted apart from the comparisons of the search
+	if ls.Results.RecompareCount != 1 || ls.Results.RecompareBytes != 8 {
+		t.Errorf("Expected 1 recompare of 8 bytes, got: %v of %v bytes",
+			ls.Results.RecompareCount, ls.Results.RecompareBytes)
+	}
+	if ls.Results.ComparisonCount != comparisons || ls.Results.BytesCompared != compared {
+		t.Errorf("Expected %v comparisons of %v bytes, got: %v of %v bytes", comparisons, compared,
+			ls.Results.ComparisonCount, ls.Results.BytesCompared)
+	}
+	verifyContents("RecompareBeforeLink", t, pathContents{"f1": "XXXX", "f2": "YYYY"})
+}
+
+func TestTmpLinkNameRand(t *testing.T) {
+	topdir := setUp("TmpLinkNameRand", t)
+	defer os.RemoveAll(topdir)
+
+	// A seeded Rand gives reproducible temp link names
+	opts := &Options{Rand: rand.New(rand.NewSource(1))}
+	ls := newLinkableState(opts)
+	fs := newFSDev(ls.status, 10000, 10000) // Arbitrary args
+	dst := I.PathInfo{Pathsplit: P.Split("dir/f1", nil)}
+	token := strconv.FormatUint(rand.New(rand.NewSource(1)).Uint64(), 36)
+	if name := fs.tmpLinkName(dst); name != "dir/f1.tmp"+token {
+		t.Errorf("Expected temp link name 'dir/f1.tmp%v', got: '%v'", token, name)
+	}
+
+	// Concurrent linking Runs each use their own random source, and so
+	// shouldn't race (when run with -race)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		dir := filepath.Join(topdir, fmt.Sprintf("d%v", i))
+		simpleFileMaker(t, pathContents{
+			filepath.Join(dir, "f1"): "X",
+			filepath.Join(dir, "f2"): "X",
+			filepath.Join(dir, "f3"): "X",
+		})
+		opts := SetupOptions(LinkingEnabled)
+		if i%2 == 0 {
+			opts.Rand = rand.New(rand.NewSource(int64(i)))
+		}
+		wg.Add(1)
+		go func(dir string, opts Options) {
+			defer wg.Done()
+			result, err := Run([]string{dir}, opts)
+			if err != nil {
+				t.Errorf("Run() returned error: %v", err)
+			} else if result.NewLinkCount != 2 {
+				t.Errorf("Expected 2 new links in '%v', got: %v", dir, result.NewLinkCount)
+			}
+		}(dir, opts)
+	}
+	wg.Wait()
+}
+
+func TestContinueOnGroupError(t *testing.T) {
+	topdir := setUp("ContinueOnGroupError", t)
+	defer os.RemoveAll(topdir)
+
+	// Make two linkable groups, gather them as in the walk phase of Run(),
+	// and then change the mtime of g2 so that the quiescence check fails
+	// while linking its group.
+	gather := func(opts *Options) (*linkableState, fsDev) {
+		m := pathContents{"f1": "XXXX", "f2": "XXXX", "g1": "YYYY", "g2": "YYYY"}
+		for pathname := range m {
+			os.Remove(pathname)
+		}
+		simpleFileMaker(t, m)
+
+		ls := newLinkableState(opts)
+		ls.Progress = &disabledProgress{}
+		var fs fsDev
+		for _, pathname := range []string{"f1", "f2", "g1", "g2"} {
+			di, err := I.LStatInfo(pathname)
+			if err != nil {
+				t.Fatalf("Couldn't stat '%v': %v", pathname, err)
+			}
+			fs = ls.dev(di, pathname)
+			if err := fs.FindIdenticalFiles(di, pathname); err != nil {
+				t.Fatalf("FindIdenticalFiles() returned error: %v", err)
+			}
+		}
+
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes("g2", later, later); err != nil {
+			t.Fatalf("Couldn't Chtimes() on 'g2': %v", err)
+		}
+		return ls, fs
+	}
+
+	opts := SetupOptions(LinkingEnabled)
+	_, fs := gather(&opts)
+	if err := fs.generateLinks(); err == nil {
+		t.Errorf("Expected generateLinks() error without ContinueOnGroupError")
+	}
+
+	opts.ContinueOnGroupError = true
+	ls, fs := gather(&opts)
+	if err := fs.generateLinks(); err != nil {
+		t.Fatalf("generateLinks() returned error: %v", err)
+	}
+	if nlinkVal("f1") != 2 || nlinkVal("g1") != 1 || nlinkVal("g2") != 1 {
+		t.Errorf("Expected only 'f1' and 'f2' to be linked")
+	}
+	expected := [][]string{{"g1", "g2"}}
+	if !reflect.DeepEqual(ls.Results.FailedGroups, expected) || ls.Results.FailedGroupCount != 1 {
+		t.Errorf("Expected FailedGroups %v, got: %v", expected, ls.Results.FailedGroups)
+	}
+}
+
+func TestLinkRateLimit(t *testing.T) {
+	topdir := setUp("LinkRateLimit", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 6; i++ {
+		m[fmt.Sprintf("f%v", i)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	// The first link is made immediately, and the rest are paced
+	const rate = 20.0
+	opts := SetupOptions(LinkingEnabled)
+	opts.LinkRateLimit = rate
+	start := time.Now()
+	result := simpleRun("LinkRateLimit", t, opts, 1, ".")
+	elapsed := time.Since(start)
+	minElapsed := time.Duration(float64(result.NewLinkCount-1) * float64(time.Second) / rate)
+	if result.NewLinkCount != 5 {
+		t.Errorf("Expected 5 new links, got: %v", result.NewLinkCount)
+	}
+	if elapsed < minElapsed {
+		t.Errorf("Expected linking to take at least %v, took: %v", minElapsed, elapsed)
+	}
+	verifyContents("LinkRateLimit", t, m)
+}
+
+func TestUnsafeDirectLink(t *testing.T) {
+	topdir := setUp("UnsafeDirectLink", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X"}
+	simpleFileMaker(t, m)
+
+	pathInfo := func(pathname string) I.PathInfo {
+		dsi, err := I.LStatInfo(pathname)
+		if err != nil {
+			t.Fatalf("Couldn't run LStatInfo(%v): %v", pathname, err)
+		}
+		return I.PathInfo{Pathsplit: P.Split(pathname, nil), StatInfo: dsi.StatInfo}
+	}
+	missing := I.PathInfo{Pathsplit: P.Split("missing", nil)}
+
+	// The default link and rename leaves dst intact when linking fails
+	opts := SetupOptions(LinkingEnabled)
+	ls := newLinkableState(&opts)
+	fs := newFSDev(ls.status, 10000, 10000) // Arbitrary args
+	if err := fs.hardlinkFiles(missing, pathInfo("f3")); err == nil {
+		t.Errorf("Expected linking to a missing src to fail")
+	}
+	verifyContents("UnsafeDirectLink", t, pathContents{"f3": "X"})
+
+	opts.UnsafeDirectLink = true
+	ps1 := pathInfo("f1")
+	fs.inoStatInfo[ps1.Ino] = &ps1.StatInfo
+	if err := fs.hardlinkFiles(ps1, pathInfo("f2")); err != nil {
+		t.Fatalf("Direct linking failed: %v", err)
+	}
+	if nlinkVal("f1") != 2 || nlinkVal("f2") != 2 {
+		t.Errorf("Expected 'f1' and 'f2' to be linked")
+	}
+	if entries, _ := ioutil.ReadDir("."); len(entries) != 3 {
+		t.Errorf("Expected no temp links, got: %v entries", len(entries))
+	}
+	verifyContents("UnsafeDirectLink", t, pathContents{"f1": "X", "f2": "X"})
+
+	// Unlike the default, the removed dst is lost when linking fails
+	if err := fs.hardlinkFiles(missing, pathInfo("f3")); err == nil {
+		t.Errorf("Expected linking to a missing src to fail")
+	}
+	if _, err := os.Lstat("f3"); !os.IsNotExist(err) {
+		t.Errorf("Expected 'f3' to be removed by the failed direct link")
+	}
+}
+
+func TestPreserveAtime(t *testing.T) {
+	topdir := setUp("PreserveAtime", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+
+	// An atime older than the mtime is updated by reads, even with
+	// relatime mounts (but not with noatime).
+	fi, err := os.Lstat("f1")
+	if err != nil {
+		t.Fatalf("Couldn't Lstat 'f1': %v", err)
+	}
+	atime := fi.ModTime().Add(-time.Hour).Truncate(time.Second)
+	for _, p := range []string{"f1", "f2"} {
+		if err := os.Chtimes(p, atime, fi.ModTime()); err != nil {
+			t.Fatalf("Couldn't Chtimes '%v': %v", p, err)
+		}
+	}
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.PreserveAtime = true
+	r := simpleRun("PreserveAtime", t, opts, 1, ".")
+	if r.FailedAtimeRestoreCount != 0 {
+		t.Errorf("Expected no failed atime restores, got: %v", r.FailedAtimeRestoreCount)
+	}
+	if nlinkVal("f1") != 2 {
+		t.Fatalf("Expected 'f1' and 'f2' to be linked")
+	}
+	dsi, err := I.LStatInfo("f1")
+	if err != nil {
+		t.Fatalf("Couldn't run LStatInfo(f1): %v", err)
+	}
+	if !dsi.Atim.Equal(atime) {
+		t.Errorf("Expected atime %v to be preserved, got: %v", atime, dsi.Atim)
+	}
+	if !dsi.Mtim.Equal(fi.ModTime()) {
+		t.Errorf("Expected mtime %v to be unchanged, got: %v", fi.ModTime(), dsi.Mtim)
+	}
+}
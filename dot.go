@@ -0,0 +1,70 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WriteDOT writes a Graphviz DOT digraph to w, in which the nodes are
+// pathnames, and the edges connect the pathnames that share an inode.  The
+// existing links are solid edges from their src pathname, and the new links
+// (linkable or linked) are dashed edges from their src pathname.  The
+// StoreExistingLinkResults and StoreNewLinkResults options must be enabled
+// for the Run() to gather the respective links.
+func (r *Results) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph hardlinkable {"); err != nil {
+		return err
+	}
+
+	srcs := make([]string, 0, len(r.ExistingLinks))
+	for src := range r.ExistingLinks {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		for _, dst := range r.ExistingLinks[src] {
+			if err := writeDOTEdge(w, src, dst, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, paths := range r.LinkPaths {
+		src := paths[0]
+		for _, dst := range paths[1:] {
+			if err := writeDOTEdge(w, src, dst, " [style=dashed]"); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func writeDOTEdge(w io.Writer, src, dst, attrs string) error {
+	_, err := fmt.Fprintf(w, "\t%s -> %s%s;\n", strconv.Quote(src), strconv.Quote(dst), attrs)
+	return err
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	topdir := setUp("DOT", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1": "X",
+		"f2": "X",
+		"f3": "X",
+		"g1": "YY",
+	})
+	simpleLinkMaker(t, "g1", "g2")
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.StoreExistingLinkResults = true
+	result, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if len(result.LinkPaths) != 1 {
+		t.Fatalf("Expected 1 link group, got: %v", result.LinkPaths)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteDOT(&buf); err != nil {
+		t.Fatalf("WriteDOT() returned error: %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "digraph hardlinkable {\n") || !strings.HasSuffix(out, "\n}\n") {
+		t.Errorf("Expected digraph framing, got:\n%v", out)
+	}
+
+	// A dashed edge for each planned link, and a solid one for the existing link
+	paths := result.LinkPaths[0]
+	for _, dst := range paths[1:] {
+		edge := fmt.Sprintf("\t%q -> %q [style=dashed];\n", paths[0], dst)
+		if !strings.Contains(out, edge) {
+			t.Errorf("Expected edge %q in DOT output:\n%v", edge, out)
+		}
+	}
+	if strings.Count(out, "[style=dashed]") != len(paths)-1 {
+		t.Errorf("Expected %v dashed edges, got:\n%v", len(paths)-1, out)
+	}
+	if !strings.Contains(out, "\t\"g1\" -> \"g2\";\n") && !strings.Contains(out, "\t\"g2\" -> \"g1\";\n") {
+		t.Errorf("Expected existing link edge between 'g1' and 'g2', got:\n%v", out)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"path"
+	"sort"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+// Explanation describes how a single file relates to the files found in the
+// searched directories, for debugging why it is (or isn't) linkable.
+type Explanation struct {
+	Path string `json:"path"`
+
+	// Pathnames already linked to the file (ie. the same inode)
+	ExistingLinks []string `json:"existingLinks,omitempty"`
+
+	// Pathnames of files with the same size, and of those, the files that
+	// also have the same inode hash (ie. size and the compared inode
+	// parameters)
+	SizeMatches []string `json:"sizeMatches,omitempty"`
+	HashMatches []string `json:"hashMatches,omitempty"`
+
+	// Pathnames of the files with equal content that are linkable
+	EqualPaths []string `json:"equalPaths,omitempty"`
+
+	// The files with equal content that aren't linkable, and why
+	BlockedPaths []BlockedPath `json:"blockedPaths,omitempty"`
+}
+
+// BlockedPath is a file with content equal to the explained file, which can't
+// be linked to it, along with the reasons.
+type BlockedPath struct {
+	Path    string   `json:"path"`
+	Reasons []string `json:"reasons"`
+}
+
+// The reasons given for the Explanation BlockedPaths
+const (
+	BlockedDevice = "different device"
+	BlockedMtime  = "mismatched modification time"
+	BlockedMode   = "mismatched mode"
+	BlockedOwner  = "mismatched uid/gid"
+	BlockedXAttr  = "mismatched xattrs"
+)
+
+// ExplainPath compares the given file to each of the files found in the
+// searchDirs (with the walk related Options applied, and the files rejected
+// by the size and mode limits skipped), and returns an Explanation of which
+// files it matches, and why the files with equal content may not be
+// linkable.  No linking is performed.
+func ExplainPath(pathname string, opts Options, searchDirs []string) (Explanation, error) {
+	opts.LinkingEnabled = false
+	e := Explanation{Path: pathname}
+	if err := opts.Validate(); err != nil {
+		return e, err
+	}
+	dirs, files, err := ValidateDirsAndFiles(searchDirs)
+	if err != nil {
+		return e, err
+	}
+	target, err := I.LStatInfo(pathname)
+	if err != nil {
+		return e, err
+	}
+
+	ls := newLinkableState(&opts)
+	ls.Progress = &disabledProgress{}
+	o := ls.Options
+	targetHash := I.HashIno(target.StatInfo, o.ignoresSize(), o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner)
+
+	done := make(chan struct{})
+	c := matchedPathnames(*o, ls.Results, ls.pool, done, dirs, files)
+	defer func() {
+		close(done)
+		for range c {
+		}
+	}()
+	for pe := range c {
+		if pe.err != nil {
+			return e, pe.err
+		}
+		if path.Clean(pe.pathname) == path.Clean(pathname) {
+			continue
+		}
+		di, statErr := I.LStatInfo(pe.pathname)
+		if statErr != nil {
+			if o.IgnoreWalkErrors {
+				continue
+			}
+			return e, statErr
+		}
+		if di.Dev == target.Dev && di.Ino == target.Ino {
+			e.ExistingLinks = append(e.ExistingLinks, pe.pathname)
+			continue
+		}
+		if !isLinkCandidate(di, pe.pathname, o, ls.Results, false) {
+			continue
+		}
+		if di.Size != target.Size && !o.ignoresSize() {
+			continue
+		}
+		if di.Size == target.Size {
+			e.SizeMatches = append(e.SizeMatches, pe.pathname)
+		}
+		if I.HashIno(di.StatInfo, o.ignoresSize(), o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner) == targetHash {
+			e.HashMatches = append(e.HashMatches, pe.pathname)
+		}
+
+		targetPI := I.PathInfo{Pathsplit: P.Split(pathname, nil), StatInfo: target.StatInfo}
+		pi := I.PathInfo{Pathsplit: P.Split(pe.pathname, nil), StatInfo: di.StatInfo}
+		eq, cmpErr := contentsEqual(ls.status, targetPI, pi)
+		if cmpErr != nil {
+			if o.IgnoreWalkErrors {
+				continue
+			}
+			return e, cmpErr
+		}
+		if !eq {
+			continue
+		}
+		if reasons := blockedReasons(o, target, pathname, di, pe.pathname); len(reasons) > 0 {
+			e.BlockedPaths = append(e.BlockedPaths, BlockedPath{Path: pe.pathname, Reasons: reasons})
+		} else {
+			e.EqualPaths = append(e.EqualPaths, pe.pathname)
+		}
+	}
+
+	sort.Strings(e.ExistingLinks)
+	sort.Strings(e.SizeMatches)
+	sort.Strings(e.HashMatches)
+	sort.Strings(e.EqualPaths)
+	sort.Slice(e.BlockedPaths, func(i, j int) bool {
+		return e.BlockedPaths[i].Path < e.BlockedPaths[j].Path
+	})
+	return e, nil
+}
+
+// blockedReasons returns the reasons (according to the Options) that two
+// files of equal content can't be linked.
+func blockedReasons(o *Options, di1 I.DevStatInfo, pathname1 string, di2 I.DevStatInfo, pathname2 string) []string {
+	pi1 := I.PathInfo{StatInfo: di1.StatInfo}
+	pi2 := I.PathInfo{StatInfo: di2.StatInfo}
+	var reasons []string
+	if di1.Dev != di2.Dev {
+		reasons = append(reasons, BlockedDevice)
+	}
+	if !o.IgnoreTime && !pi1.EqualTime(pi2) {
+		reasons = append(reasons, BlockedMtime)
+	}
+	if !o.IgnorePerm && !pi1.EqualMode(pi2) {
+		reasons = append(reasons, BlockedMode)
+	}
+	if !o.IgnoreOwner && !pi1.EqualOwnership(pi2) {
+		reasons = append(reasons, BlockedOwner)
+	}
+	if o.comparesXAttrs() {
+		if eq, _ := I.EqualXAttrs(pathname1, pathname2); !eq {
+			reasons = append(reasons, BlockedXAttr)
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,83 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExplainPath(t *testing.T) {
+	topdir := setUp("ExplainPath", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"d/t":     "X",
+		"d/eq1":   "X",
+		"d/eq2":   "X",
+		"d/mtime": "X",
+		"d/mode":  "X",
+		"d/diff":  "Y",
+		"d/big":   "XX",
+	})
+	simpleLinkMaker(t, "d/t", "d/link")
+	older := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("d/mtime", older, older); err != nil {
+		t.Fatalf("Couldn't Chtimes() on 'd/mtime': %v", err)
+	}
+	if err := os.Chmod("d/mode", 0600); err != nil {
+		t.Fatalf("Couldn't Chmod() on 'd/mode': %v", err)
+	}
+
+	e, err := ExplainPath("d/t", SetupOptions(), []string{"d"})
+	if err != nil {
+		t.Fatalf("ExplainPath() returned error: %v", err)
+	}
+	expected := Explanation{
+		Path:          "d/t",
+		ExistingLinks: []string{"d/link"},
+		SizeMatches:   []string{"d/diff", "d/eq1", "d/eq2", "d/mode", "d/mtime"},
+		HashMatches:   []string{"d/diff", "d/eq1", "d/eq2"},
+		EqualPaths:    []string{"d/eq1", "d/eq2"},
+		BlockedPaths: []BlockedPath{
+			{Path: "d/mode", Reasons: []string{BlockedMode}},
+			{Path: "d/mtime", Reasons: []string{BlockedMtime}},
+		},
+	}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("ExplainPath() expected:\n%+v\ngot:\n%+v", expected, e)
+	}
+
+	// The blocking metadata can be ignored
+	e, err = ExplainPath("d/t", SetupOptions(ContentOnly), []string{"d"})
+	if err != nil {
+		t.Fatalf("ExplainPath() returned error: %v", err)
+	}
+	if len(e.BlockedPaths) != 0 || len(e.EqualPaths) != 4 {
+		t.Errorf("Expected 4 equal paths with ContentOnly, got: %+v", e)
+	}
+
+	if _, err := ExplainPath("d/missing", SetupOptions(), []string{"d"}); err == nil {
+		t.Errorf("Expected ExplainPath() error for missing file")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunUseFadvise(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Fadvise Run'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "Y", "f4": "Y", "f5": "Z"}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.SearchThresh = 0 // Also read digests
+	want := simpleRun(name, t, opts, 2, ".")
+
+	opts.UseFadvise = true
+	got := simpleRun(name, t, opts, 2, ".")
+	if !reflect.DeepEqual(sortedGroups(got.LinkPaths), sortedGroups(want.LinkPaths)) {
+		t.Errorf("%v: Expected link paths %v, got: %v", name, want.LinkPaths, got.LinkPaths)
+	}
+	if got.ComparisonCount != want.ComparisonCount || got.InodeRemovedCount != want.InodeRemovedCount {
+		t.Errorf("%v: Expected equal stats, got: %+v vs %+v", name, got.RunStats, want.RunStats)
+	}
+}
+
+// sortedGroups returns the sorted paths of each group, with the groups sorted
+func sortedGroups(groups [][]string) []string {
+	var s []string
+	for _, g := range groups {
+		s = append(s, strings.Join(sortedPaths(g), " "))
+	}
+	return sortedPaths(s)
+}
@@ -21,6 +21,12 @@
 package hardlinkable
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+	"time"
+
 	I "github.com/chadnetzer/hardlinkable/internal/inode"
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
 )
@@ -34,19 +40,49 @@ type fsDev struct {
 	InoPaths     I.PathsMap
 	LinkableInos I.LinkableInoSets
 	I.InoDigests
-	pool *P.StringPool
+
+	// The sizes of which more than one inode has been seen, and the first
+	// (held back) file of the other seen sizes.  A file whose size is
+	// unique can't have a content match, so it's only searched once
+	// another file of its size is found.
+	seenSizes   map[uint64]bool
+	uniqueSizes map[uint64]uniqueSizeFile
+
+	// Cached results of the CheckDirWritable option checks
+	writableDirs map[string]bool
+
+	// Cached results of the DenyDigests option checks
+	deniedInos map[I.Ino]bool
+
+	// Content-only inode matching, for the AdvisoryContentGroups option
+	advInoHashes    I.InoHashes
+	advLinkableInos I.LinkableInoSets
 }
 
 func newFSDev(lstatus status, dev, maxNLinks uint64) fsDev {
+	return newFSDevSize(lstatus, dev, maxNLinks, 0)
+}
+
+// newFSDevSize returns an fsDev with its inode maps presized for n inodes
+func newFSDevSize(lstatus status, dev, maxNLinks uint64, n int) fsDev {
+	inoDigests := I.NewInoDigests()
+	inoDigests.Fadvise = lstatus.Options != nil && lstatus.Options.UseFadvise
 	return fsDev{
 		status:       lstatus,
 		Dev:          dev,
 		MaxNLinks:    maxNLinks,
-		inoHashes:    make(I.InoHashes),
-		inoStatInfo:  make(I.InoStatInfo),
-		InoPaths:     make(I.PathsMap),
+		inoHashes:    make(I.InoHashes, n),
+		inoStatInfo:  make(I.InoStatInfo, n),
+		InoPaths:     make(I.PathsMap, n),
 		LinkableInos: make(I.LinkableInoSets),
-		InoDigests:   I.NewInoDigests(),
+		InoDigests:   inoDigests,
+		seenSizes:    make(map[uint64]bool),
+		uniqueSizes:  make(map[uint64]uniqueSizeFile),
+		writableDirs: make(map[string]bool),
+		deniedInos:   make(map[I.Ino]bool),
+
+		advInoHashes:    make(I.InoHashes),
+		advLinkableInos: make(I.LinkableInoSets),
 	}
 }
 
@@ -62,21 +98,58 @@ func newFSDev(lstatus status, dev, maxNLinks uint64) fsDev {
 //
 // An error is returned if there was a problem reading files during a
 // comparison.
-func (f *fsDev) FindIdenticalFiles(di I.DevStatInfo, pathname string) (err error) {
+func (f *fsDev) FindIdenticalFiles(di I.DevStatInfo, pathname string) error {
 	panicIf(f.Dev != di.Dev, "Mismatched Dev %d for %s\n", f.Dev, pathname)
 	curPath := P.Split(pathname, f.pool)
+
+	// Hold back the first file of each size, until another file of the
+	// same size is found (see addUniqueSizes)
+	if f.holdsUniqueSizes() {
+		if u, ok := f.uniqueSizes[di.Size]; ok {
+			delete(f.uniqueSizes, di.Size)
+			f.seenSizes[di.Size] = true
+			held := I.DevStatInfo{Dev: f.Dev, StatInfo: u.si}
+			if err := f.findIdenticalFiles(held, u.path); err != nil {
+				return err
+			}
+		} else if !f.seenSizes[di.Size] {
+			f.uniqueSizes[di.Size] = uniqueSizeFile{si: di.StatInfo, path: curPath}
+			return nil
+		}
+	}
+	return f.findIdenticalFiles(di, curPath)
+}
+
+// findIdenticalFiles searches for an inode with identical contents to the
+// given file, as described for FindIdenticalFiles().
+func (f *fsDev) findIdenticalFiles(di I.DevStatInfo, curPath P.Pathsplit) (err error) {
 	curPS := I.PathInfo{Pathsplit: curPath, StatInfo: di.StatInfo}
 	ino := di.StatInfo.Ino
 
-	if _, ok := f.inoStatInfo[ino]; !ok {
+	_, seenIno := f.inoStatInfo[ino]
+	if !seenIno {
 		f.Results.foundInode(di.StatInfo.Nlink)
+		f.Results.foundNlink(di.StatInfo.Nlink, f.MaxNLinks)
+	}
+
+	// Only record the existing links, without any content comparisons
+	o := f.Options
+	if o.ExistingLinksOnly {
+		if seenIno {
+			if f.InoPaths.HasPath(ino, curPath) {
+				return
+			}
+			f.addExistingLink(ino, curPath)
+		}
+		f.inoStatInfo[ino] = &di.StatInfo
+		f.InoPaths.AppendPath(ino, curPath)
+		return
 	}
 
 	// Compute a "hash" from inode stat info, and store it if new.  If it's
 	// a previously seen inode hash, check to see if one of the previously
 	// seen inodes with that hash also has identical file contents.
-	o := f.Options
-	H := I.HashIno(di.StatInfo, o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner)
+	H := I.HashIno(di.StatInfo, o.ignoresSize(), o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner)
 	if _, ok := f.inoHashes[H]; !ok {
 		// Setup for a newly seen hash value
 		f.Results.missedHash()
@@ -89,9 +162,7 @@ func (f *fsDev) FindIdenticalFiles(di I.DevStatInfo, pathname string) (err error
 			if f.InoPaths.HasPath(ino, curPath) {
 				return
 			}
-			seenPath := f.InoPaths.ArbitraryPath(ino)
-			seenSize := f.inoStatInfo[ino].Size
-			f.Results.foundExistingLink(seenPath, curPath, seenSize)
+			f.addExistingLink(ino, curPath)
 		}
 		// See if this inode is already one we've determined can be
 		// linked to another one, in which case we can avoid repeating
@@ -100,25 +171,32 @@ func (f *fsDev) FindIdenticalFiles(di I.DevStatInfo, pathname string) (err error
 		hi := f.inoHashes[H]
 		if !li.Overlaps(hi) {
 			// Get a list of previously seen inodes that may be linkable
-			cachedSeq, useDigest := f.cachedInos(H, curPS)
+			cachedSeq, useDigest, numSameDigest := f.cachedInos(H, curPS)
 
 			// Search the list of potential inodes, looking for a match
 			foundLinkable := false
+			startComparisons := f.Results.ComparisonCount
 			if len(cachedSeq) > 0 {
 				f.Results.searchedInoSeq()
 			}
-			for _, cachedIno := range cachedSeq {
+			for i, cachedIno := range cachedSeq {
 				f.Results.incInoSeqIterations()
 				cachedPS := f.PathInfoFromIno(cachedIno)
 
 				var areLinkable bool
 				areLinkable, err = f.areFilesLinkable(cachedPS, curPS, useDigest)
 				if areLinkable {
+					if i == 0 && numSameDigest > 0 {
+						f.Results.digestFirstHit()
+					}
 					f.LinkableInos.Add(cachedPS.Ino, ino)
 					foundLinkable = true
 					break
 				}
 			}
+			if len(cachedSeq) > 0 {
+				f.Results.searchedGroup(f.Results.ComparisonCount - startComparisons)
+			}
 
 			// Add hash to set if no match was found in current set
 			if !foundLinkable {
@@ -126,27 +204,258 @@ func (f *fsDev) FindIdenticalFiles(di I.DevStatInfo, pathname string) (err error
 				inoSet := f.inoHashes[H]
 				inoSet.Add(ino)
 			}
+		} else {
+			f.Results.skippedConsolidatedIno()
 		}
 	}
 	// Remember Inode and filename/path information for each seen file
 	f.inoStatInfo[ino] = &di.StatInfo
 	f.InoPaths.AppendPath(ino, curPath)
 
+	if err == nil && !seenIno && o.findsAdvisoryMatches() {
+		err = f.findAdvisoryMatch(curPS)
+	}
+
 	return
 }
 
+// uniqueSizeFile is a file held back by FindIdenticalFiles(), as no other file
+// of its size has been found.
+type uniqueSizeFile struct {
+	si   I.StatInfo
+	path P.Pathsplit
+}
+
+// holdsUniqueSizes returns true if the files of a unique size are held back
+// from the search for identical files.  They can't be when the sizes of
+// linkable files may differ, or when only existing links are searched.
+func (f *fsDev) holdsUniqueSizes() bool {
+	return !f.Options.ignoresSize() && !f.Options.ExistingLinksOnly
+}
+
+// addUniqueSizes adds the held back files whose size was unique among the
+// walked files, without searching for a match.  Must be called once the walk
+// is finished (ie. before the gathered groups are added).
+func (f *fsDev) addUniqueSizes() {
+	for size, u := range f.uniqueSizes {
+		f.Results.skippedUniqueSize()
+		f.addUnsearchedFile(u)
+		delete(f.uniqueSizes, size)
+	}
+}
+
+// addUnsearchedFile remembers the inode and path information of the file,
+// without searching for an identical file.
+func (f *fsDev) addUnsearchedFile(u uniqueSizeFile) {
+	si := u.si
+	f.Results.foundInode(si.Nlink)
+	f.Results.foundNlink(si.Nlink, f.MaxNLinks)
+	f.inoStatInfo[si.Ino] = &si
+	f.InoPaths.AppendPath(si.Ino, u.path)
+}
+
+// addExistingLink records the given pathname as an existing link to a
+// previously seen pathname of the inode.
+func (f *fsDev) addExistingLink(ino I.Ino, curPath P.Pathsplit) {
+	seenPath := f.InoPaths.ArbitraryPath(ino)
+	seenSize := f.inoStatInfo[ino].Size
+	f.Results.foundExistingLink(seenPath, curPath, seenSize, f.Dev, ino)
+	// Check each cluster once, when its first existing link is found
+	if f.Options.ReportExistingClusterAnomalies && f.InoPaths[ino].CountPaths() == 1 {
+		f.Results.checkExistingCluster(seenPath.Join(), f.inoStatInfo[ino])
+	}
+	if f.Options.OnExistingLink != nil {
+		f.Options.OnExistingLink(seenPath.Join(), curPath.Join(), seenSize)
+	}
+}
+
+// findAdvisoryMatch searches for a previously seen inode with equal content to
+// the given newly seen inode, ignoring all the inode parameters (time,
+// permission, ownership, and xattrs), and records any match in the advisory
+// LinkableInos.  These matches are only reported, never linked.
+func (f *fsDev) findAdvisoryMatch(ps I.PathInfo) error {
+	H := I.HashIno(ps.StatInfo, f.Options.ignoresSize(), true, true, true)
+	inoSet, ok := f.advInoHashes[H]
+	if !ok {
+		f.advInoHashes[H] = I.NewSet(ps.Ino)
+		return nil
+	}
+	for _, cachedIno := range inoSet.AsSlice() {
+		cachedPS := f.PathInfoFromIno(cachedIno)
+		eq, err := contentsEqual(f.status, cachedPS, ps)
+		if err != nil {
+			if !f.isNonFatalCmpErr(err) {
+				return err
+			}
+			continue
+		}
+		if eq {
+			f.advLinkableInos.Add(cachedIno, ps.Ino)
+			return nil
+		}
+	}
+	inoSet.Add(ps.Ino)
+	return nil
+}
+
+// addAdvisoryGroups stores in the Results the groups of content-equal inodes
+// which the normal matching rules will not fully link together.  Must be
+// called before generateLinks() moves paths between the inodes.
+func (f *fsDev) addAdvisoryGroups() {
+	for advSet := range f.advLinkableInos.All() {
+		inos := advSet.AsSlice()
+		if f.LinkableInos.Containing(inos[0]).HasAll(inos...) {
+			continue
+		}
+		group := make([]string, 0, len(inos))
+		for _, ino := range inos {
+			pathname := f.InoPaths.ArbitraryPath(ino).Join()
+			group = append(group, pathname)
+			f.Results.skippedInode(f.Dev, uint64(ino), pathname, UnlinkedInodeMismatch)
+		}
+		sort.Strings(group)
+		f.Results.foundAdvisoryGroup(group)
+	}
+}
+
+// addMtimeSpreadGroups stores in the Results the groups of content-equal
+// inodes which have differing modification times, along with those times.
+// Must be called before generateLinks() moves paths between the inodes.
+func (f *fsDev) addMtimeSpreadGroups() {
+	for advSet := range f.advLinkableInos.All() {
+		var g MtimeSpreadGroup
+		for _, ino := range advSet.AsSlice() {
+			g.Paths = append(g.Paths, f.InoPaths.ArbitraryPath(ino).Join())
+			mtim := f.inoStatInfo[ino].Mtim
+			seen := false
+			for _, t := range g.Mtimes {
+				if t.Equal(mtim) {
+					seen = true
+					break
+				}
+			}
+			if !seen {
+				g.Mtimes = append(g.Mtimes, mtim)
+			}
+		}
+		if len(g.Mtimes) < 2 {
+			continue
+		}
+		sort.Strings(g.Paths)
+		sort.Slice(g.Mtimes, func(i, j int) bool { return g.Mtimes[i].Before(g.Mtimes[j]) })
+		f.Results.foundMtimeSpreadGroup(g)
+	}
+}
+
+// addHashBuckets passes the pathnames of the walked inodes to the Results,
+// grouped by their inode hash, for debugging.  Must be called before
+// generateLinks() moves paths between the inodes.
+func (f *fsDev) addHashBuckets() {
+	o := f.Options
+	for ino, si := range f.inoStatInfo {
+		H := I.HashIno(*si, o.ignoresSize(), o.IgnoreTime, o.IgnorePerm, o.IgnoreOwner)
+		for _, p := range f.InoPaths[ino].PathsAsSlice() {
+			f.Results.foundHashBucketPath(uint64(H), p.Join())
+		}
+	}
+	for _, pathnames := range f.Results.HashBuckets {
+		sort.Strings(pathnames)
+	}
+}
+
+// addInodePaths stores the pathnames of each inode in the Results.  Must be
+// called after generateLinks(), so that the linked pathnames are included.
+func (f *fsDev) addInodePaths() {
+	for ino, fp := range f.InoPaths {
+		if fp.IsEmpty() {
+			continue
+		}
+		var pathnames []string
+		for _, p := range fp.PathsAsSlice() {
+			pathnames = append(pathnames, p.Join())
+		}
+		sort.Strings(pathnames)
+		f.Results.foundInodePaths(f.Dev, uint64(ino), pathnames)
+	}
+}
+
+// uniqueContentCount returns the number of distinct file contents, counting
+// each group of linkable inodes once.  Equal content inodes that can't be
+// linked (ie. due to mismatched inode parameters) are counted separately.
+// Must be called before generateLinks() removes the linked inodes.
+func (f *fsDev) uniqueContentCount() int64 {
+	n := int64(len(f.inoStatInfo))
+	for linkableSet := range f.LinkableInos.All() {
+		n -= int64(len(linkableSet) - 1)
+	}
+	return n
+}
+
+// addMostDuplicated passes the groups of identical files (the linkable inodes,
+// and inodes with existing links) to the Results, to find the group with the
+// most pathnames.  Only the groups at least as large as the largest found so
+// far have their pathnames gathered.  Must be called before generateLinks()
+// moves paths between the inodes.
+func (f *fsDev) addMostDuplicated() {
+	seen := I.NewSet()
+	found := func(inos []I.Ino) {
+		var n int
+		for _, ino := range inos {
+			n += f.InoPaths[ino].CountPaths()
+		}
+		if int64(n) < f.Results.MaxDuplicationCount {
+			return
+		}
+		pathnames := make([]string, 0, n)
+		for _, ino := range inos {
+			for _, p := range f.InoPaths[ino].PathsAsSlice() {
+				pathnames = append(pathnames, p.Join())
+			}
+		}
+		sort.Strings(pathnames)
+		f.Results.foundDuplicatedGroup(pathnames)
+	}
+	for linkableSet := range f.LinkableInos.All() {
+		inos := linkableSet.AsSlice()
+		for _, ino := range inos {
+			seen.Add(ino)
+		}
+		found(inos)
+	}
+	for ino, fp := range f.InoPaths {
+		if !seen.Has(ino) && fp.CountPaths() > 1 {
+			found([]I.Ino{ino})
+		}
+	}
+}
+
 // cachedInos returns a slice of inos that can be searched for equal contents.
+// When digests are used, it also returns the number of inos with matching
+// digests that were moved to the front of the slice.
 // Also return true if searching by file content digests was enabled (triggered
 // by the length of the search list for the given hash exceeding a threshold).
-func (f *fsDev) cachedInos(H I.Hash, ps I.PathInfo) ([]I.Ino, bool) {
+func (f *fsDev) cachedInos(H I.Hash, ps I.PathInfo) ([]I.Ino, bool, int) {
 	var cachedSeq []I.Ino
+	var numSameDigest int
 	cachedSet := f.inoHashes[H]
+	f.Results.foundInoBucket(len(cachedSet))
 	// If digest option is enabled, and cached inode lists are long enough,
-	// then use digests in the search.
+	// then use digests in the search.  Digests of zero padded (or newline
+	// terminated) files won't match, so they aren't used when trailing
+	// content is ignored.
 	thresh := f.Options.SearchThresh
-	useDigest := thresh >= 0 && len(cachedSet) > thresh
+	useDigest := thresh >= 0 && len(cachedSet) > thresh && !f.Options.ignoresSize() &&
+		f.Options.CustomEqual == nil
+	if useDigest && f.Options.MaxDigestFileSize > 0 && ps.Size > f.Options.MaxDigestFileSize {
+		f.Results.skippedDigestSize()
+		useDigest = false
+	}
 	if useDigest {
-		digest, err := I.ContentDigest(ps.Pathsplit.Join(), f.digestBuf)
+		start := time.Now()
+		digest, err := I.ContentDigest(ps.Pathsplit.Join(), f.digestBuf, f.Options.UseFadvise)
+		if f.Options.ProfileTiming {
+			f.Results.addIOTime(start)
+		}
 		if err == nil {
 			// With digests, we take the (potentially long) set of cached inodes (ie.
 			// those inodes that all have the same InoHash), and remove the inodes that
@@ -158,6 +467,8 @@ func (f *fsDev) cachedInos(H I.Hash, ps I.PathInfo) ([]I.Ino, bool) {
 			noDigests := cachedSet.Difference(f.InosWithDigest)
 			sameDigests := cachedSet.Intersection(f.InoDigests.GetInos(digest))
 			cachedSeq = append(sameDigests.AsSlice(), noDigests.AsSlice()...)
+			numSameDigest = len(sameDigests)
+			f.Results.digestReorderedInos(numSameDigest)
 		} else {
 			// Resort to the non-digest search upon error
 			cachedSeq = cachedSet.AsSlice()
@@ -166,7 +477,7 @@ func (f *fsDev) cachedInos(H I.Hash, ps I.PathInfo) ([]I.Ino, bool) {
 		cachedSeq = cachedSet.AsSlice()
 	}
 
-	return cachedSeq, useDigest
+	return cachedSeq, useDigest, numSameDigest
 }
 
 // Return a PathInfo for the given Ino, chosen from our stored path/stat data
@@ -184,7 +495,17 @@ func (f *fsDev) areFilesLinkable(pi1 I.PathInfo, pi2 I.PathInfo, useDigest bool)
 		return false, nil
 	}
 	if pi1.Size != pi2.Size {
-		return false, nil
+		if f.Options.IgnoreTrailingNewline {
+			if pi1.Size+1 != pi2.Size && pi2.Size+1 != pi1.Size {
+				return false, nil
+			}
+		} else if f.Options.IgnoreBOM {
+			if !isBOMSizeDiff(pi1.Size, pi2.Size) && !isBOMSizeDiff(pi2.Size, pi1.Size) {
+				return false, nil
+			}
+		} else if !f.Options.IgnoreTrailingZeros {
+			return false, nil
+		}
 	}
 	if !f.Options.IgnoreTime && !pi1.EqualTime(pi2) {
 		return false, nil
@@ -195,32 +516,69 @@ func (f *fsDev) areFilesLinkable(pi1 I.PathInfo, pi2 I.PathInfo, useDigest bool)
 	if !f.Options.IgnoreOwner && !pi1.EqualOwnership(pi2) {
 		return false, nil
 	}
-	if !f.Options.IgnoreXAttr {
-		if eq, _ := I.EqualXAttrs(pi1.Join(), pi2.Join()); !eq {
+	// The xattrs are read at most once for the pair, and reused for the
+	// mismatch stats.
+	var xattrsRead, eqX bool
+	var errX error
+	equalXAttrs := func() (bool, error) {
+		if !xattrsRead {
+			xattrsRead = true
+			f.Results.comparedXAttrs()
+			eqX, errX = I.EqualXAttrs(pi1.Join(), pi2.Join())
+		}
+		return eqX, errX
+	}
+	lazyXAttrs := f.Options.XAttrCompareMode == XAttrLazy
+	if f.Options.comparesXAttrs() && !lazyXAttrs {
+		if eq, _ := equalXAttrs(); !eq {
 			return false, nil
 		}
 	}
 
+	// Never link files with denied content
+	denied, err := f.anyDeniedDigest(pi1, pi2)
+	if err != nil {
+		if !f.isNonFatalCmpErr(err) {
+			return false, err
+		}
+		return false, nil
+	}
+	if denied {
+		return false, nil
+	}
+
 	// Compute digest for both files, since they will have to be read in
 	// anyway for comparison.
 	if useDigest {
+		start := time.Now()
 		if f.InoDigests.NewDigest(pi1, f.digestBuf) {
 			f.Results.computedDigest()
 		}
 		if f.InoDigests.NewDigest(pi2, f.digestBuf) {
 			f.Results.computedDigest()
 		}
+		if f.Options.ProfileTiming {
+			f.Results.addIOTime(start)
+		}
 	}
 
 	f.Results.didComparison()
-	eq, err := areFileContentsEqual(f.status, pi1.Join(), pi2.Join())
+	eq, err := contentsEqual(f.status, pi1, pi2)
 	if err != nil {
-		return false, err
+		if !f.isNonFatalCmpErr(err) {
+			return false, err
+		}
+		return false, nil
 	}
 
 	// If two equal files are found, determine if any of the ignored inode
 	// parameters would have precluded returning a true value, had they not
 	// been ignored (and record in the Results).
+	if eq && f.Options.comparesXAttrs() && lazyXAttrs {
+		if eqX, _ := equalXAttrs(); !eqX {
+			return false, nil
+		}
+	}
 	if eq {
 		f.Results.foundEqualFiles()
 
@@ -243,10 +601,13 @@ func (f *fsDev) areFilesLinkable(pi1 I.PathInfo, pi2 I.PathInfo, useDigest bool)
 			f.Results.addMismatchedGIDBytes(pi1.Size)
 			addMismatchTotalBytes = true
 		}
-		eqX, err := I.EqualXAttrs(pi1.Join(), pi2.Join())
-		if err == nil && !eqX {
-			f.Results.addMismatchedXAttrBytes(pi1.Size)
-			addMismatchTotalBytes = true
+		// In-memory files (from AnalyzeFiles) have no xattrs
+		if f.memContents == nil && f.Options.XAttrCompareMode != XAttrSkip {
+			eqX, err := equalXAttrs()
+			if err == nil && !eqX {
+				f.Results.addMismatchedXAttrBytes(pi1.Size)
+				addMismatchTotalBytes = true
+			}
 		}
 		if addMismatchTotalBytes {
 			f.Results.addMismatchedTotalBytes(pi1.Size)
@@ -254,3 +615,53 @@ func (f *fsDev) areFilesLinkable(pi1 I.PathInfo, pi2 I.PathInfo, useDigest bool)
 	}
 	return eq, nil
 }
+
+// anyDeniedDigest returns true if the content digest of any of the given
+// inodes is one of the DenyDigests.  The digests are computed once per inode,
+// and the denied inodes are recorded in the Results.
+func (f *fsDev) anyDeniedDigest(pis ...I.PathInfo) (bool, error) {
+	if len(f.deniedDigests) == 0 {
+		return false, nil
+	}
+	var anyDenied bool
+	for _, pi := range pis {
+		denied, ok := f.deniedInos[pi.Ino]
+		if !ok {
+			digest, err := f.contentSHA256(pi)
+			if err != nil {
+				return false, err
+			}
+			denied = f.deniedDigests[digest]
+			f.deniedInos[pi.Ino] = denied
+			if denied {
+				f.Results.skippedDeniedDigest()
+				f.Results.skippedInode(f.Dev, uint64(pi.Ino), pi.Join(), UnlinkedDeniedDigest)
+			}
+		}
+		anyDenied = anyDenied || denied
+	}
+	return anyDenied, nil
+}
+
+// contentSHA256 returns the hex encoded SHA-256 digest of the content of the
+// given file (which is in memory with AnalyzeFiles).
+func (f *fsDev) contentSHA256(pi I.PathInfo) (string, error) {
+	if f.memContents != nil {
+		sum := sha256.Sum256(f.memContents[pi.Join()])
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return contentSHA256(pi.Join())
+}
+
+// isNonFatalCmpErr returns true (and records the error) if the given file
+// comparison error should be treated as the files being unequal.
+func (f *fsDev) isNonFatalCmpErr(err error) bool {
+	if !f.Options.ComparisonErrorsAreNonFatal {
+		return false
+	}
+	f.Results.comparisonError()
+	if f.Options.DebugLevel > 0 {
+		log.Printf("\r%v  Treating as unequal...", err)
+	}
+	return true
+}
@@ -42,6 +42,10 @@ import (
 // struct
 type CLIOptions struct {
 	JSONOutputEnabled      bool
+	OneLineOutputEnabled   bool
+	CompatOutputEnabled    bool
+	DOTOutputEnabled       bool
+	CLIReportCurrent       bool
 	ProgressOutputDisabled bool
 	UseNewLinkDisabled     bool
 	CLIContentOnly         bool
@@ -50,8 +54,22 @@ type CLIOptions struct {
 	CLIFileIncludes        RegexArray
 	CLIFileExcludes        RegexArray
 	CLIDirExcludes         RegexArray
+	CLIMountExcludes       []string
+	CLISnapshotRoots       []string
+	CLISrcRoots            []string
+	CLIDstRoots            []string
 	CLISearchThresh        intN
+	CLIMaxDigestSize       uintN
+	CLIMaxFiles            intN
+	CLIInodeTarget         intN
+	CLIMinDuplicates       intN
+	CLIBucketWorkers       intN
+	CLIWalkWorkers         intN
+	CLIMaxComponents       intN
+	CLINlinkWarnMargin     uintN
+	CLIBlockSize           uintN
 	CLIDebugLevel          int
+	CLIAuditLogPath        string
 
 	// Verbosity controls the level of output when calling the output
 	// options.  Verbosity 0 prints a short summary of results (space
@@ -73,7 +91,20 @@ func (c CLIOptions) ToOptions() hardlinkable.Options {
 	o.FileIncludes = c.CLIFileIncludes.vals
 	o.FileExcludes = c.CLIFileExcludes.vals
 	o.DirExcludes = c.CLIDirExcludes.vals
+	o.ExcludeMountpoints = c.CLIMountExcludes
 	o.SearchThresh = c.CLISearchThresh.n
+	o.MaxDigestFileSize = c.CLIMaxDigestSize.n
+	o.MaxFiles = int64(c.CLIMaxFiles.n)
+	o.TargetInodeReduction = int64(c.CLIInodeTarget.n)
+	o.MinDuplicateCount = c.CLIMinDuplicates.n
+	o.SnapshotRoots = c.CLISnapshotRoots
+	o.SrcRoots = c.CLISrcRoots
+	o.DstRoots = c.CLIDstRoots
+	o.BucketWorkers = c.CLIBucketWorkers.n
+	o.WalkWorkers = c.CLIWalkWorkers.n
+	o.MaxPathComponents = c.CLIMaxComponents.n
+	o.NlinkWarnMargin = c.CLINlinkWarnMargin.n
+	o.BlockSize = c.CLIBlockSize.n
 	o.DebugLevel = uint(c.CLIDebugLevel)
 	if c.CLIContentOnly {
 		o.IgnoreTime = true
@@ -86,15 +117,18 @@ func (c CLIOptions) ToOptions() hardlinkable.Options {
 	if c.Verbosity > 0 {
 		o.ShowExtendedRunStats = true
 	}
-	if c.Verbosity > 1 || c.JSONOutputEnabled {
+	if c.Verbosity > 1 || c.JSONOutputEnabled || c.DOTOutputEnabled || o.NewLinksOutputPath != "" {
 		o.StoreNewLinkResults = true
 	}
-	if c.Verbosity > 2 || c.JSONOutputEnabled {
+	if c.Verbosity > 2 || c.JSONOutputEnabled || c.DOTOutputEnabled || o.ExistingLinksOutputPath != "" {
 		o.StoreExistingLinkResults = true
 	}
 	if c.LinkingEnabled {
 		c.CheckQuiescence = true
 	}
+	if c.CLIReportCurrent {
+		o.ExistingLinksOnly = true
+	}
 	return o
 }
 
@@ -179,6 +213,15 @@ func CLIRun(args []string, co CLIOptions) {
 	var err error
 
 	opts := co.ToOptions()
+	if co.CLIAuditLogPath != "" {
+		f, err := os.OpenFile(co.CLIAuditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts.AuditLog = f
+	}
 	if co.ProgressOutputDisabled {
 		results, err = hardlinkable.Run(args, opts)
 	} else {
@@ -214,14 +257,56 @@ func CLIRun(args []string, co CLIOptions) {
 	}
 
 	if results.Phase != hardlinkable.StartPhase {
+		if err := results.WriteLinkOutputFiles(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		if co.JSONOutputEnabled {
 			results.OutputJSONResults()
+		} else if co.CLIReportCurrent {
+			results.OutputCurrentlySaved()
+		} else if co.OneLineOutputEnabled {
+			fmt.Println(results.OneLineSummary())
+		} else if co.CompatOutputEnabled {
+			results.WriteCompatSummary(os.Stdout)
+		} else if co.DOTOutputEnabled {
+			results.WriteDOT(os.Stdout)
 		} else {
+			// Links written to their own output files are only
+			// also shown here at the verbosity that would store them
+			if co.ExistingLinksOutputPath != "" && co.Verbosity < 3 {
+				results.ExistingLinks = nil
+			}
+			if co.NewLinksOutputPath != "" && co.Verbosity < 2 {
+				results.LinkPaths = nil
+			}
 			results.OutputResults()
 		}
 	}
 }
 
+// CLIVerify runs hardlinkable.Verify on the given dirs and files, outputting
+// the files that could still be linked (and the existing links, when
+// verbose), and exits with a nonzero status if there are any (or if the run
+// failed).
+func CLIVerify(args []string, co CLIOptions) {
+	results, err := hardlinkable.Verify(args, co.ToOptions())
+	if co.JSONOutputEnabled {
+		results.OutputJSONResults()
+	} else {
+		if co.Verbosity > 0 {
+			results.OutputExistingLinks()
+			if len(results.ExistingLinks) > 0 && len(results.LinkPaths) > 0 {
+				fmt.Println("")
+			}
+		}
+		results.OutputNewLinks()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func init() {
 	co := CLIOptions{}
 
@@ -245,6 +330,14 @@ by hardlinking identical files.  It can also perform the linking.`,
 	flg.CountVarP(&co.Verbosity, "verbose", "v", "``Increase verbosity level (up to 3 times)")
 	flg.BoolVar(&co.ProgressOutputDisabled, "no-progress", false, "Disable progress output while processing")
 	flg.BoolVar(&co.JSONOutputEnabled, "json", false, "Output results as JSON")
+	flg.BoolVar(&co.OneLineOutputEnabled, "oneline", false, "Output a one line summary (ie. for cron emails)")
+	flg.BoolVar(&co.CompatOutputEnabled, "compat", false, "Output a summary like the util-linux hardlink tool")
+	flg.BoolVar(&co.DOTOutputEnabled, "dot", false, "Output the existing and new links as a Graphviz DOT graph")
+	flg.BoolVar(&co.SortOutputByPath, "sort-output", false, "Output the link groups sorted by pathname")
+	flg.BoolVar(&co.SummarizeExistingLinks, "summarize-existing", false, "Output only the existing link counts of each file")
+	flg.BoolVar(&co.CLIReportCurrent, "report-current", false, "Only report the space saved by existing links")
+	flg.BoolVar(&co.StoreInodeNumbers, "inode-numbers", false, "Add link groups with dev/inode numbers to JSON")
+	flg.BoolVar(&co.IncludeDigestInOutput, "group-digests", false, "Add link groups with content digests to JSON")
 
 	flg.BoolVar(&co.LinkingEnabled, "enable-linking", false, "Perform the actual linking (implies --quiescence)")
 
@@ -254,23 +347,116 @@ by hardlinking identical files.  It can also perform the linking.`,
 	flg.BoolVarP(&co.IgnoreOwner, "ignore-owner", "o", false, "File uid/gid need not match")
 	flg.BoolVarP(&co.IgnoreXAttr, "ignore-xattr", "x", false, "Xattrs need not match")
 	flg.BoolVarP(&co.CLIContentOnly, "content-only", "c", false, "Only file contents have to match (ie. -potx)")
+	flg.BoolVar(&co.AdvisoryContentGroups, "advisory", false, "Report equal files with mismatched inode params")
+	flg.BoolVar(&co.ReportSimilar, "similar", false, "Report similar (not identical) files (slow)")
+	flg.BoolVar(&co.IgnoreTrailingNewline, "ignore-newline", false, "Files differing only by a trailing newline can match")
+	flg.BoolVar(&co.KeepTrailingNewline, "keep-newline", false, "Keep the trailing newline form when linking")
+	flg.BoolVar(&co.IgnoreTrailingZeros, "ignore-trailing-zeros", false, "Files differing only by trailing zeros can match")
+	flg.BoolVar(&co.IgnoreBOM, "ignore-bom", false, "Files differing only by a leading byte-order mark can match")
 
 	co.CLIMinFileSize.n = hardlinkable.DefaultMinFileSize
 	flg.VarP(&co.CLIMinFileSize, "min-size", "s", "Minimum file size")
 	flg.VarP(&co.CLIMaxFileSize, "max-size", "S", "Maximum file size")
+	flg.VarP(&co.CLIMaxFiles, "max-files", "", "Stop walking after N files (0 means no limit)")
+	flg.VarP(&co.CLIInodeTarget, "inode-target", "", "Stop linking after removing N inodes (0 means no limit)")
+	flg.StringArrayVar(&co.CLISnapshotRoots, "snapshot", nil, "Snapshot `dir`(s) never used as link sources")
+	flg.StringArrayVar(&co.CLISrcRoots, "src-root", nil, "Only use files in `dir`(s) as link sources")
+	flg.StringArrayVar(&co.CLIDstRoots, "dst-root", nil, "Only replace files in `dir`(s) with links")
+	co.CLIMinDuplicates.n = hardlinkable.DefaultMinDuplicateCount
+	flg.VarP(&co.CLIMinDuplicates, "min-dups", "", "Only link groups of at least N identical files")
+	flg.StringVar(&co.ChangedSinceFile, "changed-since", "", "Only files modified since the mtime of marker `file`")
+	flg.DurationVar(&co.MinFileAge, "min-age", 0, "Minimum time since file modification (ie. 10m)")
+	flg.BoolVar(&co.OnlyBlockAligned, "block-aligned", false, "Only files whose size is a block size multiple")
+	flg.VarP(&co.CLIBlockSize, "block-size", "", "Block size for --block-aligned (0 uses the fs value)")
 
 	flg.VarP(&co.CLIFileIncludes, "include", "i", "Regex(es) used to include files (overrides excludes)")
 	flg.VarP(&co.CLIFileExcludes, "exclude", "e", "Regex(es) used to exclude files")
 	flg.VarP(&co.CLIDirExcludes, "exclude-dir", "E", "Regex(es) used to exclude dirs")
+	flg.StringArrayVar(&co.CLIMountExcludes, "exclude-mount", nil, "Mount point `dir`(s) to exclude")
+	flg.BoolVar(&co.UseIgnoreFiles, "ignore-files", false, "Exclude names matching .hardlinkignore file globs")
+	flg.BoolVar(&co.SkipVolatileFiles, "skip-volatile", false, "Skip editor swap, temp, and partial download files")
+	flg.VarP(&co.CLIMaxComponents, "max-components", "", "Skip files with over N pathname components")
+	flg.StringArrayVar(&co.DenyDigests, "deny-digest", nil, "Never link files with SHA-256 `digest`(s)")
+	flg.BoolVar(&co.ExplicitFilesBypassFilters, "explicit-files", false, "Given files bypass the size and regex filters")
+	flg.BoolVar(&co.StoreExcludedPaths, "show-excluded", false, "Output the excluded file and dir pathnames")
 	flg.CountVarP(&co.CLIDebugLevel, "debug", "d", "``Increase debugging level")
 
 	flg.BoolVar(&co.IgnoreWalkErrors, "ignore-walkerr", false, "Continue on file/dir read errs")
+	flg.BoolVar(&co.ComparisonErrorsAreNonFatal, "nonfatal-cmperr", false, "Treat file comparison errors as unequal files")
 	flg.BoolVar(&co.IgnoreLinkErrors, "ignore-linkerr", false, "Continue when linking fails")
+	flg.BoolVar(&co.ContinueOnGroupError, "continue-group-err", false, "Skip to the next group when linking a group fails")
+	flg.VarP(&co.CLINlinkWarnMargin, "nlink-warn-margin", "", "Report inodes within N links of the nlink limit")
 	flg.BoolVar(&co.CheckQuiescence, "quiescence", false, "Abort if filesystem is being modified")
+	flg.BoolVar(&co.WarnUnusualInodes, "warn-unusual", false, "Warn of linked special files and dirs")
 	flg.BoolVar(&co.UseNewLinkDisabled, "disable-newest", false, "Disable using newest link mtime/uid/gid")
+	flg.BoolVar(&co.PreserveAtime, "preserve-atime", false, "Restore the access time of linked inodes")
+	flg.BoolVar(&co.RecompareBeforeLink, "recompare", false, "Compare file contents again just before linking")
+	flg.BoolVar(&co.AssertPredictedSavings, "assert-savings", false, "Fail if linking didn't free the predicted space")
+	flg.Float64Var(&co.LinkRateLimit, "link-rate", 0, "Limit linking to N links per second (0 means no limit)")
+	flg.BoolVar(&co.CheckDirWritable, "check-writable", false, "Skip links in dirs that aren't writable")
+	flg.StringVar(&co.CanonicalStoreDir, "store-dir", "", "Also link each group into `dir`, named by content digest")
+	flg.BoolVar(&co.SkipOpenFiles, "skip-open", false, "Skip linking files open by other processes (Linux only)")
+	flg.StringVar(&co.ExistingLinksOutputPath, "existing-json", "", "Write the existing links as JSON to `file`")
+	flg.StringVar(&co.NewLinksOutputPath, "new-json", "", "Write the new links as JSON to `file`")
+	flg.StringVar(&co.DeviceJSONOutputDir, "per-device-json", "", "Write each device's links as JSON files in `dir`")
+	flg.StringVar(&co.LockFile, "lock-file", "", "Lock `file` while linking, to serialize concurrent runs")
+	flg.BoolVar(&co.LockWait, "lock-wait", false, "Wait for a held --lock-file instead of failing")
+	flg.StringVar(&co.RequireBackupMarker, "backup-marker", "", "Only link if each dir holds the backup marker file `name`")
+	flg.StringVar(&co.CLIAuditLogPath, "audit-log", "", "Append a line for each attempted link to `file`")
+	flg.StringVar(&co.SyslogTag, "syslog", "", "Send the run start, errors, and summary to syslog with `tag`")
+	flg.StringVar(&co.SyslogAddr, "syslog-addr", "", "Remote syslog server `addr` (ie. udp:loghost:514)")
+	flg.BoolVar(&co.UnsafeDirectLink, "unsafe-direct-link", false, "Remove and link each file directly (not atomic)")
+	flg.StringVar(&co.TempLinkPattern, "tmp-pattern", "", "Temp link pathname pattern (ie. '%s/.hl-%s')")
+	flg.BoolVar(&co.IONice, "ionice", false, "Use idle IO priority while running (Linux only)")
 
 	co.CLISearchThresh.n = hardlinkable.DefaultSearchThresh
 	flg.VarP(&co.CLISearchThresh, "search-thresh", "", "Ino search length before enabling digests")
+	flg.VarP(&co.CLIMaxDigestSize, "max-digest-size", "", "Don't use digests for files over size N")
+	flg.BoolVar(&co.QuickPrefixCompare, "quick-prefix", false, "Compare a short prefix before full comparison")
+	flg.BoolVar(&co.CompareTailFirst, "tail-first", false, "Compare a short tail before full comparison")
+	flg.VarP(&co.CLIBucketWorkers, "bucket-workers", "", "Compare files with N concurrent workers")
+	flg.VarP(&co.CLIWalkWorkers, "walk-workers", "", "Walk the given dirs with N concurrent workers")
+	flg.BoolVar(&co.StreamingLink, "streaming", false, "Link each size of files in turn, to bound memory use")
+	flg.BoolVar(&co.UseMmap, "mmap", false, "Use mmap to compare large files")
+	flg.BoolVar(&co.UseFadvise, "fadvise", false, "Advise the kernel of sequential, uncached file reads")
+	flg.BoolVar(&co.ProfileTiming, "profile-timing", false, "Measure the file IO and CPU time (with --debug)")
 
 	flg.SortFlags = false
+
+	vco := CLIOptions{}
+
+	// verifyCmd checks that a tree is (and remains) fully linked
+	verifyCmd := &cobra.Command{
+		Use:   "verify [OPTIONS] dir1 [dir2...] [files...]",
+		Short: "Check that no identical files remain unlinked",
+		Long: `Walk the given dirs (without linking) and report any identical files that
+could still be linked, exiting with a nonzero status if there are any.`,
+		Args: cobra.MinimumNArgs(1),
+		DisableFlagsInUseLine: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			CLIVerify(args, vco)
+		},
+	}
+
+	vflg := verifyCmd.Flags()
+
+	vflg.CountVarP(&vco.Verbosity, "verbose", "v", "``Also output the existing links")
+	vflg.BoolVar(&vco.JSONOutputEnabled, "json", false, "Output results as JSON")
+	vflg.BoolVarP(&vco.SameName, "same-name", "f", false, "Filenames need to be identical")
+	vflg.BoolVarP(&vco.IgnoreTime, "ignore-time", "t", false, "File modification times need not match")
+	vflg.BoolVarP(&vco.IgnorePerm, "ignore-perm", "p", false, "File permission (mode) need not match")
+	vflg.BoolVarP(&vco.IgnoreOwner, "ignore-owner", "o", false, "File uid/gid need not match")
+	vflg.BoolVarP(&vco.IgnoreXAttr, "ignore-xattr", "x", false, "Xattrs need not match")
+	vflg.BoolVarP(&vco.CLIContentOnly, "content-only", "c", false, "Only file contents have to match (ie. -potx)")
+	vco.CLIMinFileSize.n = hardlinkable.DefaultMinFileSize
+	vflg.VarP(&vco.CLIMinFileSize, "min-size", "s", "Minimum file size")
+	vflg.VarP(&vco.CLIMaxFileSize, "max-size", "S", "Maximum file size")
+	vflg.VarP(&vco.CLIFileIncludes, "include", "i", "Regex(es) used to include files (overrides excludes)")
+	vflg.VarP(&vco.CLIFileExcludes, "exclude", "e", "Regex(es) used to exclude files")
+	vflg.VarP(&vco.CLIDirExcludes, "exclude-dir", "E", "Regex(es) used to exclude dirs")
+	vco.CLIMinDuplicates.n = hardlinkable.DefaultMinDuplicateCount
+	vco.CLISearchThresh.n = hardlinkable.DefaultSearchThresh
+
+	vflg.SortFlags = false
+	rootCmd.AddCommand(verifyCmd)
 }
@@ -31,6 +31,7 @@ type Digest uint32
 type InoDigests struct {
 	InoSets        map[Digest]Set
 	InosWithDigest Set
+	Fadvise        bool // Passed to ContentDigest()
 }
 
 func NewInoDigests() InoDigests {
@@ -54,7 +55,7 @@ func (id *InoDigests) NewDigest(pi PathInfo, buf []byte) bool {
 	var computed bool
 	if !id.InosWithDigest.Has(pi.Ino) {
 		pathname := pi.Pathsplit.Join()
-		digest, err := ContentDigest(pathname, buf)
+		digest, err := ContentDigest(pathname, buf, id.Fadvise)
 		if err == nil {
 			digestHelper(id, pi, digest)
 			computed = true
@@ -78,13 +79,19 @@ func digestHelper(id *InoDigests, pi PathInfo, digest Digest) {
 // without doing a full comparison.  Typically this will be used when a full
 // file comparison will be performed anyway (incurring the IO overhead), and
 // saving the digest to help quickly reduce the set of possibly equal inodes
-// later (ie. reducing the length of the repeated linear searches).
-func ContentDigest(pathname string, buf []byte) (Digest, error) {
+// later (ie. reducing the length of the repeated linear searches).  With
+// fadvise, the kernel is advised of the sequential read, and that the read
+// pages aren't needed afterwards.
+func ContentDigest(pathname string, buf []byte, fadvise bool) (Digest, error) {
 	f, err := os.Open(pathname)
 	if err != nil {
 		return 0, err
 	}
 	defer f.Close()
+	if fadvise {
+		FadviseSequential(f)
+		defer FadviseDontNeed(f)
+	}
 
 	n, err := ReadChunk(f, buf)
 	if err != nil && err != io.EOF {
@@ -0,0 +1,54 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// +build amd64 arm64
+
+package inode
+
+import (
+	"os"
+	"syscall"
+)
+
+// Values from linux/fadvise.h
+const (
+	fadvSequential = 2
+	fadvDontNeed   = 4
+)
+
+func fadvise(f *os.File, advice uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, advice, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// FadviseSequential advises the kernel that the whole file will be read
+// sequentially, so that it can read ahead more aggressively.
+func FadviseSequential(f *os.File) error {
+	return fadvise(f, fadvSequential)
+}
+
+// FadviseDontNeed advises the kernel that the cached pages of the file won't
+// be needed again, so that a large scan doesn't evict more useful pages.
+func FadviseDontNeed(f *os.File) error {
+	return fadvise(f, fadvDontNeed)
+}
@@ -27,9 +27,12 @@ type InoHashes map[Hash]Set
 // HashIno produces an equal hash for potentially equal files, based only on
 // Inode metadata (size, time, etc.).  Content still has to be verified for
 // equality (but unequal hashes indicate files that definitely need not be
-// compared)
-func HashIno(si StatInfo, ignoreTime, ignorePerm, ignoreOwner bool) Hash {
-	h := uint64(si.Size)
+// compared).  With ignoreSize, files of differing sizes may also hash equal.
+func HashIno(si StatInfo, ignoreSize, ignoreTime, ignorePerm, ignoreOwner bool) Hash {
+	var h uint64
+	if !ignoreSize {
+		h = uint64(si.Size)
+	}
 	// The main requirement is that files that could be equal have equal
 	// hashes.  It's less important if unequal files also have the same
 	// hash value, since we will still compare the actual file content
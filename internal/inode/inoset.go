@@ -228,6 +228,14 @@ func (l LinkableInoSets) Containing(ino Ino) Set {
 // has completed, and moved to the link generation phase), no locking of
 // LinkableInoSets is required.
 func (l LinkableInoSets) All() <-chan Set {
+	return l.AllUntil(nil)
+}
+
+// AllUntil is All(), but closing the done channel stops the sending of the
+// InoSets (and closes the returned channel), so that the receiver can stop
+// iterating early without leaking the sending goroutine.  A nil done channel
+// never stops the sending.
+func (l LinkableInoSets) AllUntil(done <-chan struct{}) <-chan Set {
 	// Make a slice of the Ino keys in LinkableInoSets, so that we can sort
 	// them.  This allows us to output the full number of linkableInoSets
 	// in a deterministic order (leading to more repeatable ordering of
@@ -251,7 +259,11 @@ func (l LinkableInoSets) All() <-chan Set {
 			if seen.Has(startIno) {
 				continue
 			}
-			out <- linkableInoSetHelper(l, startIno, seen)
+			select {
+			case out <- linkableInoSetHelper(l, startIno, seen):
+			case <-done:
+				return
+			}
 		}
 	}()
 	return out
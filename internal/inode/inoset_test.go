@@ -278,3 +278,23 @@ func TestLinkableInoSets(t *testing.T) {
 		}
 	}
 }
+
+func TestLinkableInoSetsAllUntil(t *testing.T) {
+	l := make(LinkableInoSets)
+	for i := Ino(0); i < 20; i += 2 {
+		l.Add(i, i+1)
+	}
+
+	// Stopping early closes the channel, rather than sending all the sets
+	done := make(chan struct{})
+	c := l.AllUntil(done)
+	<-c
+	close(done)
+	n := 0
+	for range c {
+		n++
+	}
+	if n > 1 {
+		t.Errorf("Expected at most 1 InoSet after done was closed, got: %v", n)
+	}
+}
@@ -31,13 +31,15 @@ type InoStatInfo map[Ino]*StatInfo
 
 // os.FileInfo and syscall.Stat_t fields that we care about
 type StatInfo struct {
-	Size  uint64
-	Ino   Ino
-	Nlink uint64
-	Uid   uint32
-	Gid   uint32
-	Mode  os.FileMode
-	Mtim  time.Time
+	Size   uint64
+	Ino    Ino
+	Nlink  uint64
+	Uid    uint32
+	Gid    uint32
+	Mode   os.FileMode
+	Mtim   time.Time
+	Atim   time.Time
+	Blocks uint64 // Allocated 512-byte blocks
 }
 
 // We need the Dev value returned from stat, but it can be discarded when we
@@ -60,13 +62,15 @@ func LStatInfo(pathname string) (DevStatInfo, error) {
 	di := DevStatInfo{
 		Dev: uint64(stat_t.Dev),
 		StatInfo: StatInfo{
-			Size:  uint64(stat_t.Size),
-			Ino:   Ino(stat_t.Ino),
-			Nlink: uint64(stat_t.Nlink),
-			Uid:   uint32(stat_t.Uid),
-			Gid:   uint32(stat_t.Gid),
-			Mode:  fi.Mode(),
-			Mtim:  fi.ModTime(),
+			Size:   uint64(stat_t.Size),
+			Ino:    Ino(stat_t.Ino),
+			Nlink:  uint64(stat_t.Nlink),
+			Uid:    uint32(stat_t.Uid),
+			Gid:    uint32(stat_t.Gid),
+			Mode:   fi.Mode(),
+			Mtim:   fi.ModTime(),
+			Atim:   atime(stat_t),
+			Blocks: uint64(stat_t.Blocks),
 		},
 	}
 
@@ -20,29 +20,45 @@
 
 package pathpool
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // "Strings" are really headers with a backing store, so by storing and reusing
 // strings, we may be able to reuse the underlying backing store.
 type StringPool struct {
-	m map[string]string
+	savedBytes uint64 // Accessed atomically, so kept 64-bit aligned
+	m          map[string]string
 	sync.RWMutex
 }
 
 func NewPool() *StringPool {
+	return NewPoolSize(0)
+}
+
+// NewPoolSize returns a pool presized for (approx.) the given number of strings
+func NewPoolSize(hint int) *StringPool {
+	if hint < 0 {
+		hint = 0
+	}
 	return &StringPool{
-		m: make(map[string]string),
+		m: make(map[string]string, hint),
 	}
 }
 
 // Try to find and return a string in the pool map, and add it if it isn't
-// already there.  Not concurrency safe
+// already there.  Not concurrency safe.  A nil pool returns the given string.
 func (sp *StringPool) Intern(s string) string {
+	if sp == nil {
+		return s
+	}
 	sp.RLock()
 	r, ok := sp.m[s]
 	sp.RUnlock()
 
 	if ok {
+		atomic.AddUint64(&sp.savedBytes, uint64(len(r)))
 		return r
 	}
 
@@ -54,3 +70,15 @@ func (sp *StringPool) Intern(s string) string {
 	sp.Unlock()
 	return s
 }
+
+// Stats returns the number of strings in the pool, and the total bytes of the
+// interned strings which reused a string already in the pool.
+func (sp *StringPool) Stats() (count int, savedBytes uint64) {
+	if sp == nil {
+		return 0, 0
+	}
+	sp.RLock()
+	count = len(sp.m)
+	sp.RUnlock()
+	return count, atomic.LoadUint64(&sp.savedBytes)
+}
@@ -0,0 +1,144 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Values from linux/ioprio.h
+const (
+	ioprioClassShift = 13
+	ioprioClassIdle  = 3
+	ioprioWhoProcess = 1
+)
+
+// The previous IO priorities of the threads, while any Run has set the idle
+// priority (ie. overlapping Runs share the setting, and the last one to
+// finish restores the priorities).
+var ioPriority struct {
+	sync.Mutex
+	users int
+	prevs map[int]uintptr
+}
+
+// setIdleIOPriority sets the IO scheduling class of all the threads of the
+// process to "idle", so that the scan has less impact on interactive
+// workloads.  (The priority is per-thread, and threads created later inherit
+// it from their creator.)  Returns true if the priority was applied, and a
+// func that restores the previous priority of the threads, once no other Run
+// is using the idle priority.  Only the threads that existed when the idle
+// priority was set are restored.
+func setIdleIOPriority() (func(), bool, error) {
+	ioPriority.Lock()
+	defer ioPriority.Unlock()
+	if ioPriority.users == 0 {
+		prevs, err := setThreadsIdle()
+		if err != nil {
+			return func() {}, false, err
+		}
+		ioPriority.prevs = prevs
+	}
+	ioPriority.users++
+
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			ioPriority.Lock()
+			defer ioPriority.Unlock()
+			ioPriority.users--
+			if ioPriority.users == 0 {
+				restoreThreads(ioPriority.prevs)
+				ioPriority.prevs = nil
+			}
+		})
+	}
+	return restore, true, nil
+}
+
+// setThreadsIdle sets the idle IO priority of the current threads, and
+// returns their previous priorities by thread id.
+func setThreadsIdle() (map[int]uintptr, error) {
+	tids, err := threadIDs()
+	if err != nil {
+		return nil, err
+	}
+	prevs := make(map[int]uintptr)
+	idle := uintptr(ioprioClassIdle << ioprioClassShift)
+	for _, tid := range tids {
+		p, err := getIOPriority(tid)
+		if err != nil {
+			continue // The thread exited
+		}
+		if err := setIOPriority(tid, idle); err != nil {
+			restoreThreads(prevs)
+			return nil, err
+		}
+		prevs[tid] = p
+	}
+	return prevs, nil
+}
+
+// restoreThreads restores the given IO priorities of the threads (ignoring
+// those that have exited).
+func restoreThreads(prevs map[int]uintptr) {
+	for tid, p := range prevs {
+		setIOPriority(tid, p)
+	}
+}
+
+// getIOPriority returns the IO priority of the given thread (0 being the
+// calling thread)
+func getIOPriority(tid int) (uintptr, error) {
+	prio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return prio, nil
+}
+
+// setIOPriority sets the IO priority of the given thread (0 being the calling
+// thread)
+func setIOPriority(tid int, prio uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// threadIDs returns the ids of the threads of the process
+func threadIDs() ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	var tids []int
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
@@ -0,0 +1,109 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestRunIONice(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'IONice Run'"
+
+	m := pathContents{"d/f1": "X", "d/f2": "X"}
+	simpleFileMaker(t, m)
+
+	prev, err := getIOPriority(0)
+	if err != nil {
+		t.Skipf("Couldn't get the IO priority: %v", err)
+	}
+	if prev>>ioprioClassShift == ioprioClassIdle {
+		t.Skip("Already running with the idle IO priority")
+	}
+
+	tids, err := threadIDs()
+	if err != nil {
+		t.Fatalf("%v: Couldn't get the thread ids: %v", name, err)
+	}
+
+	// The walking threads have the idle IO priority
+	var walkPrios []uintptr
+	opts := SetupOptions(LinkingDisabled)
+	opts.IONice = true
+	opts.DirFilter = func(pathname string, info os.FileInfo) bool {
+		p, _ := getIOPriority(0)
+		walkPrios = append(walkPrios, p)
+		return true
+	}
+	simpleRun(name, t, opts, 1, ".")
+	if len(walkPrios) == 0 {
+		t.Fatalf("%v: Expected the DirFilter to be called", name)
+	}
+	for _, p := range walkPrios {
+		if p>>ioprioClassShift != ioprioClassIdle {
+			t.Errorf("%v: Expected idle IO priority while walking, got: %v", name, p)
+		}
+	}
+
+	// The previous priority of the threads is restored after the Run
+	for _, tid := range tids {
+		if p, err := getIOPriority(tid); err == nil && p>>ioprioClassShift == ioprioClassIdle {
+			t.Errorf("%v: Expected thread %v priority to be restored, got: %v", name, tid, p)
+		}
+	}
+}
+
+func TestIdleIOPriorityOverlapped(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	prev, err := getIOPriority(0)
+	if err != nil {
+		t.Skipf("Couldn't get the IO priority: %v", err)
+	}
+	if prev>>ioprioClassShift == ioprioClassIdle {
+		t.Skip("Already running with the idle IO priority")
+	}
+	isIdle := func() bool {
+		p, _ := getIOPriority(0)
+		return p>>ioprioClassShift == ioprioClassIdle
+	}
+
+	// Overlapping uses only restore the priority once both are finished
+	restore1, applied, err := setIdleIOPriority()
+	if !applied || err != nil {
+		t.Fatalf("Expected idle IO priority to be applied, got: %v, %v", applied, err)
+	}
+	restore2, _, _ := setIdleIOPriority()
+	restore1()
+	restore1()
+	if !isIdle() {
+		t.Errorf("Expected idle IO priority until the last use is restored")
+	}
+	restore2()
+	if p, _ := getIOPriority(0); p != prev {
+		t.Errorf("Expected restored IO priority %v, got: %v", prev, p)
+	}
+}
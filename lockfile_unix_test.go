@@ -0,0 +1,69 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package hardlinkable
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunLockFile(t *testing.T) {
+	topdir := setUp("LockFile", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+
+	lockPath := filepath.Join(topdir, "lock")
+	unlock, err := lockFile(lockPath, false)
+	if err != nil {
+		t.Fatalf("Couldn't acquire lock file: %v", err)
+	}
+
+	name := "testname: 'Lock File'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.LockFile = lockPath
+	start := time.Now()
+	result, err := Run([]string{"f1", "f2"}, opts)
+	if _, ok := err.(*ErrLockHeld); !ok {
+		t.Errorf("%v: Expected ErrLockHeld error, got: %v", name, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("%v: Run() didn't fail fast with a held lock", name)
+	}
+	if result.RunSuccessful || result.NewLinkCount != 0 {
+		t.Errorf("%v: Expected no linking with a held lock", name)
+	}
+	verifyInodeCounts(name, t, &result, 0, 0, 1, "f1", "f2")
+
+	// Once released, the run proceeds with linking
+	if err := unlock(); err != nil {
+		t.Fatalf("Couldn't release lock file: %v", err)
+	}
+	result, err = Run([]string{"f1", "f2"}, opts)
+	if err != nil || result.NewLinkCount != 1 {
+		t.Errorf("%v: Expected 1 new link after unlocking, got: %v %v", name, result.NewLinkCount, err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+)
+
+// The synthetic device and nlink limit of the files given to AnalyzeFiles
+const memDev = 0
+const memMaxNLinks = math.MaxUint32
+
+// FileDesc describes a file (its pathname, inode parameters and content) that
+// is given to AnalyzeFiles, in place of a file on a real filesystem.
+type FileDesc struct {
+	Path    string
+	Size    uint64
+	Mtime   time.Time
+	Mode    os.FileMode
+	Uid     uint32
+	Gid     uint32
+	Content []byte
+}
+
+// AnalyzeFiles performs the linkable file search and link generation of Run
+// on the given file descriptions, rather than on walked files, and returns
+// the Results.  Each FileDesc is treated as a separate inode on a single
+// device, and the Size must match the length of the Content.
+//
+// No filesystem access is performed, so linking is never enabled, content
+// digests and xattrs aren't used, and the walk options (such as the include
+// and exclude regexes) aren't applied.  ReportSimilar is not supported.
+func AnalyzeFiles(files []FileDesc, opts Options) (Results, error) {
+	opts.LinkingEnabled = false
+	opts.IgnoreXAttr = true
+	opts.CheckQuiescence = false
+	opts.CheckDirWritable = false
+	opts.SearchThresh = -1
+	opts.BucketWorkers = 0
+	ls := newLinkableState(&opts)
+
+	if err := opts.Validate(); err != nil {
+		return *ls.Results, err
+	}
+	if opts.ReportSimilar {
+		return *ls.Results, fmt.Errorf("ReportSimilar is not supported by AnalyzeFiles")
+	}
+
+	ls.Progress = &disabledProgress{}
+	defer ls.Progress.Done()
+
+	ls.memContents = make(map[string][]byte, len(files))
+	err := analyzeHelper(files, ls)
+	return *ls.Results, err
+}
+
+// analyzeHelper gathers the described files, in place of the walk phase of
+// runHelper, and completes the link generation.
+func analyzeHelper(files []FileDesc, ls *linkableState) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("AnalyzeFiles stopped early: %v ", r)
+		}
+	}()
+
+	ls.Results.start()
+	defer ls.Results.end()
+
+	ls.Results.Phase = WalkPhase
+	fsdev := newFSDev(ls.status, memDev, memMaxNLinks)
+	ls.fsDevs[memDev] = fsdev
+	for i, fd := range files {
+		pathname := path.Clean(fd.Path)
+		if _, ok := ls.memContents[pathname]; ok {
+			return fmt.Errorf("Duplicate FileDesc pathname: %v", pathname)
+		}
+		if fd.Size != uint64(len(fd.Content)) {
+			return fmt.Errorf("FileDesc '%v' Size %v doesn't match its content length %v",
+				pathname, fd.Size, len(fd.Content))
+		}
+
+		if ls.Options.MaxFiles > 0 && ls.Results.FileCount >= ls.Options.MaxFiles {
+			ls.Results.HitFileLimit = true
+			break
+		}
+
+		di := I.DevStatInfo{
+			Dev: memDev,
+			StatInfo: I.StatInfo{
+				Size:   fd.Size,
+				Ino:    I.Ino(i + 1),
+				Nlink:  1,
+				Uid:    fd.Uid,
+				Gid:    fd.Gid,
+				Mode:   fd.Mode,
+				Mtim:   fd.Mtime,
+				Blocks: (fd.Size + 511) / 512, // Unfragmented
+			},
+		}
+		ls.memContents[pathname] = fd.Content
+		if !isLinkCandidate(di, pathname, ls.Options, ls.Results, false) {
+			continue
+		}
+		ls.Results.foundFile()
+
+		if err := fsdev.FindIdenticalFiles(di, pathname); err != nil {
+			return err
+		}
+	}
+
+	return linkHelper(ls)
+}
+
+// memContentsEqual returns true if the given in-memory file contents are equal
+// (allowing for the ignored trailing content and byte-order mark Options).
+func memContentsEqual(s status, b1, b2 []byte) bool {
+	s.Results.addBytesCompared(uint64(len(b1) + len(b2)))
+	if s.Options.IgnoreBOM {
+		l1, l2 := bomLen(b1), bomLen(b2)
+		if l1 > 0 && l2 > 0 && !bytes.Equal(b1[:l1], b2[:l2]) {
+			return false
+		}
+		b1, b2 = b1[l1:], b2[l2:]
+	}
+	if bytes.Equal(b1, b2) {
+		return true
+	}
+	shortB, longB := b1, b2
+	if len(b1) > len(b2) {
+		shortB, longB = b2, b1
+	}
+	if !bytes.HasPrefix(longB, shortB) {
+		return false
+	}
+	trailing := longB[len(shortB):]
+	if s.Options.IgnoreTrailingZeros {
+		return len(bytes.Trim(trailing, "\x00")) == 0
+	}
+	if s.Options.IgnoreTrailingNewline {
+		return len(trailing) == 1 && trailing[0] == '\n'
+	}
+	return false
+}
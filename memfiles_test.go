@@ -0,0 +1,117 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeFiles(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	desc := func(pathname, content string, mtime time.Time, mode os.FileMode) FileDesc {
+		return FileDesc{
+			Path:    pathname,
+			Size:    uint64(len(content)),
+			Mtime:   mtime,
+			Mode:    mode,
+			Content: []byte(content),
+		}
+	}
+	files := []FileDesc{
+		desc("a/f1", "XXXX", now, 0644),
+		desc("b/f1", "XXXX", now, 0644),
+		desc("c/f1", "XXXX", now, 0644),
+		desc("d/f1", "XXXX", later, 0644),             // Differing mtime
+		desc("e/f1", "YYYY", now, 0644),               // Differing content
+		desc("f/f1", "XXXX", now, 0644|os.ModeSetuid), // Setuid
+	}
+
+	sortedLinkPaths := func(r Results) [][]string {
+		var groups [][]string
+		for _, l := range r.LinkPaths {
+			g := append([]string(nil), l...)
+			sort.Strings(g)
+			groups = append(groups, g)
+		}
+		return groups
+	}
+
+	opts := SetupOptions(LinkingEnabled)
+	r, err := AnalyzeFiles(files, opts)
+	if err != nil {
+		t.Fatalf("AnalyzeFiles() returned error: %v", err)
+	}
+	expected := [][]string{{"a/f1", "b/f1", "c/f1"}}
+	if got := sortedLinkPaths(r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected LinkPaths: %v, got: %v", expected, got)
+	}
+	if r.FileCount != 5 || r.InodeRemovedCount != 2 || r.InodeRemovedByteAmount != 8 {
+		t.Errorf("Expected 5 files and 2 removed inodes (8 bytes), got: %v, %v (%v bytes)",
+			r.FileCount, r.InodeRemovedCount, r.InodeRemovedByteAmount)
+	}
+	if r.RunStats.SkippedSetuidCount != 1 {
+		t.Errorf("Expected 1 skipped setuid file, got: %v", r.RunStats.SkippedSetuidCount)
+	}
+	if r.Opts.LinkingEnabled {
+		t.Errorf("Expected AnalyzeFiles() to never enable linking")
+	}
+
+	opts.IgnoreTime = true
+	r, err = AnalyzeFiles(files, opts)
+	if err != nil {
+		t.Fatalf("AnalyzeFiles() returned error: %v", err)
+	}
+	expected = [][]string{{"a/f1", "b/f1", "c/f1", "d/f1"}}
+	if got := sortedLinkPaths(r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected IgnoreTime LinkPaths: %v, got: %v", expected, got)
+	}
+
+	// Ignored trailing content is compared in memory
+	opts = SetupOptions()
+	opts.IgnoreTrailingNewline = true
+	r, err = AnalyzeFiles([]FileDesc{
+		desc("f1", "XXXX", now, 0644),
+		desc("f2", "XXXX\n", now, 0644),
+	}, opts)
+	if err != nil {
+		t.Fatalf("AnalyzeFiles() returned error: %v", err)
+	}
+	expected = [][]string{{"f1", "f2"}}
+	if got := sortedLinkPaths(r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected IgnoreTrailingNewline LinkPaths: %v, got: %v", expected, got)
+	}
+
+	// Invalid descriptions are rejected
+	bad := desc("f1", "XXXX", now, 0644)
+	bad.Size = 3
+	if _, err := AnalyzeFiles([]FileDesc{bad}, SetupOptions()); err == nil {
+		t.Errorf("Expected error for mismatched FileDesc Size")
+	}
+	dup := []FileDesc{desc("f1", "X", now, 0644), desc("./f1", "X", now, 0644)}
+	if _, err := AnalyzeFiles(dup, SetupOptions()); err == nil {
+		t.Errorf("Expected error for duplicate FileDesc pathnames")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"io"
+)
+
+type metric struct {
+	name  string
+	help  string
+	value interface{}
+}
+
+// WriteMetrics writes key RunStats values to w as Prometheus text-format
+// gauges, with metric names prefixed by the given namespace (if non-empty).
+// This allows monitoring tools to scrape the results of the last run.
+func (r *Results) WriteMetrics(w io.Writer, namespace string) error {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "_"
+	}
+	runSeconds := r.EndTime.Sub(r.StartTime).Seconds()
+	savedBytes := r.ExistingLinkByteAmount + r.InodeRemovedByteAmount
+	metrics := []metric{
+		{"dirs", "Number of directories walked.", r.DirCount},
+		{"files", "Number of files walked.", r.FileCount},
+		{"comparisons", "Number of file content comparisons.", r.ComparisonCount},
+		{"inodes", "Number of inodes encountered.", r.InodeCount},
+		{"inodes_removed", "Number of inodes removed (or removable) by linking.", r.InodeRemovedCount},
+		{"new_links", "Number of new links made (or possible).", r.NewLinkCount},
+		{"existing_links", "Number of existing links encountered.", r.ExistingLinkCount},
+		{"existing_link_bytes", "Bytes already saved by existing links.", r.ExistingLinkByteAmount},
+		{"inode_removed_bytes", "Additional bytes saved (or saveable) by linking.", r.InodeRemovedByteAmount},
+		{"saved_bytes", "Total bytes saved (or saveable).", savedBytes},
+		{"run_duration_seconds", "Duration of the run in seconds.", runSeconds},
+		{"run_successful", "1 if the run completed successfully, otherwise 0.", boolToInt(r.RunSuccessful)},
+	}
+	for _, m := range metrics {
+		name := prefix + m.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n",
+			name, m.help, name, name, m.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
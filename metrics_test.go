@@ -0,0 +1,80 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	r := newResults(&Options{})
+	r.FileCount = 10
+	r.InodeRemovedCount = 3
+	r.ExistingLinkByteAmount = 100
+	r.InodeRemovedByteAmount = 23
+	r.ComparisonCount = 7
+	r.StartTime = time.Unix(1000, 0)
+	r.EndTime = r.StartTime.Add(1500 * time.Millisecond)
+	r.RunSuccessful = true
+
+	var b bytes.Buffer
+	if err := r.WriteMetrics(&b, "hardlinkable"); err != nil {
+		t.Fatalf("WriteMetrics() returned error: %v", err)
+	}
+	out := b.String()
+
+	wants := []string{
+		"hardlinkable_files 10\n",
+		"hardlinkable_inodes_removed 3\n",
+		"hardlinkable_saved_bytes 123\n",
+		"hardlinkable_comparisons 7\n",
+		"hardlinkable_run_duration_seconds 1.5\n",
+		"hardlinkable_run_successful 1\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected metric output to contain %q, got:\n%v", want, out)
+		}
+	}
+
+	// Every sample must be preceded by well-formed HELP and TYPE lines
+	typeRE := regexp.MustCompile(`^# TYPE [a-zA-Z_:][a-zA-Z0-9_:]* gauge$`)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines)%3 != 0 {
+		t.Fatalf("Expected HELP, TYPE, and sample line triples, got:\n%v", out)
+	}
+	for i := 0; i < len(lines); i += 3 {
+		if !strings.HasPrefix(lines[i], "# HELP ") {
+			t.Errorf("Malformed HELP line: %q", lines[i])
+		}
+		if !typeRE.MatchString(lines[i+1]) {
+			t.Errorf("Malformed TYPE line: %q", lines[i+1])
+		}
+		name := strings.Fields(lines[i+1])[2]
+		if !strings.HasPrefix(lines[i+2], name+" ") {
+			t.Errorf("Sample line %q doesn't match TYPE name %q", lines[i+2], name)
+		}
+	}
+}
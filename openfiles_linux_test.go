@@ -0,0 +1,66 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestRunSkipOpenFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Skip Open Files'"
+
+	m := pathContents{"f1": "X", "f2": "X", "g1": "YY", "g2": "YY"}
+	simpleFileMaker(t, m)
+
+	// Hold f2 open in another process while linking
+	f, err := os.Open("f2")
+	if err != nil {
+		t.Fatalf("Couldn't open 'f2': %v", err)
+	}
+	defer f.Close()
+	cmd := exec.Command("sleep", "60")
+	cmd.Stdin = f
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Couldn't start 'sleep' process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	f.Close()
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.SkipOpenFiles = true
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"g1", "g2"})
+	if result.SkippedOpenFileCount != 1 {
+		t.Errorf("%v: Expected 1 skipped open file link, got: %v", name, result.SkippedOpenFileCount)
+	}
+	if nlinkVal("f1") != 1 || nlinkVal("f2") != 1 {
+		t.Errorf("%v: Expected open file 'f2' to not be linked", name)
+	}
+	verifyContents(name, t, m)
+}
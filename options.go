@@ -20,16 +20,69 @@
 
 package hardlinkable
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
 
 const DefaultSearchThresh = 1
 const DefaultMinFileSize = 1
 const DefaultUseNewestLink = true
+const DefaultMinDuplicateCount = 2
 const DefaultStoreExistingLinkResults = true // Non-cli default
 const DefaultStoreNewLinkResults = true      // Non-cli default
 const DefaultShowExtendedRunStats = false    // Non-cli default
 const DefaultShowRunStats = true             // Non-cli default
 
+// IgnoreFileName is the name of the per-directory files holding the patterns
+// of pathnames to exclude, when the UseIgnoreFiles option is enabled.
+const IgnoreFileName = ".hardlinkignore"
+
+// DefaultVolatilePatterns are the filename glob patterns of common editor and
+// download temporary files, which are skipped when the SkipVolatileFiles
+// option is enabled.  It can be extended before calling Run().
+var DefaultVolatilePatterns = []string{"*.swp", "*.tmp", "*~", ".#*", "*.part"}
+
+// SrcPathPreference selects which of a group of identical inodes is preferred
+// as the link source, keeping its inode (and metadata).
+type SrcPathPreference int
+
+const (
+	// MostLinked prefers the inode with the highest nlink count, which
+	// minimizes the number of links made (the default).
+	MostLinked SrcPathPreference = iota
+	// NewestMtime prefers the most recently modified inode
+	NewestMtime
+	// OldestMtime prefers the least recently modified inode
+	OldestMtime
+)
+
+// XAttrCompareMode selects when (and whether) the xattrs of a pair of files are
+// compared.
+type XAttrCompareMode int
+
+const (
+	// XAttrEager compares the xattrs before the file contents (the
+	// default), avoiding content comparisons of files that can't be
+	// linked.
+	XAttrEager XAttrCompareMode = iota
+	// XAttrLazy compares the xattrs only once the file contents are
+	// found to be equal, which avoids the xattr syscalls for the many
+	// unequal files of large buckets.
+	XAttrLazy
+	// XAttrSkip never reads the xattrs, so files are linked regardless
+	// of their xattrs (as with IgnoreXAttr), and no xattr mismatches
+	// are counted.
+	XAttrSkip
+)
+
 // Options is passed to the Run() func, and controls the operation of the
 // hardlinkable algorithm, including what inode parameters much match for files
 // to be compared for equality, what files and directories are included or
@@ -54,6 +107,10 @@ type Options struct {
 	// IgnoreXAttr enabled allows files with different xattrs can be linked
 	IgnoreXAttr bool
 
+	// XAttrCompareMode selects whether the xattrs are compared before or
+	// after the file contents, or not at all.
+	XAttrCompareMode XAttrCompareMode
+
 	// LinkingEnabled causes the Run to perform the linking step
 	LinkingEnabled bool
 
@@ -73,6 +130,11 @@ type Options struct {
 	// the more recent inode when files are linked.
 	UseNewestLink bool
 
+	// PreserveAtime enabled restores the access time of the src inode,
+	// after linking, to the value it had when walked (before it was read
+	// for comparisons).  Failures to restore are counted in the Results.
+	PreserveAtime bool
+
 	// FileIncludes is a slice of regex expressions that control what
 	// filenames will be considered for linking.  If given without any
 	// FileExcludes, the walked files must match one of the includes.  If
@@ -87,16 +149,139 @@ type Options struct {
 	// directories will be excluded from the file discovery walk.
 	DirExcludes []string
 
+	// UseIgnoreFiles enabled reads the IgnoreFileName file (if any) of
+	// each walked directory, and excludes the files and dirs in that
+	// subtree whose names match one of its glob patterns (one per line,
+	// with '#' starting a comment line).
+	UseIgnoreFiles bool
+
+	// SkipVolatileFiles enabled excludes files whose names match the
+	// DefaultVolatilePatterns (such as editor swap files and partial
+	// downloads), which are likely to be changed or removed soon.
+	SkipVolatileFiles bool
+
+	// MaxPathComponents, when greater than zero, excludes files whose
+	// pathname has more than the given number of components (ie. the
+	// filename and its dirnames), such as in pathologically deep trees.
+	// The directories are still walked.
+	MaxPathComponents int
+
+	// DenyDigests are the hex SHA-256 content digests of files that
+	// should never be linked (such as known sensitive files).  The
+	// digests are only computed for files that would otherwise be
+	// compared for linking.
+	DenyDigests []string
+
+	// ExplicitFilesBypassFilters enabled allows the files given explicitly
+	// to Run() (rather than found by walking a directory) to be considered
+	// for linking regardless of the file size limits and the
+	// include/exclude regexes.
+	ExplicitFilesBypassFilters bool
+
+	// ExcludeMountpoints is a slice of directory pathnames which are
+	// skipped during the walk, when they are found to be mount points
+	// (ie. on a different device than their parent directory).
+	ExcludeMountpoints []string
+
 	// StoreExistingLinkResults allows controlling whether to store
 	// discovered existing links in Results. Command line option Verbosity
 	// > 2 can override.
 	StoreExistingLinkResults bool
 
+	// SummarizeExistingLinks enabled stores just the count of existing
+	// links (and the bytes they save) for each linked src pathname, in
+	// Results.ExistingLinkSummaries, rather than every existing link
+	// pathname.  This bounds the memory and output for trees with very
+	// many existing links.  Only used with StoreExistingLinkResults.
+	SummarizeExistingLinks bool
+
+	// ReportExistingClusterAnomalies enabled checks the inode of each
+	// cluster of existing links against the ExpectedClusterPerm,
+	// ExpectedClusterUid, and ExpectedClusterGid values, and records the
+	// clusters that don't match in Results.ExistingClusterAnomalies.
+	// The clusters are only reported, never changed.
+	ReportExistingClusterAnomalies bool
+
+	// ExpectedClusterPerm is the permission bits that existing link
+	// clusters are expected to have.  Zero means the permission bits
+	// aren't checked.
+	ExpectedClusterPerm os.FileMode
+
+	// ExpectedClusterUid and ExpectedClusterGid are the owner and group
+	// ids that existing link clusters are expected to have, when
+	// CheckClusterUid and CheckClusterGid (respectively) are enabled.
+	CheckClusterUid    bool
+	ExpectedClusterUid uint32
+	CheckClusterGid    bool
+	ExpectedClusterGid uint32
+
+	// StoreInodeNumbers enabled stores the groups of linked (and linkable)
+	// pathnames in Results.LinkGroups, along with the device and inode
+	// numbers of their source inode.
+	StoreInodeNumbers bool
+
+	// IncludeDigestInOutput enabled adds the SHA-256 digest of the content
+	// to each group of newly linked (or linkable) pathnames, so that the
+	// groups can be correlated across runs and hosts.  The groups are
+	// stored in Results.LinkGroups (as with StoreInodeNumbers).
+	IncludeDigestInOutput bool
+
+	// StoreUnlinkedReasons enabled records the inodes which were
+	// candidates for linking, but which weren't linked, along with the
+	// reason, for Results.UnlinkedInodes().
+	StoreUnlinkedReasons bool
+
+	// StoreInodePaths enabled keeps the pathnames of each walked inode (as
+	// they are after linking), for Results.PathsForInode().
+	StoreInodePaths bool
+
+	// DisablePathPool disables the interning of the dir and file names of
+	// the walked pathnames, which can reduce overhead for one-shot runs,
+	// at the cost of more memory when there are many pathnames.
+	DisablePathPool bool
+
+	// PathPoolHint presizes the pathname interning pool for the given
+	// number of strings (dirnames and filenames), for large runs.
+	PathPoolHint int
+
+	// ExpectedFileCount, when greater than zero, is an estimate of the
+	// number of files to be walked, which is used to presize the inode
+	// maps and the Results link groups.  This can reduce reallocations
+	// for very large trees, but has no effect on the results.
+	ExpectedFileCount int64
+
 	// StoreNewLinkResults allows controlling whether to store discovered
 	// new hardlinkable pathnames in Results. Command line option Verbosity
 	// > 1 can override.
 	StoreNewLinkResults bool
 
+	// StoreLinkPlan enabled stores the sequence of links of a dry run (and
+	// the inode information of their files) in the Results.LinkPlan, so
+	// that they can be performed later with Results.Apply().
+	StoreLinkPlan bool
+
+	// SortOutputByPath enabled outputs the existing and new link groups
+	// sorted by their src pathnames (and their dst pathnames also
+	// sorted), rather than in the order they were found, so that the
+	// output of separate runs can be easily compared.
+	SortOutputByPath bool
+
+	// ExistingLinksOutputPath, when not empty, is the pathname that
+	// Results.WriteLinkOutputFiles writes the existing links to (as
+	// JSON).  Requires StoreExistingLinkResults.
+	ExistingLinksOutputPath string
+
+	// NewLinksOutputPath, when not empty, is the pathname that
+	// Results.WriteLinkOutputFiles writes the new (or linkable) links to
+	// (as JSON).  Requires StoreNewLinkResults.
+	NewLinksOutputPath string
+
+	// DeviceJSONOutputDir, when not empty, is the directory that
+	// Results.WriteLinkOutputFiles writes a JSON file of the links and
+	// main stats of each device to (named by the device number), which
+	// are gathered in Results.DeviceResults.
+	DeviceJSONOutputDir string
+
 	// ShowExtendedRunStats enabled displays additional Result stats
 	// output.  Command line option Verbosity > 0 can override.
 	ShowExtendedRunStats bool
@@ -109,10 +294,21 @@ type Options struct {
 	// being unable to read a file for comparision.
 	IgnoreWalkErrors bool
 
+	// ComparisonErrorsAreNonFatal treats an error while comparing the
+	// contents of two files (such as a read error) as the files being
+	// unequal, so that they aren't linked, rather than as a walk error.
+	ComparisonErrorsAreNonFatal bool
+
 	// IgnoreLinkErrors allows Run to continue when linking fails (or any
 	// errors during the Link phase)
 	IgnoreLinkErrors bool
 
+	// ContinueOnGroupError allows Run to continue with the remaining
+	// linkable groups when linking a group of identical files fails.  The
+	// rest of the failed group is not linked, and its pathnames are stored
+	// in the Results FailedGroups.
+	ContinueOnGroupError bool
+
 	// CheckQuiescence enabled looks for signs of the filesystems changing
 	// during walk.  Always enabled when LinkingEnabled is true.
 	CheckQuiescence bool
@@ -125,6 +321,338 @@ type Options struct {
 	// amount of memory, but potentially at greatly increased runtime in
 	// worst case scenarios with many, many files.
 	SearchThresh int
+
+	// MaxDigestFileSize, when greater than zero, disables the use of
+	// digests for files larger than the given size, which are instead
+	// only searched for by content comparison (regardless of the
+	// SearchThresh).
+	MaxDigestFileSize uint64
+
+	// QuickPrefixCompare enabled compares a small prefix of larger files
+	// before the full content comparison, so that files differing near
+	// their start can be rejected with minimal IO.
+	QuickPrefixCompare bool
+
+	// CompareTailFirst enabled compares a small chunk at the end of
+	// larger files before the full content comparison, so that files
+	// sharing a long prefix (such as logs), but differing at their end,
+	// can be rejected with minimal IO.
+	CompareTailFirst bool
+
+	// StoreExcludedPaths enabled records the pathnames of the files and
+	// dirs excluded by the include/exclude regexes in Results, which can
+	// help with debugging those rules.
+	StoreExcludedPaths bool
+
+	// IgnoreTrailingZeros enabled allows files of differing sizes to be
+	// linked, when their content matches up to the length of the shorter
+	// file, and the remainder of the longer file is all zero bytes (such
+	// as padded disk images).  Note that linking will change the content
+	// length of one of the pathnames, so only enable when that is
+	// acceptable.
+	IgnoreTrailingZeros bool
+
+	// IgnoreTrailingNewline enabled allows files to be linked when the
+	// content of one is exactly the content of the other plus a single
+	// trailing newline ('\n').  Only one form of the content can be kept
+	// when linking, which is chosen by KeepTrailingNewline.
+	IgnoreTrailingNewline bool
+
+	// KeepTrailingNewline chooses the form of the content that is kept
+	// when linking with IgnoreTrailingNewline.  When enabled, the files
+	// without the trailing newline are linked to the files with it,
+	// otherwise the files with the newline are linked to those without.
+	KeepTrailingNewline bool
+
+	// IgnoreBOM enabled allows files to be linked when the content of one
+	// is exactly the content of the other preceded by a byte-order mark
+	// (UTF-8, or UTF-16 big or little endian).  The form of the content
+	// without the byte-order mark is kept when linking.
+	IgnoreBOM bool
+
+	// IONice enabled lowers the IO scheduling priority of the process to
+	// the "idle" class during the Run (Linux only), to reduce the impact on
+	// other workloads.
+	IONice bool
+
+	// MinFileAge excludes files that were modified more recently than the
+	// given duration before the start of the Run, to avoid linking files
+	// that may still be actively written.
+	MinFileAge time.Duration
+
+	// OnlyBlockAligned enabled excludes the files whose size isn't a
+	// multiple of the block size, for block-level deduplication setups.
+	OnlyBlockAligned bool
+
+	// BlockSize is the block size used by OnlyBlockAligned.  When zero,
+	// the block size of each device's filesystem is used (and files of
+	// a device whose block size can't be determined aren't excluded).
+	BlockSize uint64
+
+	// RecompareBeforeLink enabled compares the contents of the src and
+	// dst files again immediately before linking them, and skips (and
+	// counts) the links whose contents no longer match.  This is a
+	// stronger check than the stat comparison of CheckQuiescence, at the
+	// cost of reading the files again.  Only used with LinkingEnabled.
+	RecompareBeforeLink bool
+
+	// AssertPredictedSavings enabled checks, after linking, that the
+	// inodes predicted to be removed were actually freed (ie. all their
+	// names were linked away, and the inodes their linked pathnames now
+	// refer to have the expected nlink), and returns an
+	// *ErrPredictedSavings error from Run (or Apply) if the freed bytes
+	// diverge from the InodeRemovedByteAmount.  This guards against bugs in the link
+	// generation, at the cost of remembering and re-statting the linked
+	// pathnames.  Only used with LinkingEnabled.
+	AssertPredictedSavings bool
+
+	// LinkRateLimit, when greater than zero, is the maximum number of links
+	// made per second, to reduce the load spikes on shared filesystems
+	// (such as a NAS).  Only used with LinkingEnabled.
+	LinkRateLimit float64
+
+	// ChangedSinceFile, when not empty, is the pathname of a marker file.
+	// Only the files modified at or after the marker file's mtime are
+	// considered for linking, which allows incremental runs (by touching
+	// the marker after each run).  Note that the unchanged files are not
+	// linked to, even if the changed files are equal to them.
+	ChangedSinceFile string
+
+	// CheckDirWritable enabled skips linking pathnames when either the src
+	// or dst directory isn't writable, rather than failing when linking.
+	// The skipped links are counted in the Results.
+	CheckDirWritable bool
+
+	// SkipOpenFiles enabled skips linking files that are held open by other
+	// processes when the Link phase starts, to reduce the chance of racing
+	// with active writers.  The skipped links are counted in the Results.
+	// Linux only (found from /proc), and ignored elsewhere.
+	SkipOpenFiles bool
+
+	// CanonicalStoreDir, when set, is a directory on the same device as
+	// the linked files, into which each linked group of identical files
+	// is also linked, named by the SHA-256 digest of its content.  Groups
+	// whose store file already exists (ie. from a previous run) are
+	// relinked to it, making a simple content-addressable store.  Only
+	// used with LinkingEnabled.
+	CanonicalStoreDir string
+
+	// LockFile, when not empty, is the pathname of a file which is
+	// exclusively locked (with an advisory flock()) while linking, so that
+	// concurrent runs using the same LockFile don't link at the same time.
+	// The lock is only taken when LinkingEnabled is set.
+	LockFile string
+
+	// LockWait enabled waits for a held LockFile to be released, rather
+	// than failing with an ErrLockHeld error.
+	LockWait bool
+
+	// RequireBackupMarker, when not empty, is the name of a file which
+	// must exist in each of the given top-level dirs (and the dirs of the
+	// given files) for linking to proceed, as a token that a backup
+	// exists.  Otherwise Run returns an ErrNoBackupMarker error before
+	// walking.  Only used with LinkingEnabled.
+	RequireBackupMarker string
+
+	// OnExistingLink, when not nil, is called during the walk for each
+	// pathname found to be an existing link to a previously walked
+	// pathname (src), along with the file size.
+	OnExistingLink func(src, dst string, size uint64) `json:"-"`
+
+	// DirFilter, when not nil, is called during the walk for each
+	// directory found below the given dirs, with its Lstat() info.
+	// Returning false excludes the directory (and its subtree) from the
+	// walk, as with DirExcludes.  It may be called concurrently with
+	// WalkWorkers.
+	DirFilter func(pathname string, info os.FileInfo) bool `json:"-"`
+
+	// Now, when not nil, is called instead of time.Now() for the run's
+	// start and end times (and so the MinFileAge window and RunTime),
+	// the progress output, and the AuditLog timestamps.  This allows
+	// deterministic tests.  The link pacing and IO profiling durations
+	// still use the real clock.
+	Now func() time.Time `json:"-"`
+
+	// CustomEqual, when not nil, is called to decide if two files (of
+	// compatible size and inode parameters) are equal, instead of
+	// comparing their contents, which allows domain-specific equality.
+	// Content digests aren't used to narrow the search when it is set.
+	// It may be called concurrently with BucketWorkers.
+	CustomEqual func(a, b FileMetaContentAccessor) (bool, error) `json:"-"`
+
+	// AuditLog, when not nil, is written a line for every attempted link
+	// (successful or failed), with the time, the src and dst pathnames, and
+	// the file size.  Lines are written (and flushed, if the Writer has a
+	// Flush method) as the links are made.
+	AuditLog io.Writer `json:"-"`
+
+	// SyslogTag, when not empty, sends the start of the Run(), any error
+	// that stops it, and its one line summary to syslog with the given
+	// tag.  Only supported on Unix platforms.
+	SyslogTag string
+
+	// SyslogPriority is the log/syslog Priority (facility and severity)
+	// used with SyslogTag.  Zero uses LOG_USER|LOG_INFO.
+	SyslogPriority int
+
+	// SyslogAddr is the remote syslog server used with SyslogTag, as
+	// "network:address" (ie. "udp:loghost:514").  If empty, the local
+	// syslog server is used.
+	SyslogAddr string
+
+	// Rand, when not nil, is the random source used for the temporary
+	// link pathnames (such as a seeded source, for reproducible names).
+	// Otherwise, each Run uses its own internally seeded source.  A Rand
+	// is not safe for concurrent use, so shouldn't be shared by concurrent
+	// Runs.
+	Rand *rand.Rand `json:"-"`
+
+	// TempLinkPattern controls the temporary pathname used when linking,
+	// before it is renamed to the destination pathname.  It must contain
+	// two '%s' verbs, the first is replaced with the destination dirname
+	// and the second with a random token (ie. "%s/.hardlinkable-%s").  The
+	// temporary pathname must be in the destination dir.  When empty, the
+	// destination pathname with a ".tmp" suffix and random token is used.
+	TempLinkPattern string
+
+	// UnsafeDirectLink enabled replaces each destination pathname by
+	// removing it and then linking it directly to the source, rather than
+	// linking to a temporary pathname and renaming it over the
+	// destination.  This saves a directory operation per link, but is not
+	// atomic: if the process crashes (or the link fails) after the
+	// removal, the destination pathname is lost.  Use with caution.
+	UnsafeDirectLink bool
+
+	// NlinkWarnMargin, when greater than zero, records the inodes whose
+	// nlink count after linking is within the given margin of the
+	// filesystem's maximum nlink count in Results.NearNlinkLimitInodes,
+	// as a warning before the limit starts splitting the linked groups.
+	NlinkWarnMargin uint64
+
+	// MaxFiles limits the number of files that are considered for
+	// linking.  When reached, the walk is stopped and linking proceeds
+	// with the files gathered so far.  Zero means no limit.
+	MaxFiles int64
+
+	// TargetInodeReduction stops generating links once the given number
+	// of inodes have been removed (or would be removed), leaving the
+	// remaining linkable files unlinked.  Zero means no target.
+	TargetInodeReduction int64
+
+	// SrcPathPreference selects which inode of a group of identical files
+	// is preferred as the link source, when the inodes have differing
+	// modification times (with IgnoreTime).  The SnapshotRoots take
+	// precedence over the preference.
+	SrcPathPreference SrcPathPreference
+
+	// SnapshotRoots is a slice of walked directory pathnames which are
+	// snapshots (copies) of the other walked directories.  When linking,
+	// the inodes with pathnames outside of the SnapshotRoots are used as
+	// the link sources, so that the "live" files keep their inodes.  The
+	// roots must be given in the same form (relative or absolute) as the
+	// walked directories.
+	SnapshotRoots []string
+
+	// SrcRoots and DstRoots restrict linking to one direction.  When
+	// SrcRoots is set, only inodes with a pathname within one of the
+	// SrcRoots are used as link sources, and when DstRoots is set, only
+	// pathnames within one of the DstRoots are replaced by links.  Giving
+	// both allows the files of one tree to be linked to the identical
+	// files of another tree, without modifying the first.  Like the
+	// SnapshotRoots, the roots must be given in the same form as the
+	// walked directories.
+	SrcRoots []string
+	DstRoots []string
+
+	// MinDuplicateCount is the minimum number of pathnames a group of
+	// identical files must have to be linked.  Smaller groups are left
+	// unlinked, and counted in the Results.  Values of 2 or less link all
+	// the groups.
+	MinDuplicateCount int
+
+	// BucketWorkers, when greater than one, is the number of goroutines
+	// used to compare files concurrently.  Files are distributed to the
+	// workers by their inode hash bucket (files which could be linked are
+	// always in the same bucket), and each bucket is processed serially.
+	// Not used with AdvisoryContentGroups, which compares across buckets.
+	BucketWorkers int
+
+	// WalkWorkers, when greater than one, is the number of goroutines
+	// used to walk the given directories concurrently (each directory is
+	// walked by a single goroutine).  Can help on network filesystems
+	// with high latency, but many concurrent directory reads may also
+	// hurt, so keep it modest.
+	WalkWorkers int
+
+	// StreamingLink enabled bounds the memory used for very large trees.
+	// A preliminary walk counts the files of each size (per device), and
+	// then during the main walk, each class of equal size files is linked
+	// (and its inode maps freed) as soon as its last file is walked,
+	// rather than holding the inodes of all the sizes until the walk
+	// completes.  Only the per size counts are kept for the whole walk.
+	// It can't be combined with the options that match files of differing
+	// sizes (IgnoreTrailingZeros, IgnoreTrailingNewline, IgnoreBOM, and
+	// ReportSimilar), or with BucketWorkers.
+	StreamingLink bool
+
+	// UseMmap enabled compares the contents of large files by mmapping
+	// them, rather than with repeated reads, which can improve throughput
+	// for very large equal files.  Falls back to read comparisons for
+	// smaller files, or if mmap fails.
+	UseMmap bool
+
+	// UseFadvise enabled advises the kernel (on Linux) that the compared
+	// and digested files are read sequentially, and that their cached
+	// pages aren't needed afterwards, which can reduce the page cache
+	// churn of large scans.  It has no effect on other platforms.
+	UseFadvise bool
+
+	// ProfileTiming enabled measures the time spent reading files (for
+	// content comparisons and digests), and the remaining run time, in
+	// RunStats.IOTimeMillis and CPUTimeMillis.  The IO time of the
+	// BucketWorkers is summed.
+	ProfileTiming bool
+
+	// ExistingLinksOnly enabled only finds the existing links between the
+	// walked pathnames (and the space they currently save), without
+	// reading any file contents or generating new links.  It cannot be
+	// combined with LinkingEnabled, AdvisoryContentGroups,
+	// ReportMtimeSpread, or ReportSimilar.
+	ExistingLinksOnly bool
+
+	// AdvisoryContentGroups enabled also finds groups of files with equal
+	// content, regardless of their inode parameters (time, permission,
+	// ownership, and xattrs), and reports the groups that would not
+	// otherwise be fully linked in Results.AdvisoryGroups.  These are
+	// never linked, but can indicate which Ignore options would help.
+	AdvisoryContentGroups bool
+
+	// ReportMtimeSpread enabled finds groups of files with equal content
+	// (in the same way as AdvisoryContentGroups), and reports the groups
+	// with differing modification times in Results.MtimeSpreadGroups,
+	// to help decide whether IgnoreTime is warranted.
+	ReportMtimeSpread bool
+
+	// ReportFragmentationRisk enabled checks the allocated blocks of the
+	// linked (or linkable) files against their sizes, and lists the files
+	// whose allocation is far from their size (such as heavily fragmented
+	// or preallocated files on copy-on-write filesystems, or sparse
+	// files) in Results.FragmentationRiskPaths.  This is advisory only,
+	// and doesn't prevent linking.
+	ReportFragmentationRisk bool
+
+	// ReportSimilar enabled finds files which share substantial content
+	// (such as shifted or partially modified copies), but which aren't
+	// identical, and reports them in Results.SimilarGroups.  These files
+	// are never linked.  All the files are read an additional time, so
+	// this can greatly increase the run time.
+	ReportSimilar bool
+
+	// WarnUnusualInodes enabled checks the non-regular files encountered
+	// by the walk, and records warnings in Results for special inodes
+	// (devices, fifos, etc.) with multiple links, and for directory inodes
+	// found at more than one pathname.
+	WarnUnusualInodes bool
 }
 
 // SetupOptions returns a Options struct with the defaults initialized and the
@@ -134,6 +662,7 @@ func SetupOptions(args ...func(*Options)) Options {
 		SearchThresh:             DefaultSearchThresh,
 		MinFileSize:              DefaultMinFileSize,
 		UseNewestLink:            DefaultUseNewestLink,
+		MinDuplicateCount:        DefaultMinDuplicateCount,
 		StoreExistingLinkResults: DefaultStoreExistingLinkResults,
 		StoreNewLinkResults:      DefaultStoreNewLinkResults,
 		ShowExtendedRunStats:     DefaultShowExtendedRunStats,
@@ -208,6 +737,14 @@ func MaxFileSize(size uint64) func(*Options) {
 	}
 }
 
+// MinFileAge sets the minimum time since modification of files that can be
+// linked
+func MinFileAge(age time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.MinFileAge = age
+	}
+}
+
 // DebugLevel sets the debugging level (1,2,or 3)
 func DebugLevel(debugLevel uint) func(*Options) {
 	return func(o *Options) {
@@ -238,6 +775,43 @@ func CheckQuiescence(o *Options) {
 	o.CheckQuiescence = true
 }
 
+// ignoresSize returns true if files of differing sizes can match, due to
+// ignored trailing (or leading byte-order mark) content.
+func (o *Options) ignoresSize() bool {
+	return o.IgnoreTrailingZeros || o.IgnoreTrailingNewline || o.IgnoreBOM
+}
+
+// findsAdvisoryMatches returns true if the content-only matching of files is
+// needed, regardless of their inode parameters.
+func (o *Options) findsAdvisoryMatches() bool {
+	return o.AdvisoryContentGroups || o.ReportMtimeSpread
+}
+
+// now returns the current time from the Now option, or else time.Now()
+func (o *Options) now() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// comparesXAttrs returns true if files must have equal xattrs to be linked
+func (o *Options) comparesXAttrs() bool {
+	return !o.IgnoreXAttr && o.XAttrCompareMode != XAttrSkip
+}
+
+// storesLinkGroups returns true if the link groups are stored in
+// Results.LinkGroups.
+func (o *Options) storesLinkGroups() bool {
+	return o.StoreInodeNumbers || o.IncludeDigestInOutput
+}
+
+// assertsPredictedSavings returns true if the actually freed inodes are
+// checked against the predicted savings after linking.
+func (o *Options) assertsPredictedSavings() bool {
+	return o.AssertPredictedSavings && o.LinkingEnabled
+}
+
 // Validate will ensure that contradictory Options aren't set, and that
 // dependent Options are set.  An error will be returned if Options is invalid.
 func (o *Options) Validate() error {
@@ -246,6 +820,94 @@ func (o *Options) Validate() error {
 			o.MinFileSize, o.MaxFileSize)
 	}
 
+	if o.MaxFiles < 0 {
+		return fmt.Errorf("MaxFiles (%v) cannot be negative", o.MaxFiles)
+	}
+
+	if o.XAttrCompareMode < XAttrEager || o.XAttrCompareMode > XAttrSkip {
+		return fmt.Errorf("XAttrCompareMode (%v) is not a valid mode", o.XAttrCompareMode)
+	}
+	if o.SrcPathPreference < MostLinked || o.SrcPathPreference > OldestMtime {
+		return fmt.Errorf("SrcPathPreference (%v) is not a valid preference", o.SrcPathPreference)
+	}
+
+	if o.LinkRateLimit < 0 {
+		return fmt.Errorf("LinkRateLimit (%v) cannot be negative", o.LinkRateLimit)
+	}
+
+	if o.MaxPathComponents < 0 {
+		return fmt.Errorf("MaxPathComponents (%v) cannot be negative", o.MaxPathComponents)
+	}
+
+	for _, d := range o.DenyDigests {
+		if b, err := hex.DecodeString(d); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("DenyDigests entry (%v) is not a hex SHA-256 digest", d)
+		}
+	}
+
+	if o.MinDuplicateCount < 0 {
+		return fmt.Errorf("MinDuplicateCount (%v) cannot be negative", o.MinDuplicateCount)
+	}
+
+	if o.BucketWorkers < 0 {
+		return fmt.Errorf("BucketWorkers (%v) cannot be negative", o.BucketWorkers)
+	}
+	if o.ExpectedClusterPerm&^os.ModePerm != 0 {
+		return fmt.Errorf("ExpectedClusterPerm (%o) must only contain permission bits",
+			uint32(o.ExpectedClusterPerm))
+	}
+	if o.ExistingLinksOutputPath != "" && !o.StoreExistingLinkResults {
+		return fmt.Errorf("ExistingLinksOutputPath requires StoreExistingLinkResults")
+	}
+	if o.NewLinksOutputPath != "" && !o.StoreNewLinkResults {
+		return fmt.Errorf("NewLinksOutputPath requires StoreNewLinkResults")
+	}
+	if o.RequireBackupMarker != "" && strings.Contains(o.RequireBackupMarker, "/") {
+		return fmt.Errorf("RequireBackupMarker (%v) must be a file name, not a path", o.RequireBackupMarker)
+	}
+	if o.StreamingLink && (o.ignoresSize() || o.ReportSimilar || o.BucketWorkers > 1) {
+		return fmt.Errorf("StreamingLink cannot be combined with options matching files of differing sizes, or BucketWorkers")
+	}
+	if o.WalkWorkers < 0 {
+		return fmt.Errorf("WalkWorkers (%v) cannot be negative", o.WalkWorkers)
+	}
+
+	if o.ExpectedFileCount < 0 {
+		return fmt.Errorf("ExpectedFileCount (%v) cannot be negative", o.ExpectedFileCount)
+	}
+
+	if o.PathPoolHint < 0 {
+		return fmt.Errorf("PathPoolHint (%v) cannot be negative", o.PathPoolHint)
+	}
+
+	if o.TargetInodeReduction < 0 {
+		return fmt.Errorf("TargetInodeReduction (%v) cannot be negative", o.TargetInodeReduction)
+	}
+
+	if o.IgnoreTrailingNewline && o.IgnoreTrailingZeros {
+		return fmt.Errorf("IgnoreTrailingNewline and IgnoreTrailingZeros cannot both be enabled")
+	}
+	if o.IgnoreBOM && (o.IgnoreTrailingZeros || o.IgnoreTrailingNewline) {
+		return fmt.Errorf("IgnoreBOM cannot be combined with IgnoreTrailingZeros or IgnoreTrailingNewline")
+	}
+	if o.KeepTrailingNewline && !o.IgnoreTrailingNewline {
+		return fmt.Errorf("KeepTrailingNewline requires IgnoreTrailingNewline to be enabled")
+	}
+
+	if o.ExistingLinksOnly && (o.LinkingEnabled || o.findsAdvisoryMatches() || o.ReportSimilar) {
+		return fmt.Errorf("ExistingLinksOnly cannot be combined with LinkingEnabled, AdvisoryContentGroups, ReportMtimeSpread, or ReportSimilar")
+	}
+
+	if o.TempLinkPattern != "" {
+		p := o.TempLinkPattern
+		if strings.Count(p, "%") != 2 || strings.Count(p, "%s") != 2 {
+			return fmt.Errorf("TempLinkPattern (%v) must have exactly two '%%s' verbs", p)
+		}
+		if path.Dir(fmt.Sprintf(p, "dir", "token")) != "dir" {
+			return fmt.Errorf("TempLinkPattern (%v) must be in the destination dir", p)
+		}
+	}
+
 	if o.ShowExtendedRunStats {
 		o.ShowRunStats = true
 	}
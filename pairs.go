@@ -0,0 +1,137 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"log"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+// AnalyzePairs compares only the given pairs of pathnames (such as candidate
+// duplicates found by an external index), rather than walking directories, and
+// links (or reports as linkable) the pairs whose inode parameters and content
+// are compatible according to the Options.  Pairs on different devices are
+// skipped.  The walk related options (such as the include and exclude
+// regexes), and the advisory matching options, aren't applied.
+func AnalyzePairs(pairs [][2]string, opts Options) (Results, error) {
+	ls := newLinkableState(&opts)
+
+	if err := opts.Validate(); err != nil {
+		return *ls.Results, err
+	}
+
+	ls.Progress = &disabledProgress{}
+	defer ls.Progress.Done()
+
+	err := analyzePairsHelper(pairs, ls)
+	return *ls.Results, err
+}
+
+// analyzePairsHelper compares the given pairs, in place of the walk phase of
+// runHelper, and completes the link generation.
+func analyzePairsHelper(pairs [][2]string, ls *linkableState) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("AnalyzePairs stopped early: %v ", r)
+		}
+	}()
+
+	ls.Results.start()
+	defer ls.Results.end()
+
+	ls.Results.Phase = WalkPhase
+	for _, pair := range pairs {
+		var dis [2]I.DevStatInfo
+		var statErr error
+		for i, pathname := range pair {
+			if dis[i], statErr = I.LStatInfo(pathname); statErr != nil {
+				break
+			}
+		}
+		if statErr != nil {
+			if !ls.Options.IgnoreWalkErrors {
+				return statErr
+			}
+			ls.Results.SkippedFileErrCount++
+			if ls.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping...", statErr)
+			}
+			continue
+		}
+		if dis[0].Dev != dis[1].Dev ||
+			!isLinkCandidate(dis[0], pair[0], ls.Options, ls.Results, false) ||
+			!isLinkCandidate(dis[1], pair[1], ls.Options, ls.Results, false) {
+			continue
+		}
+
+		fsdev := ls.dev(dis[0], pair[0])
+		cmpErr := fsdev.findIdenticalPair(dis[0], pair[0], dis[1], pair[1])
+		if cmpErr != nil {
+			if !ls.Options.IgnoreWalkErrors {
+				return cmpErr
+			}
+			ls.Results.SkippedFileErrCount++
+			if ls.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping...", cmpErr)
+			}
+		}
+	}
+
+	return linkHelper(ls)
+}
+
+// findIdenticalPair records the pathnames of the pair, and compares the pair
+// of files, adding their inodes to the linkable sets if they can be linked.
+func (f *fsDev) findIdenticalPair(di1 I.DevStatInfo, pathname1 string, di2 I.DevStatInfo, pathname2 string) error {
+	pi1 := f.addPairPath(di1, pathname1)
+	pi2 := f.addPairPath(di2, pathname2)
+	if pi1.Ino == pi2.Ino || f.LinkableInos.Containing(pi1.Ino).Has(pi2.Ino) {
+		return nil
+	}
+
+	areLinkable, err := f.areFilesLinkable(pi1, pi2, false)
+	if areLinkable {
+		f.LinkableInos.Add(pi1.Ino, pi2.Ino)
+	}
+	return err
+}
+
+// addPairPath records the inode and pathname of a file of a compared pair (as
+// an existing link, if the inode was previously seen with another pathname),
+// and returns its PathInfo.
+func (f *fsDev) addPairPath(di I.DevStatInfo, pathname string) I.PathInfo {
+	curPath := P.Split(pathname, f.pool)
+	ino := di.Ino
+	if _, ok := f.inoStatInfo[ino]; !ok {
+		f.Results.foundInode(di.Nlink)
+		f.Results.foundFile()
+		f.inoStatInfo[ino] = &di.StatInfo
+		f.InoPaths.AppendPath(ino, curPath)
+	} else if !f.InoPaths.HasPath(ino, curPath) {
+		f.Results.foundFile()
+		f.addExistingLink(ino, curPath)
+		f.InoPaths.AppendPath(ino, curPath)
+	}
+	return f.PathInfoFromIno(ino)
+}
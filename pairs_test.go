@@ -0,0 +1,84 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAnalyzePairs(t *testing.T) {
+	topdir := setUp("AnalyzePairs", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"a1": "XXXX",
+		"a2": "XXXX",
+		"a3": "XXXX",
+		"b1": "YYYY",
+		"b2": "ZZZZ",
+		"c1": "WWWW",
+		"c2": "WWWW", // Equal, but not given as a pair
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'AnalyzePairs'"
+	pairs := [][2]string{
+		{"a1", "a2"},
+		{"a2", "a3"},
+		{"b1", "b2"}, // Unequal content
+		{"a1", "b1"}, // Unequal content
+	}
+	result, err := AnalyzePairs(pairs, SetupOptions(LinkingEnabled))
+	if err != nil {
+		t.Fatalf("%v: AnalyzePairs() returned error: %v", name, err)
+	}
+	if !result.RunSuccessful {
+		t.Errorf("%v: AnalyzePairs() was not successful", name)
+	}
+	if len(result.LinkPaths) != 1 || !verifyLinkPaths(name, t, &result, paths{"a1", "a2", "a3"}) {
+		t.Errorf("%v: Expected only a1, a2, and a3 to be linked, got: %v", name, result.LinkPaths)
+	}
+	if result.FileCount != 5 || result.ComparisonCount != 4 {
+		t.Errorf("%v: Expected 5 files and 4 comparisons, got: %v, %v",
+			name, result.FileCount, result.ComparisonCount)
+	}
+	verifyInodeCounts(name, t, &result, 2, 8, 3, "a1", "a2", "a3")
+	for _, f := range []string{"b1", "b2", "c1", "c2"} {
+		if nlinkVal(f) != 1 {
+			t.Errorf("%v: Expected '%v' to not be linked", name, f)
+		}
+	}
+	verifyContents(name, t, m)
+
+	// Missing files are errors, unless walk errors are ignored
+	pairs = [][2]string{{"c1", "missing"}}
+	if _, err := AnalyzePairs(pairs, SetupOptions()); err == nil {
+		t.Errorf("%v: Expected error for missing pair file", name)
+	}
+	opts := SetupOptions()
+	opts.IgnoreWalkErrors = true
+	result, err = AnalyzePairs(pairs, opts)
+	if err != nil || result.SkippedFileErrCount != 1 {
+		t.Errorf("%v: Expected skipped missing pair file, got: %v (err: %v)",
+			name, result.SkippedFileErrCount, err)
+	}
+}
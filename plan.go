@@ -0,0 +1,246 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+// LinkPlan is the sequence of links determined by a dry run (with the
+// StoreLinkPlan option), which can be performed later (such as after the
+// results are reviewed) with Apply(), without walking and comparing the
+// files again.
+type LinkPlan struct {
+	Links []PlannedLink
+}
+
+// PlannedLink is a planned link of the Dst pathname to the Src pathname, on
+// the given device.
+type PlannedLink struct {
+	Dev uint64
+	Src PlannedFile
+	Dst PlannedFile
+}
+
+// PlannedFile is a pathname of a PlannedLink, with the inode information at
+// the time of the dry run.
+type PlannedFile struct {
+	Path  string
+	Ino   uint64
+	Nlink uint64
+	Size  uint64
+	Mode  os.FileMode
+	Uid   uint32
+	Gid   uint32
+	Mtime time.Time
+	Atime time.Time
+}
+
+// plannedFile returns the PlannedFile of the given PathInfo
+func plannedFile(pi I.PathInfo) PlannedFile {
+	return PlannedFile{
+		Path:  pi.Join(),
+		Ino:   uint64(pi.Ino),
+		Nlink: pi.Nlink,
+		Size:  pi.Size,
+		Mode:  pi.Mode,
+		Uid:   pi.Uid,
+		Gid:   pi.Gid,
+		Mtime: pi.Mtim,
+		Atime: pi.Atim,
+	}
+}
+
+// pathInfo returns the PathInfo of the PlannedFile
+func (pf PlannedFile) pathInfo(pool *P.StringPool) I.PathInfo {
+	return I.PathInfo{
+		Pathsplit: P.Split(pf.Path, pool),
+		StatInfo: I.StatInfo{
+			Ino:   I.Ino(pf.Ino),
+			Nlink: pf.Nlink,
+			Size:  pf.Size,
+			Mode:  pf.Mode,
+			Uid:   pf.Uid,
+			Gid:   pf.Gid,
+			Mtim:  pf.Mtime,
+			Atim:  pf.Atime,
+		},
+	}
+}
+
+// plannedLink adds a link to the LinkPlan, in the order it would be made
+// (with the StoreLinkPlan option).
+func (r *Results) plannedLink(src, dst I.PathInfo, dev uint64) {
+	if !r.Opts.StoreLinkPlan {
+		return
+	}
+	if r.LinkPlan == nil {
+		r.LinkPlan = &LinkPlan{}
+	}
+	r.Links = append(r.Links, PlannedLink{Dev: dev, Src: plannedFile(src), Dst: plannedFile(dst)})
+}
+
+// LinkOp is a link of the Dst pathname to the Src pathname, and its position
+// (from zero) in the sequence of links.
+type LinkOp struct {
+	Src   string `json:"src"`
+	Dst   string `json:"dst"`
+	Order int    `json:"order"`
+}
+
+// OrderedLinkPlan returns the links in the precise order that they were made
+// (or, for a dry run, would be made), including the order in which sources
+// are revisited when the nlink limit splits their groups.  When linking is
+// enabled, the new links must have been stored (with StoreNewLinkResults).
+func (r *Results) OrderedLinkPlan() []LinkOp {
+	var ops []LinkOp
+	if r.LinkPlan != nil {
+		for i, l := range r.Links {
+			ops = append(ops, LinkOp{Src: l.Src.Path, Dst: l.Dst.Path, Order: i})
+		}
+		return ops
+	}
+	// The LinkPaths groups are in link order, since a group is only
+	// extended by consecutive links from the same src.
+	for _, group := range r.LinkPaths {
+		for _, dst := range group[1:] {
+			ops = append(ops, LinkOp{Src: group[0], Dst: dst, Order: len(ops)})
+		}
+	}
+	return ops
+}
+
+// Apply performs the links of the LinkPlan of a dry run, with the given
+// Options (LinkingEnabled is implied), and returns the Results of the
+// linking.  Each link is skipped if either of its files was modified after
+// the dry run (ie. they must be unchanged, except by the previously applied
+// links).  An error is returned if the Results have no LinkPlan (ie. the dry
+// run lacked the StoreLinkPlan option).
+func (r *Results) Apply(opts Options) (Results, error) {
+	opts.LinkingEnabled = true
+	ls := newLinkableState(&opts)
+
+	if err := opts.Validate(); err != nil {
+		return *ls.Results, err
+	}
+	if r.LinkPlan == nil {
+		return *ls.Results, fmt.Errorf("Results have no LinkPlan to Apply (requires the StoreLinkPlan option)")
+	}
+
+	ls.Progress = &disabledProgress{}
+	defer ls.Progress.Done()
+
+	err := applyHelper(r.LinkPlan, ls)
+	return *ls.Results, err
+}
+
+// applyHelper performs the planned links, tracking the expected inode
+// information of the files as the links are made.
+func applyHelper(plan *LinkPlan, ls *linkableState) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Apply stopped early: %v ", r)
+		}
+	}()
+
+	ls.Results.start()
+	defer ls.Results.end()
+
+	unlock, err := ls.startLinkPhase()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, l := range plan.Links {
+		fsdev, ok := ls.fsDevs[l.Dev]
+		if !ok {
+			fsdev = newFSDev(ls.status, l.Dev, 0)
+			ls.fsDevs[l.Dev] = fsdev
+		}
+
+		// The inodes are expected to be as in the dry run, until linked
+		planned := []I.PathInfo{l.Src.pathInfo(ls.pool), l.Dst.pathInfo(ls.pool)}
+		for _, pi := range planned {
+			if _, ok := fsdev.inoStatInfo[pi.Ino]; !ok {
+				si := pi.StatInfo
+				fsdev.inoStatInfo[pi.Ino] = &si
+				ls.Results.foundInode(si.Nlink)
+			}
+		}
+		srcSI := fsdev.inoStatInfo[planned[0].Ino]
+		dstSI := fsdev.inoStatInfo[planned[1].Ino]
+		src := I.PathInfo{Pathsplit: planned[0].Pathsplit, StatInfo: *srcSI}
+		dst := I.PathInfo{Pathsplit: planned[1].Pathsplit, StatInfo: *dstSI}
+
+		// The same checks as made before the links of a Run
+		if fsdev.skipsUnlinkablePair(src, dst) {
+			continue
+		}
+		if modifiedErr := fsdev.haveNotBeenModified(src, dst); modifiedErr != nil {
+			if ls.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping...", modifiedErr)
+			}
+			ls.Results.modifiedBeforeApply()
+			ls.Results.skippedInode(l.Dev, uint64(dst.Ino), dst.Join(), UnlinkedContentChanged)
+			continue
+		}
+		if skip, cmpErr := fsdev.skipsChangedContents(src, dst); cmpErr != nil {
+			return cmpErr
+		} else if skip {
+			continue
+		}
+
+		fsdev.pacer.wait()
+		linkingErr := fsdev.hardlinkFiles(src, dst)
+		if err := fsdev.auditLink(src, dst, linkingErr); err != nil {
+			return err
+		}
+		if linkingErr != nil {
+			if !ls.Options.IgnoreLinkErrors {
+				return linkingErr
+			} else if ls.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping...", linkingErr)
+			}
+			ls.Results.skippedNewLink(src.Pathsplit, dst.Pathsplit)
+			ls.Results.skippedInode(l.Dev, uint64(dst.Ino), dst.Join(), UnlinkedLinkError)
+			continue
+		}
+
+		ls.Results.foundNewLink(src, dst, l.Dev)
+		srcSI.Nlink++
+		dstSI.Nlink--
+		if dstSI.Nlink == 0 {
+			ls.Results.foundRemovedInode(dstSI.Size, dst.Dirname, l.Dev, uint64(dst.Ino))
+		}
+	}
+	if err := ls.checkPredictedSavings(); err != nil {
+		return err
+	}
+	ls.Results.runCompletedSuccessfully()
+
+	return nil
+}
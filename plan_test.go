@@ -0,0 +1,162 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestResultsApply(t *testing.T) {
+	topdir := setUp("Apply", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X", "g1": "YY", "g2": "YY"}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Apply'"
+	if r := simpleRun(name, t, SetupOptions(LinkingDisabled), 2, "."); r.LinkPlan != nil {
+		t.Errorf("%v: Expected no LinkPlan without StoreLinkPlan, got: %+v", name, r.LinkPlan)
+	}
+	opts := SetupOptions(LinkingDisabled)
+	opts.StoreLinkPlan = true
+	result := simpleRun(name, t, opts, 2, ".")
+	if result.LinkPlan == nil || len(result.Links) != 3 {
+		t.Fatalf("%v: Expected a LinkPlan of 3 links, got: %+v", name, result.LinkPlan)
+	}
+	for _, f := range []string{"f1", "f2", "f3", "g1", "g2"} {
+		if nlinkVal(f) != 1 {
+			t.Fatalf("%v: Expected dry run to not link '%v'", name, f)
+		}
+	}
+
+	// Modify g2 after the dry run, so that its planned link is skipped
+	if err := ioutil.WriteFile("g2", []byte("ZZZ"), 0644); err != nil {
+		t.Fatalf("%v: Couldn't modify 'g2': %v", name, err)
+	}
+	m["g2"] = "ZZZ"
+
+	applyOpts := SetupOptions()
+	applyOpts.AssertPredictedSavings = true
+	applied, err := result.Apply(applyOpts)
+	if err != nil {
+		t.Fatalf("%v: Apply() returned error: %v", name, err)
+	}
+	if !applied.RunSuccessful || !applied.Opts.LinkingEnabled {
+		t.Errorf("%v: Expected successful Apply() with linking enabled", name)
+	}
+	verifyInodeCounts(name, t, &applied, 2, 2, 3, "f1", "f2", "f3")
+	if applied.NewLinkCount != 2 || applied.ModifiedBeforeApplyCount != 1 {
+		t.Errorf("%v: Expected 2 new links and 1 modified skip, got: %v, %v",
+			name, applied.NewLinkCount, applied.ModifiedBeforeApplyCount)
+	}
+	if nlinkVal("g1") != 1 || nlinkVal("g2") != 1 {
+		t.Errorf("%v: Expected modified 'g2' to not be linked", name)
+	}
+	verifyContents(name, t, m)
+
+	// Results from a linking Run have no plan
+	linked := simpleRun(name, t, SetupOptions(LinkingEnabled), 0, ".")
+	if _, err := linked.Apply(SetupOptions()); err == nil {
+		t.Errorf("%v: Expected Apply() error without a LinkPlan", name)
+	}
+}
+
+func TestResultsApplyRecompare(t *testing.T) {
+	topdir := setUp("ApplyRecompare", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "XXXX", "f2": "XXXX"})
+
+	name := "testname: 'Apply Recompare'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.StoreLinkPlan = true
+	result := simpleRun(name, t, opts, 1, ".")
+
+	// Change the contents of f2 after the dry run, keeping the same size
+	// and mtime so that the modification check doesn't detect it.
+	fi, err := os.Lstat("f2")
+	if err != nil {
+		t.Fatalf("%v: Couldn't stat 'f2': %v", name, err)
+	}
+	if err := ioutil.WriteFile("f2", []byte("YYYY"), 0644); err != nil {
+		t.Fatalf("%v: Couldn't modify 'f2': %v", name, err)
+	}
+	if err := os.Chtimes("f2", fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("%v: Couldn't Chtimes() on 'f2': %v", name, err)
+	}
+
+	applyOpts := SetupOptions()
+	applyOpts.RecompareBeforeLink = true
+	applied, err := result.Apply(applyOpts)
+	if err != nil {
+		t.Fatalf("%v: Apply() returned error: %v", name, err)
+	}
+	if applied.ContentChangedBeforeLinkCount != 1 || applied.NewLinkCount != 0 {
+		t.Errorf("%v: Expected 1 changed content skip and no links, got: %v, %v",
+			name, applied.ContentChangedBeforeLinkCount, applied.NewLinkCount)
+	}
+	verifyContents(name, t, pathContents{"f1": "XXXX", "f2": "YYYY"})
+}
+
+func TestResultsOrderedLinkPlan(t *testing.T) {
+	topdir := setUp("Apply", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"f1": "X", "f2": "X", "f3": "X", "f4": "X",
+		"g1": "YY", "g2": "YY", "h1": "ZZZ", "h2": "ZZZ", "h3": "ZZZ",
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Ordered Link Plan'"
+	opts := SetupOptions(LinkingDisabled)
+	want := simpleRun(name, t, opts, 3, ".").OrderedLinkPlan()
+	if len(want) != 6 {
+		t.Fatalf("%v: Expected 6 ordered links, got: %+v", name, want)
+	}
+	for i, op := range want {
+		if op.Order != i {
+			t.Errorf("%v: Expected link %+v to have order %v", name, op, i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		got := simpleRun(name, t, opts, 3, ".").OrderedLinkPlan()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: Expected the same link order, got: %+v vs %+v", name, got, want)
+		}
+	}
+
+	// A stored LinkPlan gives the same order
+	planOpts := SetupOptions(LinkingDisabled)
+	planOpts.StoreLinkPlan = true
+	if got := simpleRun(name, t, planOpts, 3, ".").OrderedLinkPlan(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: Expected LinkPlan order %+v, got: %+v", name, want, got)
+	}
+
+	// Linking runs give the order of the made links
+	linked := simpleRun(name, t, SetupOptions(LinkingEnabled), 3, ".")
+	if got := linked.OrderedLinkPlan(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: Expected linked order %+v, got: %+v", name, want, got)
+	}
+}
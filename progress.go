@@ -62,7 +62,7 @@ func newTTYProgress(results *Results, options *Options) *ttyProgress {
 	if options.LinkingEnabled {
 		fmt.Println(" -- Linking enabled. Filesystem will be modified. CTRL-C to abort --")
 	}
-	now := time.Now()
+	now := options.now()
 	p := ttyProgress{
 		lastFPSTime:    now,
 		updateDelay:    60 * time.Millisecond,
@@ -108,7 +108,7 @@ func (p *ttyProgress) Show() {
 		return
 	}
 
-	now := time.Now()
+	now := p.options.now()
 
 	numFiles := p.results.FileCount
 
@@ -23,12 +23,17 @@ package hardlinkable
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"path"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
 )
 
@@ -47,6 +52,13 @@ const (
 	EndPhase
 )
 
+// The SrcSelectionCounts keys
+const (
+	SrcSelectedNewest     = "newest"
+	SrcSelectedOldest     = "oldest"
+	SrcSelectedMostLinked = "mostLinked"
+)
+
 // RunStats holds information about counts, the number of files found to be
 // linkable, the bytes that linking would save (or did save), and a variety of
 // related, useful, or just interesting information gathered during the Run().
@@ -55,8 +67,11 @@ type RunStats struct {
 	FileCount              int64  `json:"fileCount"`
 	FileTooSmallCount      int64  `json:"fileTooSmallCount"`
 	FileTooLargeCount      int64  `json:"fileTooLargeCount"`
+	TooRecentFileCount     int64  `json:"tooRecentFileCount"`
 	ComparisonCount        int64  `json:"comparisonCount"`
 	InodeCount             int64  `json:"inodeCount"`
+	DeviceCount            int64  `json:"deviceCount"`
+	UniqueContentCount     int64  `json:"uniqueContentCount"`
 	InodeRemovedCount      int64  `json:"inodeRemovedCount"`
 	NlinkCount             int64  `json:"nlinkCount"`
 	ExistingLinkCount      int64  `json:"existingLinkCount"`
@@ -65,6 +80,16 @@ type RunStats struct {
 	InodeRemovedByteAmount uint64 `json:"inodeRemovedByteAmount"`
 	BytesCompared          uint64 `json:"bytesCompared"`
 
+	// The most bytes read (from both files) by a single file comparison,
+	// and the average per comparison, to help with tuning SearchThresh
+	MaxComparisonBytes uint64 `json:"maxComparisonBytes"`
+	AvgComparisonBytes uint64 `json:"avgComparisonBytes"`
+
+	// The time spent reading file contents, and the rest of the run
+	// time (with ProfileTiming)
+	IOTimeMillis  int64 `json:"ioTimeMillis"`
+	CPUTimeMillis int64 `json:"cpuTimeMillis"`
+
 	// Some stats on files that compared equal, but which had some
 	// mismatching inode parameters.  This can be helpful for tuning the
 	// command line options on subsequent runs.
@@ -86,6 +111,9 @@ type RunStats struct {
 	SkippedFileErrCount int64 `json:"skippedFileErrCount"`
 	SkippedLinkErrCount int64 `json:"skippedLinkErrCount"`
 
+	// Count of top-level dirs that were removed while being walked
+	RootVanishedCount int64 `json:"rootVanishedCount"`
+
 	// Counts of files and dirs excluded by the Regex matches
 	ExcludedDirCount  int64 `json:"excludedDirCount"`
 	ExcludedFileCount int64 `json:"excludedFileCount"`
@@ -95,6 +123,63 @@ type RunStats struct {
 	SkippedSetuidCount int64 `json:"skippedSetuidCount"`
 	SkippedSetgidCount int64 `json:"skippedSetgidCount"`
 
+	// Count of files skipped by the ChangedSinceFile option, because they
+	// were modified before the marker file
+	UnchangedSinceMarkerCount int64 `json:"unchangedSinceMarkerCount"`
+
+	// Count of files skipped by the SkipVolatileFiles option
+	SkippedVolatileCount int64 `json:"skippedVolatileCount"`
+
+	// Count of files skipped by the MaxPathComponents option
+	TooDeepPathCount int64 `json:"tooDeepPathCount"`
+
+	// Count of inodes left unlinked from the rest of their group of
+	// identical files, because of the SameName option
+	SameNameSplitCount int64 `json:"sameNameSplitCount"`
+
+	// Count of inodes not linked because their content digest matched
+	// one of the DenyDigests
+	DeniedDigestSkipCount int64 `json:"deniedDigestSkipCount"`
+
+	// Count of linkable groups of identical files that weren't linked,
+	// because they had fewer than MinDuplicateCount pathnames
+	BelowMinDuplicateCount int64 `json:"belowMinDuplicateCount"`
+
+	// Count of links skipped by the RecompareBeforeLink option, because
+	// the file contents no longer matched
+	ContentChangedBeforeLinkCount int64 `json:"contentChangedBeforeLinkCount"`
+
+	// Count of the RecompareBeforeLink comparisons, and the bytes they
+	// compared.  These aren't included in the BytesCompared of the search.
+	RecompareCount int64  `json:"recompareCount"`
+	RecompareBytes uint64 `json:"recompareBytes"`
+
+	// Count of planned links skipped by Apply(), because a file was
+	// modified after the dry run
+	ModifiedBeforeApplyCount int64 `json:"modifiedBeforeApplyCount"`
+
+	// Count of links skipped by the CheckDirWritable option, because the
+	// src or dst dir wasn't writable
+	SkippedReadonlyDirCount int64 `json:"skippedReadonlyDirCount"`
+
+	// Count of links skipped by the SkipOpenFiles option, because the src
+	// or dst file was open by another process
+	SkippedOpenFileCount int64 `json:"skippedOpenFileCount"`
+
+	// Counts for the CanonicalStoreDir option, of the new store files, the
+	// pathnames relinked to existing store files, and the skipped groups
+	CanonicalStoredCount  int64 `json:"canonicalStoredCount"`
+	CanonicalLinkedCount  int64 `json:"canonicalLinkedCount"`
+	CanonicalSkippedCount int64 `json:"canonicalSkippedCount"`
+
+	// Count of linkable groups abandoned by the ContinueOnGroupError
+	// option, because of an error while linking them
+	FailedGroupCount int64 `json:"failedGroupCount"`
+
+	// Count of file comparisons that failed with an error, and were treated
+	// as unequal by the ComparisonErrorsAreNonFatal option
+	ComparisonErrorCount int64 `json:"comparisonErrorCount"`
+
 	// Also keep track of files with bits other than the permission bits
 	// set (other than setuid/setgid and bits already excluded by "regular"
 	// file bits)
@@ -109,12 +194,144 @@ type RunStats struct {
 	InoSeqIterationCount int64 `json:"inoSeqIterationCount"`
 	DigestComputedCount  int64 `json:"digestComputedCount"`
 
+	// Count of inodes moved to the front of a search sequence because of
+	// matching digests, and how often the first of those was a match.
+	DigestReorderedInoCount int64 `json:"digestReorderedInoCount"`
+	DigestFirstHitCount     int64 `json:"digestFirstHitCount"`
+
+	// Count of searches that didn't use digests, because the file was
+	// larger than MaxDigestFileSize
+	DigestSizeSkipCount int64 `json:"digestSizeSkipCount"`
+
+	// Count of files whose size was unique among the walked files (so
+	// none could have equal content), which skipped the hashing and
+	// content comparisons
+	UniqueSizeSkipCount int64 `json:"uniqueSizeSkipCount"`
+
+	// Count of hash list searches skipped because the inode (seen again
+	// at another pathname) was already found to be linkable
+	ConsolidationSkipCount int64 `json:"consolidationSkipCount"`
+
+	// The most inodes held at once for link generation (which is all the
+	// inodes, unless the StreamingLink option is used)
+	PeakInodeCount int64 `json:"peakInodeCount"`
+
+	// Count of comparisons rejected by the QuickPrefixCompare option,
+	// before the full content comparison.
+	QuickPrefixRejectCount int64 `json:"quickPrefixRejectCount"`
+
+	// Count of comparisons rejected by the CompareTailFirst option, before
+	// the full content comparison.
+	QuickTailRejectCount int64 `json:"quickTailRejectCount"`
+
+	// Count of file pairs whose xattrs were compared
+	XAttrCompareCount int64 `json:"xattrCompareCount"`
+
+	// Count of files skipped for a size that isn't a multiple of the
+	// block size (with the OnlyBlockAligned option)
+	NonBlockAlignedSkipCount int64 `json:"nonBlockAlignedSkipCount"`
+
+	// Count of comparisons performed with mmapped files (UseMmap option)
+	MmapComparisonCount int64 `json:"mmapComparisonCount"`
+
+	// Summary of the content comparisons needed to search each list of
+	// files with equal inode hashes, for judging how well the
+	// SearchThresh and digests are pruning the searches
+	ComparisonsPerGroup ComparisonSummary `json:"comparisonsPerGroup"`
+
+	// Counts of the link groups whose src inode was the newest, oldest,
+	// and/or most linked of the group (keyed by the SrcSelected names)
+	SrcSelectionCounts map[string]int64 `json:"srcSelectionCounts,omitempty"`
+
+	// The number of pathnames in the largest group of identical files
+	MaxDuplicationCount int64 `json:"maxDuplicationCount"`
+
+	// The number of strings interned in the pathname pool(s), and the
+	// bytes of the pathname components which reused a pooled string.
+	PooledStringCount      int64  `json:"pooledStringCount"`
+	PooledStringBytesSaved uint64 `json:"pooledStringBytesSaved"`
+
 	// Counts of how many times the hardlinkFiles() func wasn't able to
 	// successfully change inode times and/or uid/gid.  Since we ignore
 	// such errors and continue anyway (ie. it's a best-effort attempt,
 	// rather than a guarantee), the counts are debugging info.
 	FailedLinkChtimesCount int64 `json:"failedLinkChtimesCount"`
 	FailedLinkChownCount   int64 `json:"failedLinkChownCount"`
+
+	// Count of how many times the access time of a linked src inode
+	// couldn't be restored (with the PreserveAtime option).
+	FailedAtimeRestoreCount int64 `json:"failedAtimeRestoreCount"`
+}
+
+// LinkGroupInfo holds the pathnames which are (or will be, after linking)
+// hardlinks to the same source inode, along with the device and inode numbers
+// of that inode.  The first pathname is the link source.
+type LinkGroupInfo struct {
+	Dev    uint64   `json:"dev"`
+	SrcIno uint64   `json:"srcIno"`
+	Paths  []string `json:"paths"`
+	Size   uint64   `json:"size"`
+	Digest string   `json:"digest,omitempty"` // With IncludeDigestInOutput
+}
+
+// DeviceResults are the links and main stats of a single device (with the
+// DeviceJSONOutputDir option).
+type DeviceResults struct {
+	Dev                    uint64              `json:"dev"`
+	FileCount              int64               `json:"fileCount"`
+	ExistingLinkCount      int64               `json:"existingLinkCount"`
+	ExistingLinkByteAmount uint64              `json:"existingLinkByteAmount"`
+	NewLinkCount           int64               `json:"newLinkCount"`
+	InodeRemovedCount      int64               `json:"inodeRemovedCount"`
+	InodeRemovedByteAmount uint64              `json:"inodeRemovedByteAmount"`
+	ExistingLinks          map[string][]string `json:"existingLinks"`
+	LinkPaths              [][]string          `json:"linkPaths"`
+}
+
+// ExistingLinkSummary is the count of existing links to a src pathname, and
+// the total bytes that they save (with the SummarizeExistingLinks option).
+type ExistingLinkSummary struct {
+	Links int64  `json:"links"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// ExistingClusterAnomaly is a cluster of existing links whose inode doesn't
+// have the expected permission bits or ownership, with one of its pathnames.
+type ExistingClusterAnomaly struct {
+	Path string      `json:"path"`
+	Perm os.FileMode `json:"perm"`
+	Uid  uint32      `json:"uid"`
+	Gid  uint32      `json:"gid"`
+}
+
+// FileEntry is a pathname and its file size
+type FileEntry struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+// NlinkLimitInode is an inode whose nlink count (after linking) is near the
+// maximum nlink count of its filesystem, with one of its pathnames.
+type NlinkLimitInode struct {
+	Path     string `json:"path"`
+	Nlink    uint64 `json:"nlink"`
+	MaxNlink uint64 `json:"maxNlink"`
+}
+
+// MtimeSpreadGroup is a group of pathnames of equal content files, and the
+// distinct modification times (oldest first) of their inodes.
+type MtimeSpreadGroup struct {
+	Paths  []string    `json:"paths"`
+	Mtimes []time.Time `json:"mtimes"`
+}
+
+// ComparisonSummary is the minimum, median and maximum number of content
+// comparisons performed when searching the lists of files with equal inode
+// hashes.
+type ComparisonSummary struct {
+	Min    int64 `json:"min"`
+	Median int64 `json:"median"`
+	Max    int64 `json:"max"`
 }
 
 // Results contains the RunStats information, as well as the found existing and
@@ -125,16 +342,108 @@ type Results struct {
 	ExistingLinks     map[string][]string `json:"existingLinks"`
 	ExistingLinkSizes map[string]uint64   `json:"existingLinkSizes"`
 	LinkPaths         [][]string          `json:"linkPaths"`
-	SkippedLinkPaths  [][]string          `json:"skippedLinkPaths"` // Skipped when link failed
+	LinkPathSizes     []uint64            `json:"linkPathSizes,omitempty"` // File size of each LinkPaths group
+	SkippedLinkPaths  [][]string          `json:"skippedLinkPaths"`        // Skipped when link failed
+	FailedGroups      [][]string          `json:"failedGroups,omitempty"`
+	ExcludedFilePaths []string            `json:"excludedFilePaths,omitempty"`
+	ExcludedDirPaths  []string            `json:"excludedDirPaths,omitempty"`
+	AdvisoryGroups    [][]string          `json:"advisoryGroups,omitempty"`
+	LinkGroups        []LinkGroupInfo     `json:"linkGroups,omitempty"`
+	SimilarGroups     [][]string          `json:"similarGroups,omitempty"`
+
+	// The existing link counts by src pathname, instead of ExistingLinks
+	// (with the SummarizeExistingLinks option)
+	ExistingLinkSummaries map[string]ExistingLinkSummary `json:"existingLinkSummaries,omitempty"`
+
+	// The links and stats of each device, by device number (with the
+	// DeviceJSONOutputDir option)
+	DeviceResults map[uint64]*DeviceResults `json:"deviceResults,omitempty"`
+
+	// Existing link clusters with unexpected permission bits or ownership
+	// (with the ReportExistingClusterAnomalies option)
+	ExistingClusterAnomalies []ExistingClusterAnomaly `json:"existingClusterAnomalies,omitempty"`
+
+	// Linked (or linkable) files whose allocated blocks are far from
+	// their size (with the ReportFragmentationRisk option)
+	FragmentationRiskPaths []string `json:"fragmentationRiskPaths,omitempty"`
+
+	// Inodes within NlinkWarnMargin of the maximum nlink count
+	NearNlinkLimitInodes []NlinkLimitInode `json:"nearNlinkLimitInodes,omitempty"`
+
+	// Groups of equal content files with differing mtimes (with the
+	// ReportMtimeSpread option)
+	MtimeSpreadGroups []MtimeSpreadGroup `json:"mtimeSpreadGroups,omitempty"`
+
+	// The bytes freed (or freeable) by linking, by the dirname of the
+	// pathname whose inode was removed.
+	RemovedInodeDirBytes map[string]uint64 `json:"removedInodeDirBytes,omitempty"`
+
+	// The count of inodes removed (or removable) by linking, by the same
+	// dirnames as the RemovedInodeDirBytes.
+	RemovedInodeDirCounts map[string]int64 `json:"removedInodeDirCounts,omitempty"`
+
+	// The pathnames of the walked files, by their inode hash (with
+	// DebugLevel > 2).  Only files in the same bucket are compared.
+	HashBuckets map[uint64][]string `json:"hashBuckets,omitempty"`
+
+	// The sorted pathnames of the largest group of identical files
+	MostDuplicatedPaths []string `json:"mostDuplicatedPaths,omitempty"`
+
+	UnusualInodeWarnings []string `json:"unusualInodeWarnings,omitempty"`
 	RunStats
 	StartTime time.Time `json:"startTime"`
 	EndTime   time.Time `json:"endTime"`
 	RunTime   string    `json:"runTime"`
 	Opts      Options   `json:"options"`
 
+	// The links planned by a dry run (ie. without LinkingEnabled) with the
+	// StoreLinkPlan option, which can be performed later with Apply()
+	*LinkPlan `json:"-"`
+
 	// Set to true when Run() has completed successfully
 	RunSuccessful bool `json:"runSuccessful"`
 
+	// Set to true when the walk was stopped early by the MaxFiles limit
+	HitFileLimit bool `json:"hitFileLimit"`
+
+	// Set to true when linking was stopped by the TargetInodeReduction
+	HitInodeTarget bool `json:"hitInodeTarget"`
+
+	// Maps the LinkGroups entries to their inode, while running
+	linkGroupIndex map[devIno]int
+
+	// The inodes that weren't linked (with StoreUnlinkedReasons)
+	unlinkedInodes map[devIno]UnlinkedInode
+
+	// The walked pathnames of each inode (with StoreInodePaths)
+	inodePaths map[devIno][]string
+
+	// Maps the NearNlinkLimitInodes entries to their inode
+	nearNlinkIndex map[devIno]int
+
+	// The inodes already checked for FragmentationRiskPaths
+	fragmentationChecked map[devIno]bool
+
+	// The filesystem block sizes by device (with OnlyBlockAligned)
+	blockSizes map[uint64]uint64
+
+	// The number of searched inode hash lists, by the number of content
+	// comparisons the search performed (for ComparisonsPerGroup)
+	groupComparisons map[int64]int64
+
+	// The dst inodes of the new links, and the expected nlinks of the
+	// linked inodes (with AssertPredictedSavings)
+	linkedAwayInodes map[devIno]*linkedAwayInode
+	expectedNlinks   map[devIno]uint64
+
+	// The largest values reached, for LimitUtilization()
+	largestFileSize   uint64
+	largestInoBucket  int
+	largestNlinkRatio float64
+
+	// The accumulated file reading time (with ProfileTiming)
+	ioTime time.Duration
+
 	// Record which 'phase' we've gotten to in the algorithms, in case of
 	// early termination of the run.
 	Phase RunPhases `json:"phase"`
@@ -146,6 +455,11 @@ func newResults(o *Options) *Results {
 		ExistingLinkSizes: make(map[string]uint64),
 		Opts:              *o,
 	}
+	// Presize the link groups, assuming a modest fraction of the files
+	// are linkable.
+	if o.ExpectedFileCount > 0 && o.StoreNewLinkResults {
+		r.LinkPaths = make([][]string, 0, o.ExpectedFileCount/4)
+	}
 	return &r
 }
 
@@ -157,6 +471,31 @@ func (r *Results) foundFile() {
 	r.FileCount++
 }
 
+// deviceResults returns the DeviceResults of the given device (creating it if
+// needed), or nil if they aren't stored.
+func (r *Results) deviceResults(dev uint64) *DeviceResults {
+	if r.Opts.DeviceJSONOutputDir == "" {
+		return nil
+	}
+	if r.DeviceResults == nil {
+		r.DeviceResults = make(map[uint64]*DeviceResults)
+	}
+	d, ok := r.DeviceResults[dev]
+	if !ok {
+		d = &DeviceResults{Dev: dev, ExistingLinks: make(map[string][]string)}
+		r.DeviceResults[dev] = d
+	}
+	return d
+}
+
+// foundDeviceFile counts a found file of the given device, for its
+// DeviceResults.
+func (r *Results) foundDeviceFile(dev uint64) {
+	if d := r.deviceResults(dev); d != nil {
+		d.FileCount++
+	}
+}
+
 func (r *Results) foundFileTooSmall() {
 	r.FileTooSmallCount++
 }
@@ -165,6 +504,32 @@ func (r *Results) foundFileTooLarge() {
 	r.FileTooLargeCount++
 }
 
+func (r *Results) foundFileTooRecent() {
+	r.TooRecentFileCount++
+}
+
+func (r *Results) foundNonBlockAlignedFile() {
+	r.NonBlockAlignedSkipCount++
+}
+
+// blockSize returns the BlockSize option, or else the (cached) block size of
+// the filesystem of the given device, determined from the pathname.  Zero is
+// returned if it can't be determined.
+func (r *Results) blockSize(dev uint64, pathname string) uint64 {
+	if r.Opts.BlockSize > 0 {
+		return r.Opts.BlockSize
+	}
+	if bs, ok := r.blockSizes[dev]; ok {
+		return bs
+	}
+	if r.blockSizes == nil {
+		r.blockSizes = make(map[uint64]uint64)
+	}
+	bs, _ := I.BlockSize(pathname)
+	r.blockSizes[dev] = bs
+	return bs
+}
+
 func (r *Results) addMismatchedMtimeBytes(size uint64) {
 	r.MismatchedMtimeCount++
 	r.MismatchedMtimeBytes += size
@@ -200,9 +565,104 @@ func (r *Results) foundInode(n uint64) {
 	r.NlinkCount += int64(n)
 }
 
-func (r *Results) foundRemovedInode(size uint64) {
+func (r *Results) selectedSrc(selection string) {
+	if r.SrcSelectionCounts == nil {
+		r.SrcSelectionCounts = make(map[string]int64)
+	}
+	r.SrcSelectionCounts[selection]++
+}
+
+func (r *Results) belowMinDuplicateCount() {
+	r.BelowMinDuplicateCount++
+}
+
+func (r *Results) contentChangedBeforeLink() {
+	r.ContentChangedBeforeLinkCount++
+}
+
+// recomparedBeforeLink moves the bytes compared by a RecompareBeforeLink
+// comparison (since startBytes) from the search stats to the recompare counts,
+// and restores the MaxComparisonBytes of the search.
+func (r *Results) recomparedBeforeLink(startBytes, startMax uint64) {
+	r.RecompareCount++
+	r.RecompareBytes += r.BytesCompared - startBytes
+	r.BytesCompared = startBytes
+	r.MaxComparisonBytes = startMax
+}
+
+func (r *Results) modifiedBeforeApply() {
+	r.ModifiedBeforeApplyCount++
+}
+
+func (r *Results) skippedReadonlyDir() {
+	r.SkippedReadonlyDirCount++
+}
+
+func (r *Results) skippedOpenFile() {
+	r.SkippedOpenFileCount++
+}
+
+func (r *Results) storedCanonical() {
+	r.CanonicalStoredCount++
+}
+
+func (r *Results) linkedCanonical() {
+	r.CanonicalLinkedCount++
+}
+
+func (r *Results) skippedCanonical() {
+	r.CanonicalSkippedCount++
+}
+
+func (r *Results) comparisonError() {
+	r.ComparisonErrorCount++
+}
+
+func (r *Results) failedGroup(pathnames []string) {
+	r.FailedGroupCount++
+	r.FailedGroups = append(r.FailedGroups, pathnames)
+}
+
+func (r *Results) foundRemovedInode(size uint64, dirname string, dev, ino uint64) {
 	r.InodeRemovedCount++
 	r.InodeRemovedByteAmount += size
+	if r.Opts.assertsPredictedSavings() {
+		l := r.linkedAway(devIno{dev, ino})
+		l.removed = true
+		l.size = size
+	}
+	if d := r.deviceResults(dev); d != nil {
+		d.InodeRemovedCount++
+		d.InodeRemovedByteAmount += size
+	}
+	if r.Opts.StoreNewLinkResults {
+		if r.RemovedInodeDirBytes == nil {
+			r.RemovedInodeDirBytes = make(map[string]uint64)
+		}
+		r.RemovedInodeDirBytes[dirname] += size
+		if r.RemovedInodeDirCounts == nil {
+			r.RemovedInodeDirCounts = make(map[string]int64)
+		}
+		r.RemovedInodeDirCounts[dirname]++
+	}
+}
+
+// Track count of excluded files, and optionally keep a list of their
+// pathnames for later output.
+func (r *Results) excludedFile(pathname string) {
+	r.ExcludedFileCount++
+	if r.Opts.StoreExcludedPaths {
+		r.ExcludedFilePaths = append(r.ExcludedFilePaths, pathname)
+	}
+}
+
+// Track count of excluded dirs, and optionally keep a list of their pathnames
+// for later output.
+func (r *Results) excludedDir(pathname string) {
+	r.ExcludedDirCount++
+	if r.Opts.StoreExcludedPaths {
+		r.ExcludedDirPaths = append(r.ExcludedDirPaths, pathname)
+	}
 }
 
 func (r *Results) foundSetuidFile() {
@@ -213,6 +673,26 @@ func (r *Results) foundSetgidFile() {
 	r.SkippedSetgidCount++
 }
 
+func (r *Results) unchangedSinceMarker() {
+	r.UnchangedSinceMarkerCount++
+}
+
+func (r *Results) skippedVolatileFile() {
+	r.SkippedVolatileCount++
+}
+
+func (r *Results) skippedTooDeepPath() {
+	r.TooDeepPathCount++
+}
+
+func (r *Results) splitBySameName() {
+	r.SameNameSplitCount++
+}
+
+func (r *Results) skippedDeniedDigest() {
+	r.DeniedDigestSkipCount++
+}
+
 func (r *Results) foundNonPermBitFile() {
 	r.SkippedNonPermBitCount++
 }
@@ -237,6 +717,71 @@ func (r *Results) noHashMatch() {
 	r.HashMismatchCount++
 }
 
+func (r *Results) skippedUniqueSize() {
+	r.UniqueSizeSkipCount++
+}
+
+func (r *Results) skippedConsolidatedIno() {
+	r.ConsolidationSkipCount++
+}
+
+func (r *Results) foundFileSize(size uint64) {
+	if size > r.largestFileSize {
+		r.largestFileSize = size
+	}
+}
+
+func (r *Results) foundInoBucket(n int) {
+	if n > r.largestInoBucket {
+		r.largestInoBucket = n
+	}
+}
+
+// foundNlink keeps the largest ratio of an inode's nlink count to the maximum
+// nlink count of its filesystem.
+func (r *Results) foundNlink(nlink, maxNlink uint64) {
+	if maxNlink == 0 {
+		return
+	}
+	if ratio := float64(nlink) / float64(maxNlink); ratio > r.largestNlinkRatio {
+		r.largestNlinkRatio = ratio
+	}
+}
+
+// LimitUtilization returns how close the run came to the limits set by its
+// Options, as the fraction of each limit that was reached (ie. 1.0 means the
+// limit was reached).  The keys are:
+//
+//	"maxFileSize"   the largest file found, of MaxFileSize
+//	"maxFiles"      the files found, of MaxFiles
+//	"searchThresh"  the most inodes with the same hash, of SearchThresh
+//	"maxNLinks"     the largest nlink count, of the filesystem's maximum
+//
+// Options that don't set a limit (ie. MaxFileSize of zero) are left out.
+func (r *Results) LimitUtilization() map[string]float64 {
+	u := make(map[string]float64)
+	o := r.Opts
+	if o.MaxFileSize > 0 {
+		u["maxFileSize"] = float64(r.largestFileSize) / float64(o.MaxFileSize)
+	}
+	if o.MaxFiles > 0 {
+		u["maxFiles"] = float64(r.FileCount) / float64(o.MaxFiles)
+	}
+	if o.SearchThresh > 0 {
+		u["searchThresh"] = float64(r.largestInoBucket) / float64(o.SearchThresh)
+	}
+	if r.largestNlinkRatio > 0 {
+		u["maxNLinks"] = r.largestNlinkRatio
+	}
+	return u
+}
+
+func (r *Results) foundPeakInodes(n int64) {
+	if n > r.PeakInodeCount {
+		r.PeakInodeCount = n
+	}
+}
+
 func (r *Results) didComparison() {
 	r.ComparisonCount++
 }
@@ -245,6 +790,18 @@ func (r *Results) addBytesCompared(n uint64) {
 	r.BytesCompared += n
 }
 
+// addIOTime accumulates the file reading time since start.  Intended to be
+// deferred, when ProfileTiming is enabled.
+func (r *Results) addIOTime(start time.Time) {
+	r.ioTime += time.Since(start)
+}
+
+func (r *Results) foundComparisonBytes(n uint64) {
+	if n > r.MaxComparisonBytes {
+		r.MaxComparisonBytes = n
+	}
+}
+
 func (r *Results) foundEqualFiles() {
 	r.EqualComparisonCount++
 }
@@ -253,14 +810,101 @@ func (r *Results) computedDigest() {
 	r.DigestComputedCount++
 }
 
+func (r *Results) skippedDigestSize() {
+	r.DigestSizeSkipCount++
+}
+
+func (r *Results) digestReorderedInos(n int) {
+	r.DigestReorderedInoCount += int64(n)
+}
+
+func (r *Results) digestFirstHit() {
+	r.DigestFirstHitCount++
+}
+
+func (r *Results) quickPrefixRejected() {
+	r.QuickPrefixRejectCount++
+}
+
+func (r *Results) quickTailRejected() {
+	r.QuickTailRejectCount++
+}
+
+func (r *Results) comparedXAttrs() {
+	r.XAttrCompareCount++
+}
+
+func (r *Results) usedMmapComparison() {
+	r.MmapComparisonCount++
+}
+
+func (r *Results) addPoolStats(pool *P.StringPool) {
+	count, saved := pool.Stats()
+	r.PooledStringCount += int64(count)
+	r.PooledStringBytesSaved += saved
+}
+
+// searchedGroup records the number of content comparisons performed while
+// searching a list of files with equal inode hashes.
+func (r *Results) searchedGroup(comparisons int64) {
+	if r.groupComparisons == nil {
+		r.groupComparisons = make(map[int64]int64)
+	}
+	r.groupComparisons[comparisons]++
+}
+
+// summarizeGroupComparisons returns the min, median and max of the recorded
+// per group comparison counts (the lower median for an even count).
+func summarizeGroupComparisons(counts map[int64]int64) ComparisonSummary {
+	var total int64
+	keys := make([]int64, 0, len(counts))
+	for k, n := range counts {
+		keys = append(keys, k)
+		total += n
+	}
+	if total == 0 {
+		return ComparisonSummary{}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	cs := ComparisonSummary{Min: keys[0], Max: keys[len(keys)-1]}
+	var seen int64
+	for _, k := range keys {
+		seen += counts[k]
+		if seen > (total-1)/2 {
+			cs.Median = k
+			break
+		}
+	}
+	return cs
+}
+
 func (r *Results) start() {
-	r.StartTime = time.Now()
+	r.StartTime = r.Opts.now()
 }
 
 func (r *Results) end() {
-	r.EndTime = time.Now()
+	r.pruneLinkGroups()
+	if r.ComparisonCount > 0 {
+		r.AvgComparisonBytes = r.BytesCompared / uint64(r.ComparisonCount)
+	}
+	r.ComparisonsPerGroup = summarizeGroupComparisons(r.groupComparisons)
+	r.EndTime = r.Opts.now()
 	duration := r.EndTime.Sub(r.StartTime)
 	r.RunTime = duration.Round(time.Millisecond).String()
+	if r.Opts.ProfileTiming {
+		cpuTime := duration - r.ioTime
+		if cpuTime < 0 {
+			cpuTime = 0 // Possible with the summed BucketWorkers IO time
+		}
+		r.IOTimeMillis = ceilMillis(r.ioTime)
+		r.CPUTimeMillis = ceilMillis(cpuTime)
+	}
+}
+
+// ceilMillis returns the duration in milliseconds, rounded up so that any
+// measured time is non-zero.
+func ceilMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
 }
 
 func (r *Results) runCompletedSuccessfully() {
@@ -270,37 +914,68 @@ func (r *Results) runCompletedSuccessfully() {
 
 // Track the count of new links, and optionally keep a list of linkable or
 // linked pathnames for later output.
-func (r *Results) foundNewLink(srcP, dstP P.Pathsplit) {
+func (r *Results) foundNewLink(srcPI, dstPI I.PathInfo, dev uint64) {
 	r.NewLinkCount++
+	src := srcPI.Join()
+	dst := dstPI.Join()
+	r.linkedInode(dev, uint64(srcPI.Ino))
+	r.linkedInode(dev, uint64(dstPI.Ino))
+	if r.Opts.assertsPredictedSavings() {
+		l := r.linkedAway(devIno{dev, uint64(dstPI.Ino)})
+		l.paths = append(l.paths, dst)
+		r.expectNlinkChange(devIno{dev, uint64(srcPI.Ino)}, srcPI.Nlink, true)
+		r.expectNlinkChange(devIno{dev, uint64(dstPI.Ino)}, dstPI.Nlink, false)
+	}
+	if d := r.deviceResults(dev); d != nil {
+		d.NewLinkCount++
+		d.LinkPaths = appendLinkPair(d.LinkPaths, src, dst)
+	}
+	if r.Opts.storesLinkGroups() {
+		r.moveLinkGroupPath(devIno{dev, uint64(dstPI.Ino)}, dst)
+		r.addLinkGroupPaths(devIno{dev, uint64(srcPI.Ino)}, srcPI.Size, src, dst)
+	}
 	if !r.Opts.StoreNewLinkResults {
 		return
 	}
-	src := srcP.Join()
-	dst := dstP.Join()
-	N := len(r.LinkPaths)
-	if N == 0 {
-		r.LinkPaths = [][]string{[]string{src, dst}}
-	} else {
-		prevSrc := r.LinkPaths[N-1][0]
-		if src == prevSrc {
-			r.LinkPaths[N-1] = append(r.LinkPaths[N-1], dst)
-		} else {
-			pair := []string{src, dst}
-			r.LinkPaths = append(r.LinkPaths, pair)
-		}
+	if N := len(r.LinkPaths); N == 0 || r.LinkPaths[N-1][0] != src {
+		r.LinkPathSizes = append(r.LinkPathSizes, srcPI.Size)
+	}
+	r.LinkPaths = appendLinkPair(r.LinkPaths, src, dst)
+}
+
+// appendLinkPair adds the dst pathname to the last of the link groups if it
+// has the same src, otherwise a new [src, dst] group is added.
+func appendLinkPair(groups [][]string, src, dst string) [][]string {
+	N := len(groups)
+	if N > 0 && groups[N-1][0] == src {
+		groups[N-1] = append(groups[N-1], dst)
+		return groups
 	}
+	return append(groups, []string{src, dst})
 }
 
 // Track count of existing links found during walk, and optionally keep a list
 // of them and their sizes for later output.
-func (r *Results) foundExistingLink(srcP P.Pathsplit, dstP P.Pathsplit, size uint64) {
+func (r *Results) foundExistingLink(srcP P.Pathsplit, dstP P.Pathsplit, size uint64, dev uint64, ino I.Ino) {
 	r.ExistingLinkCount++
 	r.ExistingLinkByteAmount += size
+	src := srcP.Join()
+	dst := dstP.Join()
+	if r.Opts.storesLinkGroups() {
+		r.addLinkGroupPaths(devIno{dev, uint64(ino)}, size, src, dst)
+	}
+	if d := r.deviceResults(dev); d != nil {
+		d.ExistingLinkCount++
+		d.ExistingLinkByteAmount += size
+		d.ExistingLinks[src] = append(d.ExistingLinks[src], dst)
+	}
 	if !r.Opts.StoreExistingLinkResults {
 		return
 	}
-	src := srcP.Join()
-	dst := dstP.Join()
+	if r.Opts.SummarizeExistingLinks {
+		r.summarizeExistingLink(src, size)
+		return
+	}
 	dests, ok := r.ExistingLinks[src]
 	if !ok {
 		dests = []string{dst}
@@ -315,6 +990,104 @@ func (r *Results) foundExistingLink(srcP P.Pathsplit, dstP P.Pathsplit, size uin
 			src, size, r.ExistingLinkSizes[src]))
 }
 
+// summarizeExistingLink adds an existing link of the given size to the src
+// pathname's summary.
+func (r *Results) summarizeExistingLink(src string, size uint64) {
+	if r.ExistingLinkSummaries == nil {
+		r.ExistingLinkSummaries = make(map[string]ExistingLinkSummary)
+	}
+	sum := r.ExistingLinkSummaries[src]
+	sum.Links++
+	sum.Bytes += size
+	r.ExistingLinkSummaries[src] = sum
+}
+
+// hasStoredExistingLinks returns true if any existing links (or their
+// summaries) were stored.
+func (r *Results) hasStoredExistingLinks() bool {
+	return len(r.ExistingLinks) > 0 || len(r.ExistingLinkSummaries) > 0
+}
+
+// addLinkGroupPaths adds the pathnames to the LinkGroups entry for the given
+// inode, creating the entry if needed.  Pathnames already in the entry are not
+// added again.
+func (r *Results) addLinkGroupPaths(di devIno, size uint64, pathnames ...string) {
+	if r.linkGroupIndex == nil {
+		r.linkGroupIndex = make(map[devIno]int)
+	}
+	i, ok := r.linkGroupIndex[di]
+	if !ok {
+		i = len(r.LinkGroups)
+		r.linkGroupIndex[di] = i
+		r.LinkGroups = append(r.LinkGroups, LinkGroupInfo{
+			Dev:    di.dev,
+			SrcIno: di.ino,
+			Size:   size,
+		})
+	}
+	g := &r.LinkGroups[i]
+	for _, p := range pathnames {
+		found := false
+		for _, gp := range g.Paths {
+			if gp == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			g.Paths = append(g.Paths, p)
+		}
+	}
+}
+
+// needsLinkGroupDigest returns true if the LinkGroups entry for the given
+// inode doesn't yet have its content digest.
+func (r *Results) needsLinkGroupDigest(di devIno) bool {
+	i, ok := r.linkGroupIndex[di]
+	return ok && r.LinkGroups[i].Digest == ""
+}
+
+// setLinkGroupDigest sets the content digest of the LinkGroups entry for the
+// given inode.
+func (r *Results) setLinkGroupDigest(di devIno, digest string) {
+	if i, ok := r.linkGroupIndex[di]; ok {
+		r.LinkGroups[i].Digest = digest
+	}
+}
+
+// moveLinkGroupPath removes the pathname from the LinkGroups entry for the
+// given inode (if any), since it has been linked to another inode.  Entries
+// left with less than two pathnames are pruned when the Run() completes.
+func (r *Results) moveLinkGroupPath(di devIno, pathname string) {
+	i, ok := r.linkGroupIndex[di]
+	if !ok {
+		return
+	}
+	g := &r.LinkGroups[i]
+	for j, p := range g.Paths {
+		if p == pathname {
+			g.Paths = append(g.Paths[:j], g.Paths[j+1:]...)
+			break
+		}
+	}
+}
+
+// pruneLinkGroups removes the LinkGroups entries which no longer have any
+// links (ie. their pathnames were all moved to other inodes).
+func (r *Results) pruneLinkGroups() {
+	if r.LinkGroups == nil {
+		return
+	}
+	groups := r.LinkGroups[:0]
+	for _, g := range r.LinkGroups {
+		if len(g.Paths) > 1 {
+			groups = append(groups, g)
+		}
+	}
+	r.LinkGroups = groups
+	r.linkGroupIndex = nil
+}
+
 // Track the count of skipped new links (ie. those where linking was attempted,
 // but failed), and optionally keep a list of linkable or linked pathnames for
 // later output.
@@ -339,6 +1112,336 @@ func (r *Results) skippedNewLink(srcP, dstP P.Pathsplit) {
 	}
 }
 
+func (r *Results) foundUnusualInode(warning string) {
+	r.UnusualInodeWarnings = append(r.UnusualInodeWarnings, warning)
+}
+
+// foundAdvisoryGroup keeps a list of the pathnames of equal content files,
+// that would not be fully linked due to mismatched inode parameters.
+func (r *Results) foundAdvisoryGroup(pathnames []string) {
+	r.AdvisoryGroups = append(r.AdvisoryGroups, pathnames)
+}
+
+// foundNearNlinkLimit records (or updates the nlink count of) an inode that is
+// near the maximum nlink count.
+func (r *Results) foundNearNlinkLimit(dev, ino uint64, pathname string, nlink, maxNlink uint64) {
+	if r.nearNlinkIndex == nil {
+		r.nearNlinkIndex = make(map[devIno]int)
+	}
+	di := devIno{dev, ino}
+	if i, ok := r.nearNlinkIndex[di]; ok {
+		r.NearNlinkLimitInodes[i].Nlink = nlink
+		return
+	}
+	r.nearNlinkIndex[di] = len(r.NearNlinkLimitInodes)
+	r.NearNlinkLimitInodes = append(r.NearNlinkLimitInodes,
+		NlinkLimitInode{Path: pathname, Nlink: nlink, MaxNlink: maxNlink})
+}
+
+// checkExistingCluster records the existing link cluster of the given pathname
+// if its inode doesn't have the expected permission bits or ownership.
+func (r *Results) checkExistingCluster(pathname string, si *I.StatInfo) {
+	o := r.Opts
+	perm := si.Mode.Perm()
+	if (o.ExpectedClusterPerm == 0 || perm == o.ExpectedClusterPerm) &&
+		(!o.CheckClusterUid || si.Uid == o.ExpectedClusterUid) &&
+		(!o.CheckClusterGid || si.Gid == o.ExpectedClusterGid) {
+		return
+	}
+	r.ExistingClusterAnomalies = append(r.ExistingClusterAnomalies,
+		ExistingClusterAnomaly{Path: pathname, Perm: perm, Uid: si.Uid, Gid: si.Gid})
+}
+
+// fragmentationSlack is the allocation beyond a file's size that is expected
+// from block rounding (and small preallocations), and isn't considered a
+// fragmentation risk.
+const fragmentationSlack = 64 * 1024
+
+// isFragmentationRisk returns true if the allocated bytes of the inode are
+// more than twice (plus some slack) its size, or less than half of it.
+func isFragmentationRisk(si I.StatInfo) bool {
+	if si.Size == 0 {
+		return false
+	}
+	allocated := si.Blocks * 512
+	return allocated > 2*si.Size+fragmentationSlack || 2*allocated < si.Size
+}
+
+// checkFragmentationRisk records the pathname if its inode (which is checked
+// just once) is a fragmentation risk.
+func (r *Results) checkFragmentationRisk(dev uint64, pi I.PathInfo) {
+	if r.fragmentationChecked == nil {
+		r.fragmentationChecked = make(map[devIno]bool)
+	}
+	di := devIno{dev, uint64(pi.Ino)}
+	if r.fragmentationChecked[di] {
+		return
+	}
+	r.fragmentationChecked[di] = true
+	if isFragmentationRisk(pi.StatInfo) {
+		r.FragmentationRiskPaths = append(r.FragmentationRiskPaths, pi.Join())
+	}
+}
+
+func (r *Results) foundMtimeSpreadGroup(g MtimeSpreadGroup) {
+	r.MtimeSpreadGroups = append(r.MtimeSpreadGroups, g)
+}
+
+// foundDuplicatedGroup keeps the (sorted) pathnames of a group of identical
+// files, if it is larger than any previously found group.  Equal sized groups
+// are ordered by their first pathname, for consistent results.
+func (r *Results) foundDuplicatedGroup(pathnames []string) {
+	n := int64(len(pathnames))
+	if n < r.MaxDuplicationCount {
+		return
+	}
+	if n == r.MaxDuplicationCount && len(r.MostDuplicatedPaths) > 0 &&
+		pathnames[0] >= r.MostDuplicatedPaths[0] {
+		return
+	}
+	r.MaxDuplicationCount = n
+	r.MostDuplicatedPaths = pathnames
+}
+
+// foundSimilarGroup keeps a list of the pathnames of files with substantially
+// similar, but not identical, content.
+func (r *Results) foundSimilarGroup(pathnames []string) {
+	r.SimilarGroups = append(r.SimilarGroups, pathnames)
+}
+
+// foundHashBucketPath adds the pathname to the list of its inode hash bucket
+// (for debugging the distribution of the inode hashes).
+func (r *Results) foundHashBucketPath(hash uint64, pathname string) {
+	if r.HashBuckets == nil {
+		r.HashBuckets = make(map[uint64][]string)
+	}
+	r.HashBuckets[hash] = append(r.HashBuckets[hash], pathname)
+}
+
+// Filter returns a copy of the Results, with the link path groups (and the
+// other pathname results) limited to those having at least one pathname with
+// the given prefix.  The RunStats link counts and amounts, and the removed
+// inode counts and amounts, are recomputed for the filtered groups when the
+// corresponding link results were stored (other stats are unchanged).  The
+// DeviceResults aren't filtered, and are omitted from the copy.
+func (r Results) Filter(prefix string) Results {
+	hasPrefix := func(pathnames ...string) bool {
+		for _, p := range pathnames {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	filterGroups := func(groups [][]string) [][]string {
+		var filtered [][]string
+		for _, g := range groups {
+			if hasPrefix(g...) {
+				filtered = append(filtered, append([]string(nil), g...))
+			}
+		}
+		return filtered
+	}
+	filterPaths := func(pathnames []string) []string {
+		var filtered []string
+		for _, p := range pathnames {
+			if hasPrefix(p) {
+				filtered = append(filtered, p)
+			}
+		}
+		return filtered
+	}
+
+	inDir := func(dirname string) bool {
+		return hasPrefix(dirname + "/")
+	}
+
+	// The filtered Results shares no maps or slices with r
+	f := r
+	f.ExistingLinks = make(map[string][]string)
+	f.ExistingLinkSizes = make(map[string]uint64)
+	for src, dsts := range r.ExistingLinks {
+		if hasPrefix(src) || hasPrefix(dsts...) {
+			f.ExistingLinks[src] = append([]string(nil), dsts...)
+			f.ExistingLinkSizes[src] = r.ExistingLinkSizes[src]
+		}
+	}
+	if r.ExistingLinkSummaries != nil {
+		f.ExistingLinkSummaries = make(map[string]ExistingLinkSummary)
+		for src, sum := range r.ExistingLinkSummaries {
+			if hasPrefix(src) {
+				f.ExistingLinkSummaries[src] = sum
+			}
+		}
+	}
+	f.LinkPaths = filterGroups(r.LinkPaths)
+	f.LinkPathSizes = nil
+	if len(r.LinkPathSizes) == len(r.LinkPaths) {
+		for i, g := range r.LinkPaths {
+			if hasPrefix(g...) {
+				f.LinkPathSizes = append(f.LinkPathSizes, r.LinkPathSizes[i])
+			}
+		}
+	}
+	f.SkippedLinkPaths = filterGroups(r.SkippedLinkPaths)
+	f.FailedGroups = filterGroups(r.FailedGroups)
+	f.AdvisoryGroups = filterGroups(r.AdvisoryGroups)
+	f.SimilarGroups = filterGroups(r.SimilarGroups)
+	f.RemovedInodeDirBytes = nil
+	for dirname, size := range r.RemovedInodeDirBytes {
+		if inDir(dirname) {
+			if f.RemovedInodeDirBytes == nil {
+				f.RemovedInodeDirBytes = make(map[string]uint64)
+			}
+			f.RemovedInodeDirBytes[dirname] = size
+		}
+	}
+	f.RemovedInodeDirCounts = nil
+	for dirname, n := range r.RemovedInodeDirCounts {
+		if inDir(dirname) {
+			if f.RemovedInodeDirCounts == nil {
+				f.RemovedInodeDirCounts = make(map[string]int64)
+			}
+			f.RemovedInodeDirCounts[dirname] = n
+		}
+	}
+	f.MostDuplicatedPaths = nil
+	if hasPrefix(r.MostDuplicatedPaths...) {
+		f.MostDuplicatedPaths = append([]string(nil), r.MostDuplicatedPaths...)
+	}
+	f.LinkGroups, f.linkGroupIndex = nil, nil
+	for _, g := range r.LinkGroups {
+		if hasPrefix(g.Paths...) {
+			if f.linkGroupIndex == nil {
+				f.linkGroupIndex = make(map[devIno]int)
+			}
+			f.linkGroupIndex[devIno{g.Dev, g.SrcIno}] = len(f.LinkGroups)
+			g.Paths = append([]string(nil), g.Paths...)
+			f.LinkGroups = append(f.LinkGroups, g)
+		}
+	}
+	f.ExcludedFilePaths = filterPaths(r.ExcludedFilePaths)
+	f.ExcludedDirPaths = filterPaths(r.ExcludedDirPaths)
+	f.FragmentationRiskPaths = filterPaths(r.FragmentationRiskPaths)
+
+	f.ExistingClusterAnomalies = nil
+	for _, a := range r.ExistingClusterAnomalies {
+		if hasPrefix(a.Path) {
+			f.ExistingClusterAnomalies = append(f.ExistingClusterAnomalies, a)
+		}
+	}
+	nearNlinkInos := make(map[int]devIno, len(r.nearNlinkIndex))
+	for di, i := range r.nearNlinkIndex {
+		nearNlinkInos[i] = di
+	}
+	f.NearNlinkLimitInodes, f.nearNlinkIndex = nil, nil
+	for i, n := range r.NearNlinkLimitInodes {
+		if hasPrefix(n.Path) {
+			if di, ok := nearNlinkInos[i]; ok {
+				if f.nearNlinkIndex == nil {
+					f.nearNlinkIndex = make(map[devIno]int)
+				}
+				f.nearNlinkIndex[di] = len(f.NearNlinkLimitInodes)
+			}
+			f.NearNlinkLimitInodes = append(f.NearNlinkLimitInodes, n)
+		}
+	}
+	f.MtimeSpreadGroups = nil
+	for _, g := range r.MtimeSpreadGroups {
+		if hasPrefix(g.Paths...) {
+			g.Paths = append([]string(nil), g.Paths...)
+			g.Mtimes = append([]time.Time(nil), g.Mtimes...)
+			f.MtimeSpreadGroups = append(f.MtimeSpreadGroups, g)
+		}
+	}
+	f.HashBuckets = nil
+	for H, pathnames := range r.HashBuckets {
+		if filtered := filterPaths(pathnames); len(filtered) > 0 {
+			if f.HashBuckets == nil {
+				f.HashBuckets = make(map[uint64][]string)
+			}
+			f.HashBuckets[H] = filtered
+		}
+	}
+	f.UnusualInodeWarnings = nil
+	for _, w := range r.UnusualInodeWarnings {
+		if strings.Contains(w, "'"+prefix) {
+			f.UnusualInodeWarnings = append(f.UnusualInodeWarnings, w)
+		}
+	}
+	f.unlinkedInodes = nil
+	for di, u := range r.unlinkedInodes {
+		if hasPrefix(u.Path) {
+			if f.unlinkedInodes == nil {
+				f.unlinkedInodes = make(map[devIno]UnlinkedInode)
+			}
+			f.unlinkedInodes[di] = u
+		}
+	}
+	f.inodePaths = nil
+	for di, pathnames := range r.inodePaths {
+		if hasPrefix(pathnames...) {
+			if f.inodePaths == nil {
+				f.inodePaths = make(map[devIno][]string)
+			}
+			f.inodePaths[di] = append([]string(nil), pathnames...)
+		}
+	}
+	if r.LinkPlan != nil {
+		f.LinkPlan = &LinkPlan{}
+		for _, l := range r.Links {
+			if hasPrefix(l.Src.Path, l.Dst.Path) {
+				f.Links = append(f.Links, l)
+			}
+		}
+	}
+	f.groupComparisons = nil
+	for n, count := range r.groupComparisons {
+		if f.groupComparisons == nil {
+			f.groupComparisons = make(map[int64]int64)
+		}
+		f.groupComparisons[n] = count
+	}
+
+	// The per-device results, and the state kept for the link phase,
+	// aren't filtered.
+	f.DeviceResults = nil
+	f.blockSizes = nil
+	f.fragmentationChecked = nil
+	f.linkedAwayInodes = nil
+	f.expectedNlinks = nil
+
+	if r.Opts.StoreExistingLinkResults {
+		f.ExistingLinkCount = 0
+		f.ExistingLinkByteAmount = 0
+		for src, dsts := range f.ExistingLinks {
+			f.ExistingLinkCount += int64(len(dsts))
+			f.ExistingLinkByteAmount += f.ExistingLinkSizes[src] * uint64(len(dsts))
+		}
+		for _, sum := range f.ExistingLinkSummaries {
+			f.ExistingLinkCount += sum.Links
+			f.ExistingLinkByteAmount += sum.Bytes
+		}
+	}
+	if r.Opts.StoreNewLinkResults {
+		f.NewLinkCount = 0
+		for _, g := range f.LinkPaths {
+			f.NewLinkCount += int64(len(g) - 1)
+		}
+		f.SkippedLinkErrCount = 0
+		for _, g := range f.SkippedLinkPaths {
+			f.SkippedLinkErrCount += int64(len(g) - 1)
+		}
+		f.InodeRemovedCount = 0
+		f.InodeRemovedByteAmount = 0
+		for dirname, n := range f.RemovedInodeDirCounts {
+			f.InodeRemovedCount += n
+			f.InodeRemovedByteAmount += f.RemovedInodeDirBytes[dirname]
+		}
+	}
+	return f
+}
+
 // OutputResults prints results in text form, including existing links that
 // were found, new pathnames that were discovered to be linkable, and stats
 // about the run giving information on the amount of data that can be saved (or
@@ -346,8 +1449,24 @@ func (r *Results) skippedNewLink(srcP, dstP P.Pathsplit) {
 func (r *Results) OutputResults() {
 	showStats := r.Opts.ShowRunStats || r.Opts.ShowExtendedRunStats
 
+	r.OutputUnusualInodeWarnings()
+	if len(r.UnusualInodeWarnings) > 0 &&
+		(len(r.ExcludedFilePaths) > 0 || len(r.ExcludedDirPaths) > 0 ||
+			r.hasStoredExistingLinks() || len(r.LinkPaths) > 0 ||
+			len(r.SkippedLinkPaths) > 0 || len(r.AdvisoryGroups) > 0 ||
+			len(r.SimilarGroups) > 0 || showStats) {
+		fmt.Println("")
+	}
+
+	r.OutputExcludedPaths()
+	if (len(r.ExcludedFilePaths) > 0 || len(r.ExcludedDirPaths) > 0) &&
+		(r.hasStoredExistingLinks() || len(r.LinkPaths) > 0 ||
+			len(r.SkippedLinkPaths) > 0 || showStats) {
+		fmt.Println("")
+	}
+
 	r.OutputExistingLinks()
-	if len(r.ExistingLinks) > 0 &&
+	if r.hasStoredExistingLinks() &&
 		(len(r.LinkPaths) > 0 || len(r.SkippedLinkPaths) > 0 || showStats) {
 		fmt.Println("")
 	}
@@ -358,7 +1477,18 @@ func (r *Results) OutputResults() {
 	}
 
 	r.OutputSkippedNewLinks()
-	if len(r.SkippedLinkPaths) > 0 && showStats {
+	if len(r.SkippedLinkPaths) > 0 &&
+		(len(r.AdvisoryGroups) > 0 || len(r.SimilarGroups) > 0 || showStats) {
+		fmt.Println("")
+	}
+
+	r.OutputAdvisoryGroups()
+	if len(r.AdvisoryGroups) > 0 && (len(r.SimilarGroups) > 0 || showStats) {
+		fmt.Println("")
+	}
+
+	r.OutputSimilarGroups()
+	if len(r.SimilarGroups) > 0 && showStats {
 		fmt.Println("")
 	}
 
@@ -367,16 +1497,62 @@ func (r *Results) OutputResults() {
 	}
 }
 
+// OutputUnusualInodeWarnings shows the warnings about unusually linked special
+// files and directories (if WarnUnusualInodes was enabled).
+func (r *Results) OutputUnusualInodeWarnings() {
+	if len(r.UnusualInodeWarnings) == 0 {
+		return
+	}
+	s := make([]string, 0)
+	s = append(s, "Unusual inode warnings")
+	s = append(s, "----------------------")
+	s = append(s, r.UnusualInodeWarnings...)
+	fmt.Println(strings.Join(s, "\n"))
+}
+
+// OutputExcludedPaths shows in text form the dir and file pathnames that were
+// excluded by the include/exclude regexes (if StoreExcludedPaths was enabled).
+func (r *Results) OutputExcludedPaths() {
+	if len(r.ExcludedDirPaths) == 0 && len(r.ExcludedFilePaths) == 0 {
+		return
+	}
+	s := make([]string, 0)
+	s = append(s, "Excluded paths")
+	s = append(s, "--------------")
+	for _, p := range r.ExcludedDirPaths {
+		s = append(s, "dir:  "+p)
+	}
+	for _, p := range r.ExcludedFilePaths {
+		s = append(s, "file: "+p)
+	}
+	fmt.Println(strings.Join(s, "\n"))
+}
+
 // OutputExistingLinks shows in text form the existing links that were found by
 // Run.
 func (r *Results) OutputExistingLinks() {
-	if len(r.ExistingLinks) == 0 {
+	if !r.hasStoredExistingLinks() {
 		return
 	}
 	s := make([]string, 0)
 	s = append(s, "Currently hardlinked files")
 	s = append(s, "--------------------------")
-	for src, dsts := range r.ExistingLinks {
+	if len(r.ExistingLinkSummaries) > 0 {
+		r.outputExistingLinkSummaries(s)
+		return
+	}
+	srcs := make([]string, 0, len(r.ExistingLinks))
+	for src := range r.ExistingLinks {
+		srcs = append(srcs, src)
+	}
+	if r.Opts.SortOutputByPath {
+		sort.Strings(srcs)
+	}
+	for _, src := range srcs {
+		dsts := r.ExistingLinks[src]
+		if r.Opts.SortOutputByPath {
+			dsts = sortedPaths(dsts)
+		}
 		s = append(s, fmt.Sprintf("from: %v", src))
 		for _, dst := range dsts {
 			s = append(s, fmt.Sprintf("  to: %v", dst))
@@ -393,6 +1569,24 @@ func (r *Results) OutputExistingLinks() {
 	}
 }
 
+// outputExistingLinkSummaries shows the existing link counts of each src
+// pathname, after the given header lines.
+func (r *Results) outputExistingLinkSummaries(s []string) {
+	srcs := make([]string, 0, len(r.ExistingLinkSummaries))
+	for src := range r.ExistingLinkSummaries {
+		srcs = append(srcs, src)
+	}
+	if r.Opts.SortOutputByPath {
+		sort.Strings(srcs)
+	}
+	for _, src := range srcs {
+		sum := r.ExistingLinkSummaries[src]
+		s = append(s, fmt.Sprintf("from: %v  links: %v  Total saved: %v",
+			src, sum.Links, Humanize(sum.Bytes)))
+	}
+	fmt.Println(strings.Join(s, "\n"))
+}
+
 // OutputNewLinks shows in text form the pathnames that were discovered to be
 // linkable.
 func (r *Results) OutputNewLinks() {
@@ -407,7 +1601,7 @@ func (r *Results) OutputNewLinks() {
 		s = append(s, "Files that are hardlinkable")
 		s = append(s, "---------------------------")
 	}
-	outputLinkPaths(s, r.LinkPaths)
+	outputLinkPaths(s, r.sortedLinkPaths(r.LinkPaths))
 }
 
 // OutputSkippedNewLinks shows in text form the pathnames that were skipped due
@@ -419,10 +1613,74 @@ func (r *Results) OutputSkippedNewLinks() {
 	s := make([]string, 0)
 	s = append(s, "Files that had linking errors this run")
 	s = append(s, "--------------------------------------")
-	outputLinkPaths(s, r.SkippedLinkPaths)
+	outputLinkPaths(s, r.sortedLinkPaths(r.SkippedLinkPaths))
+	fmt.Println(strings.Join(s, "\n"))
+}
+
+// OutputAdvisoryGroups shows in text form the groups of files with equal
+// content, which are not all linkable due to mismatched inode parameters.
+func (r *Results) OutputAdvisoryGroups() {
+	if len(r.AdvisoryGroups) == 0 {
+		return
+	}
+	s := make([]string, 0)
+	s = append(s, "Equal files with mismatched inode parameters")
+	s = append(s, "--------------------------------------------")
+	for i, group := range r.AdvisoryGroups {
+		if i > 0 {
+			s = append(s, "")
+		}
+		s = append(s, group...)
+	}
 	fmt.Println(strings.Join(s, "\n"))
 }
 
+// OutputSimilarGroups shows the groups of files that share substantial, but not
+// identical, content (if ReportSimilar was enabled).
+func (r *Results) OutputSimilarGroups() {
+	if len(r.SimilarGroups) == 0 {
+		return
+	}
+	s := make([]string, 0)
+	s = append(s, "Similar (but not identical) files")
+	s = append(s, "---------------------------------")
+	for i, group := range r.SimilarGroups {
+		if i > 0 {
+			s = append(s, "")
+		}
+		s = append(s, group...)
+	}
+	fmt.Println(strings.Join(s, "\n"))
+}
+
+// sortedLinkPaths returns the link path groups sorted by their src pathname
+// (with their dst pathnames also sorted), if the SortOutputByPath option is
+// enabled.  Otherwise the groups are returned unchanged.
+func (r *Results) sortedLinkPaths(lp [][]string) [][]string {
+	if !r.Opts.SortOutputByPath {
+		return lp
+	}
+	sorted := make([][]string, 0, len(lp))
+	for _, paths := range lp {
+		if len(paths) == 0 {
+			continue
+		}
+		g := append([]string{paths[0]}, sortedPaths(paths[1:])...)
+		sorted = append(sorted, g)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i][0] < sorted[j][0]
+	})
+	return sorted
+}
+
+// sortedPaths returns a sorted copy of the pathnames
+func sortedPaths(pathnames []string) []string {
+	s := append([]string(nil), pathnames...)
+	sort.Strings(s)
+	return s
+}
+
 // outputLinkPaths is a helper for outputting LinkPaths slices
 func outputLinkPaths(s []string, lp [][]string) {
 	for _, paths := range lp {
@@ -466,6 +1724,9 @@ func (r *Results) OutputRunStats() {
 	}
 	s = statStr(s, "Directories", r.DirCount)
 	s = statStr(s, "Files", r.FileCount)
+	if r.HitFileLimit {
+		s = statStr(s, "Walk stopped at file limit", r.Opts.MaxFiles)
+	}
 	if r.Opts.LinkingEnabled {
 		s = statStr(s, "Hardlinked this run", r.NewLinkCount)
 		s = statStr(s, "Removed inodes", r.InodeRemovedCount)
@@ -473,6 +1734,12 @@ func (r *Results) OutputRunStats() {
 		s = statStr(s, "Hardlinkable this run", r.NewLinkCount)
 		s = statStr(s, "Removable inodes", r.InodeRemovedCount)
 	}
+	if r.HitInodeTarget {
+		s = statStr(s, "Stopped at inode target", r.Opts.TargetInodeReduction)
+	}
+	if r.BelowMinDuplicateCount > 0 {
+		s = statStr(s, "Groups below min duplicates", r.BelowMinDuplicateCount)
+	}
 	s = statStr(s, "Currently linked bytes", r.ExistingLinkByteAmount, humanizeParens(r.ExistingLinkByteAmount))
 	totalBytes := r.ExistingLinkByteAmount + r.InodeRemovedByteAmount
 	var s1, s2 string
@@ -493,6 +1760,8 @@ func (r *Results) OutputRunStats() {
 	if r.Opts.ShowExtendedRunStats || r.Opts.DebugLevel > 0 {
 		s = statStr(s, "Comparisons", r.ComparisonCount)
 		s = statStr(s, "Inodes", r.InodeCount)
+		s = statStr(s, "Devices", r.DeviceCount)
+		s = statStr(s, "Unique contents", r.UniqueContentCount)
 		unwalkedNlinks := r.NlinkCount - r.FileCount
 		if unwalkedNlinks > 0 {
 			unwalkedNlinkStr := fmt.Sprintf("(Unwalked Nlinks: %v)", unwalkedNlinks)
@@ -500,12 +1769,21 @@ func (r *Results) OutputRunStats() {
 		}
 		s = statStr(s, "Existing links", r.ExistingLinkCount)
 		s = statStr(s, "Total old + new links", totalLinks)
+		if r.MaxDuplicationCount > 1 {
+			s = statStr(s, "Most duplicated file count", r.MaxDuplicationCount)
+		}
 		if r.FileTooLargeCount > 0 {
 			s = statStr(s, "Total too large files", r.FileTooLargeCount)
 		}
 		if r.FileTooSmallCount > 0 {
 			s = statStr(s, "Total too small files", r.FileTooSmallCount)
 		}
+		if r.TooRecentFileCount > 0 {
+			s = statStr(s, "Total too recent files", r.TooRecentFileCount)
+		}
+		if r.NonBlockAlignedSkipCount > 0 {
+			s = statStr(s, "Total non block aligned files", r.NonBlockAlignedSkipCount)
+		}
 		if r.ExcludedDirCount > 0 {
 			s = statStr(s, "Total excluded dirs", r.ExcludedDirCount)
 		}
@@ -542,6 +1820,10 @@ func (r *Results) OutputRunStats() {
 		if r.BytesCompared > 0 {
 			s = statStr(s, "Total bytes compared", r.BytesCompared,
 				humanizeParens(r.BytesCompared))
+			s = statStr(s, "Max comparison bytes", r.MaxComparisonBytes,
+				humanizeParens(r.MaxComparisonBytes))
+			s = statStr(s, "Avg comparison bytes", r.AvgComparisonBytes,
+				humanizeParens(r.AvgComparisonBytes))
 		}
 
 		remainingInodes := r.InodeCount - r.InodeRemovedCount
@@ -556,6 +1838,21 @@ func (r *Results) OutputRunStats() {
 		if r.SkippedNonPermBitCount > 0 {
 			s = statStr(s, "Skipped files with non-perm bits set", r.SkippedNonPermBitCount)
 		}
+		if r.UnchangedSinceMarkerCount > 0 {
+			s = statStr(s, "Skipped unchanged files", r.UnchangedSinceMarkerCount)
+		}
+		if r.SkippedVolatileCount > 0 {
+			s = statStr(s, "Skipped volatile files", r.SkippedVolatileCount)
+		}
+		if r.TooDeepPathCount > 0 {
+			s = statStr(s, "Skipped too deep files", r.TooDeepPathCount)
+		}
+		if r.SameNameSplitCount > 0 {
+			s = statStr(s, "Same name split inodes", r.SameNameSplitCount)
+		}
+		if r.DeniedDigestSkipCount > 0 {
+			s = statStr(s, "Skipped denied digest inodes", r.DeniedDigestSkipCount)
+		}
 		if r.SkippedDirErrCount > 0 {
 			s = statStr(s, "Dir errors this run", r.SkippedDirErrCount)
 		}
@@ -565,6 +1862,45 @@ func (r *Results) OutputRunStats() {
 		if r.SkippedLinkErrCount > 0 {
 			s = statStr(s, "Link errors this run", r.SkippedLinkErrCount)
 		}
+		if r.ComparisonErrorCount > 0 {
+			s = statStr(s, "Comparison errors this run", r.ComparisonErrorCount)
+		}
+		if r.SkippedReadonlyDirCount > 0 {
+			s = statStr(s, "Read-only dir links skipped", r.SkippedReadonlyDirCount)
+		}
+		if r.SkippedOpenFileCount > 0 {
+			s = statStr(s, "Open file links skipped", r.SkippedOpenFileCount)
+		}
+		if len(r.ExistingClusterAnomalies) > 0 {
+			s = statStr(s, "Existing cluster anomalies", int64(len(r.ExistingClusterAnomalies)))
+		}
+		if len(r.FragmentationRiskPaths) > 0 {
+			s = statStr(s, "Fragmentation risk files", int64(len(r.FragmentationRiskPaths)))
+		}
+		if len(r.NearNlinkLimitInodes) > 0 {
+			s = statStr(s, "Inodes near nlink limit", int64(len(r.NearNlinkLimitInodes)))
+		}
+		if r.Opts.CanonicalStoreDir != "" {
+			s = statStr(s, "Canonical store files added", r.CanonicalStoredCount,
+				fmt.Sprintf("(relinked: %v  skipped groups: %v)",
+					r.CanonicalLinkedCount, r.CanonicalSkippedCount))
+		}
+		if r.RecompareCount > 0 {
+			s = statStr(s, "Recompared before linking", r.RecompareCount,
+				humanizeParens(r.RecompareBytes))
+		}
+		if r.ContentChangedBeforeLinkCount > 0 {
+			s = statStr(s, "Changed content links skipped", r.ContentChangedBeforeLinkCount)
+		}
+		if r.ModifiedBeforeApplyCount > 0 {
+			s = statStr(s, "Modified file links skipped", r.ModifiedBeforeApplyCount)
+		}
+		if r.FailedGroupCount > 0 {
+			s = statStr(s, "Link groups failed", r.FailedGroupCount)
+		}
+		if r.RootVanishedCount > 0 {
+			s = statStr(s, "Vanished dirs this run", r.RootVanishedCount)
+		}
 	}
 
 	if r.Opts.DebugLevel > 0 {
@@ -582,14 +1918,51 @@ func (r *Results) OutputRunStats() {
 		}
 		s = statStr(s, "Total hash list iterations", r.InoSeqIterationCount,
 			fmt.Sprintf("(avg per search: %v)", avgItersPerSearch))
+		s = statStr(s, "Total unique size skips", r.UniqueSizeSkipCount)
+		s = statStr(s, "Total consolidation skips", r.ConsolidationSkipCount)
+		s = statStr(s, "Peak inodes held", r.PeakInodeCount)
 		s = statStr(s, "Total equal comparisons", r.EqualComparisonCount)
+		if len(r.groupComparisons) > 0 {
+			c := r.ComparisonsPerGroup
+			s = statStr(s, "Comparisons per group", fmt.Sprintf("min: %v  median: %v  max: %v",
+				c.Min, c.Median, c.Max))
+		}
 		s = statStr(s, "Total digests computed", r.DigestComputedCount)
+		if r.Opts.MaxDigestFileSize > 0 {
+			s = statStr(s, "Total digest size skips", r.DigestSizeSkipCount)
+		}
+		s = statStr(s, "Total digest reordered inos", r.DigestReorderedInoCount,
+			fmt.Sprintf("(first hits: %v)", r.DigestFirstHitCount))
+		if r.Opts.QuickPrefixCompare {
+			s = statStr(s, "Total quick prefix rejects", r.QuickPrefixRejectCount)
+		}
+		if r.Opts.CompareTailFirst {
+			s = statStr(s, "Total quick tail rejects", r.QuickTailRejectCount)
+		}
+		if r.XAttrCompareCount > 0 {
+			s = statStr(s, "Total xattr comparisons", r.XAttrCompareCount)
+		}
+		if r.Opts.UseMmap {
+			s = statStr(s, "Total mmap comparisons", r.MmapComparisonCount)
+		}
+		if r.Opts.ProfileTiming {
+			s = statStr(s, "Total IO time", time.Duration(r.IOTimeMillis)*time.Millisecond)
+			s = statStr(s, "Total CPU time", time.Duration(r.CPUTimeMillis)*time.Millisecond)
+		}
+		if len(r.SrcSelectionCounts) > 0 {
+			c := r.SrcSelectionCounts
+			s = statStr(s, "Total link src selections", fmt.Sprintf("newest: %v  oldest: %v  most linked: %v",
+				c[SrcSelectedNewest], c[SrcSelectedOldest], c[SrcSelectedMostLinked]))
+		}
 		if r.FailedLinkChtimesCount > 0 {
 			s = statStr(s, "Failed link Chtimes", r.FailedLinkChtimesCount)
 		}
 		if r.FailedLinkChownCount > 0 {
 			s = statStr(s, "Failed link Chown", r.FailedLinkChownCount)
 		}
+		if r.FailedAtimeRestoreCount > 0 {
+			s = statStr(s, "Failed atime restores", r.FailedAtimeRestoreCount)
+		}
 	}
 
 	if r.Opts.DebugLevel > 1 {
@@ -599,6 +1972,10 @@ func (r *Results) OutputRunStats() {
 		s = statStr(s, "Mem Alloc", Humanize(m.Alloc))
 		s = statStr(s, "Mem Sys", Humanize(m.Sys))
 		s = statStr(s, "Num live objects", m.Mallocs-m.Frees)
+		if r.PooledStringCount > 0 {
+			s = statStr(s, "Pooled strings", r.PooledStringCount,
+				fmt.Sprintf("bytes saved: %v", Humanize(r.PooledStringBytesSaved)))
+		}
 	}
 	printSlices(s)
 
@@ -609,12 +1986,137 @@ func (r *Results) OutputRunStats() {
 
 // OutputJSONResults outputs a JSON formatted object with all the information
 // gathered by Run() about existing and new links, and stats on space saved,
-// etc.
+// etc.  A marshaling error is output to stderr instead.
 func (r *Results) OutputJSONResults() {
-	b, _ := json.Marshal(r)
+	b, err := r.MarshalJSONResults()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
 	fmt.Println(string(b))
 }
 
+// MarshalJSONResults returns the JSON formatted object that OutputJSONResults
+// outputs, so that it can be written elsewhere.
+func (r Results) MarshalJSONResults() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LargestLinkableFiles returns up to n of the largest files that were linked
+// (or are linkable), from the largest down, when StoreNewLinkResults is
+// enabled.  Files of equal size are ordered by pathname.  The sizes come from
+// LinkPathSizes, so Results without a size for each of the LinkPaths groups
+// (such as those saved by older versions) return no files.
+func (r *Results) LargestLinkableFiles(n int) []FileEntry {
+	var files []FileEntry
+	if len(r.LinkPathSizes) == len(r.LinkPaths) {
+		for i, g := range r.LinkPaths {
+			for _, dst := range g[1:] {
+				files = append(files, FileEntry{Path: dst, Size: r.LinkPathSizes[i]})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Path < files[j].Path
+	})
+	if n < 0 {
+		n = 0
+	}
+	if n < len(files) {
+		files = files[:n]
+	}
+	return files
+}
+
+// DirectoriesWithSavings returns the sorted, unique directories that contain
+// at least one linked (or linkable) dst pathname, when StoreNewLinkResults is
+// enabled.
+func (r *Results) DirectoriesWithSavings() []string {
+	seen := make(map[string]bool)
+	dirs := []string{}
+	for _, g := range r.LinkPaths {
+		for _, dst := range g[1:] {
+			dir := path.Dir(dst)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// PathsForInode returns the sorted walked pathnames of the given inode, as they
+// are after any linking, when the StoreInodePaths option is enabled.  Returns
+// nil if the inode wasn't found.
+func (r *Results) PathsForInode(dev, ino uint64) []string {
+	return r.inodePaths[devIno{dev, ino}]
+}
+
+func (r *Results) foundInodePaths(dev, ino uint64, pathnames []string) {
+	if r.inodePaths == nil {
+		r.inodePaths = make(map[devIno][]string)
+	}
+	r.inodePaths[devIno{dev, ino}] = pathnames
+}
+
+// CurrentlySavedBytes returns the space currently saved by the existing links
+// found between the walked pathnames.
+func (r *Results) CurrentlySavedBytes() uint64 {
+	return r.ExistingLinkByteAmount
+}
+
+// OutputCurrentlySaved prints only the existing link stats, such as for a Run()
+// with the ExistingLinksOnly option.
+func (r *Results) OutputCurrentlySaved() {
+	s := make([][]string, 0)
+	s = statStr(s, "Files", r.FileCount)
+	s = statStr(s, "Existing links", r.ExistingLinkCount)
+	s = statStr(s, "Currently linked bytes", r.CurrentlySavedBytes(),
+		humanizeParens(r.CurrentlySavedBytes()))
+	printSlices(s)
+}
+
+// OneLineSummary returns a single line summary of the Run(), with the file
+// count, the (removable) removed inodes, the bytes saved (or saveable), and the
+// run time.  Suitable for cron emails and notifications.
+func (r *Results) OneLineSummary() string {
+	if r.Opts.LinkingEnabled {
+		return fmt.Sprintf("hardlinkable: %v files, removed %v inodes, saved %v in %v",
+			r.FileCount, r.InodeRemovedCount, Humanize(r.InodeRemovedByteAmount), r.RunTime)
+	}
+	return fmt.Sprintf("hardlinkable: %v files, removable %v inodes, saveable %v in %v",
+		r.FileCount, r.InodeRemovedCount, Humanize(r.InodeRemovedByteAmount), r.RunTime)
+}
+
+// WriteCompatSummary writes a summary of the Run() in the format of the
+// util-linux hardlink tool's summary lines, for scripts that parse its output.
+func (r *Results) WriteCompatSummary(w io.Writer) error {
+	mode := "dry-run"
+	if r.Opts.LinkingEnabled {
+		mode = "real"
+	}
+	duration := r.EndTime.Sub(r.StartTime).Seconds()
+	lines := [][2]string{
+		{"Mode:", mode},
+		{"Files:", fmt.Sprintf("%v", r.FileCount)},
+		{"Linked:", fmt.Sprintf("%v files", r.NewLinkCount)},
+		{"Compared:", fmt.Sprintf("%v files", r.ComparisonCount)},
+		{"Saved:", Humanize(r.InodeRemovedByteAmount)},
+		{"Duration:", fmt.Sprintf("%.6f seconds", duration)},
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintf(w, "%-25s %s\n", l[0], l[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Add a new row of string colums to the given slice of string slices
 func statStr(a [][]string, args ...interface{}) [][]string {
 	s := make([]string, 0)
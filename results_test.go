@@ -21,7 +21,21 @@
 package hardlinkable
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
 )
 
 func TestHumanize(t *testing.T) {
@@ -86,3 +100,570 @@ func TestHumanizedUint64(t *testing.T) {
 		}
 	}
 }
+
+func TestResultsFilter(t *testing.T) {
+	r := newResults(&Options{StoreExistingLinkResults: true, StoreNewLinkResults: true})
+	r.ExistingLinks = map[string][]string{
+		"A/e1": []string{"A/e2", "B/e3"},
+		"B/e4": []string{"B/e5"},
+	}
+	r.ExistingLinkSizes = map[string]uint64{"A/e1": 10, "B/e4": 20}
+	r.ExistingLinkCount = 3
+	r.ExistingLinkByteAmount = 40
+	r.LinkPaths = [][]string{
+		[]string{"A/f1", "A/f2", "A/f3"},
+		[]string{"B/f4", "A/f5"},
+		[]string{"B/f6", "B/f7"},
+	}
+	r.NewLinkCount = 4
+	r.SkippedLinkPaths = [][]string{[]string{"B/s1", "B/s2"}}
+	r.SkippedLinkErrCount = 1
+	r.foundRemovedInode(5, "A", 1, 100)
+	r.foundRemovedInode(7, "B", 1, 101)
+	r.foundRemovedInode(9, "B", 1, 102)
+	r.HashBuckets = map[uint64][]string{1: []string{"A/f1", "B/f6"}, 2: []string{"B/f4"}}
+	r.DeviceResults = map[uint64]*DeviceResults{1: &DeviceResults{Dev: 1, NewLinkCount: 4}}
+
+	f := r.Filter("A/")
+	if len(f.LinkPaths) != 2 || f.LinkPaths[0][0] != "A/f1" || f.LinkPaths[1][0] != "B/f4" {
+		t.Errorf("Filtered LinkPaths incorrect: %v", f.LinkPaths)
+	}
+	if f.NewLinkCount != 3 {
+		t.Errorf("Filtered NewLinkCount expected 3, got: %v", f.NewLinkCount)
+	}
+	if _, ok := f.ExistingLinks["A/e1"]; !ok || len(f.ExistingLinks) != 1 {
+		t.Errorf("Filtered ExistingLinks incorrect: %v", f.ExistingLinks)
+	}
+	if f.ExistingLinkCount != 2 || f.ExistingLinkByteAmount != 20 {
+		t.Errorf("Filtered ExistingLink stats incorrect: %v %v",
+			f.ExistingLinkCount, f.ExistingLinkByteAmount)
+	}
+	if len(f.SkippedLinkPaths) != 0 || f.SkippedLinkErrCount != 0 {
+		t.Errorf("Filtered SkippedLinkPaths expected empty, got: %v", f.SkippedLinkPaths)
+	}
+
+	if f.InodeRemovedCount != 1 || f.InodeRemovedByteAmount != 5 {
+		t.Errorf("Filtered removed inode stats expected 1 and 5, got: %v %v",
+			f.InodeRemovedCount, f.InodeRemovedByteAmount)
+	}
+	if len(f.HashBuckets) != 1 || len(f.HashBuckets[1]) != 1 || f.DeviceResults != nil {
+		t.Errorf("Filtered HashBuckets and DeviceResults incorrect: %v %v", f.HashBuckets, f.DeviceResults)
+	}
+
+	// The original Results are unchanged, and share no maps
+	f.ExistingLinks["A/e1"][0] = "X"
+	f.RemovedInodeDirBytes["A"] = 0
+	if len(r.LinkPaths) != 3 || len(r.ExistingLinks) != 2 || r.NewLinkCount != 4 ||
+		r.InodeRemovedCount != 3 || r.InodeRemovedByteAmount != 21 || len(r.HashBuckets) != 2 {
+		t.Errorf("Filter() modified the original Results")
+	}
+	if r.ExistingLinks["A/e1"][0] != "A/e2" || r.RemovedInodeDirBytes["A"] != 5 {
+		t.Errorf("Filtered Results share the original maps")
+	}
+}
+
+func TestResultsOneLineSummary(t *testing.T) {
+	r := newResults(&Options{LinkingEnabled: true})
+	r.FileCount = 1234
+	r.InodeRemovedCount = 56
+	r.InodeRemovedByteAmount = 7 * 1024 * 1024 * 1024
+	r.RunTime = "12s"
+
+	expected := "hardlinkable: 1234 files, removed 56 inodes, saved 7 GiB in 12s"
+	if s := r.OneLineSummary(); s != expected {
+		t.Errorf("OneLineSummary() expected '%v', got: '%v'", expected, s)
+	}
+
+	r.Opts.LinkingEnabled = false
+	for _, sub := range []string{"1234 files", "removable 56 inodes", "saveable 7 GiB", "in 12s"} {
+		if s := r.OneLineSummary(); !strings.Contains(s, sub) {
+			t.Errorf("OneLineSummary() '%v' doesn't contain '%v'", s, sub)
+		}
+	}
+}
+
+func TestResultsMarshalJSONResults(t *testing.T) {
+	topdir := setUp("MarshalJSON", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "X", "f2": "X", "f3": "X", "g1": "YY"})
+	r := simpleRun("MarshalJSON", t, SetupOptions(LinkingDisabled), 1, ".")
+
+	b, err := r.MarshalJSONResults()
+	if err != nil {
+		t.Fatalf("MarshalJSONResults() returned error: %v", err)
+	}
+	var got struct {
+		RunSuccessful     bool       `json:"runSuccessful"`
+		FileCount         int64      `json:"fileCount"`
+		NewLinkCount      int64      `json:"newLinkCount"`
+		InodeRemovedCount int64      `json:"inodeRemovedCount"`
+		LinkPaths         [][]string `json:"linkPaths"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Couldn't decode the JSON results: %v", err)
+	}
+	if !got.RunSuccessful || got.FileCount != 4 || got.NewLinkCount != 2 ||
+		got.InodeRemovedCount != 2 || !reflect.DeepEqual(got.LinkPaths, r.LinkPaths) {
+		t.Errorf("Expected JSON results matching %+v, got: %+v", r.RunStats, got)
+	}
+}
+
+func TestResultsWriteCompatSummary(t *testing.T) {
+	r := newResults(&Options{})
+	r.FileCount = 1234
+	r.NewLinkCount = 56
+	r.ComparisonCount = 78
+	r.InodeRemovedByteAmount = 7 * 1024 * 1024 * 1024
+	r.StartTime = time.Unix(1500000000, 0)
+	r.EndTime = r.StartTime.Add(1500 * time.Millisecond)
+
+	var b bytes.Buffer
+	if err := r.WriteCompatSummary(&b); err != nil {
+		t.Fatalf("WriteCompatSummary() returned error: %v", err)
+	}
+	expected := "" +
+		"Mode:                     dry-run\n" +
+		"Files:                    1234\n" +
+		"Linked:                   56 files\n" +
+		"Compared:                 78 files\n" +
+		"Saved:                    7 GiB\n" +
+		"Duration:                 1.500000 seconds\n"
+	if b.String() != expected {
+		t.Errorf("WriteCompatSummary() expected:\n%v\ngot:\n%v", expected, b.String())
+	}
+
+	b.Reset()
+	r.Opts.LinkingEnabled = true
+	r.WriteCompatSummary(&b)
+	if !strings.HasPrefix(b.String(), "Mode:                     real\n") {
+		t.Errorf("WriteCompatSummary() expected real mode, got:\n%v", b.String())
+	}
+}
+
+// captureStdout returns the output written to os.Stdout by the given func
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Couldn't create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		done <- b
+	}()
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done)
+}
+
+func TestResultsDirectoriesWithSavings(t *testing.T) {
+	topdir := setUp("DirsWithSavings", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"a/f1":     "X",
+		"b/f2":     "X",
+		"b/c/f3":   "X",
+		"d/g1":     "YY",
+		"d/g2":     "YY",
+		"e/unique": "ZZZ",
+	})
+
+	r := simpleRun("DirsWithSavings", t, SetupOptions(LinkingDisabled), 2, ".")
+	want := make(map[string]bool)
+	for _, g := range r.LinkPaths {
+		for _, dst := range g[1:] {
+			want[path.Dir(dst)] = true
+		}
+	}
+	dirs := r.DirectoriesWithSavings()
+	if !sort.StringsAreSorted(dirs) || len(dirs) != len(want) {
+		t.Fatalf("Expected sorted dirs %v, got: %v", want, dirs)
+	}
+	for _, d := range dirs {
+		if !want[d] {
+			t.Errorf("Unexpected dir '%v' in: %v", d, dirs)
+		}
+		if d == "e" {
+			t.Errorf("Expected no savings in dir 'e'")
+		}
+	}
+}
+
+func TestResultsSortOutputByPath(t *testing.T) {
+	r := newResults(&Options{SortOutputByPath: true})
+	r.ExistingLinks = map[string][]string{
+		"c/e1": []string{"c/e3", "a/e2"},
+		"a/e4": []string{"b/e5"},
+		"b/e6": []string{"b/e7"},
+	}
+	r.ExistingLinkSizes = map[string]uint64{"c/e1": 1, "a/e4": 1, "b/e6": 1}
+	r.LinkPaths = [][]string{
+		[]string{"z/f1", "z/f3", "z/f2"},
+		[]string{"a/f4", "b/f5"},
+		[]string{"m/f6", "a/f7"},
+	}
+
+	for _, tc := range []struct {
+		output      func()
+		wantFroms   []string
+		wantFirstTo string
+	}{
+		{r.OutputExistingLinks, []string{"a/e4", "b/e6", "c/e1"}, "b/e5"},
+		{r.OutputNewLinks, []string{"a/f4", "m/f6", "z/f1"}, "b/f5"},
+	} {
+		var froms, tos []string
+		for _, line := range strings.Split(captureStdout(t, tc.output), "\n") {
+			if strings.HasPrefix(line, "from: ") {
+				froms = append(froms, strings.TrimPrefix(line, "from: "))
+			} else if strings.HasPrefix(line, "  to: ") {
+				tos = append(tos, strings.TrimPrefix(line, "  to: "))
+			}
+		}
+		if !reflect.DeepEqual(froms, tc.wantFroms) {
+			t.Errorf("Expected sorted 'from:' lines %v, got: %v", tc.wantFroms, froms)
+		}
+		if len(tos) == 0 || tos[0] != tc.wantFirstTo || !sort.StringsAreSorted(tos[len(tos)-2:]) {
+			t.Errorf("Expected sorted 'to:' lines, got: %v", tos)
+		}
+	}
+}
+
+func TestResultsLinkGroupInodeNumbers(t *testing.T) {
+	topdir := setUp("LinkGroups", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1": "X",
+		"f2": "X",
+		"f3": "X",
+		"g1": "YY",
+		"g2": "YY",
+	})
+	if err := os.Link("f1", "f1.link"); err != nil {
+		t.Fatalf("Couldn't create hardlink: %v", err)
+	}
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.StoreInodeNumbers = true
+	result, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if len(result.LinkGroups) != 2 {
+		t.Fatalf("Expected 2 link groups, got: %v", result.LinkGroups)
+	}
+	for _, g := range result.LinkGroups {
+		di, err := I.LStatInfo(g.Paths[0])
+		if err != nil {
+			t.Fatalf("Couldn't stat link group src '%v': %v", g.Paths[0], err)
+		}
+		if g.SrcIno != uint64(di.Ino) || g.Dev != di.Dev || g.Size != di.Size {
+			t.Errorf("Link group %+v doesn't match src stat: %+v", g, di)
+		}
+		for _, p := range g.Paths[1:] {
+			pdi, _ := I.LStatInfo(p)
+			if uint64(pdi.Ino) != g.SrcIno {
+				t.Errorf("Link group path '%v' ino %v, expected: %v", p, pdi.Ino, g.SrcIno)
+			}
+		}
+		if g.Size == 1 && len(g.Paths) != 4 {
+			t.Errorf("Expected 4 pathnames in link group, got: %v", g.Paths)
+		}
+	}
+}
+
+func TestResultsLinkGroupDigests(t *testing.T) {
+	topdir := setUp("LinkGroupDigests", t)
+	defer os.RemoveAll(topdir)
+
+	// Separate mtimes keep the equal a and b files in separate groups
+	simpleFileMaker(t, pathContents{"a1": "hello", "a2": "hello"})
+	simpleFileMaker(t, pathContents{"b1": "hello", "b2": "hello"})
+	simpleFileMaker(t, pathContents{"c1": "other", "c2": "other"})
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.IncludeDigestInOutput = true
+	result := simpleRun("LinkGroupDigests", t, opts, 3, ".")
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Couldn't marshal Results: %v", err)
+	}
+	var decoded struct {
+		LinkGroups []map[string]interface{} `json:"linkGroups"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("Couldn't unmarshal Results: %v", err)
+	}
+	if len(decoded.LinkGroups) != 3 {
+		t.Fatalf("Expected 3 link groups in JSON, got: %v", decoded.LinkGroups)
+	}
+	digests := make(map[string]string)
+	for _, g := range decoded.LinkGroups {
+		digest, ok := g["digest"].(string)
+		if !ok || digest == "" {
+			t.Fatalf("Expected a digest in link group: %v", g)
+		}
+		paths := g["paths"].([]interface{})
+		digests[paths[0].(string)[:1]] = digest
+	}
+	hello := sha256.Sum256([]byte("hello"))
+	if digests["a"] != hex.EncodeToString(hello[:]) || digests["a"] != digests["b"] {
+		t.Errorf("Expected equal 'hello' digests for the a and b groups, got: %v", digests)
+	}
+	if digests["c"] == digests["a"] {
+		t.Errorf("Expected differing content to have differing digests, got: %v", digests)
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	topdir := setUp("DiffResults", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1": "X",
+		"f2": "X",
+		"g1": "YY",
+		"g2": "YY",
+		"g3": "YY",
+	})
+
+	before := simpleRun("DiffResults", t, SetupOptions(), 2, ".")
+	simpleRun("DiffResults", t, SetupOptions(LinkingEnabled), 2, ".")
+	after := simpleRun("DiffResults", t, SetupOptions(), 0, ".")
+
+	d := DiffResults(*before, *after)
+	changed := newSet(d.ChangedStats...)
+	for _, name := range []string{"newLinkCount", "existingLinkCount", "inodeCount", "inodeRemovedCount"} {
+		if _, ok := changed[name]; !ok {
+			t.Errorf("Expected '%v' in changed stats, got: %v", name, d.ChangedStats)
+		}
+	}
+	if _, ok := changed["fileCount"]; ok {
+		t.Errorf("Expected unchanged 'fileCount', got: %v", d.ChangedStats)
+	}
+	if d.Before.NewLinkCount != 3 || d.After.NewLinkCount != 0 {
+		t.Errorf("Expected NewLinkCount 3 -> 0, got: %v -> %v",
+			d.Before.NewLinkCount, d.After.NewLinkCount)
+	}
+	if len(d.NewLinkGroups) != 0 || len(d.GoneLinkGroups) != 2 {
+		t.Errorf("Expected 0 new and 2 gone link groups, got: %v, %v",
+			d.NewLinkGroups, d.GoneLinkGroups)
+	}
+	if len(d.NewExistingLinkGroups) != 2 || len(d.GoneExistingLinkGroups) != 0 {
+		t.Errorf("Expected 2 new and 0 gone existing link groups, got: %v, %v",
+			d.NewExistingLinkGroups, d.GoneExistingLinkGroups)
+	}
+	for i, g := range d.GoneLinkGroups {
+		if i < len(d.NewExistingLinkGroups) && groupKey(g) != groupKey(d.NewExistingLinkGroups[i]) {
+			t.Errorf("Expected linked group %v to become existing links, got: %v",
+				g, d.NewExistingLinkGroups[i])
+		}
+	}
+
+	if d := DiffResults(*after, *after); len(d.ChangedStats) != 0 || len(d.NewLinkGroups) != 0 ||
+		len(d.NewExistingLinkGroups) != 0 || len(d.GoneExistingLinkGroups) != 0 {
+		t.Errorf("Expected no differences between identical results, got: %+v", d)
+	}
+}
+
+func TestResultsSaveLoad(t *testing.T) {
+	topdir := setUp("SaveLoad", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1": "X",
+		"f2": "X",
+		"g1": "YY",
+		"g2": "YY",
+	})
+	simpleLinkMaker(t, "g1", "g3")
+
+	opts := SetupOptions()
+	opts.StoreInodeNumbers = true
+	opts.AuditLog = ioutil.Discard
+	opts.FileExcludes = []string{"nomatch"}
+	r := simpleRun("SaveLoad", t, opts, 2, ".")
+
+	var buf bytes.Buffer
+	if err := r.Save(&buf); err != nil {
+		t.Fatalf("Couldn't save Results: %v", err)
+	}
+	loaded, err := LoadResults(&buf)
+	if err != nil {
+		t.Fatalf("Couldn't load Results: %v", err)
+	}
+
+	if !reflect.DeepEqual(r.RunStats, loaded.RunStats) {
+		t.Errorf("Loaded RunStats differ.  Saved: %+v, loaded: %+v", r.RunStats, loaded.RunStats)
+	}
+	if !reflect.DeepEqual(r.LinkPaths, loaded.LinkPaths) {
+		t.Errorf("Loaded LinkPaths differ.  Saved: %v, loaded: %v", r.LinkPaths, loaded.LinkPaths)
+	}
+	if !reflect.DeepEqual(r.LargestLinkableFiles(10), loaded.LargestLinkableFiles(10)) ||
+		len(loaded.LargestLinkableFiles(10)) != 2 {
+		t.Errorf("Loaded LargestLinkableFiles differ.  Saved: %v, loaded: %v",
+			r.LargestLinkableFiles(10), loaded.LargestLinkableFiles(10))
+	}
+	if !reflect.DeepEqual(r.ExistingLinks, loaded.ExistingLinks) ||
+		!reflect.DeepEqual(r.ExistingLinkSizes, loaded.ExistingLinkSizes) {
+		t.Errorf("Loaded ExistingLinks differ.  Saved: %v, loaded: %v", r.ExistingLinks, loaded.ExistingLinks)
+	}
+	if !reflect.DeepEqual(r.LinkGroups, loaded.LinkGroups) {
+		t.Errorf("Loaded LinkGroups differ.  Saved: %v, loaded: %v", r.LinkGroups, loaded.LinkGroups)
+	}
+	if !r.StartTime.Equal(loaded.StartTime) || r.RunSuccessful != loaded.RunSuccessful {
+		t.Errorf("Loaded StartTime or RunSuccessful differ")
+	}
+
+	// The Options are saved, except for the AuditLog writer and Rand
+	if loaded.Opts.AuditLog != nil {
+		t.Errorf("Expected AuditLog to not be saved")
+	}
+	r.Opts.AuditLog = nil
+	r.Opts.Rand = nil
+	if !reflect.DeepEqual(r.Opts, loaded.Opts) {
+		t.Errorf("Loaded Options differ.  Saved: %+v, loaded: %+v", r.Opts, loaded.Opts)
+	}
+}
+
+func TestResultsWriteDeviceJSONFiles(t *testing.T) {
+	topdir := setUp("DeviceJSONFiles", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"d/f1": "X",
+		"d/f2": "X",
+		"d/f3": "X",
+		"d/g1": "YY",
+	})
+	simpleLinkMaker(t, "d/g1", "d/g2")
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.DeviceJSONOutputDir = "devices"
+	r := simpleRun("DeviceJSONFiles", t, opts, 1, "d")
+	if err := r.WriteLinkOutputFiles(); err != nil {
+		t.Fatalf("WriteLinkOutputFiles() returned error: %v", err)
+	}
+
+	fis, err := ioutil.ReadDir("devices")
+	if err != nil {
+		t.Fatalf("Couldn't read the device JSON dir: %v", err)
+	}
+	if len(fis) != len(r.DeviceResults) || len(fis) != 1 {
+		t.Fatalf("Expected 1 device JSON file, got: %v", len(fis))
+	}
+	b, err := ioutil.ReadFile(path.Join("devices", fis[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d DeviceResults
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatalf("Couldn't decode '%v': %v", fis[0].Name(), err)
+	}
+	if fis[0].Name() != fmt.Sprintf("%v.json", d.Dev) {
+		t.Errorf("Expected device JSON file named by its dev %v, got: %v", d.Dev, fis[0].Name())
+	}
+	if d.FileCount != r.FileCount || d.NewLinkCount != 2 || d.InodeRemovedCount != 2 ||
+		d.ExistingLinkCount != 1 || d.InodeRemovedByteAmount != r.InodeRemovedByteAmount {
+		t.Errorf("Expected device counts to match the run's, got: %+v", d)
+	}
+	if !reflect.DeepEqual(d.LinkPaths, r.LinkPaths) || len(d.ExistingLinks) != 1 {
+		t.Errorf("Expected device links %v, got: %v", r.LinkPaths, d.LinkPaths)
+	}
+}
+
+func TestResultsWriteLinkOutputFiles(t *testing.T) {
+	topdir := setUp("LinkOutputFiles", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"d/f1": "X",
+		"d/f2": "X",
+		"d/g1": "YY",
+	})
+	simpleLinkMaker(t, "d/g1", "d/g2")
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.ExistingLinksOutputPath = "existing.json"
+	opts.NewLinksOutputPath = "new.json"
+	r := simpleRun("LinkOutputFiles", t, opts, 1, "d")
+	if err := r.WriteLinkOutputFiles(); err != nil {
+		t.Fatalf("WriteLinkOutputFiles() returned error: %v", err)
+	}
+
+	var existing, newLinks map[string]interface{}
+	for pathname, m := range map[string]*map[string]interface{}{"existing.json": &existing, "new.json": &newLinks} {
+		b, err := ioutil.ReadFile(pathname)
+		if err != nil {
+			t.Fatalf("Couldn't read '%v': %v", pathname, err)
+		}
+		if err := json.Unmarshal(b, m); err != nil {
+			t.Fatalf("Couldn't decode '%v': %v", pathname, err)
+		}
+	}
+	if _, ok := existing["existingLinks"]; !ok || existing["linkPaths"] != nil {
+		t.Errorf("Expected only existing links in existing.json, got: %v", existing)
+	}
+	if s := fmt.Sprint(existing["existingLinks"]); !strings.Contains(s, "d/g2") || strings.Contains(s, "d/f1") {
+		t.Errorf("Expected existing link 'd/g2' only, got: %v", s)
+	}
+	if _, ok := newLinks["linkPaths"]; !ok || newLinks["existingLinks"] != nil {
+		t.Errorf("Expected only new links in new.json, got: %v", newLinks)
+	}
+	if s := fmt.Sprint(newLinks["linkPaths"]); !strings.Contains(s, "d/f1") || strings.Contains(s, "d/g") {
+		t.Errorf("Expected new link 'd/f1' only, got: %v", s)
+	}
+
+	// The link results must be stored to be output
+	opts.StoreExistingLinkResults = false
+	if _, err := Run([]string{"d"}, opts); err == nil {
+		t.Errorf("Expected ExistingLinksOutputPath without StoreExistingLinkResults to be an error")
+	}
+}
+
+func TestResultsLimitUtilization(t *testing.T) {
+	topdir := setUp("LimitUtilization", t)
+	defer os.RemoveAll(topdir)
+
+	// Six unequal files with the same size and mtime share an inode hash
+	simpleFileMaker(t, pathContents{
+		"a": "A", "b": "B", "c": "C", "d": "D", "e": "E", "f": "F",
+		"big": makeString("X", 50),
+	})
+	simpleLinkMaker(t, "big", "big2", "big3")
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.MaxFileSize = 100
+	opts.MaxFiles = 100
+	opts.SearchThresh = 4
+	r := simpleRun("LimitUtilization", t, opts, 0, ".")
+
+	u := r.LimitUtilization()
+	if len(u) != 4 {
+		t.Fatalf("Expected 4 limit utilizations, got: %v", u)
+	}
+	if u["maxFileSize"] != 0.5 {
+		t.Errorf("Expected maxFileSize utilization 0.5, got: %v", u["maxFileSize"])
+	}
+	if u["maxFiles"] != 0.09 {
+		t.Errorf("Expected maxFiles utilization 0.09, got: %v", u["maxFiles"])
+	}
+	if u["searchThresh"] != 1.25 {
+		t.Errorf("Expected searchThresh utilization 1.25, got: %v", u["searchThresh"])
+	}
+	if u["maxNLinks"] <= 0 || u["maxNLinks"] >= 1 {
+		t.Errorf("Expected maxNLinks utilization between 0 and 1, got: %v", u["maxNLinks"])
+	}
+
+	// Unset limits aren't reported
+	r = simpleRun("LimitUtilization", t, SetupOptions(LinkingDisabled), 0, ".")
+	if _, ok := r.LimitUtilization()["maxFileSize"]; ok {
+		t.Errorf("Expected no maxFileSize utilization without a MaxFileSize")
+	}
+}
@@ -29,7 +29,9 @@ import (
 	"log"
 	"os"
 	"path"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/chadnetzer/hardlinkable/internal/inode"
 )
@@ -49,7 +51,7 @@ func RunWithProgress(dirsAndFiles []string, opts Options) (Results, error) {
 	ls.Progress = newTTYProgress(ls.Results, ls.Options)
 	defer ls.Progress.Done()
 
-	err = runHelper(dirsAndFiles, ls)
+	err = syslogRunHelper(dirsAndFiles, ls)
 	return *ls.Results, err
 }
 
@@ -66,10 +68,47 @@ func Run(dirsAndFiles []string, opts Options) (Results, error) {
 	ls.Progress = &disabledProgress{}
 	defer ls.Progress.Done()
 
-	err := runHelper(dirsAndFiles, ls)
+	err := syslogRunHelper(dirsAndFiles, ls)
 	return *ls.Results, err
 }
 
+// ErrLockHeld is returned when the Options.LockFile is already locked by
+// another process (or Run), and Options.LockWait isn't enabled.
+type ErrLockHeld struct {
+	Path string
+}
+
+func (e *ErrLockHeld) Error() string {
+	return fmt.Sprintf("lock file '%v' is held by another process", e.Path)
+}
+
+// ErrNoBackupMarker is returned when linking is enabled, but the
+// Options.RequireBackupMarker file doesn't exist in one of the given roots.
+type ErrNoBackupMarker struct {
+	Root   string
+	Marker string
+}
+
+func (e *ErrNoBackupMarker) Error() string {
+	return fmt.Sprintf("backup marker '%v' not found in '%v', refusing to link", e.Marker, e.Root)
+}
+
+// checkBackupMarkers returns an ErrNoBackupMarker error if the marker file
+// doesn't exist in one of the given dirs, or the dirs of the given files.
+func checkBackupMarkers(marker string, dirs, files []string) error {
+	roots := append([]string{}, dirs...)
+	for _, pathname := range files {
+		roots = append(roots, path.Dir(pathname))
+	}
+	for _, root := range roots {
+		fi, err := os.Stat(path.Join(root, marker))
+		if err != nil || !fi.Mode().IsRegular() {
+			return &ErrNoBackupMarker{Root: root, Marker: marker}
+		}
+	}
+	return nil
+}
+
 // runHelper is called by the public Run funcs, with an already initialized
 // options, to complete the scanning and result gathering.
 func runHelper(dirsAndFiles []string, ls *linkableState) (err error) {
@@ -83,15 +122,71 @@ func runHelper(dirsAndFiles []string, ls *linkableState) (err error) {
 	if err != nil {
 		return err
 	}
+	if ls.Options.RequireBackupMarker != "" && ls.Options.LinkingEnabled {
+		if err := checkBackupMarkers(ls.Options.RequireBackupMarker, dirs, files); err != nil {
+			return err
+		}
+	}
 	ls.Results.start()
 	defer ls.Results.end()
 
+	// Only consider files modified since the marker file, if given
+	var changedSince time.Time
+	if ls.Options.ChangedSinceFile != "" {
+		fi, statErr := os.Stat(ls.Options.ChangedSinceFile)
+		if statErr != nil {
+			return statErr
+		}
+		changedSince = fi.ModTime()
+	}
+
+	if ls.Options.IONice {
+		restore, applied, ioErr := setIdleIOPriority()
+		defer restore()
+		if ls.Options.DebugLevel > 0 {
+			log.Printf("Idle IO priority applied: %v (err: %v)", applied, ioErr)
+		}
+	}
+
 	// Phase 1: Gather path and inode information by walking the dirs and
 	// files, looking for files that can be linked due to identical
 	// contents, and optionally equivalent inode parameters (time,
 	// permission, ownership, etc.)
 	ls.Results.Phase = WalkPhase
-	c := matchedPathnames(*ls.Options, ls.Results, ls.pool, dirs, files)
+
+	// With StreamingLink, first count the files of each size class, so
+	// that each class can be linked as soon as its files are walked.
+	var stream *streamState
+	if ls.Options.StreamingLink {
+		if stream, err = newStreamState(ls, dirs, files, changedSince); err != nil {
+			return err
+		}
+		ls.findOpenFiles()
+	}
+
+	done := make(chan struct{})
+	c := matchedPathnames(*ls.Options, ls.Results, ls.pool, done, dirs, files)
+
+	// stopWalk halts the walk goroutine and waits for it to finish, so
+	// that it no longer updates the Results.
+	var stopOnce sync.Once
+	stopWalk := func() {
+		stopOnce.Do(func() {
+			close(done)
+			for range c {
+			}
+		})
+	}
+	defer stopWalk()
+
+	// Optionally compare the files of separate inode hash buckets
+	// concurrently.  The advisory matching compares across buckets, and so
+	// requires a serial run.
+	var workers *bucketWorkers
+	if ls.Options.BucketWorkers > 1 && !ls.Options.findsAdvisoryMatches() {
+		workers = newBucketWorkers(ls.Options, ls.Options.BucketWorkers)
+		defer workers.wait()
+	}
 	for pe := range c {
 		// Handle early termination of the directory walk.  If
 		// IgnoreWalkErrors is set, we won't get any errors here.
@@ -99,6 +194,14 @@ func runHelper(dirsAndFiles []string, ls *linkableState) (err error) {
 			return pe.err
 		}
 
+		// Stop the walk when the file limit is reached, and continue
+		// on with the files gathered so far.
+		if ls.Options.MaxFiles > 0 && ls.Results.FileCount >= ls.Options.MaxFiles {
+			ls.Results.HitFileLimit = true
+			stopWalk()
+			break
+		}
+
 		ls.Progress.Show()
 		di, statErr := inode.LStatInfo(pe.pathname)
 		if statErr != nil {
@@ -116,37 +219,33 @@ func runHelper(dirsAndFiles []string, ls *linkableState) (err error) {
 			}
 		}
 
-		// Ignore files with setuid/setgid bits.  Linking them could
-		// have security implications.
-		if di.Mode&os.ModeSetuid != 0 {
-			ls.Results.foundSetuidFile()
+		bypass := pe.explicit && ls.Options.ExplicitFilesBypassFilters
+		if !isLinkCandidate(di, pe.pathname, ls.Options, ls.Results, bypass) {
 			continue
 		}
-		if di.Mode&os.ModeSetgid != 0 {
-			ls.Results.foundSetgidFile()
-			continue
-		}
-
-		// Also exclude files with any other non-perm mode bits set
-		if di.Mode != (di.Mode & os.ModePerm) {
-			ls.Results.foundNonPermBitFile()
+		if !changedSince.IsZero() && di.Mtim.Before(changedSince) {
+			ls.Results.unchangedSinceMarker()
 			continue
 		}
+		// If the file hasn't been rejected by this
+		// point, add it to the found count
+		ls.Results.foundFile()
+		ls.Results.foundDeviceFile(di.Dev)
+		ls.Results.foundFileSize(di.Size)
 
-		// Ensure the files fall within the allowed Size range
-		if di.Size < ls.Options.MinFileSize {
-			ls.Results.foundFileTooSmall()
+		if workers != nil {
+			if workers.hasFailed() {
+				break
+			}
+			workers.add(di, pe.pathname)
 			continue
 		}
-		if ls.Options.MaxFileSize > 0 &&
-			di.Size > ls.Options.MaxFileSize {
-			ls.Results.foundFileTooLarge()
+		if stream != nil {
+			if err := stream.add(ls, di, pe.pathname); err != nil {
+				return err
+			}
 			continue
 		}
-		// If the file hasn't been rejected by this
-		// point, add it to the found count
-		ls.Results.foundFile()
-
 		fsdev := ls.dev(di, pe.pathname)
 		cmpErr := fsdev.FindIdenticalFiles(di, pe.pathname)
 		if cmpErr != nil {
@@ -161,35 +260,205 @@ func runHelper(dirsAndFiles []string, ls *linkableState) (err error) {
 		}
 	}
 
+	if workers != nil {
+		stopWalk()
+		if err := workers.wait(); err != nil {
+			return err
+		}
+		workers.mergeInto(ls)
+	}
+
+	if stream != nil {
+		return stream.finish(ls)
+	}
+	return linkHelper(ls)
+}
+
+// linkHelper completes a Run once all the files have been gathered, by
+// finalizing the found file statistics and groups, and then generating (and
+// optionally performing) the links.
+func linkHelper(ls *linkableState) error {
 	ls.Progress.Clear()
+	ls.Results.addPoolStats(ls.pool)
 
 	// Calculate and store the number of unique paths encountered by the
 	// walk, overwriting the possibly less accurate counts gathered during
 	// the walk (if files specified twice, for example, they will only be
 	// counted once here)
-	var numPaths int64
-	for _, fsdev := range ls.fsDevs {
-		p, _ := fsdev.InoPaths.PathCount()
-		numPaths += p
+	ls.Results.FileCount = ls.addGatheredGroups()
+	ls.Results.DeviceCount = int64(len(ls.fsDevs))
+	if ls.Options.ReportSimilar {
+		ls.addSimilarGroups()
 	}
-	ls.Results.FileCount = numPaths
 
 	// Phase 2: Link generation - with all the path and inode information
 	// collected, iterate over all the inode links sorted from highest
 	// nlink count to lowest, gathering accurate linking statistics,
 	// determine what link() pairs and in what order are needed to produce
 	// the desired result, and optionally link them if requested.
+	unlock, err := ls.startLinkPhase()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := ls.generateLinks(); err != nil {
+		return err
+	}
+	if err := ls.checkPredictedSavings(); err != nil {
+		return err
+	}
+	ls.Results.runCompletedSuccessfully()
+
+	return nil
+}
+
+// addGatheredGroups adds the stats and groups of the gathered files to the
+// Results, and returns the number of unique pathnames gathered.  Must be
+// called before generateLinks().
+func (ls *linkableState) addGatheredGroups() int64 {
+	var numPaths, numInos int64
+	for _, fsdev := range ls.fsDevs {
+		fsdev.addUniqueSizes()
+		p, _ := fsdev.InoPaths.PathCount()
+		numPaths += p
+		numInos += int64(len(fsdev.inoStatInfo))
+		ls.Results.UniqueContentCount += fsdev.uniqueContentCount()
+	}
+	ls.Results.foundPeakInodes(numInos)
+
+	if ls.Options.AdvisoryContentGroups {
+		for _, fsdev := range ls.fsDevs {
+			fsdev.addAdvisoryGroups()
+		}
+	}
+	if ls.Options.ReportMtimeSpread {
+		for _, fsdev := range ls.fsDevs {
+			fsdev.addMtimeSpreadGroups()
+		}
+	}
+	for _, fsdev := range ls.fsDevs {
+		fsdev.addMostDuplicated()
+		if ls.Options.DebugLevel > 2 {
+			fsdev.addHashBuckets()
+		}
+	}
+	return numPaths
+}
+
+// startLinkPhase prepares for generating the links, taking the LockFile (if
+// linking).  The returned func releases the lock.
+func (ls *linkableState) startLinkPhase() (func() error, error) {
 	ls.Results.Phase = LinkPhase
+	ls.findOpenFiles()
+	return ls.lockLinking()
+}
+
+// findOpenFiles finds the inodes of files held open by other processes (with
+// SkipOpenFiles, if linking).
+func (ls *linkableState) findOpenFiles() {
+	if ls.Options.SkipOpenFiles && ls.Options.LinkingEnabled {
+		if err := findOpenFileInodes(ls.openInos); err != nil && ls.Options.DebugLevel > 0 {
+			log.Printf("Couldn't find open files: %v", err)
+		}
+	}
+}
+
+// lockLinking takes the LockFile (if linking).  The returned func releases
+// the lock.
+func (ls *linkableState) lockLinking() (func() error, error) {
+	if ls.Options.LockFile != "" && ls.Options.LinkingEnabled {
+		unlock, lockErr := lockFile(ls.Options.LockFile, ls.Options.LockWait)
+		if lockErr != nil {
+			return nil, lockErr
+		}
+		return unlock, nil
+	}
+	return func() error { return nil }, nil
+}
+
+// checkPredictedSavings returns an error if the inodes actually freed by
+// linking don't match the predicted savings (with AssertPredictedSavings).
+func (ls *linkableState) checkPredictedSavings() error {
+	if !ls.Options.assertsPredictedSavings() {
+		return nil
+	}
+	return ls.Results.checkPredictedSavings(inode.LStatInfo)
+}
+
+// generateLinks generates (and optionally performs) the links of each device
+func (ls *linkableState) generateLinks() error {
 	for _, fsdev := range ls.fsDevs {
 		if err := fsdev.generateLinks(); err != nil {
 			return err
 		}
 	}
-	ls.Results.runCompletedSuccessfully()
-
+	if ls.Options.StoreInodePaths {
+		for _, fsdev := range ls.fsDevs {
+			fsdev.addInodePaths()
+		}
+	}
 	return nil
 }
 
+// isLinkCandidate returns true if the file with the given stat info can be
+// considered for linking, based on its mode bits, and the size and age
+// Options.  The size limits are not checked if ignoreSize is true.  The
+// Results counts of rejected files (and the reason for the inode of the
+// pathname) are updated.
+func isLinkCandidate(di inode.DevStatInfo, pathname string, o *Options, r *Results, ignoreSize bool) bool {
+	skipped := func(reason string) {
+		r.skippedInode(di.Dev, uint64(di.Ino), pathname, reason)
+	}
+
+	// Ignore files with setuid/setgid bits.  Linking them could
+	// have security implications.
+	if di.Mode&os.ModeSetuid != 0 {
+		r.foundSetuidFile()
+		skipped(UnlinkedSetuid)
+		return false
+	}
+	if di.Mode&os.ModeSetgid != 0 {
+		r.foundSetgidFile()
+		skipped(UnlinkedSetgid)
+		return false
+	}
+
+	// Also exclude files with any other non-perm mode bits set
+	if di.Mode != (di.Mode & os.ModePerm) {
+		r.foundNonPermBitFile()
+		skipped(UnlinkedNonPermBits)
+		return false
+	}
+
+	// Ensure the files fall within the allowed Size range
+	if !ignoreSize && di.Size < o.MinFileSize {
+		r.foundFileTooSmall()
+		skipped(UnlinkedTooSmall)
+		return false
+	}
+	if !ignoreSize && o.MaxFileSize > 0 &&
+		di.Size > o.MaxFileSize {
+		r.foundFileTooLarge()
+		skipped(UnlinkedTooLarge)
+		return false
+	}
+	if !ignoreSize && o.OnlyBlockAligned {
+		if bs := r.blockSize(di.Dev, pathname); bs > 0 && di.Size%bs != 0 {
+			r.foundNonBlockAlignedFile()
+			skipped(UnlinkedNotAligned)
+			return false
+		}
+	}
+	// Skip recently modified files, which may still be changing
+	if o.MinFileAge > 0 &&
+		di.Mtim.After(r.StartTime.Add(-o.MinFileAge)) {
+		r.foundFileTooRecent()
+		skipped(UnlinkedTooRecent)
+		return false
+	}
+	return true
+}
+
 type devIno struct {
 	dev uint64
 	ino uint64
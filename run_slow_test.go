@@ -95,3 +95,63 @@ func TestMaxNlinks(t *testing.T) {
 		}
 	}
 }
+
+func TestNlinkWarnMargin(t *testing.T) {
+	topdir := setUp("NlinkWarnMargin", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 5; i++ {
+		m[fmt.Sprintf("n%v", i)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.NlinkWarnMargin = 1
+	ls := newLinkableState(&opts)
+	ls.Progress = &disabledProgress{}
+
+	// Gather the files, as in the walk phase of Run()
+	var fs fsDev
+	for i := 0; i < 5; i++ {
+		pathname := fmt.Sprintf("n%v", i)
+		di, err := inode.LStatInfo(pathname)
+		if err != nil {
+			t.Fatalf("Couldn't stat '%v': %v", pathname, err)
+		}
+		fs = ls.dev(di, pathname)
+		if err := fs.FindIdenticalFiles(di, pathname); err != nil {
+			t.Fatalf("FindIdenticalFiles() returned error: %v", err)
+		}
+	}
+
+	// Override the filesystem nlink limit with a low value, so that
+	// linking all the files brings the src inode within the margin.
+	fs.MaxNLinks = 5
+	if err := fs.generateLinks(); err != nil {
+		t.Fatalf("generateLinks() returned error: %v", err)
+	}
+	verifyContents("NlinkWarnMargin", t, m)
+
+	near := ls.Results.NearNlinkLimitInodes
+	if len(near) != 1 {
+		t.Fatalf("Expected 1 near nlink limit inode, got: %v", near)
+	}
+	if near[0].Nlink != 5 || near[0].MaxNlink != 5 || nlinkVal(near[0].Path) != 5 {
+		t.Errorf("Expected near nlink limit inode with nlink 5, got: %+v", near[0])
+	}
+
+	// A margin that isn't reached reports nothing
+	for i := 1; i < 5; i++ {
+		pathname := fmt.Sprintf("n%v", i)
+		os.Remove(pathname)
+	}
+	simpleFileMaker(t, pathContents{"n1": "Y", "n2": "Y"})
+	r, err := Run([]string{"n1", "n2"}, opts)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if len(r.NearNlinkLimitInodes) != 0 {
+		t.Errorf("Expected no near nlink limit inodes, got: %v", r.NearNlinkLimitInodes)
+	}
+}
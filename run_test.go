@@ -21,6 +21,8 @@
 package hardlinkable
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -34,6 +36,7 @@ import (
 	"testing"
 	"time"
 
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
 	"github.com/pkg/xattr"
 )
 
@@ -345,6 +348,31 @@ func verifyLinkPaths(name string, t *testing.T, r *Results, p paths) bool {
 	return false
 }
 
+// sortedLinkGroups returns the LinkPaths with each group, and the groups
+// themselves, sorted so that the groups of different Runs can be compared.
+func sortedLinkGroups(r *Results) [][]string {
+	var groups [][]string
+	for _, lp := range r.LinkPaths {
+		groups = append(groups, sortedPaths(lp))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// verifySameRunStats checks that two Runs of the same files found and linked
+// the same files.
+func verifySameRunStats(name string, t *testing.T, a, b *Results) {
+	x, y := a.RunStats, b.RunStats
+	if x.FileCount != y.FileCount || x.DirCount != y.DirCount ||
+		x.InodeCount != y.InodeCount || x.NlinkCount != y.NlinkCount ||
+		x.NewLinkCount != y.NewLinkCount || x.ExistingLinkCount != y.ExistingLinkCount ||
+		x.UniqueContentCount != y.UniqueContentCount || x.DeviceCount != y.DeviceCount ||
+		x.InodeRemovedCount != y.InodeRemovedCount ||
+		x.InodeRemovedByteAmount != y.InodeRemovedByteAmount {
+		t.Errorf("%v: RunStats differ: %+v vs %+v", name, x, y)
+	}
+}
+
 func verifyInodeCounts(name string, t *testing.T, r *Results, inoRemovedCount int64, inoRemovedBytes uint64, nlinkCount uint32, filenames ...string) {
 	if r.InodeRemovedCount != inoRemovedCount {
 		t.Errorf("%v: InodeRemovedCount expected: %v, got: %v\n", name, inoRemovedCount, r.InodeRemovedCount)
@@ -552,7 +580,10 @@ func TestRunLinkingTable(t *testing.T) {
 			for src, dsts := range tst.l {
 				simpleLinkMaker(t, src, dsts...)
 			}
-			result := simpleRun(tst.name, t, tst.opts, numNonEmpty(tst.lpo), ".")
+			// The predicted savings must match the actual savings
+			opts := tst.opts
+			opts.AssertPredictedSavings = true
+			result := simpleRun(tst.name, t, opts, numNonEmpty(tst.lpo), ".")
 			verified := false
 		VerifiedTest:
 			for _, lp := range tst.lpo {
@@ -646,403 +677,1463 @@ func TestRunTwoDifferentTimesIgnoreTime(t *testing.T) {
 	verifyContents(name, t, m)
 }
 
-func TestRunIgnorePerm(t *testing.T) {
+func TestRunAdvisoryContentGroups(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, IgnorePerm)
+	opts := SetupOptions(LinkingDisabled)
+	opts.AdvisoryContentGroups = true
 
-	name := "testname: 'Two Unequal File Modes w/ IgnorePerm'"
+	name := "testname: 'Advisory Content Groups'"
 
-	m := pathContents{"f1": "X", "f2": "X"}
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X", "f4": "YY"}
 	simpleFileMaker(t, m)
-	if err := os.Chmod("f1", 0644); err != nil {
-		t.Fatalf("Couldn't set file 'f1' mode to '0644': %v", err)
-	}
-	if err := os.Chmod("f2", 0755); err != nil {
-		t.Fatalf("Couldn't set file 'f2' mode to '0755': %v", err)
+	now := time.Now()
+	if err := os.Chtimes("f3", now, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Couldn't Chtimes() on test file 'f3'")
 	}
 	result := simpleRun(name, t, opts, 1, ".")
 	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
-	verifyContents(name, t, m)
+	for _, lp := range result.LinkPaths {
+		for _, p := range lp {
+			if p == "f3" {
+				t.Errorf("%v: Mismatched time file 'f3' found in LinkPaths: %v", name, result.LinkPaths)
+			}
+		}
+	}
+	want := []string{"f1", "f2", "f3"}
+	if len(result.AdvisoryGroups) != 1 || !reflect.DeepEqual(result.AdvisoryGroups[0], want) {
+		t.Errorf("%v: AdvisoryGroups expected: %v, got: %v", name, [][]string{want}, result.AdvisoryGroups)
+	}
+
+	// Fully linkable groups aren't reported
+	opts.IgnoreTime = true
+	result = simpleRun(name, t, opts, 1, ".")
+	if len(result.AdvisoryGroups) != 0 {
+		t.Errorf("%v: Expected no AdvisoryGroups with IgnoreTime, got: %v", name, result.AdvisoryGroups)
+	}
 }
 
-func TestRunExcludeFiles(t *testing.T) {
+func TestRunReportMtimeSpread(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled)
-	opts.FileExcludes = append(opts.FileExcludes, `.*\.ext$`, `^prefix_.*`)
+	opts := SetupOptions(LinkingDisabled)
+	opts.ReportMtimeSpread = true
 
-	name := "testname: 'Exclude Files'"
+	name := "testname: 'Report Mtime Spread'"
 
-	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X", "f4": "YY", "f5": "YY"}
 	simpleFileMaker(t, m)
+	now := time.Now()
+	for i, pathname := range []string{"f2", "f3"} {
+		mtime := now.Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(pathname, now, mtime); err != nil {
+			t.Fatalf("Couldn't Chtimes() on test file '%v'", pathname)
+		}
+	}
 	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
-	verifyContents(name, t, m)
+	if len(result.MtimeSpreadGroups) != 1 {
+		t.Fatalf("%v: Expected 1 MtimeSpreadGroup, got: %v", name, result.MtimeSpreadGroups)
+	}
+	g := result.MtimeSpreadGroups[0]
+	if want := []string{"f1", "f2", "f3"}; !reflect.DeepEqual(g.Paths, want) {
+		t.Errorf("%v: Expected group paths %v, got: %v", name, want, g.Paths)
+	}
+	if len(g.Mtimes) != 3 {
+		t.Errorf("%v: Expected 3 distinct mtimes, got: %v", name, g.Mtimes)
+	}
+	for i := 1; i < len(g.Mtimes); i++ {
+		if !g.Mtimes[i-1].Before(g.Mtimes[i]) {
+			t.Errorf("%v: Expected mtimes sorted oldest first, got: %v", name, g.Mtimes)
+		}
+	}
 }
 
-func TestRunExcludeDirs(t *testing.T) {
+func TestRunReportSimilar(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled)
-	opts.DirExcludes = append(opts.DirExcludes, `^A.*`, `.*B$`)
+	opts := SetupOptions(LinkingDisabled)
+	opts.ReportSimilar = true
 
-	name := "testname: 'Exclude Dirs'"
+	name := "testname: 'Report Similar'"
 
-	m := pathContents{"Aetc/f1": "X", "preB/f2": "X", "etcA/f1": "X", "Bpre/f2": "X"}
+	// A random file, and a copy shifted by an inserted prefix
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 64*1024)
+	rng.Read(data)
+	other := make([]byte, 64*1024)
+	rng.Read(other)
+	m := pathContents{
+		"f1": string(data),
+		"f2": "inserted prefix" + string(data[:len(data)-1000]),
+		"f3": string(data),
+		"f4": string(other),
+	}
 	simpleFileMaker(t, m)
+
 	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"etcA/f1", "Bpre/f2"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "etcA/f1", "Bpre/f2")
-	verifyContents(name, t, m)
+	verifyLinkPaths(name, t, result, paths{"f1", "f3"})
+	for _, lp := range result.LinkPaths {
+		for _, p := range lp {
+			if p == "f2" {
+				t.Errorf("%v: Similar file 'f2' found in LinkPaths: %v", name, result.LinkPaths)
+			}
+		}
+	}
+	if len(result.SimilarGroups) != 1 {
+		t.Fatalf("%v: Expected 1 SimilarGroup, got: %v", name, result.SimilarGroups)
+	}
+	g := newSet(result.SimilarGroups[0]...)
+	if _, ok := g["f2"]; !ok || len(g) != 3 {
+		t.Errorf("%v: SimilarGroups expected: [f1 f2 f3], got: %v", name, result.SimilarGroups)
+	}
+
+	// Nothing reported when disabled
+	opts.ReportSimilar = false
+	result = simpleRun(name, t, opts, 1, ".")
+	if len(result.SimilarGroups) != 0 {
+		t.Errorf("%v: Expected no SimilarGroups when disabled, got: %v", name, result.SimilarGroups)
+	}
 }
 
-func TestRunIncludeFiles(t *testing.T) {
+func TestRunMostDuplicated(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
+	m := pathContents{
+		"a1": "A", "a2": "A", "a3": "A", "a4": "A",
+		"b1": "BB", "b2": "BB",
+		"c1": "CCC", "c2": "CCC",
+	}
+	simpleFileMaker(t, m)
+	// An existing link also counts towards the group size
+	if err := os.Link("a1", "a5"); err != nil {
+		t.Fatalf("Couldn't create hardlink: %v", err)
+	}
+
+	name := "testname: 'Most Duplicated'"
 	opts := SetupOptions(LinkingEnabled)
-	opts.FileIncludes = append(opts.FileIncludes, `.*\.ext$`, `^prefix_.*`)
+	result := simpleRun(name, t, opts, 3, ".")
+	if result.MaxDuplicationCount != 5 {
+		t.Errorf("%v: MaxDuplicationCount expected: 5, got: %v", name, result.MaxDuplicationCount)
+	}
+	want := []string{"a1", "a2", "a3", "a4", "a5"}
+	if !reflect.DeepEqual(result.MostDuplicatedPaths, want) {
+		t.Errorf("%v: MostDuplicatedPaths expected: %v, got: %v", name, want, result.MostDuplicatedPaths)
+	}
+}
 
-	name := "testname: 'Include Files'"
+func TestRunExplicitFilesBypassFilters(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X"}
 	simpleFileMaker(t, m)
-	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f3.ext", "prefix_f4"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "f3.ext", "prefix_f4")
-	verifyContents(name, t, m)
+
+	name := "testname: 'Explicit Files Bypass Filters'"
+	opts := SetupOptions(LinkingDisabled, MinFileSize(2))
+	opts.FileExcludes = []string{"^f2$"}
+	result := simpleRun(name, t, opts, 0, "f1", "f2", "f3")
+	if result.FileCount != 0 {
+		t.Errorf("%v: Expected no files considered, got: %v", name, result.FileCount)
+	}
+
+	opts.ExplicitFilesBypassFilters = true
+	result = simpleRun(name, t, opts, 1, "f1", "f2", "f3")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2", "f3"})
+
+	// Files found by walking are still filtered
+	result = simpleRun(name, t, opts, 0, ".")
+	if result.FileCount != 0 {
+		t.Errorf("%v: Expected no walked files considered, got: %v", name, result.FileCount)
+	}
 }
 
-func TestRunReincludeExcludedFiles(t *testing.T) {
+func TestRunSkipVolatileFiles(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled)
-	opts.FileExcludes = append(opts.FileExcludes, `.*\.ext$`, `^prefix_.*`)
-	opts.FileIncludes = append(opts.FileIncludes, `^prefix_.*`)
-
-	name := "testname: 'Include Files'"
-
-	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	m := pathContents{"f1": "X", ".f1.swp": "X", "f2~": "X"}
 	simpleFileMaker(t, m)
+
+	name := "testname: 'Skip Volatile Files'"
+	opts := SetupOptions(LinkingDisabled)
 	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f1", "f2", "prefix_f4"})
-	verifyInodeCounts(name, t, result, 2, 2, 3, "f1", "f2", "prefix_f4")
-	verifyContents(name, t, m)
+	if result.FileCount != 3 {
+		t.Errorf("%v: Expected 3 files considered, got: %v", name, result.FileCount)
+	}
+
+	opts.SkipVolatileFiles = true
+	result = simpleRun(name, t, opts, 0, ".")
+	if result.FileCount != 1 {
+		t.Errorf("%v: Expected 1 file considered, got: %v", name, result.FileCount)
+	}
+	if result.SkippedVolatileCount != 2 {
+		t.Errorf("%v: Expected 2 skipped volatile files, got: %v", name, result.SkippedVolatileCount)
+	}
 }
 
-func TestRunMinMaxSize(t *testing.T) {
+func TestRunMaxPathComponents(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, MinFileSize(2), MaxFileSize(2))
-
-	name := "testname: 'Min/Max File Sizes'"
-
-	m := pathContents{"f1": "X", "f2": "X", "f3": "YY", "f4": "YY", "f5": "ZZZ", "f6": "ZZZ"}
+	m := pathContents{"A/f1": "X", "A/f2": "X", "A/B/C/f3": "X"}
 	simpleFileMaker(t, m)
-	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f3", "f4"})
-	verifyInodeCounts(name, t, result, 1, 2, 2, "f3", "f4")
+
+	name := "testname: 'Max Path Components'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.MaxPathComponents = 3
+	result := simpleRun(name, t, opts, 1, "A")
+	verifyLinkPaths(name, t, result, paths{"A/f1", "A/f2"})
+	if result.TooDeepPathCount != 1 {
+		t.Errorf("%v: Expected 1 too deep file, got: %v", name, result.TooDeepPathCount)
+	}
+	if nlinkVal("A/B/C/f3") != 1 {
+		t.Errorf("%v: Expected deeply nested 'A/B/C/f3' to not be linked", name)
+	}
 	verifyContents(name, t, m)
 }
 
-func TestRunExcludedMinMaxSize(t *testing.T) {
+func TestRunSameNameSplitCount(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, MinFileSize(2), MaxFileSize(2))
+	m := pathContents{"A/f1": "X", "B/f1": "X", "C/f2": "X", "D/f3": "X", "E/f3": "X"}
+	simpleFileMaker(t, m)
 
-	name := "testname: 'Excluded Min/Max File Sizes'"
+	name := "testname: 'Same Name Split Count'"
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 1, ".")
+	if result.SameNameSplitCount != 0 {
+		t.Errorf("%v: Expected no same name splits without SameName, got: %v",
+			name, result.SameNameSplitCount)
+	}
 
-	m := pathContents{"f1": "X", "f2": "X", "f5": "ZZZ", "f6": "ZZZ"}
-	simpleFileMaker(t, m)
-	result := simpleRun(name, t, opts, 0, ".")
-	verifyLinkPaths(name, t, result, paths{})
-	verifyInodeCounts(name, t, result, 0, 0, 1, "f1", "f2", "f5", "f6")
+	// The f1, f2, and f3 inodes can't be consolidated with SameName
+	result = simpleRun(name, t, SetupOptions(LinkingEnabled, SameName), 2, ".")
+	if result.SameNameSplitCount != 2 {
+		t.Errorf("%v: Expected 2 same name split inodes, got: %v", name, result.SameNameSplitCount)
+	}
+	if result.InodeRemovedCount != 2 {
+		t.Errorf("%v: Expected 2 removed inodes, got: %v", name, result.InodeRemovedCount)
+	}
 	verifyContents(name, t, m)
 }
 
-func TestRunZeroMinSize(t *testing.T) {
+func TestRunDenyDigests(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, MinFileSize(0), MaxFileSize(1))
-
-	name := "testname: 'Zero Min File Size'"
-
-	m := pathContents{"f1": "", "f2": ""}
+	m := pathContents{"f1": "secret", "f2": "secret", "g1": "public", "g2": "public"}
 	simpleFileMaker(t, m)
+
+	name := "testname: 'Deny Digests'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.StoreUnlinkedReasons = true
+	opts.DenyDigests = []string{strings.ToUpper(fmt.Sprintf("%x", sha256.Sum256([]byte("secret"))))}
 	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-	verifyInodeCounts(name, t, result, 1, 0, 2, "f1", "f2")
+	if !verifyLinkPaths(name, t, result, paths{"g1", "g2"}) {
+		t.Errorf("%v: Expected g1 and g2 to be linked, got: %v", name, result.LinkPaths)
+	}
+	if result.DeniedDigestSkipCount != 2 {
+		t.Errorf("%v: Expected 2 denied digest inodes, got: %v", name, result.DeniedDigestSkipCount)
+	}
+	if nlinkVal("f1") != 1 || nlinkVal("f2") != 1 {
+		t.Errorf("%v: Expected denied 'f1' and 'f2' to not be linked", name)
+	}
+	for _, u := range result.UnlinkedInodes() {
+		if u.Reason != UnlinkedDeniedDigest {
+			t.Errorf("%v: Expected denied digest reason for '%v', got: %v", name, u.Path, u.Reason)
+		}
+	}
 	verifyContents(name, t, m)
+
+	opts.DenyDigests = []string{"abc"}
+	if _, err := Run([]string{"."}, opts); err == nil {
+		t.Errorf("%v: Expected error for invalid DenyDigests entry", name)
+	}
 }
 
-func TestRunCrossedMinMaxSize(t *testing.T) {
+func TestRunExistingLinksOnly(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	const min = 2
-	const max = 1
-	opts := SetupOptions(LinkingEnabled, MinFileSize(min), MaxFileSize(max))
+	m := pathContents{
+		"f1": "XXXXXXXXXX",
+		"g1": "YYYYY",
+		"h1": "ZZZ",
+		"h2": "ZZZ",
+	}
+	simpleFileMaker(t, m)
+	simpleLinkMaker(t, "f1", "f2", "f3")
+	simpleLinkMaker(t, "g1", "g2")
 
-	result, err := Run([]string{"."}, opts)
-	if err == nil {
-		t.Errorf("Run succeeded with incorrect min(%v) and max(%v) size options\n", min, max)
+	name := "testname: 'Existing Links Only'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.ExistingLinksOnly = true
+	result := simpleRun(name, t, opts, 0, ".")
+	if saved := result.CurrentlySavedBytes(); saved != 2*10+5 {
+		t.Errorf("%v: Expected %v currently saved bytes, got: %v", name, 2*10+5, saved)
 	}
-	if result.RunSuccessful {
-		t.Errorf("Run result was 'successful' with improper min(%v) and max(%v) size options\n", min, max)
+	if result.ExistingLinkCount != 3 {
+		t.Errorf("%v: Expected 3 existing links, got: %v", name, result.ExistingLinkCount)
+	}
+	if result.NewLinkCount != 0 || result.ComparisonCount != 0 || result.BytesCompared != 0 {
+		t.Errorf("%v: Expected no comparisons or new links, got: %v %v %v", name,
+			result.ComparisonCount, result.BytesCompared, result.NewLinkCount)
+	}
+
+	opts.LinkingEnabled = true
+	if _, err := Run([]string{"."}, opts); err == nil {
+		t.Errorf("%v: Expected error combining with LinkingEnabled", name)
 	}
 }
 
-func TestRunEqualXAttrs(t *testing.T) {
+func TestRunMinDuplicateCount(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
+	m := pathContents{
+		"f1": "X", "f2": "X",
+		"g1": "YY", "g2": "YY", "g3": "YY", "g4": "YY",
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Min Duplicate Count'"
 	opts := SetupOptions(LinkingEnabled)
+	opts.MinDuplicateCount = 3
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"g1", "g2", "g3", "g4"})
+	if result.BelowMinDuplicateCount != 1 {
+		t.Errorf("%v: Expected 1 group below min duplicates, got: %v", name, result.BelowMinDuplicateCount)
+	}
+	if nlinkVal("f1") != 1 || nlinkVal("f2") != 1 || nlinkVal("g1") != 4 {
+		t.Errorf("%v: Unexpected nlinks: %v %v %v", name, nlinkVal("f1"), nlinkVal("f2"), nlinkVal("g1"))
+	}
+	verifyContents(name, t, m)
+}
 
-	name := "testname: 'Equal Xattrs'"
+func TestRunSnapshotRoots(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-	m := pathContents{"f1": "X", "f2": "X"}
+	m := pathContents{
+		"live/f1": "X", "live/f2": "YY", "live/sub/f3": "ZZZ",
+		"snap/f1": "X", "snap/f2": "YY", "snap/sub/f3": "ZZZ",
+		"snap2/f1": "X",
+	}
 	simpleFileMaker(t, m)
-	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
+	// Snapshot inodes with higher nlinks would otherwise be the link src
+	simpleLinkMaker(t, "snap/f1", "snap/f1.link")
+	simpleLinkMaker(t, "snap/sub/f3", "snap/sub/f3.link")
+
+	liveInfo := make(map[string]os.FileInfo)
+	for _, f := range []string{"f1", "f2", "sub/f3"} {
+		liveInfo[f], _ = os.Lstat(path.Join("live", f))
 	}
-	if err := xattr.Set("f2", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.foo':'bar'  %v\n", err)
+
+	name := "testname: 'Snapshot Roots'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.SnapshotRoots = []string{"snap", "snap2/"}
+	result := simpleRun(name, t, opts, 3, "snap", "live", "snap2")
+	for _, lp := range result.LinkPaths {
+		if !strings.HasPrefix(lp[0], "live/") {
+			t.Errorf("%v: Expected live src pathname, got: %v", name, lp)
+		}
 	}
-	if err := xattr.Set("f1", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.baz':'abc'  %v\n", err)
+	for _, f := range []string{"f1", "f2", "sub/f3"} {
+		fi, _ := os.Lstat(path.Join("live", f))
+		if !os.SameFile(liveInfo[f], fi) {
+			t.Errorf("%v: Expected 'live/%v' to keep its inode", name, f)
+		}
 	}
-	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	if nlinkVal("live/f1") != 4 || nlinkVal("live/sub/f3") != 3 {
+		t.Errorf("%v: Expected snapshot files linked to live files, got nlinks: %v %v",
+			name, nlinkVal("live/f1"), nlinkVal("live/sub/f3"))
 	}
-
-	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
 	verifyContents(name, t, m)
 }
 
-func TestRunUnequalXAttrs(t *testing.T) {
+func TestRunSrcDstRoots(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled)
+	m := pathContents{
+		"A/f1": "X", "A/f2": "YY", "A/dup": "YY",
+		"B/f1": "X", "B/f2": "YY", "B/sub/f3": "YY",
+		"C/f1": "X",
+	}
+	simpleFileMaker(t, m)
+	// The B inode with higher nlinks would otherwise be the link src
+	simpleLinkMaker(t, "B/f1", "B/f1.link")
 
-	name := "testname: 'Unequal Xattrs'"
+	srcInfo := make(map[string]os.FileInfo)
+	for _, f := range []string{"A/f1", "A/f2", "A/dup", "C/f1"} {
+		srcInfo[f], _ = os.Lstat(f)
+	}
 
-	m := pathContents{"f1": "X", "f2": "X"}
-	simpleFileMaker(t, m)
-	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
+	name := "testname: 'Src and Dst Roots'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.SrcRoots = []string{"A"}
+	opts.DstRoots = []string{"B/"}
+	result := simpleRun(name, t, opts, 2, "B", "A", "C")
+	for _, lp := range result.LinkPaths {
+		if !strings.HasPrefix(lp[0], "A/") {
+			t.Errorf("%v: Expected src pathname in A, got: %v", name, lp)
+		}
+		for _, dst := range lp[1:] {
+			if !strings.HasPrefix(dst, "B/") {
+				t.Errorf("%v: Expected dst pathname in B, got: %v", name, lp)
+			}
+		}
 	}
-	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	// None of the files outside of B were replaced
+	for f, fi := range srcInfo {
+		newFi, _ := os.Lstat(f)
+		if !os.SameFile(fi, newFi) {
+			t.Errorf("%v: Expected '%v' to keep its inode", name, f)
+		}
+	}
+	if nlinkVal("A/f1") != 3 || nlinkVal("C/f1") != 1 {
+		t.Errorf("%v: Expected B files linked only to A files, got nlinks: %v %v",
+			name, nlinkVal("A/f1"), nlinkVal("C/f1"))
+	}
+	if nlinkVal("A/f2")+nlinkVal("A/dup") != 4 || nlinkVal("B/f2") != 3 {
+		t.Errorf("%v: Expected A files left unlinked to each other, got nlinks: %v %v",
+			name, nlinkVal("A/f2"), nlinkVal("A/dup"))
 	}
-
-	result := simpleRun(name, t, opts, 0, ".")
-	verifyLinkPaths(name, t, result, paths{})
-	verifyInodeCounts(name, t, result, 0, 0, 1, "f1", "f2")
 	verifyContents(name, t, m)
 }
 
-func TestRunEqualXAttrsIgnoreXAttr(t *testing.T) {
+func TestRunComparisonsPerGroup(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, IgnoreXAttr)
-
-	name := "testname: 'Unequal Xattrs w/ IgnoreXattr'"
-
-	m := pathContents{"f1": "X", "f2": "X"}
-	simpleFileMaker(t, m)
-	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
-	}
-	if err := xattr.Set("f2", "user.foo", []byte{'x', 'y', 'z'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.foo':'xyz'  %v\n", err)
+	// Equal sized files with differing content all share one inode hash
+	const numFiles = 6
+	m := pathContents{}
+	for i := 0; i < numFiles; i++ {
+		m[fmt.Sprintf("f%d", i)] = fmt.Sprintf("content%d", i)
 	}
-	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
-		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Comparisons Per Group'"
+	opts := SetupOptions(IgnoreTime)
+	opts.SearchThresh = -1
+	result := simpleRun(name, t, opts, 0, ".")
+	// Without digests, each file is compared to all the previous ones
+	want := ComparisonSummary{Min: 1, Median: numFiles / 2, Max: numFiles - 1}
+	if result.ComparisonsPerGroup != want {
+		t.Errorf("%v: Expected ComparisonsPerGroup %+v, got: %+v",
+			name, want, result.ComparisonsPerGroup)
 	}
 
-	result := simpleRun(name, t, opts, 1, ".")
-	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	// With digests, the differing files needn't be compared at all
+	opts.SearchThresh = 0
+	result = simpleRun(name, t, opts, 0, ".")
+	if result.ComparisonsPerGroup.Max >= numFiles-1 {
+		t.Errorf("%v: Expected digests to reduce the max comparisons, got: %+v",
+			name, result.ComparisonsPerGroup)
+	}
 	verifyContents(name, t, m)
 }
 
-func TestRunLinearVsDigestSearch(t *testing.T) {
+func TestRunUnlinkedInodes(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	// Note - linking disabled to allow re-running multiple times
-	opts := SetupOptions(LinkingDisabled)
-
 	m := pathContents{
-		"f1": "X", "f2": "X",
-		"f3": "YY", "f4": "YY",
-		"f5": "ZZZ", "f6": "ZZZ",
-		"a1": "A", "a2": "A", "a3": "A", "a4": "A", "a5": "A",
-		"a6": "A", "a7": "A", "a8": "A", "a9": "A", "a10": "A",
-		"b1": "B", "b2": "B", "b3": "B", "b4": "B", "b5": "B",
-		"b6": "B", "b7": "B", "b8": "B", "b9": "B", "b10": "B",
-		"c1": "C", "c2": "C", "c3": "C", "c4": "C", "c5": "C",
-		"c6": "C", "c7": "C", "c8": "C", "c9": "C", "c10": "C",
+		"small": "",
+		"suid":  "S",
+		"t1":    "T", "t2": "T",
+		"m1": "MM", "m2": "MM",
+		"l1": "LL", "l2": "LL", "l3": "LL",
 	}
 	simpleFileMaker(t, m)
+	if err := os.Chmod("suid", 0644|os.ModeSetuid); err != nil {
+		t.Fatalf("Couldn't chmod 'suid': %v", err)
+	}
+	older := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("t2", older, older); err != nil {
+		t.Fatalf("Couldn't Chtimes() on 't2': %v", err)
+	}
 
-	// Confirm that results match for different max linear search lengths
-	for i := -1; i < 12; i++ {
-		name := fmt.Sprintf("testname: 'Linear Vs Digest Search' val=%v", i)
-		opts.SearchThresh = i
-		result := simpleRun(name, t, opts, 6, ".")
-		verifyLinkPaths(name, t, result, paths{"f1", "f2"})
-		verifyLinkPaths(name, t, result, paths{"f3", "f4"})
-		verifyLinkPaths(name, t, result, paths{"f5", "f6"})
-		verifyInodeCounts(name, t, result, 30, 33, 1)
-		verifyContents(name, t, m)
+	name := "testname: 'Unlinked Inodes'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.StoreUnlinkedReasons = true
+	opts.AdvisoryContentGroups = true
+	opts.MinDuplicateCount = 3
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"l1", "l2", "l3"})
+
+	want := map[string]string{
+		"small": UnlinkedTooSmall,
+		"suid":  UnlinkedSetuid,
+		"t1":    UnlinkedInodeMismatch,
+		"t2":    UnlinkedInodeMismatch,
+		"m1":    UnlinkedBelowMinDups,
+		"m2":    UnlinkedBelowMinDups,
+	}
+	got := make(map[string]string)
+	for _, u := range result.UnlinkedInodes() {
+		got[u.Path] = u.Reason
+		if nlinkVal(u.Path) != 1 {
+			t.Errorf("%v: Unlinked inode '%v' has nlinks: %v", name, u.Path, nlinkVal(u.Path))
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: Expected unlinked inodes %v, got: %v", name, want, got)
+	}
+
+	// No reasons are stored by default
+	opts.StoreUnlinkedReasons = false
+	result = simpleRun(name, t, opts, 0, ".")
+	if len(result.UnlinkedInodes()) != 0 {
+		t.Errorf("%v: Expected no unlinked inodes, got: %v", name, result.UnlinkedInodes())
 	}
 }
 
-type PathnameSet map[string]struct{} // string = pathname
-type Clusters []PathnameSet
+func TestRunChangedSinceFile(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-func newPathnameSet(s string) PathnameSet {
-	ps := PathnameSet{}
-	ps[s] = struct{}{}
-	return ps
+	simpleFileMaker(t, pathContents{"old1": "X", "old2": "X", "marker": ""})
+	hourAgo := time.Now().Add(-time.Hour)
+	for _, f := range []string{"old1", "old2"} {
+		if err := os.Chtimes(f, hourAgo, hourAgo); err != nil {
+			t.Fatalf("Couldn't Chtimes() on '%v': %v", f, err)
+		}
+	}
+	markerTime := hourAgo.Add(30 * time.Minute)
+	if err := os.Chtimes("marker", markerTime, markerTime); err != nil {
+		t.Fatalf("Couldn't Chtimes() on 'marker': %v", err)
+	}
+	simpleFileMaker(t, pathContents{"dir/new1": "X", "dir/new2": "X"})
+
+	name := "testname: 'Changed Since File'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.ChangedSinceFile = "marker"
+	result := simpleRun(name, t, opts, 1, "dir", "old1", "old2")
+	verifyLinkPaths(name, t, result, paths{"dir/new1", "dir/new2"})
+	if result.UnchangedSinceMarkerCount != 2 {
+		t.Errorf("%v: Expected 2 unchanged files, got: %v", name, result.UnchangedSinceMarkerCount)
+	}
+	if result.FileCount != 2 {
+		t.Errorf("%v: Expected 2 files considered, got: %v", name, result.FileCount)
+	}
+
+	opts.ChangedSinceFile = "nonexistent"
+	if _, err := Run([]string{"dir"}, opts); err == nil {
+		t.Errorf("%v: Expected error for missing marker file", name)
+	}
 }
 
-// Add newPath to the cluster containing prevPath
-func (c Clusters) addToCluster(prevPath, newPath string) {
-	for _, m := range c {
-		if _, ok := m[prevPath]; ok {
-			m[newPath] = struct{}{}
-			break
+func TestRunHashBuckets(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"a": "AA", "b": "BB", "c": "CCC", "d": "DD"})
+	older := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("d", older, older); err != nil {
+		t.Fatalf("Couldn't Chtimes() on 'd': %v", err)
+	}
+
+	name := "testname: 'Hash Buckets'"
+	result := simpleRun(name, t, SetupOptions(), 0, ".")
+	if result.HashBuckets != nil {
+		t.Errorf("%v: Expected no hash buckets without debugging, got: %v", name, result.HashBuckets)
+	}
+
+	result = simpleRun(name, t, SetupOptions(DebugLevel(3)), 0, ".")
+	buckets := make(map[string]uint64)
+	for H, pathnames := range result.HashBuckets {
+		for _, p := range pathnames {
+			buckets[p] = H
 		}
 	}
+	if len(buckets) != 4 {
+		t.Fatalf("%v: Expected 4 bucketed files, got: %v", name, result.HashBuckets)
+	}
+	if buckets["a"] != buckets["b"] {
+		t.Errorf("%v: Expected equal size and mtime files in same bucket: %v", name, result.HashBuckets)
+	}
+	if buckets["a"] == buckets["c"] || buckets["a"] == buckets["d"] {
+		t.Errorf("%v: Expected differing size or mtime files in other buckets: %v", name, result.HashBuckets)
+	}
 }
 
-type randTestVals struct {
-	minSize            int
-	maxSize            int
-	numDirs            int64
-	numFiles           int64
-	numNewLinks        int64
-	numExistingLinks   int64
-	numInodes          int64
-	numNlinks          int64
-	linkPathsBytes     uint64
-	existingLinksBytes uint64
-	pc                 pathContents        // pathname:contents map
-	contentPaths       map[string][]string // contents:[]pathname map
-	contentClusters    map[string]Clusters // contents:Clusters
+func TestRunSrcPathPreference(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-	// A set of all the file contents we've used, and their usage count
-	contents map[string]int // contents:fileCount
+	simpleFileMaker(t, pathContents{
+		"f1": "X", "f2": "X", "f3": "X",
+		"g1": "YY", "g2": "YY",
+	})
+	// The older inodes have more links, so would otherwise be the src
+	simpleLinkMaker(t, "f1", "f1.link")
+	simpleLinkMaker(t, "g1", "g1.link")
+	for i, f := range []string{"f1", "f2", "g1"} {
+		older := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(f, older, older); err != nil {
+			t.Fatalf("Couldn't Chtimes() on '%v': %v", f, err)
+		}
+	}
+
+	name := "testname: 'Src Path Preference'"
+	opts := SetupOptions(LinkingEnabled, IgnoreTime)
+	opts.SrcPathPreference = NewestMtime
+	result := simpleRun(name, t, opts, 2, ".")
+	if n := result.SrcSelectionCounts[SrcSelectedNewest]; n != int64(len(result.LinkPaths)) {
+		t.Errorf("%v: Expected %v newest src selections, got: %v (%v)", name,
+			len(result.LinkPaths), n, result.SrcSelectionCounts)
+	}
+	if n := result.SrcSelectionCounts[SrcSelectedMostLinked]; n != 0 {
+		t.Errorf("%v: Expected no most linked src selections, got: %v", name, n)
+	}
+	for _, lp := range result.LinkPaths {
+		if lp[0] != "f3" && lp[0] != "g2" {
+			t.Errorf("%v: Expected newest file as link src, got: %v", name, lp)
+		}
+	}
+
+	opts.SrcPathPreference = OldestMtime + 1
+	if _, err := Run([]string{"."}, opts); err == nil {
+		t.Errorf("%v: Expected error for invalid SrcPathPreference", name)
+	}
 }
 
-func newRandTestVals() *randTestVals {
-	return &randTestVals{
-		pc:              make(pathContents),
-		contentPaths:    make(map[string][]string),
-		contentClusters: make(map[string]Clusters),
-		contents:        make(map[string]int),
+func TestRunDisablePathPool(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 30; i++ {
+		m[fmt.Sprintf("d%v/f%v", i%4, i)] = strings.Repeat("X", i%5+1)
+	}
+	simpleFileMaker(t, m)
+	if err := os.Link("d0/f0", "d1/f0.link"); err != nil {
+		t.Fatalf("Couldn't create hardlink: %v", err)
+	}
+
+	// Normalize the (unordered) link groups for comparison
+	name := "testname: 'Disable Path Pool'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.StoreExistingLinkResults = true
+	opts.PathPoolHint = 100
+	pooled, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("%v: Run() returned error: %v", name, err)
 	}
+	opts.DisablePathPool = true
+	unpooled, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("%v: Run() returned error: %v", name, err)
+	}
+
+	if !reflect.DeepEqual(sortedLinkGroups(&pooled), sortedLinkGroups(&unpooled)) {
+		t.Errorf("%v: LinkPaths differ: %v vs %v", name, pooled.LinkPaths, unpooled.LinkPaths)
+	}
+	if !reflect.DeepEqual(pooled.ExistingLinks, unpooled.ExistingLinks) {
+		t.Errorf("%v: ExistingLinks differ: %v vs %v", name, pooled.ExistingLinks, unpooled.ExistingLinks)
+	}
+	verifySameRunStats(name, t, &pooled, &unpooled)
 }
 
-func setupRandTestFiles(t *testing.T, topdir string, samename bool) *randTestVals {
-	r := newRandTestVals()
+func TestRunPathPoolStats(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-	// Use "go test -count=1" to disable test result caching, otherwise the
-	// tests will not run with a new seed.
-	seed := time.Now().UnixNano()
-	rand.Seed(seed)
+	// Many dirs with the same filenames, which should be reused from the
+	// pool for each dir.
+	const numDirs = 10
+	m := pathContents{}
+	for i := 0; i < numDirs; i++ {
+		m[fmt.Sprintf("d%v/sub/file1", i)] = "X"
+		m[fmt.Sprintf("d%v/sub/file2", i)] = fmt.Sprintf("Y%v", i)
+	}
+	simpleFileMaker(t, m)
 
-	const maxContentLen = (1 << 18)
-	const maxContentIndex = maxContentLen - 1
+	name := "testname: 'Path Pool Stats'"
+	opts := SetupOptions(LinkingDisabled)
+	result := simpleRun(name, t, opts, 1, ".")
+	// The walked dirs, the dirnames of the files, and the two filenames
+	maxCount := int64((2*numDirs + 1) + numDirs + 2)
+	if result.PooledStringCount == 0 || result.PooledStringCount > maxCount {
+		t.Errorf("%v: Expected at most %v pooled strings, got: %v", name,
+			maxCount, result.PooledStringCount)
+	}
+	minSaved := uint64(2 * (numDirs - 1) * len("fileN"))
+	if result.PooledStringBytesSaved < minSaved {
+		t.Errorf("%v: Expected at least %v pool bytes saved, got: %v", name,
+			minSaved, result.PooledStringBytesSaved)
+	}
 
-	// Generate a bunch of random bytes
-	contentSrc := make([]byte, maxContentLen)
-	rand.Read(contentSrc)
+	opts.DisablePathPool = true
+	result = simpleRun(name, t, opts, 1, ".")
+	if result.PooledStringCount != 0 || result.PooledStringBytesSaved != 0 {
+		t.Errorf("%v: Expected no pool stats with disabled pool, got: %v %v", name,
+			result.PooledStringCount, result.PooledStringBytesSaved)
+	}
+}
 
-	// Setup min/max file sizes
-	if samename {
-		// Using samename option with excluded files due to size restrictions
-		// affects the end result of the linking (because the on-disk files are
-		// sorted by nlink, which are not reflected in the cluster sorting.
-		// For now, work around by disabling file size restrictions for
-		// samename mode.
-		r.maxSize = 0
-		r.minSize = 0
-	} else {
-		r.maxSize = rand.Intn(maxContentIndex) + 1
-		r.minSize = rand.Intn(r.maxSize)
+func TestRunCheckDirWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping CheckDirWritable test, since root can write read-only dirs")
+	}
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"A/f1": "X", "A/f2": "X", "B/f3": "X"}
+	simpleFileMaker(t, m)
+	if err := os.Chmod("B", 0555); err != nil {
+		t.Fatalf("Couldn't make dir 'B' read-only: %v", err)
 	}
+	defer os.Chmod("B", 0755)
 
-	dirnameChars := ShuffleString("ABC")
-	filenameChars := ShuffleString("abcde")
-	for dirs := range powersetPerms(strings.Split(dirnameChars, "")) {
-		newDir := true
-		for files := range powersetPerms(strings.Split(filenameChars, "")) {
-			dirname := strings.Join(dirs, "")
-			filename := strings.Join(files, "")
-			pathname := path.Join(dirname, filename)
+	name := "testname: 'Check Dir Writable'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.CheckDirWritable = true
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"A/f1", "A/f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "A/f1", "A/f2")
+	verifyInodeCounts(name, t, result, 1, 1, 1, "B/f3")
+	if result.SkippedReadonlyDirCount == 0 || result.SkippedLinkErrCount != 0 {
+		t.Errorf("%v: Expected skipped read-only dir links and no link errors, got: %v %v",
+			name, result.SkippedReadonlyDirCount, result.SkippedLinkErrCount)
+	}
+	verifyContents(name, t, m)
+}
 
-			if err := os.Mkdir(dirname, 0755); err != nil && !os.IsExist(err) {
-				t.Fatalf("Couldn't create dirname '%v'", dirname)
+func TestRunOnExistingLink(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"a1": "X", "b1": "YYY", "c1": "ZZ"}
+	simpleFileMaker(t, m)
+	for _, l := range [][2]string{{"a1", "a2"}, {"a1", "a3"}, {"b1", "b2"}} {
+		if err := os.Link(l[0], l[1]); err != nil {
+			t.Fatalf("Couldn't create hardlink: %v", err)
+		}
+	}
+
+	type existingLink struct {
+		src, dst string
+		size     uint64
+	}
+	var found []existingLink
+
+	name := "testname: 'On Existing Link'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.OnExistingLink = func(src, dst string, size uint64) {
+		found = append(found, existingLink{src, dst, size})
+	}
+	result := simpleRun(name, t, opts, 0, ".")
+	if int64(len(found)) != result.ExistingLinkCount || len(found) != 3 {
+		t.Fatalf("%v: Expected 3 OnExistingLink calls, got: %v", name, found)
+	}
+	sizes := map[byte]uint64{'a': 1, 'b': 3}
+	for _, l := range found {
+		if l.src[0] != l.dst[0] || l.src == l.dst || l.size != sizes[l.src[0]] {
+			t.Errorf("%v: Unexpected existing link: %+v", name, l)
+		}
+	}
+}
+
+func TestRunIgnorePerm(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, IgnorePerm)
+
+	name := "testname: 'Two Unequal File Modes w/ IgnorePerm'"
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+	if err := os.Chmod("f1", 0644); err != nil {
+		t.Fatalf("Couldn't set file 'f1' mode to '0644': %v", err)
+	}
+	if err := os.Chmod("f2", 0755); err != nil {
+		t.Fatalf("Couldn't set file 'f2' mode to '0755': %v", err)
+	}
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunIgnoreTrailingZeros(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Zero Padded Files w/ IgnoreTrailingZeros'"
+
+	padding := strings.Repeat("\x00", 3*minCmpBufSize)
+	m := pathContents{
+		"f1": "ABC", "f2": "ABC" + padding,
+		"f3": "ABD", "f4": "ABD\x00\x00X",
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingDisabled)
+	simpleRun(name, t, opts, 0, ".")
+
+	opts.IgnoreTrailingZeros = true
+	result := simpleRun(name, t, opts, 1, ".")
+	if !verifyLinkPaths(name, t, result, paths{"f1", "f2"}) {
+		t.Errorf("%v: Expected f1 and f2 to be linkable, got: %v", name, result.LinkPaths)
+	}
+	verifyContents(name, t, m)
+}
+
+func TestRunIgnoreTrailingNewline(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Trailing Newline Files w/ IgnoreTrailingNewline'"
+
+	m := pathContents{
+		"f1": "a\n", "f2": "a",
+		"f3": "b\n\n", "f4": "b",
+		"f5": strings.Repeat("c", minCmpBufSize), "f6": strings.Repeat("c", minCmpBufSize) + "\n",
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingDisabled)
+	simpleRun(name, t, opts, 0, ".")
+
+	opts.IgnoreTrailingNewline = true
+	// The kept form of the content is the link src
+	verifySrcs := func(r *Results, srcs ...string) {
+		want := newSet(srcs...)
+		for _, lp := range r.LinkPaths {
+			if _, ok := want[lp[0]]; !ok || len(lp) != 2 {
+				t.Errorf("%v: Expected link srcs %v, got: %v", name, srcs, r.LinkPaths)
 			}
+		}
+	}
+	result := simpleRun(name, t, opts, 2, ".")
+	verifySrcs(result, "f2", "f5")
+	verifyContents(name, t, m)
 
-			var b []byte
-			var s string
-			// Each new file can either be new content or repeated
-			// content, or a link to an existing path.
-			rnd := rand.Float32()
-			if len(r.pc) > 0 && rnd > 0.9 {
-				// Link to arbitrary exising pathname (can create clusters)
-				var oldPathname string
-				n := rand.Intn(len(r.pc))
-				for k := range r.pc {
-					if n == 0 {
-						oldPathname = k
-						break
-					}
-					n--
-				}
+	opts.LinkingEnabled = true
+	opts.KeepTrailingNewline = true
+	result = simpleRun(name, t, opts, 2, ".")
+	verifySrcs(result, "f1", "f6")
+	verifyInodeCounts(name, t, result, 2, 1+uint64(minCmpBufSize), 2, "f1", "f2")
+	for _, p := range []string{"f1", "f2"} {
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != "a\n" {
+			t.Errorf("%v: Expected '%v' to contain 'a\\n', got: %q", name, p, b)
+		}
+	}
+}
 
-				if err := os.Link(oldPathname, pathname); err != nil {
-					t.Fatalf("Couldn't link %v to %v: %v", pathname, oldPathname, err)
-				}
+func TestRunIgnoreBOM(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-				s = r.pc[oldPathname]
-				if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
-					r.contentClusters[s].addToCluster(oldPathname, pathname)
-				}
-			} else {
-				rnd := rand.Float32()
-				if len(r.contents) > 0 && rnd < 0.25 {
-					// Choose arbitrary existing contents
-					n := rand.Intn(len(r.contents))
-					for k := range r.contents {
-						if n == 0 {
-							b = []byte(k)
-							break
-						}
-						n--
-					}
-				} else {
-					// Come up with a previously unseen content string
-					for {
-						// Weight max length towards zero, basically
+	name := "testname: 'BOM Files w/ IgnoreBOM'"
+
+	m := pathContents{
+		"f1": "\xEF\xBB\xBFhello", "f2": "hello",
+		"f3": "\xFE\xFFb", "f4": "\xEF\xBB\xBFb",
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingDisabled)
+	simpleRun(name, t, opts, 0, ".")
+
+	opts.IgnoreBOM = true
+	opts.LinkingEnabled = true
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f2", "f1"})
+	verifyInodeCounts(name, t, result, 1, 8, 2, "f1", "f2")
+	for _, p := range []string{"f1", "f2"} {
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != "hello" {
+			t.Errorf("%v: Expected '%v' to contain 'hello', got: %q", name, p, b)
+		}
+	}
+}
+
+func TestRunExcludeFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.FileExcludes = append(opts.FileExcludes, `.*\.ext$`, `^prefix_.*`)
+
+	name := "testname: 'Exclude Files'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunExcludeDirs(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.DirExcludes = append(opts.DirExcludes, `^A.*`, `.*B$`)
+
+	name := "testname: 'Exclude Dirs'"
+
+	m := pathContents{"Aetc/f1": "X", "preB/f2": "X", "etcA/f1": "X", "Bpre/f2": "X"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"etcA/f1", "Bpre/f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "etcA/f1", "Bpre/f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunIncludeFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.FileIncludes = append(opts.FileIncludes, `.*\.ext$`, `^prefix_.*`)
+
+	name := "testname: 'Include Files'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f3.ext", "prefix_f4"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f3.ext", "prefix_f4")
+	verifyContents(name, t, m)
+}
+
+func TestRunReincludeExcludedFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.FileExcludes = append(opts.FileExcludes, `.*\.ext$`, `^prefix_.*`)
+	opts.FileIncludes = append(opts.FileIncludes, `^prefix_.*`)
+
+	name := "testname: 'Include Files'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3.ext": "X", "prefix_f4": "X"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2", "prefix_f4"})
+	verifyInodeCounts(name, t, result, 2, 2, 3, "f1", "f2", "prefix_f4")
+	verifyContents(name, t, m)
+}
+
+func TestRunMinMaxSize(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, MinFileSize(2), MaxFileSize(2))
+
+	name := "testname: 'Min/Max File Sizes'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "YY", "f4": "YY", "f5": "ZZZ", "f6": "ZZZ"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f3", "f4"})
+	verifyInodeCounts(name, t, result, 1, 2, 2, "f3", "f4")
+	verifyContents(name, t, m)
+}
+
+func TestRunExcludedMinMaxSize(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, MinFileSize(2), MaxFileSize(2))
+
+	name := "testname: 'Excluded Min/Max File Sizes'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f5": "ZZZ", "f6": "ZZZ"}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 0, ".")
+	verifyLinkPaths(name, t, result, paths{})
+	verifyInodeCounts(name, t, result, 0, 0, 1, "f1", "f2", "f5", "f6")
+	verifyContents(name, t, m)
+}
+
+func TestRunZeroMinSize(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, MinFileSize(0), MaxFileSize(1))
+
+	name := "testname: 'Zero Min File Size'"
+
+	m := pathContents{"f1": "", "f2": ""}
+	simpleFileMaker(t, m)
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 0, 2, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunMinFileAge(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, IgnoreTime, MinFileAge(10*time.Minute))
+
+	name := "testname: 'Min File Age'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X"}
+	simpleFileMaker(t, m)
+	old := time.Now().Add(-time.Hour)
+	for _, filename := range []string{"f1", "f2"} {
+		if err := os.Chtimes(filename, old, old); err != nil {
+			t.Fatalf("Couldn't Chtimes() on test file '%v'", filename)
+		}
+	}
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	verifyInodeCounts(name, t, result, 1, 1, 1, "f3")
+	if result.TooRecentFileCount != 1 {
+		t.Errorf("%v: TooRecentFileCount expected: 1, got: %v\n", name, result.TooRecentFileCount)
+	}
+	verifyContents(name, t, m)
+}
+
+func TestRunNowClock(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Now Clock'"
+
+	m := pathContents{"f1": "X", "f2": "X", "f3": "X"}
+	simpleFileMaker(t, m)
+
+	// A fixed clock, which advances a second each time it is read
+	epoch := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	var ticks time.Duration
+	opts := SetupOptions(LinkingDisabled, IgnoreTime, MinFileAge(time.Hour))
+	opts.Now = func() time.Time {
+		now := epoch.Add(ticks)
+		ticks += time.Second
+		return now
+	}
+	for filename, age := range map[string]time.Duration{"f1": 2 * time.Hour, "f2": 3 * time.Hour, "f3": time.Minute} {
+		mtime := epoch.Add(-age)
+		if err := os.Chtimes(filename, mtime, mtime); err != nil {
+			t.Fatalf("Couldn't Chtimes() on test file '%v'", filename)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		ticks = 0
+		result := simpleRun(name, t, opts, 1, ".")
+		verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+		if result.TooRecentFileCount != 1 {
+			t.Errorf("%v: TooRecentFileCount expected: 1, got: %v\n", name, result.TooRecentFileCount)
+		}
+		if !result.StartTime.Equal(epoch) || result.RunTime != "1s" {
+			t.Errorf("%v: Expected start time %v and run time 1s, got: %v, %v", name,
+				epoch, result.StartTime, result.RunTime)
+		}
+	}
+	verifyContents(name, t, m)
+}
+
+func TestRunMaxFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 20; i++ {
+		m[fmt.Sprintf("d%v/f%v", i%3, i)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Max Files'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.MaxFiles = 5
+	result, err := Run([]string{"."}, opts)
+	if err != nil || !result.RunSuccessful {
+		t.Fatalf("%v: Run() was not successful: %v", name, err)
+	}
+	if result.FileCount != 5 {
+		t.Errorf("%v: FileCount expected: 5, got: %v", name, result.FileCount)
+	}
+	if !result.HitFileLimit {
+		t.Errorf("%v: HitFileLimit expected to be true", name)
+	}
+	if result.NewLinkCount != 4 {
+		t.Errorf("%v: NewLinkCount expected: 4, got: %v", name, result.NewLinkCount)
+	}
+
+	opts.MaxFiles = 20
+	result, err = Run([]string{"."}, opts)
+	if err != nil || result.FileCount != 20 || result.HitFileLimit {
+		t.Errorf("%v: Expected all 20 files without hitting limit, got: %v %v %v",
+			name, result.FileCount, result.HitFileLimit, err)
+	}
+}
+
+func TestRunTargetInodeReduction(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// Three groups of three equal files, for up to six removable inodes
+	m := pathContents{}
+	for i := 0; i < 9; i++ {
+		m[fmt.Sprintf("f%v", i)] = strings.Repeat("X", i/3+1)
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Target Inode Reduction'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.TargetInodeReduction = 2
+	result, err := Run([]string{"."}, opts)
+	if err != nil || !result.RunSuccessful {
+		t.Fatalf("%v: Run() was not successful: %v", name, err)
+	}
+	if result.InodeRemovedCount != 2 {
+		t.Errorf("%v: InodeRemovedCount expected: 2, got: %v", name, result.InodeRemovedCount)
+	}
+	if !result.HitInodeTarget {
+		t.Errorf("%v: HitInodeTarget expected to be true", name)
+	}
+	if result.NewLinkCount != 2 {
+		t.Errorf("%v: NewLinkCount expected: 2, got: %v", name, result.NewLinkCount)
+	}
+	verifyContents(name, t, m)
+
+	// A subsequent run without a target links the rest
+	opts.TargetInodeReduction = 0
+	result, err = Run([]string{"."}, opts)
+	if err != nil || result.InodeRemovedCount != 4 || result.HitInodeTarget {
+		t.Errorf("%v: Expected 4 more removed inodes, got: %v %v %v",
+			name, result.InodeRemovedCount, result.HitInodeTarget, err)
+	}
+}
+
+func TestRunCrossedMinMaxSize(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	const min = 2
+	const max = 1
+	opts := SetupOptions(LinkingEnabled, MinFileSize(min), MaxFileSize(max))
+
+	result, err := Run([]string{"."}, opts)
+	if err == nil {
+		t.Errorf("Run succeeded with incorrect min(%v) and max(%v) size options\n", min, max)
+	}
+	if result.RunSuccessful {
+		t.Errorf("Run result was 'successful' with improper min(%v) and max(%v) size options\n", min, max)
+	}
+}
+
+func TestRunEqualXAttrs(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+
+	name := "testname: 'Equal Xattrs'"
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
+	}
+	if err := xattr.Set("f2", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.foo':'bar'  %v\n", err)
+	}
+	if err := xattr.Set("f1", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.baz':'abc'  %v\n", err)
+	}
+	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	}
+
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunUnequalXAttrs(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled)
+
+	name := "testname: 'Unequal Xattrs'"
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
+	}
+	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	}
+
+	result := simpleRun(name, t, opts, 0, ".")
+	verifyLinkPaths(name, t, result, paths{})
+	verifyInodeCounts(name, t, result, 0, 0, 1, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunEqualXAttrsIgnoreXAttr(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, IgnoreXAttr)
+
+	name := "testname: 'Unequal Xattrs w/ IgnoreXattr'"
+
+	m := pathContents{"f1": "X", "f2": "X"}
+	simpleFileMaker(t, m)
+	if err := xattr.Set("f1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f1', 'user.foo':'bar'  %v\n", err)
+	}
+	if err := xattr.Set("f2", "user.foo", []byte{'x', 'y', 'z'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.foo':'xyz'  %v\n", err)
+	}
+	if err := xattr.Set("f2", "user.baz", []byte{'a', 'b', 'c'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'f2', 'user.baz':'abc'  %v\n", err)
+	}
+
+	result := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+	verifyInodeCounts(name, t, result, 1, 1, 2, "f1", "f2")
+	verifyContents(name, t, m)
+}
+
+func TestRunXAttrCompareMode(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Xattr Compare Mode'"
+
+	m := pathContents{"a": "A", "b": "B", "c": "C", "d": "D", "f1": "X", "f2": "X", "g1": "Y", "g2": "Y"}
+	simpleFileMaker(t, m)
+	if err := xattr.Set("g1", "user.foo", []byte{'b', 'a', 'r'}); err != nil {
+		t.Fatalf("Couldn't set xattr on test file: 'g1', 'user.foo':'bar'  %v\n", err)
+	}
+
+	opts := SetupOptions(LinkingDisabled)
+	eager := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, eager, paths{"f1", "f2"})
+
+	opts.XAttrCompareMode = XAttrLazy
+	lazy := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, lazy, paths{"f1", "f2"})
+	if lazy.XAttrCompareCount != 2 || lazy.XAttrCompareCount >= eager.XAttrCompareCount {
+		t.Errorf("%v: Expected 2 lazy xattr comparisons (and fewer than the eager %v), got: %v",
+			name, eager.XAttrCompareCount, lazy.XAttrCompareCount)
+	}
+
+	opts.XAttrCompareMode = XAttrSkip
+	skip := simpleRun(name, t, opts, 2, ".")
+	if !verifyLinkPaths(name, t, skip, paths{"g1", "g2"}) {
+		t.Errorf("%v: Expected skipped xattrs to link 'g1' and 'g2', got: %v", name, skip.LinkPaths)
+	}
+	if skip.XAttrCompareCount != 0 || skip.MismatchedXAttrBytes != 0 {
+		t.Errorf("%v: Expected no skipped xattr comparisons, got: %v", name, skip.XAttrCompareCount)
+	}
+}
+
+func TestRunLinearVsDigestSearch(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// Note - linking disabled to allow re-running multiple times
+	opts := SetupOptions(LinkingDisabled)
+
+	m := pathContents{
+		"f1": "X", "f2": "X",
+		"f3": "YY", "f4": "YY",
+		"f5": "ZZZ", "f6": "ZZZ",
+		"a1": "A", "a2": "A", "a3": "A", "a4": "A", "a5": "A",
+		"a6": "A", "a7": "A", "a8": "A", "a9": "A", "a10": "A",
+		"b1": "B", "b2": "B", "b3": "B", "b4": "B", "b5": "B",
+		"b6": "B", "b7": "B", "b8": "B", "b9": "B", "b10": "B",
+		"c1": "C", "c2": "C", "c3": "C", "c4": "C", "c5": "C",
+		"c6": "C", "c7": "C", "c8": "C", "c9": "C", "c10": "C",
+	}
+	simpleFileMaker(t, m)
+
+	// Confirm that results match for different max linear search lengths
+	for i := -1; i < 12; i++ {
+		name := fmt.Sprintf("testname: 'Linear Vs Digest Search' val=%v", i)
+		opts.SearchThresh = i
+		result := simpleRun(name, t, opts, 6, ".")
+		verifyLinkPaths(name, t, result, paths{"f1", "f2"})
+		verifyLinkPaths(name, t, result, paths{"f3", "f4"})
+		verifyLinkPaths(name, t, result, paths{"f5", "f6"})
+		verifyInodeCounts(name, t, result, 30, 33, 1)
+		verifyContents(name, t, m)
+	}
+}
+
+func TestRunDigestReordering(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// Equal sized files in one hash bucket, which digests separate
+	m := pathContents{}
+	for i := 0; i < 30; i++ {
+		m[fmt.Sprintf("f%v", i)] = strings.Repeat(string("ABC"[i%3]), 2)
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Digest Reordering'"
+	opts := SetupOptions(LinkingDisabled)
+	opts.SearchThresh = -1
+	result := simpleRun(name, t, opts, 3, ".")
+	if result.DigestReorderedInoCount != 0 || result.DigestFirstHitCount != 0 {
+		t.Errorf("%v: Expected no digest reordering when disabled, got: %v %v", name,
+			result.DigestReorderedInoCount, result.DigestFirstHitCount)
+	}
+
+	opts.SearchThresh = 0
+	result = simpleRun(name, t, opts, 3, ".")
+	if result.DigestFirstHitCount == 0 {
+		t.Errorf("%v: Expected digest first hits, got: 0", name)
+	}
+	if result.DigestReorderedInoCount < result.DigestFirstHitCount {
+		t.Errorf("%v: DigestReorderedInoCount (%v) less than DigestFirstHitCount (%v)",
+			name, result.DigestReorderedInoCount, result.DigestFirstHitCount)
+	}
+}
+
+type PathnameSet map[string]struct{} // string = pathname
+type Clusters []PathnameSet
+
+func newPathnameSet(s string) PathnameSet {
+	ps := PathnameSet{}
+	ps[s] = struct{}{}
+	return ps
+}
+
+// Add newPath to the cluster containing prevPath
+func (c Clusters) addToCluster(prevPath, newPath string) {
+	for _, m := range c {
+		if _, ok := m[prevPath]; ok {
+			m[newPath] = struct{}{}
+			break
+		}
+	}
+}
+
+type randTestVals struct {
+	minSize            int
+	maxSize            int
+	numDirs            int64
+	numFiles           int64
+	numNewLinks        int64
+	numExistingLinks   int64
+	numInodes          int64
+	numNlinks          int64
+	linkPathsBytes     uint64
+	existingLinksBytes uint64
+	pc                 pathContents        // pathname:contents map
+	contentPaths       map[string][]string // contents:[]pathname map
+	contentClusters    map[string]Clusters // contents:Clusters
+
+	// A set of all the file contents we've used, and their usage count
+	contents map[string]int // contents:fileCount
+}
+
+func newRandTestVals() *randTestVals {
+	return &randTestVals{
+		pc:              make(pathContents),
+		contentPaths:    make(map[string][]string),
+		contentClusters: make(map[string]Clusters),
+		contents:        make(map[string]int),
+	}
+}
+
+func setupRandTestFiles(t *testing.T, topdir string, samename bool) *randTestVals {
+	r := newRandTestVals()
+
+	// Use "go test -count=1" to disable test result caching, otherwise the
+	// tests will not run with a new seed.
+	seed := time.Now().UnixNano()
+	rand.Seed(seed)
+
+	const maxContentLen = (1 << 18)
+	const maxContentIndex = maxContentLen - 1
+
+	// Generate a bunch of random bytes
+	contentSrc := make([]byte, maxContentLen)
+	rand.Read(contentSrc)
+
+	// Setup min/max file sizes
+	if samename {
+		// Using samename option with excluded files due to size restrictions
+		// affects the end result of the linking (because the on-disk files are
+		// sorted by nlink, which are not reflected in the cluster sorting.
+		// For now, work around by disabling file size restrictions for
+		// samename mode.
+		r.maxSize = 0
+		r.minSize = 0
+	} else {
+		r.maxSize = rand.Intn(maxContentIndex) + 1
+		r.minSize = rand.Intn(r.maxSize)
+	}
+
+	dirnameChars := ShuffleString("ABC")
+	filenameChars := ShuffleString("abcde")
+	for dirs := range powersetPerms(strings.Split(dirnameChars, "")) {
+		newDir := true
+		for files := range powersetPerms(strings.Split(filenameChars, "")) {
+			dirname := strings.Join(dirs, "")
+			filename := strings.Join(files, "")
+			pathname := path.Join(dirname, filename)
+
+			if err := os.Mkdir(dirname, 0755); err != nil && !os.IsExist(err) {
+				t.Fatalf("Couldn't create dirname '%v'", dirname)
+			}
+
+			var b []byte
+			var s string
+			// Each new file can either be new content or repeated
+			// content, or a link to an existing path.
+			rnd := rand.Float32()
+			if len(r.pc) > 0 && rnd > 0.9 {
+				// Link to arbitrary exising pathname (can create clusters)
+				var oldPathname string
+				n := rand.Intn(len(r.pc))
+				for k := range r.pc {
+					if n == 0 {
+						oldPathname = k
+						break
+					}
+					n--
+				}
+
+				if err := os.Link(oldPathname, pathname); err != nil {
+					t.Fatalf("Couldn't link %v to %v: %v", pathname, oldPathname, err)
+				}
+
+				s = r.pc[oldPathname]
+				if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
+					r.contentClusters[s].addToCluster(oldPathname, pathname)
+				}
+			} else {
+				rnd := rand.Float32()
+				if len(r.contents) > 0 && rnd < 0.25 {
+					// Choose arbitrary existing contents
+					n := rand.Intn(len(r.contents))
+					for k := range r.contents {
+						if n == 0 {
+							b = []byte(k)
+							break
+						}
+						n--
+					}
+				} else {
+					// Come up with a previously unseen content string
+					for {
+						// Weight max length towards zero, basically
 						// making it more likely to have smaller files
 						// than large (but definitely allow large)
 						const avgSize = 8192
@@ -1057,204 +2148,952 @@ func setupRandTestFiles(t *testing.T, topdir string, samename bool) *randTestVal
 						m := rand.Intn(n)
 						b = contentSrc[m:n]
 
-						if _, ok := r.contents[string(b)]; !ok {
-							break
-						}
-					}
-				}
+						if _, ok := r.contents[string(b)]; !ok {
+							break
+						}
+					}
+				}
+
+				if err := ioutil.WriteFile(pathname, b, 0644); err != nil {
+					t.Fatalf("Couldn't write pathname '%v' w/ rnd byte contents", pathname)
+				}
+
+				s = string(b)
+				if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
+					r.contents[s]++
+					r.contentClusters[s] = append(r.contentClusters[s], newPathnameSet(pathname))
+					r.contentPaths[s] = append(r.contentPaths[s], pathname)
+				}
+			}
+
+			if newDir {
+				r.numDirs++
+				newDir = false
+			}
+			if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
+				r.pc[pathname] = s
+				r.numFiles++
+			}
+		}
+	}
+	r.numDirs++ // Add in top level directory
+	return r
+}
+
+func runAndCheckFileCounts(t *testing.T, opts Options, r *randTestVals) *Results {
+	opts.MaxFileSize = uint64(r.maxSize)
+	opts.MinFileSize = uint64(r.minSize)
+	result, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Errorf("Error with Run() on random test files: %v", err)
+	}
+
+	if r.numDirs != result.DirCount {
+		t.Errorf("Expected %v dirs, got: %v", r.numDirs, result.DirCount)
+	}
+	if r.numFiles != result.FileCount {
+		t.Errorf("Expected %v files, got: %v", r.numFiles, result.FileCount)
+	}
+	return &result
+}
+
+func checkRunStats(t *testing.T, r *randTestVals, result *Results) {
+	// Count how many times file content was used more than once.  The
+	// result should equal the number of LinkPaths (ie. sets of pathnames
+	// to link together).
+	numLinkPaths := 0
+	for _, v := range r.contents {
+		if v > 1 {
+			numLinkPaths++
+		}
+	}
+	if numLinkPaths != len(result.LinkPaths) {
+		t.Errorf("Expected %v LinkPaths, got: %v", numLinkPaths, len(result.LinkPaths))
+	}
+
+	// Check to see if our expected NewLinkCount matches what was computed.
+	// This is done by having kept track of "clusters" when setting up the
+	// test files (ie. grouping files with equal content by keeping track
+	// of those that are linked together before the Run()
+	for co, cl := range r.contentClusters {
+		r.numInodes += int64(len(cl))
+
+		// Sort by highest cluster count to lowest
+		sort.Slice(cl, func(i, j int) bool { return len(cl[i]) > len(cl[j]) })
+		// Doesn't handle maxNlink scenarios
+		for i, m := range cl {
+			r.numNlinks += int64(len(m))
+
+			// The first cluster (with highest nlink count) is skipped,
+			// because they will be linked to, not from, so aren't counted
+			// by the NewLinkCount
+			if i > 0 {
+				r.numNewLinks += int64(len(m))
+				r.linkPathsBytes += uint64(len(co))
+			}
+
+			// Also count the prev links using the cluster information.
+			// Clusters of more than 1 pathname are pre-existing.
+			if len(m) > 1 {
+				r.numExistingLinks += int64(len(m) - 1)
+				r.existingLinksBytes += uint64(len(co) * (len(m) - 1))
+			}
+		}
+	}
+	if r.numInodes != result.InodeCount {
+		t.Errorf("Expected %v inodes, got: %v",
+			r.numInodes, result.InodeCount)
+	}
+	if r.numNlinks != result.NlinkCount {
+		t.Errorf("Expected %v nlinks, got: %v",
+			r.numNlinks, result.NlinkCount)
+	}
+	if r.numNewLinks != result.NewLinkCount {
+		t.Errorf("Expected %v NewLinkCount, got: %v",
+			r.numNewLinks, result.NewLinkCount)
+	}
+	if r.numExistingLinks != result.ExistingLinkCount {
+		t.Errorf("Expected %v ExistingLinkCount, got: %v",
+			r.numExistingLinks, result.ExistingLinkCount)
+	}
+	if r.linkPathsBytes != result.InodeRemovedByteAmount {
+		t.Errorf("Expected %v InodeRemovedByteAmount, got: %v",
+			r.linkPathsBytes, result.InodeRemovedByteAmount)
+	}
+	if r.existingLinksBytes != result.ExistingLinkByteAmount {
+		t.Errorf("Expected %v ExistingLinkedByteAmount, got: %v",
+			r.existingLinksBytes, result.ExistingLinkByteAmount)
+	}
+}
+
+type FilenameCounts map[string]int
+
+func checkSameNameRunStats(t *testing.T, r *randTestVals, result *Results) {
+	// Verify same filename is used in all LinkPaths
+	for _, paths := range result.LinkPaths {
+		filenames := map[string]struct{}{}
+		for _, p := range paths {
+			filenames[path.Base(p)] = struct{}{}
+		}
+		if len(filenames) > 1 {
+			t.Errorf("SameName LinkPaths has mismatched filenames: %+v", result.LinkPaths)
+		}
+	}
+
+	// Count the number of new links by counting the number of pathnames
+	// with matching filenames, but *not* counting those used in the first
+	// link cluster where the filename is encountered.
+	for _, clusters := range r.contentClusters {
+		// Sort by max nlink to min to replicate algorithm.  The
+		// results will differ otherwise
+		sort.Slice(clusters, func(i, j int) bool { return len(clusters[i]) > len(clusters[j]) })
+
+		// The number of filenames to be linked to (ie. the number found after
+		// the initial cluster)
+		linkableFC := FilenameCounts{}
+
+		// Cluster index where a filename was first encountered
+		firstSeen := FilenameCounts{}
+
+		// For each cluster in the list of clusters, keep track of which one
+		// holds the first appearance of a filename (of any given pathname),
+		// and don't count the filename (or names) in the first encountered
+		// cluster (which would act as the src inode, not the destination).
+		for i, cluster := range clusters {
+			for pathname := range cluster {
+				filename := path.Base(pathname)
+				whenSeen, ok := firstSeen[filename]
+				if !ok {
+					firstSeen[filename] = i
+				} else if whenSeen < i {
+					linkableFC[filename]++
+				}
+			}
+		}
+		for _, count := range linkableFC {
+			r.numNewLinks += int64(count)
+		}
+	}
+	if r.numNewLinks != result.NewLinkCount {
+		t.Errorf("Expected %v NewLinkCount, got: %v\n", r.numNewLinks, result.NewLinkCount)
+	}
+}
+
+// TestRandFiles creates a bunch of files with random content, some with equal
+// contents, and some pre-linked.  It checks that the result of a linking run
+// are as expected.
+func TestRandFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping RandFiles test in short mode")
+	}
+
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, ContentOnly)
+	r := setupRandTestFiles(t, topdir, opts.SameName)
+	results := runAndCheckFileCounts(t, opts, r)
+	checkRunStats(t, r, results)
+}
+
+func TestRandSameNameFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping RandFiles test in short mode")
+	}
+
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	opts := SetupOptions(LinkingEnabled, ContentOnly, SameName)
+	r := setupRandTestFiles(t, topdir, opts.SameName)
+	results := runAndCheckFileCounts(t, opts, r)
+	checkSameNameRunStats(t, r, results)
+}
+
+func TestRunExpectedFileCount(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"f1": "X",
+		"f2": "X",
+		"f3": "Y",
+		"f4": "Y",
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.ExpectedFileCount = 100
+	simpleRun("ExpectedFileCount", t, opts, 2, topdir)
+
+	opts.ExpectedFileCount = -1
+	if _, err := Run([]string{topdir}, opts); err == nil {
+		t.Errorf("Expected negative ExpectedFileCount to be rejected")
+	}
+}
+
+func TestRunPathsForInode(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"A/f1": "X",
+		"A/f2": "X",
+		"A/f3": "X",
+		"A/g1": "Y",
+	})
+	simpleLinkMaker(t, "A/f3", "A/f4")
+
+	name := "testname: 'Paths For Inode'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.StoreInodePaths = true
+	result := simpleRun(name, t, opts, 1, "A")
+
+	fi, err := os.Lstat("A/f1")
+	if err != nil {
+		t.Fatalf("%v: Couldn't stat 'A/f1': %v", name, err)
+	}
+	stat := fi.Sys().(*syscall.Stat_t)
+	expected := []string{"A/f1", "A/f2", "A/f3", "A/f4"}
+	got := result.PathsForInode(uint64(stat.Dev), uint64(stat.Ino))
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%v: Expected inode paths %v, got: %v", name, expected, got)
+	}
+	if got := result.PathsForInode(uint64(stat.Dev), 0); got != nil {
+		t.Errorf("%v: Expected no paths for unknown inode, got: %v", name, got)
+	}
+}
+
+func TestRunUniqueSizeSkip(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// All distinct sizes, except for one linkable pair
+	m := pathContents{"g1": "ZZ", "g2": "ZZ"}
+	for i := 3; i < 13; i++ {
+		m[fmt.Sprintf("f%v", i)] = strings.Repeat("X", i)
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Unique Size Skip'"
+	for _, workers := range []int{0, 4} {
+		opts := SetupOptions(LinkingDisabled)
+		opts.BucketWorkers = workers
+		result := simpleRun(name, t, opts, 1, ".")
+		if result.ComparisonCount != 1 {
+			t.Errorf("%v: Expected only the pair to be compared, got: %v", name, result.ComparisonCount)
+		}
+		if result.UniqueSizeSkipCount != 10 || result.InodeCount != 12 {
+			t.Errorf("%v: Expected 10 unique size skips of 12 inodes, got: %v, %v", name,
+				result.UniqueSizeSkipCount, result.InodeCount)
+		}
+		if result.MissedHashCount != 1 || result.FoundHashCount != 1 {
+			t.Errorf("%v: Expected only the pair to be hashed, got: %v missed, %v found", name,
+				result.MissedHashCount, result.FoundHashCount)
+		}
+	}
+
+	// No comparisons are made when all the sizes are distinct
+	if err := os.Remove("g2"); err != nil {
+		t.Fatal(err)
+	}
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 0, ".")
+	if result.ComparisonCount != 0 || result.DigestComputedCount != 0 {
+		t.Errorf("%v: Expected no comparisons or digests, got: %v, %v", name,
+			result.ComparisonCount, result.DigestComputedCount)
+	}
+	if result.UniqueSizeSkipCount != 11 || result.MissedHashCount != 0 {
+		t.Errorf("%v: Expected 11 unique size skips and no hashes, got: %v, %v", name,
+			result.UniqueSizeSkipCount, result.MissedHashCount)
+	}
+}
+
+func TestRunConsolidationSkipCount(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Consolidation Skip Count'"
+
+	// Identical files, each with an existing link in another dir.  Once
+	// the first dir is walked, the files are all known to be linkable, so
+	// the search is skipped for the existing links.
+	prevCount := int64(0)
+	for n := 2; n <= 8; n *= 2 {
+		m := pathContents{}
+		for i := 0; i < n; i++ {
+			m[fmt.Sprintf("n%v/a/f%v", n, i)] = "X"
+		}
+		simpleFileMaker(t, m)
+		for i := 0; i < n; i++ {
+			simpleLinkMaker(t, fmt.Sprintf("n%v/a/f%v", n, i), fmt.Sprintf("n%v/b/f%v", n, i))
+		}
+
+		dirs := []string{fmt.Sprintf("n%v/a", n), fmt.Sprintf("n%v/b", n)}
+		result := simpleRun(name, t, SetupOptions(LinkingDisabled), 1, dirs...)
+		if result.ConsolidationSkipCount != int64(n) || result.ConsolidationSkipCount <= prevCount {
+			t.Errorf("%v: Expected %v consolidation skips, got: %v", name, n, result.ConsolidationSkipCount)
+		}
+		if result.ComparisonCount != int64(n-1) {
+			t.Errorf("%v: Expected %v comparisons, got: %v", name, n-1, result.ComparisonCount)
+		}
+		prevCount = result.ConsolidationSkipCount
+	}
+}
+
+func TestRunComparisonBytes(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"a1": strings.Repeat("X", 100),
+		"a2": strings.Repeat("X", 100),
+		"b1": strings.Repeat("Y", 5000),
+		"b2": strings.Repeat("Y", 5000),
+		"c1": "Z",
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Comparison Bytes'"
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 2, ".")
+	if result.MaxComparisonBytes != 2*5000 {
+		t.Errorf("%v: Expected MaxComparisonBytes %v, got: %v", name, 2*5000, result.MaxComparisonBytes)
+	}
+	if result.AvgComparisonBytes != (2*100+2*5000)/2 {
+		t.Errorf("%v: Expected AvgComparisonBytes %v, got: %v", name, (2*100+2*5000)/2,
+			result.AvgComparisonBytes)
+	}
+}
+
+func TestRunMaxDigestFileSize(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// A bucket of equal sized (and mtime) large files, with one duplicate
+	m := pathContents{"dup": strings.Repeat("A", 8192)}
+	for i := 0; i < 5; i++ {
+		m[fmt.Sprintf("f%v", i)] = strings.Repeat(string(rune('A'+i)), 8192)
+	}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Max Digest File Size'"
+	opts := SetupOptions(LinkingDisabled)
+	result := simpleRun(name, t, opts, 1, ".")
+	if result.DigestComputedCount == 0 {
+		t.Fatalf("%v: Expected digests to be computed without MaxDigestFileSize", name)
+	}
+
+	opts.MaxDigestFileSize = 4096
+	result = simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, result, paths{"dup", "f0"})
+	if result.DigestComputedCount != 0 {
+		t.Errorf("%v: Expected no digests computed above MaxDigestFileSize, got: %v",
+			name, result.DigestComputedCount)
+	}
+	if result.DigestSizeSkipCount == 0 {
+		t.Errorf("%v: Expected digest size skips", name)
+	}
+
+	opts.MaxDigestFileSize = 8192
+	result = simpleRun(name, t, opts, 1, ".")
+	if result.DigestComputedCount == 0 || result.DigestSizeSkipCount != 0 {
+		t.Errorf("%v: Expected digests at MaxDigestFileSize, got: %v (skips: %v)",
+			name, result.DigestComputedCount, result.DigestSizeSkipCount)
+	}
+}
+
+func TestRunProfileTiming(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{"f1": "X", "f2": "X", "g1": "Y"}
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Profile Timing'"
+	opts := SetupOptions(LinkingDisabled)
+	result := simpleRun(name, t, opts, 1, ".")
+	if result.IOTimeMillis != 0 || result.CPUTimeMillis != 0 {
+		t.Errorf("%v: Expected no timing without ProfileTiming, got IO: %v, CPU: %v",
+			name, result.IOTimeMillis, result.CPUTimeMillis)
+	}
+
+	opts.ProfileTiming = true
+	result = simpleRun(name, t, opts, 1, ".")
+	if result.ComparisonCount == 0 {
+		t.Fatalf("%v: Expected file comparisons", name)
+	}
+	if result.IOTimeMillis <= 0 || result.CPUTimeMillis <= 0 {
+		t.Errorf("%v: Expected non-zero timing, got IO: %v, CPU: %v",
+			name, result.IOTimeMillis, result.CPUTimeMillis)
+	}
+}
+
+func TestRunCanonicalStoreDir(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"A/f1": "X",
+		"A/f2": "X",
+		"A/f3": "X",
+		"A/g1": "YY",
+		"A/g2": "YY",
+	}
+	simpleFileMaker(t, m)
+	if err := os.Mkdir("store", 0755); err != nil {
+		t.Fatalf("Couldn't create store dir: %v", err)
+	}
+
+	name := "testname: 'Canonical Store Dir'"
+	opts := SetupOptions(LinkingEnabled)
+	opts.CanonicalStoreDir = "store"
+	result := simpleRun(name, t, opts, 2, "A")
+	if result.CanonicalStoredCount != 2 {
+		t.Errorf("%v: Expected 2 canonical store files, got: %v", name, result.CanonicalStoredCount)
+	}
+	verifyStored := func(contents string, pathnames ...string) {
+		storeName := path.Join("store", fmt.Sprintf("%x", sha256.Sum256([]byte(contents))))
+		storeFI, err := os.Lstat(storeName)
+		if err != nil {
+			t.Fatalf("%v: Couldn't stat canonical store file for '%v': %v", name, contents, err)
+		}
+		for _, p := range pathnames {
+			fi, err := os.Lstat(p)
+			if err != nil {
+				t.Fatalf("%v: Couldn't stat '%v': %v", name, p, err)
+			}
+			if !os.SameFile(fi, storeFI) {
+				t.Errorf("%v: Expected '%v' to be linked to '%v'", name, p, storeName)
+			}
+		}
+	}
+	verifyStored("X", "A/f1", "A/f2", "A/f3")
+	verifyStored("YY", "A/g1", "A/g2")
+	if entries, _ := ioutil.ReadDir("store"); len(entries) != 2 {
+		t.Errorf("%v: Expected 2 canonical store files, got: %v", name, len(entries))
+	}
+
+	// A new group with already stored content is linked to the store file
+	m2 := pathContents{"B/h1": "X", "B/h2": "X"}
+	simpleFileMaker(t, m2)
+	opts.IgnoreTime = true
+	var audit bytes.Buffer
+	opts.AuditLog = &audit
+	result = simpleRun(name, t, opts, 1, "B")
+	if result.CanonicalStoredCount != 0 || result.CanonicalLinkedCount != 2 {
+		t.Errorf("%v: Expected 0 stored and 2 relinked, got: %v, %v", name,
+			result.CanonicalStoredCount, result.CanonicalLinkedCount)
+	}
+	// Both the linked and the relinked inodes of the group were removed
+	if result.InodeRemovedCount != 2 || result.InodeRemovedByteAmount != 2 {
+		t.Errorf("%v: Expected 2 removed inodes of 2 bytes, got: %v, %v", name,
+			result.InodeRemovedCount, result.InodeRemovedByteAmount)
+	}
+	if n := strings.Count(audit.String(), " LINK "); n != 3 {
+		t.Errorf("%v: Expected 3 audited links, got: %v", name, n)
+	}
+	verifyStored("X", "A/f1", "B/h1", "B/h2")
+	verifyContents(name, t, m)
+	verifyContents(name, t, m2)
+}
+
+func TestCanonicalStoreMaxNLinks(t *testing.T) {
+	for _, numFiles := range []int{5, 6} {
+		topdir := setUp("Run", t)
 
-				if err := ioutil.WriteFile(pathname, b, 0644); err != nil {
-					t.Fatalf("Couldn't write pathname '%v' w/ rnd byte contents", pathname)
-				}
+		m := pathContents{}
+		for i := 0; i < numFiles; i++ {
+			m[fmt.Sprintf("n%v", i)] = "X"
+		}
+		simpleFileMaker(t, m)
+		if err := os.Mkdir("store", 0755); err != nil {
+			t.Fatalf("Couldn't create store dir: %v", err)
+		}
 
-				s = string(b)
-				if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
-					r.contents[s]++
-					r.contentClusters[s] = append(r.contentClusters[s], newPathnameSet(pathname))
-					r.contentPaths[s] = append(r.contentPaths[s], pathname)
-				}
+		name := fmt.Sprintf("testname: 'Canonical Store MaxNLinks' files: %v", numFiles)
+		opts := SetupOptions(LinkingEnabled)
+		opts.CanonicalStoreDir = "store"
+		ls := newLinkableState(&opts)
+		ls.Progress = &disabledProgress{}
+
+		// Gather the files, as in the walk phase of Run()
+		var fs fsDev
+		for i := 0; i < numFiles; i++ {
+			pathname := fmt.Sprintf("n%v", i)
+			di, err := I.LStatInfo(pathname)
+			if err != nil {
+				t.Fatalf("%v: Couldn't stat '%v': %v", name, pathname, err)
 			}
-
-			if newDir {
-				r.numDirs++
-				newDir = false
+			fs = ls.dev(di, pathname)
+			if err := fs.FindIdenticalFiles(di, pathname); err != nil {
+				t.Fatalf("%v: FindIdenticalFiles() returned error: %v", name, err)
 			}
-			if len(s) >= r.minSize && (r.maxSize == 0 || len(s) <= r.maxSize) {
-				r.pc[pathname] = s
-				r.numFiles++
+		}
+
+		// Override the filesystem nlink limit with a low value, so that
+		// the files are linked as inodes of 3 and the rest.  The store
+		// file links the inode below the limit, or isn't stored.
+		fs.MaxNLinks = 3
+		if err := fs.generateLinks(); err != nil {
+			t.Fatalf("%v: generateLinks() returned error: %v", name, err)
+		}
+		verifyContents(name, t, m)
+		for i := 0; i < numFiles; i++ {
+			if n := nlinkVal(fmt.Sprintf("n%v", i)); n > 3 {
+				t.Errorf("%v: Expected nlink of at most 3 for 'n%v', got: %v", name, i, n)
 			}
 		}
+		entries, _ := ioutil.ReadDir("store")
+		if numFiles == 5 && (len(entries) != 1 || ls.Results.CanonicalStoredCount != 1) {
+			t.Errorf("%v: Expected 1 canonical store file, got: %v", name, len(entries))
+		}
+		if numFiles == 6 && (len(entries) != 0 || ls.Results.CanonicalSkippedCount != 1) {
+			t.Errorf("%v: Expected the group to be skipped, got %v store files", name, len(entries))
+		}
+		os.RemoveAll(topdir)
 	}
-	r.numDirs++ // Add in top level directory
-	return r
 }
 
-func runAndCheckFileCounts(t *testing.T, opts Options, r *randTestVals) *Results {
-	opts.MaxFileSize = uint64(r.maxSize)
-	opts.MinFileSize = uint64(r.minSize)
-	result, err := Run([]string{"."}, opts)
-	if err != nil {
-		t.Errorf("Error with Run() on random test files: %v", err)
+func TestRunUniqueContentCount(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"a1": "X",
+		"a2": "X",
+		"a3": "X",
+		"b1": "YY",
+		"c1": "ZZZ",
 	}
+	simpleFileMaker(t, m)
+	simpleLinkMaker(t, "c1", "c2")
 
-	if r.numDirs != result.DirCount {
-		t.Errorf("Expected %v dirs, got: %v", r.numDirs, result.DirCount)
+	name := "testname: 'Unique Content Count'"
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 1, ".")
+	if result.FileCount != 6 {
+		t.Errorf("%v: Expected 6 files, got: %v", name, result.FileCount)
 	}
-	if r.numFiles != result.FileCount {
-		t.Errorf("Expected %v files, got: %v", r.numFiles, result.FileCount)
+	if result.UniqueContentCount != 3 {
+		t.Errorf("%v: Expected 3 unique contents, got: %v", name, result.UniqueContentCount)
 	}
-	return &result
 }
 
-func checkRunStats(t *testing.T, r *randTestVals, result *Results) {
-	// Count how many times file content was used more than once.  The
-	// result should equal the number of LinkPaths (ie. sets of pathnames
-	// to link together).
-	numLinkPaths := 0
-	for _, v := range r.contents {
-		if v > 1 {
-			numLinkPaths++
-		}
+func TestRunLargestLinkableFiles(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{
+		"a1": strings.Repeat("X", 10),
+		"a2": strings.Repeat("X", 10),
+		"b1": strings.Repeat("Y", 1000),
+		"b2": strings.Repeat("Y", 1000),
+		"c1": strings.Repeat("Z", 100),
+		"c2": strings.Repeat("Z", 100),
+		"c3": strings.Repeat("Z", 100),
+		"d1": strings.Repeat("W", 5000),
 	}
-	if numLinkPaths != len(result.LinkPaths) {
-		t.Errorf("Expected %v LinkPaths, got: %v", numLinkPaths, len(result.LinkPaths))
+	simpleFileMaker(t, m)
+
+	name := "testname: 'Largest Linkable Files'"
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 3, ".")
+	largest := result.LargestLinkableFiles(2)
+	if len(largest) != 2 {
+		t.Fatalf("%v: Expected 2 largest files, got: %v", name, largest)
+	}
+	if !strings.HasPrefix(largest[0].Path, "b") || largest[0].Size != 1000 {
+		t.Errorf("%v: Expected a 'b' file of 1000 bytes first, got: %v", name, largest[0])
+	}
+	if !strings.HasPrefix(largest[1].Path, "c") || largest[1].Size != 100 {
+		t.Errorf("%v: Expected a 'c' file of 100 bytes second, got: %v", name, largest[1])
+	}
+	if all := result.LargestLinkableFiles(100); len(all) != 4 {
+		t.Errorf("%v: Expected 4 linkable files, got: %v", name, all)
 	}
 
-	// Check to see if our expected NewLinkCount matches what was computed.
-	// This is done by having kept track of "clusters" when setting up the
-	// test files (ie. grouping files with equal content by keeping track
-	// of those that are linked together before the Run()
-	for co, cl := range r.contentClusters {
-		r.numInodes += int64(len(cl))
+	// The sizes follow the filtered link groups
+	c := result.Filter("c")
+	filtered := c.LargestLinkableFiles(100)
+	if len(filtered) != 2 || filtered[0].Size != 100 || filtered[1].Size != 100 {
+		t.Errorf("%v: Expected 2 filtered 'c' files of 100 bytes, got: %v", name, filtered)
+	}
+}
 
-		// Sort by highest cluster count to lowest
-		sort.Slice(cl, func(i, j int) bool { return len(cl[i]) > len(cl[j]) })
-		// Doesn't handle maxNlink scenarios
-		for i, m := range cl {
-			r.numNlinks += int64(len(m))
+func TestRunDeviceCount(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-			// The first cluster (with highest nlink count) is skipped,
-			// because they will be linked to, not from, so aren't counted
-			// by the NewLinkCount
-			if i > 0 {
-				r.numNewLinks += int64(len(m))
-				r.linkPathsBytes += uint64(len(co))
-			}
+	simpleFileMaker(t, pathContents{
+		"A/f1": "X",
+		"B/f2": "X",
+	})
 
-			// Also count the prev links using the cluster information.
-			// Clusters of more than 1 pathname are pre-existing.
-			if len(m) > 1 {
-				r.numExistingLinks += int64(len(m) - 1)
-				r.existingLinksBytes += uint64(len(co) * (len(m) - 1))
+	name := "testname: 'Device Count'"
+	result := simpleRun(name, t, SetupOptions(LinkingDisabled), 1, "A", "B")
+	if result.DeviceCount != 1 {
+		t.Errorf("%v: Expected 1 device, got: %v", name, result.DeviceCount)
+	}
+}
+
+// benchmarkRunExpectedFileCount walks a synthetic tree of many small
+// duplicate files, with the given ExpectedFileCount hint.
+func benchmarkRunExpectedFileCount(b *testing.B, hint int64) {
+	topdir, err := ioutil.TempDir("", "hardlinkable")
+	if err != nil {
+		b.Fatalf("Couldn't create temp dir for benchmark: %v", err)
+	}
+	defer os.RemoveAll(topdir)
+
+	const numDirs, numFiles = 20, 250
+	for i := 0; i < numDirs; i++ {
+		dirname := path.Join(topdir, fmt.Sprintf("d%d", i))
+		if err := os.Mkdir(dirname, 0755); err != nil {
+			b.Fatalf("Couldn't create benchmark dir: %v", err)
+		}
+		for j := 0; j < numFiles; j++ {
+			pathname := path.Join(dirname, fmt.Sprintf("f%d", j))
+			contents := []byte(fmt.Sprintf("%d", j%(numFiles/5)))
+			if err := ioutil.WriteFile(pathname, contents, 0644); err != nil {
+				b.Fatalf("Couldn't create benchmark file: %v", err)
 			}
 		}
 	}
-	if r.numInodes != result.InodeCount {
-		t.Errorf("Expected %v inodes, got: %v",
-			r.numInodes, result.InodeCount)
-	}
-	if r.numNlinks != result.NlinkCount {
-		t.Errorf("Expected %v nlinks, got: %v",
-			r.numNlinks, result.NlinkCount)
+
+	opts := SetupOptions(LinkingDisabled, IgnoreTime)
+	opts.ExpectedFileCount = hint
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Run([]string{topdir}, opts); err != nil {
+			b.Fatalf("Run failed: %v", err)
+		}
 	}
-	if r.numNewLinks != result.NewLinkCount {
-		t.Errorf("Expected %v NewLinkCount, got: %v",
-			r.numNewLinks, result.NewLinkCount)
+}
+
+func BenchmarkRunNoExpectedFileCount(b *testing.B) {
+	benchmarkRunExpectedFileCount(b, 0)
+}
+
+func BenchmarkRunExpectedFileCount(b *testing.B) {
+	benchmarkRunExpectedFileCount(b, 20*250)
+}
+
+func TestRunRequireBackupMarker(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Require Backup Marker'"
+	m := pathContents{"d1/f1": "X", "d2/f2": "X"}
+	simpleFileMaker(t, m)
+	if err := ioutil.WriteFile("d1/.backup-ok", nil, 0644); err != nil {
+		t.Fatalf("Couldn't create marker file: %v", err)
 	}
-	if r.numExistingLinks != result.ExistingLinkCount {
-		t.Errorf("Expected %v ExistingLinkCount, got: %v",
-			r.numExistingLinks, result.ExistingLinkCount)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.RequireBackupMarker = ".backup-ok"
+	_, err := Run([]string{"d1", "d2"}, opts)
+	if e, ok := err.(*ErrNoBackupMarker); !ok || e.Root != "d2" {
+		t.Errorf("%v: Expected ErrNoBackupMarker for 'd2', got: %v", name, err)
 	}
-	if r.linkPathsBytes != result.InodeRemovedByteAmount {
-		t.Errorf("Expected %v InodeRemovedByteAmount, got: %v",
-			r.linkPathsBytes, result.InodeRemovedByteAmount)
+	if nlinkVal("d1/f1") != 1 || nlinkVal("d2/f2") != 1 {
+		t.Errorf("%v: Expected no linking without the marker", name)
 	}
-	if r.existingLinksBytes != result.ExistingLinkByteAmount {
-		t.Errorf("Expected %v ExistingLinkedByteAmount, got: %v",
-			r.existingLinksBytes, result.ExistingLinkByteAmount)
+
+	// Dry runs don't require the marker
+	opts.LinkingEnabled = false
+	simpleRun(name, t, opts, 1, "d1", "d2")
+
+	if err := ioutil.WriteFile("d2/.backup-ok", nil, 0644); err != nil {
+		t.Fatalf("Couldn't create marker file: %v", err)
 	}
+	opts.LinkingEnabled = true
+	result := simpleRun(name, t, opts, 1, "d1", "d2")
+	verifyInodeCounts(name, t, result, 1, 1, 2, "d1/f1", "d2/f2")
 }
 
-type FilenameCounts map[string]int
+func TestRunSummarizeExistingLinks(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-func checkSameNameRunStats(t *testing.T, r *randTestVals, result *Results) {
-	// Verify same filename is used in all LinkPaths
-	for _, paths := range result.LinkPaths {
-		filenames := map[string]struct{}{}
-		for _, p := range paths {
-			filenames[path.Base(p)] = struct{}{}
-		}
-		if len(filenames) > 1 {
-			t.Errorf("SameName LinkPaths has mismatched filenames: %+v", result.LinkPaths)
+	name := "testname: 'Summarize Existing Links'"
+	simpleFileMaker(t, pathContents{"f1": "X", "g1": "YYY"})
+	simpleLinkMaker(t, "f1", "f2", "f3", "f4", "f5", "f6")
+	simpleLinkMaker(t, "g1", "g2", "g3")
+
+	opts := SetupOptions(LinkingDisabled)
+	full := simpleRun(name, t, opts, 0, ".")
+
+	opts.SummarizeExistingLinks = true
+	summarized := simpleRun(name, t, opts, 0, ".")
+	if len(summarized.ExistingLinks) != 0 || len(summarized.ExistingLinkSizes) != 0 {
+		t.Errorf("%v: Expected no stored existing link paths, got: %v", name, summarized.ExistingLinks)
+	}
+	if len(summarized.ExistingLinkSummaries) != 2 {
+		t.Fatalf("%v: Expected 2 existing link summaries, got: %v", name, summarized.ExistingLinkSummaries)
+	}
+	var links int64
+	var bytes uint64
+	for src, sum := range summarized.ExistingLinkSummaries {
+		if len(full.ExistingLinks[src]) != int(sum.Links) ||
+			full.ExistingLinkSizes[src]*uint64(sum.Links) != sum.Bytes {
+			t.Errorf("%v: Summary of '%v' %+v doesn't match: %v", name, src, sum, full.ExistingLinks[src])
 		}
+		links += sum.Links
+		bytes += sum.Bytes
+	}
+	if links != 7 || links != summarized.ExistingLinkCount || bytes != summarized.ExistingLinkByteAmount {
+		t.Errorf("%v: Expected 7 summarized links and %v bytes, got: %v, %v", name,
+			summarized.ExistingLinkByteAmount, links, bytes)
 	}
+	if summarized.ExistingLinkCount != full.ExistingLinkCount ||
+		summarized.ExistingLinkByteAmount != full.ExistingLinkByteAmount {
+		t.Errorf("%v: Expected equal existing link stats, got: %v vs %v", name,
+			summarized.ExistingLinkCount, full.ExistingLinkCount)
+	}
+}
 
-	// Count the number of new links by counting the number of pathnames
-	// with matching filenames, but *not* counting those used in the first
-	// link cluster where the filename is encountered.
-	for _, clusters := range r.contentClusters {
-		// Sort by max nlink to min to replicate algorithm.  The
-		// results will differ otherwise
-		sort.Slice(clusters, func(i, j int) bool { return len(clusters[i]) > len(clusters[j]) })
+func TestRunCustomEqual(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
 
-		// The number of filenames to be linked to (ie. the number found after
-		// the initial cluster)
-		linkableFC := FilenameCounts{}
+	name := "testname: 'Custom Equal'"
+	m := pathContents{"f1": "ab", "f2": "ac", "f3": "bc", "f4": "xyz"}
+	simpleFileMaker(t, m)
 
-		// Cluster index where a filename was first encountered
-		firstSeen := FilenameCounts{}
+	opts := SetupOptions(LinkingDisabled)
+	simpleRun(name, t, opts, 0, ".")
 
-		// For each cluster in the list of clusters, keep track of which one
-		// holds the first appearance of a filename (of any given pathname),
-		// and don't count the filename (or names) in the first encountered
-		// cluster (which would act as the src inode, not the destination).
-		for i, cluster := range clusters {
-			for pathname := range cluster {
-				filename := path.Base(pathname)
-				whenSeen, ok := firstSeen[filename]
-				if !ok {
-					firstSeen[filename] = i
-				} else if whenSeen < i {
-					linkableFC[filename]++
-				}
-			}
+	// Equal if the first byte matches
+	firstByte := func(a FileMetaContentAccessor) (byte, error) {
+		rc, err := a.Open()
+		if err != nil {
+			return 0, err
 		}
-		for _, count := range linkableFC {
-			r.numNewLinks += int64(count)
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil || len(b) == 0 {
+			return 0, err
 		}
+		return b[0], nil
 	}
-	if r.numNewLinks != result.NewLinkCount {
-		t.Errorf("Expected %v NewLinkCount, got: %v\n", r.numNewLinks, result.NewLinkCount)
+	opts.CustomEqual = func(a, b FileMetaContentAccessor) (bool, error) {
+		if a.Size() != b.Size() {
+			t.Errorf("%v: CustomEqual called with unequal sizes: %v, %v", name, a.Path(), b.Path())
+		}
+		ba, err := firstByte(a)
+		if err != nil {
+			return false, err
+		}
+		bb, err := firstByte(b)
+		return ba == bb, err
+	}
+	result := simpleRun(name, t, opts, 1, ".")
+	if g := sortedPaths(result.LinkPaths[0]); len(g) != 2 || g[0] != "f1" || g[1] != "f2" {
+		t.Errorf("%v: Expected 'f1' and 'f2' to be linkable, got: %v", name, result.LinkPaths)
 	}
 }
 
-// TestRandFiles creates a bunch of files with random content, some with equal
-// contents, and some pre-linked.  It checks that the result of a linking run
-// are as expected.
-func TestRandFiles(t *testing.T) {
-	if testing.Short() {
-		t.Skip("Skipping RandFiles test in short mode")
+func TestRunStreamingLink(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	// Each dir holds one size class of files, with two contents
+	name := "testname: 'Streaming Link'"
+	m := pathContents{}
+	for i := 0; i < 40; i++ {
+		m[fmt.Sprintf("d%v/f%v", i%4, i)] = strings.Repeat(string("AB"[i/4%2]), i%4+1)
 	}
+	m["u1"] = "unique"
+	simpleFileMaker(t, m)
+	simpleLinkMaker(t, "d0/f0", "d0/f0.link")
+
+	opts := SetupOptions(LinkingDisabled)
+	full := simpleRun(name, t, opts, 8, ".")
+
+	opts.StreamingLink = true
+	streamed := simpleRun(name, t, opts, 8, ".")
+	if !reflect.DeepEqual(sortedLinkGroups(full), sortedLinkGroups(streamed)) {
+		t.Errorf("%v: LinkPaths differ: %v vs %v", name, full.LinkPaths, streamed.LinkPaths)
+	}
+	verifySameRunStats(name, t, full, streamed)
+	f, s := full.RunStats, streamed.RunStats
+	// Each size class is linked once its dir is walked, so at most one
+	// class (and the unique file) is held at once.
+	if f.PeakInodeCount != 41 || s.PeakInodeCount > 11 {
+		t.Errorf("%v: Expected peak inode count of at most 11 (vs %v), got: %v",
+			name, f.PeakInodeCount, s.PeakInodeCount)
+	}
+
+	// Many size classes are cheap, regardless of the ExpectedFileCount
+	opts.ExpectedFileCount = 200000
+	simpleRun(name, t, opts, 8, ".")
+
+	opts.LinkingEnabled = true
+	opts.AssertPredictedSavings = true
+	streamed = simpleRun(name, t, opts, 8, ".")
+	verifyInodeCounts(name, t, streamed, f.InodeRemovedCount, f.InodeRemovedByteAmount, 6,
+		"d0/f0", "d0/f8", "d0/f0.link")
+	verifyContents(name, t, m)
+}
 
+func TestRunExistingClusterAnomalies(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, ContentOnly)
-	r := setupRandTestFiles(t, topdir, opts.SameName)
-	results := runAndCheckFileCounts(t, opts, r)
-	checkRunStats(t, r, results)
+	name := "testname: 'Existing Cluster Anomalies'"
+	simpleFileMaker(t, pathContents{"f1": "X", "g1": "YYY"})
+	simpleLinkMaker(t, "f1", "f2", "f3")
+	simpleLinkMaker(t, "g1", "g2")
+	if err := os.Chmod("f1", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod("g1", 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.ReportExistingClusterAnomalies = true
+	opts.ExpectedClusterPerm = 0644
+	r := simpleRun(name, t, opts, 0, ".")
+	if len(r.ExistingClusterAnomalies) != 1 {
+		t.Fatalf("%v: Expected 1 cluster anomaly, got: %+v", name, r.ExistingClusterAnomalies)
+	}
+	a := r.ExistingClusterAnomalies[0]
+	if !strings.HasPrefix(path.Base(a.Path), "f") || a.Perm != 0600 {
+		t.Errorf("%v: Expected anomaly for the 'f' cluster with perm 0600, got: %+v", name, a)
+	}
+
+	// Matching ownership reports no anomalies
+	opts.ExpectedClusterPerm = 0
+	opts.CheckClusterUid = true
+	opts.ExpectedClusterUid = uint32(os.Getuid())
+	r = simpleRun(name, t, opts, 0, ".")
+	if len(r.ExistingClusterAnomalies) != 0 {
+		t.Errorf("%v: Expected no cluster anomalies, got: %+v", name, r.ExistingClusterAnomalies)
+	}
+
+	// The ids are only checked when enabled
+	opts.CheckClusterUid = false
+	opts.ExpectedClusterUid = uint32(os.Getuid()) + 1
+	opts.CheckClusterGid = true
+	opts.ExpectedClusterGid = uint32(os.Getgid()) + 1
+	r = simpleRun(name, t, opts, 0, ".")
+	if len(r.ExistingClusterAnomalies) != 2 {
+		t.Errorf("%v: Expected 2 cluster gid anomalies, got: %+v", name, r.ExistingClusterAnomalies)
+	}
 }
 
-func TestRandSameNameFiles(t *testing.T) {
-	if testing.Short() {
-		t.Skip("Skipping RandFiles test in short mode")
+func TestRunReportFragmentationRisk(t *testing.T) {
+	topdir := setUp("Run", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Report Fragmentation Risk'"
+	const size = 1024 * 1024
+	simpleFileMaker(t, pathContents{"full": strings.Repeat("\x00", size)})
+
+	// An equal content file with (almost) no allocated blocks
+	f, err := os.Create("sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
 	}
+	f.Close()
+	fi, err := os.Stat("full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes("sparse", fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat("sparse"); err != nil || fi.Sys().(*syscall.Stat_t).Blocks*512 >= size/2 {
+		t.Skipf("%v: Filesystem doesn't support sparse files", name)
+	}
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.ReportFragmentationRisk = true
+	r := simpleRun(name, t, opts, 1, ".")
+	if len(r.FragmentationRiskPaths) != 1 || r.FragmentationRiskPaths[0] != "sparse" {
+		t.Errorf("%v: Expected only 'sparse' to be flagged, got: %v", name, r.FragmentationRiskPaths)
+	}
+}
 
+func TestRunOnlyBlockAligned(t *testing.T) {
 	topdir := setUp("Run", t)
 	defer os.RemoveAll(topdir)
 
-	opts := SetupOptions(LinkingEnabled, ContentOnly, SameName)
-	r := setupRandTestFiles(t, topdir, opts.SameName)
-	results := runAndCheckFileCounts(t, opts, r)
-	checkSameNameRunStats(t, r, results)
+	name := "testname: 'Only Block Aligned'"
+	m := pathContents{
+		"a1": makeString("A", 1024), "a2": makeString("A", 1024),
+		"b1": makeString("B", 1000), "b2": makeString("B", 1000),
+	}
+	simpleFileMaker(t, m)
+
+	opts := SetupOptions(LinkingEnabled)
+	opts.OnlyBlockAligned = true
+	opts.BlockSize = 512
+	r := simpleRun(name, t, opts, 1, ".")
+	verifyLinkPaths(name, t, r, paths{"a1", "a2"})
+	verifyInodeCounts(name, t, r, 1, 1024, 2, "a1", "a2")
+	verifyInodeCounts(name, t, r, 1, 1024, 1, "b1", "b2")
+	if r.NonBlockAlignedSkipCount != 2 {
+		t.Errorf("%v: Expected 2 non block aligned files, got: %v", name, r.NonBlockAlignedSkipCount)
+	}
+	verifyContents(name, t, m)
+
+	// The filesystem block size is used by default
+	bs, err := I.BlockSize(".")
+	if err != nil || bs == 0 {
+		t.Skipf("%v: Couldn't determine the filesystem block size: %v", name, err)
+	}
+	var want int64
+	for _, size := range []uint64{1024, 1024, 1000, 1000} {
+		if size%bs != 0 {
+			want++
+		}
+	}
+	opts.BlockSize = 0
+	r = simpleRun(name, t, opts, 0, ".")
+	if r.NonBlockAlignedSkipCount != want {
+		t.Errorf("%v: Expected %v non block aligned files, got: %v", name, want, r.NonBlockAlignedSkipCount)
+	}
 }
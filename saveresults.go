@@ -0,0 +1,141 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// Save writes the complete Results (including the Options used for the Run)
+// to w, so that they can be reloaded with LoadResults for later analysis, or
+// for applying a dry-run's links with separate tooling.  The Options callback,
+// writer and Rand fields are not saved.
+func (r Results) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(r)
+}
+
+// LoadResults reads Results that were written by Results.Save.
+func LoadResults(rd io.Reader) (Results, error) {
+	var r Results
+	if err := gob.NewDecoder(rd).Decode(&r); err != nil {
+		return Results{}, err
+	}
+
+	// gob omits empty maps, so restore those that newResults() creates
+	if r.ExistingLinks == nil {
+		r.ExistingLinks = make(map[string][]string)
+	}
+	if r.ExistingLinkSizes == nil {
+		r.ExistingLinkSizes = make(map[string]uint64)
+	}
+	return r, nil
+}
+
+// WriteExistingLinksJSON writes just the existing links (and their sizes) to w
+// as a JSON object.
+func (r *Results) WriteExistingLinksJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		ExistingLinks         map[string][]string            `json:"existingLinks"`
+		ExistingLinkSizes     map[string]uint64              `json:"existingLinkSizes"`
+		ExistingLinkSummaries map[string]ExistingLinkSummary `json:"existingLinkSummaries,omitempty"`
+	}{r.ExistingLinks, r.ExistingLinkSizes, r.ExistingLinkSummaries})
+}
+
+// WriteNewLinksJSON writes just the new (or linkable) link groups to w as a
+// JSON object.
+func (r *Results) WriteNewLinksJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		LinkingEnabled bool       `json:"linkingEnabled"`
+		LinkPaths      [][]string `json:"linkPaths"`
+	}{r.Opts.LinkingEnabled, r.sortedLinkPaths(r.LinkPaths)})
+}
+
+// WriteLinkOutputFiles writes the existing and new links to the files given by
+// the ExistingLinksOutputPath and NewLinksOutputPath Options (if set), so that
+// each can be consumed independently, and the per-device results to the
+// DeviceJSONOutputDir (if set).
+func (r *Results) WriteLinkOutputFiles() error {
+	if r.Opts.ExistingLinksOutputPath != "" {
+		if err := writeFileWith(r.Opts.ExistingLinksOutputPath, r.WriteExistingLinksJSON); err != nil {
+			return err
+		}
+	}
+	if r.Opts.NewLinksOutputPath != "" {
+		if err := writeFileWith(r.Opts.NewLinksOutputPath, r.WriteNewLinksJSON); err != nil {
+			return err
+		}
+	}
+	if r.Opts.DeviceJSONOutputDir != "" {
+		if err := r.writeDeviceJSONFiles(r.Opts.DeviceJSONOutputDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDeviceJSONFiles writes the DeviceResults of each device to a JSON file
+// in the given dir (which is created if needed), named by the device number.
+func (r *Results) writeDeviceJSONFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for dev, d := range r.DeviceResults {
+		d := d
+		pathname := path.Join(dir, strconv.FormatUint(dev, 10)+".json")
+		err := writeFileWith(pathname, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(d)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileWith creates (or truncates) the named file, and writes to it with
+// the given func.
+func writeFileWith(pathname string, write func(io.Writer) error) error {
+	f, err := os.Create(pathname)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// GobEncode encodes the Options using their JSON encoding, since gob cannot
+// encode the Rand or AuditLog fields (which aren't saved).
+func (o Options) GobEncode() ([]byte, error) {
+	return json.Marshal(o)
+}
+
+// GobDecode decodes Options encoded by GobEncode.
+func (o *Options) GobDecode(b []byte) error {
+	return json.Unmarshal(b, o)
+}
@@ -0,0 +1,133 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+)
+
+// predictedSavingsTolerance is the fraction of the predicted removed inode
+// bytes by which the bytes actually freed may differ (with the
+// AssertPredictedSavings option).
+const predictedSavingsTolerance = 0.001
+
+// ErrPredictedSavings is returned by Run (with AssertPredictedSavings) when
+// the bytes actually freed by linking diverge from the predicted
+// InodeRemovedByteAmount.
+type ErrPredictedSavings struct {
+	Predicted uint64 // The predicted InodeRemovedByteAmount
+	Actual    uint64 // The bytes of the inodes actually freed
+}
+
+func (e *ErrPredictedSavings) Error() string {
+	return fmt.Sprintf("predicted linking to free %v, but it freed %v",
+		Humanize(e.Predicted), Humanize(e.Actual))
+}
+
+// linkedAwayInode is a dst inode of the new links, with the pathnames that
+// were linked to another inode, and the size of the inode if it was
+// predicted to be removed.
+type linkedAwayInode struct {
+	paths   []string
+	removed bool
+	size    uint64
+}
+
+// linkedAway returns the linkedAwayInode of the given inode, adding it if new.
+func (r *Results) linkedAway(di devIno) *linkedAwayInode {
+	if r.linkedAwayInodes == nil {
+		r.linkedAwayInodes = make(map[devIno]*linkedAwayInode)
+	}
+	l, ok := r.linkedAwayInodes[di]
+	if !ok {
+		l = &linkedAwayInode{}
+		r.linkedAwayInodes[di] = l
+	}
+	return l
+}
+
+// expectNlinkChange adjusts the expected nlink of the given inode after
+// linking, starting from the given (pre-link) nlink if the inode is new.
+func (r *Results) expectNlinkChange(di devIno, nlink uint64, added bool) {
+	if r.expectedNlinks == nil {
+		r.expectedNlinks = make(map[devIno]uint64)
+	}
+	n, ok := r.expectedNlinks[di]
+	if !ok {
+		n = nlink
+	}
+	if added {
+		n++
+	} else if n > 0 {
+		n--
+	}
+	r.expectedNlinks[di] = n
+}
+
+// actualRemovedBytes returns the summed size of the inodes predicted to be
+// removed, which were actually freed by linking.  An inode is freed if its
+// pathnames were all linked away (ie. no other name holds it), and each of
+// those pathnames now refers to an inode whose observed nlink rose to the
+// expected count.  The pathnames are checked with the given lstat function,
+// and those that can't be checked are assumed to still refer to the inode.
+func (r *Results) actualRemovedBytes(lstat func(string) (I.DevStatInfo, error)) uint64 {
+	var freed uint64
+	for di, l := range r.linkedAwayInodes {
+		if !l.removed || r.expectedNlinks[di] != 0 {
+			continue
+		}
+		stillLinked := false
+		for _, pathname := range l.paths {
+			dsi, err := lstat(pathname)
+			if err != nil || (dsi.Dev == di.dev && uint64(dsi.Ino) == di.ino) {
+				stillLinked = true
+				break
+			}
+			n, ok := r.expectedNlinks[devIno{dsi.Dev, uint64(dsi.Ino)}]
+			if !ok || dsi.Nlink != n {
+				stillLinked = true
+				break
+			}
+		}
+		if !stillLinked {
+			freed += l.size
+		}
+	}
+	return freed
+}
+
+// checkPredictedSavings returns an *ErrPredictedSavings error if the bytes
+// actually freed by linking differ from the InodeRemovedByteAmount by more
+// than the predictedSavingsTolerance.
+func (r *Results) checkPredictedSavings(lstat func(string) (I.DevStatInfo, error)) error {
+	predicted := r.InodeRemovedByteAmount
+	actual := r.actualRemovedBytes(lstat)
+	diff := predicted - actual
+	if actual > predicted {
+		diff = actual - predicted
+	}
+	if float64(diff) > predictedSavingsTolerance*float64(predicted) {
+		return &ErrPredictedSavings{Predicted: predicted, Actual: actual}
+	}
+	return nil
+}
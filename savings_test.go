@@ -0,0 +1,111 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"testing"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
+)
+
+func TestCheckPredictedSavings(t *testing.T) {
+	const dev = 1
+	pathInfo := func(dirname, filename string, ino I.Ino, nlink, size uint64) I.PathInfo {
+		return I.PathInfo{
+			Pathsplit: P.Pathsplit{Dirname: dirname, Filename: filename},
+			StatInfo:  I.StatInfo{Ino: ino, Nlink: nlink, Size: size},
+		}
+	}
+	src := pathInfo("d", "src", 1, 1, 100)
+	dst1 := pathInfo("d", "dst1", 2, 1, 100)
+	dst2a := pathInfo("d", "dst2a", 3, 2, 100)
+	dst2b := pathInfo("d", "dst2b", 3, 1, 100) // After dst2a was linked away
+
+	r := newResults(&Options{LinkingEnabled: true, AssertPredictedSavings: true})
+	r.foundNewLink(src, dst1, dev)
+	r.foundRemovedInode(dst1.Size, dst1.Dirname, dev, uint64(dst1.Ino))
+	src.Nlink++
+	r.foundNewLink(src, dst2a, dev)
+	src.Nlink++
+	r.foundNewLink(src, dst2b, dev)
+	r.foundRemovedInode(dst2b.Size, dst2b.Dirname, dev, uint64(dst2b.Ino))
+
+	// A fake filesystem, with all the linked pathnames referring to src
+	linkedSrc := I.DevStatInfo{Dev: dev, StatInfo: I.StatInfo{Ino: src.Ino, Nlink: 4, Size: 100}}
+	fakeFS := map[string]I.DevStatInfo{}
+	for _, pi := range []I.PathInfo{src, dst1, dst2a, dst2b} {
+		fakeFS[pi.Join()] = linkedSrc
+	}
+	lstat := func(pathname string) (I.DevStatInfo, error) {
+		if dsi, ok := fakeFS[pathname]; ok {
+			return dsi, nil
+		}
+		return I.DevStatInfo{}, os.ErrNotExist
+	}
+	if err := r.checkPredictedSavings(lstat); err != nil {
+		t.Errorf("Expected predicted savings to match, got error: %v", err)
+	}
+
+	// Inject a discrepancy, with a linked pathname still on its old inode
+	fakeFS[dst2a.Join()] = I.DevStatInfo{Dev: dev, StatInfo: dst2a.StatInfo}
+	err := r.checkPredictedSavings(lstat)
+	if e, ok := err.(*ErrPredictedSavings); !ok {
+		t.Errorf("Expected ErrPredictedSavings error, got: %v", err)
+	} else if e.Predicted != 200 || e.Actual != 100 {
+		t.Errorf("Expected 200 predicted and 100 actual bytes, got: %+v", e)
+	}
+
+	// The src inode not gaining the expected links means they didn't all
+	// move from the removed inodes
+	fakeFS[dst2a.Join()] = linkedSrc
+	short := linkedSrc
+	short.Nlink = 3
+	for _, pi := range []I.PathInfo{src, dst1, dst2a, dst2b} {
+		fakeFS[pi.Join()] = short
+	}
+	if err := r.checkPredictedSavings(lstat); err == nil {
+		t.Errorf("Expected error for a src inode lacking the linked pathnames")
+	}
+
+	// A pathname that can no longer be checked isn't assumed freed
+	for _, pi := range []I.PathInfo{src, dst1, dst2a, dst2b} {
+		fakeFS[pi.Join()] = linkedSrc
+	}
+	delete(fakeFS, dst1.Join())
+	if err := r.checkPredictedSavings(lstat); err == nil {
+		t.Errorf("Expected error for a missing linked pathname")
+	}
+
+	// An inode still held by a name that wasn't linked away isn't freed,
+	// even if it was (wrongly) predicted to be removed
+	r = newResults(&Options{LinkingEnabled: true, AssertPredictedSavings: true})
+	src.Nlink = 1
+	held := pathInfo("d", "held", 4, 2, 100)
+	r.foundNewLink(src, held, dev)
+	r.foundRemovedInode(held.Size, held.Dirname, dev, uint64(held.Ino))
+	linkedSrc.Nlink = 2
+	fakeFS = map[string]I.DevStatInfo{src.Join(): linkedSrc, held.Join(): linkedSrc}
+	if err := r.checkPredictedSavings(lstat); err == nil {
+		t.Errorf("Expected error for an inode still held by another name")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bufio"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// The files are split into chunks at boundaries chosen by a rolling hash of
+// the preceding window of bytes, so that the chunks of shifted content
+// remain the same.  Files sharing enough chunks are reported as similar.
+const (
+	similarWindowSize   = 32
+	similarChunkMask    = 1<<10 - 1 // Approx 1 KiB average chunk size
+	similarMinChunkSize = 64
+	similarRollPrime    = 1099511628211
+	similarMinShared    = 0.5 // Fraction of the smaller file's chunks
+)
+
+// similarIndex holds the chunk hashes of files, and the files containing each
+// chunk hash.
+type similarIndex struct {
+	paths  []string
+	sizes  []uint64
+	chunks []map[uint64]struct{}
+	owners map[uint64][]int
+}
+
+func newSimilarIndex() *similarIndex {
+	return &similarIndex{owners: make(map[uint64][]int)}
+}
+
+// add reads the file and stores its chunk hashes in the index
+func (s *similarIndex) add(pathname string, size uint64) error {
+	hashes, err := chunkHashes(pathname)
+	if err != nil {
+		return err
+	}
+	i := len(s.paths)
+	s.paths = append(s.paths, pathname)
+	s.sizes = append(s.sizes, size)
+	s.chunks = append(s.chunks, hashes)
+	for h := range hashes {
+		s.owners[h] = append(s.owners[h], i)
+	}
+	return nil
+}
+
+// groups returns the sorted groups of pathnames which share a substantial
+// fraction of their chunks.  Files with identical chunks (ie. equal contents)
+// are not considered similar to each other.
+func (s *similarIndex) groups() [][]string {
+	parent := make([]int, len(s.paths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i, ci := range s.chunks {
+		shared := make(map[int]int)
+		for h := range ci {
+			for _, j := range s.owners[h] {
+				if j > i {
+					shared[j]++
+				}
+			}
+		}
+		for j, n := range shared {
+			cj := s.chunks[j]
+			minLen := len(ci)
+			if len(cj) < minLen {
+				minLen = len(cj)
+			}
+			if float64(n) < similarMinShared*float64(minLen) {
+				continue
+			}
+			if n == len(ci) && n == len(cj) && s.sizes[i] == s.sizes[j] {
+				continue
+			}
+			parent[root(j)] = root(i)
+		}
+	}
+
+	members := make(map[int][]string)
+	for i, p := range s.paths {
+		r := root(i)
+		members[r] = append(members[r], p)
+	}
+	var groups [][]string
+	for _, g := range members {
+		if len(g) > 1 {
+			sort.Strings(g)
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// chunkHashes returns the set of hashes of the content defined chunks of the
+// given file.
+func chunkHashes(pathname string) (map[uint64]struct{}, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// pow is the multiplier of the byte leaving the rolling window
+	pow := uint64(1)
+	for i := 0; i < similarWindowSize; i++ {
+		pow *= similarRollPrime
+	}
+
+	hashes := make(map[uint64]struct{})
+	addChunk := func(chunk []byte) {
+		h := fnv.New64a()
+		h.Write(chunk)
+		hashes[h.Sum64()] = struct{}{}
+	}
+
+	var window [similarWindowSize]byte
+	var rolling uint64
+	chunk := make([]byte, 0, 4*(similarChunkMask+1))
+	br := bufio.NewReader(f)
+	for pos := 0; ; pos++ {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		out := window[pos%similarWindowSize]
+		window[pos%similarWindowSize] = b
+		rolling = rolling*similarRollPrime + uint64(b) - uint64(out)*pow
+
+		chunk = append(chunk, b)
+		if len(chunk) >= similarMinChunkSize && (rolling>>40)&similarChunkMask == similarChunkMask {
+			addChunk(chunk)
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		addChunk(chunk)
+	}
+	return hashes, nil
+}
+
+// addSimilarGroups stores in the Results the groups of files (one pathname
+// per inode) which share substantial content, without being identical.
+func (ls *linkableState) addSimilarGroups() {
+	s := newSimilarIndex()
+	for _, fsdev := range ls.fsDevs {
+		for ino := range fsdev.InoPaths {
+			pathname := fsdev.InoPaths.ArbitraryPath(ino).Join()
+			if err := s.add(pathname, fsdev.inoStatInfo[ino].Size); err != nil {
+				if ls.Options.DebugLevel > 0 {
+					log.Printf("\r%v  Skipping...", err)
+				}
+			}
+		}
+	}
+	for _, g := range s.groups() {
+		ls.Results.foundSimilarGroup(g)
+	}
+}
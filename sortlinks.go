@@ -22,7 +22,9 @@ package hardlinkable
 
 import (
 	"log"
+	"path"
 	"sort"
+	"strings"
 
 	I "github.com/chadnetzer/hardlinkable/internal/inode"
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
@@ -60,6 +62,124 @@ func (f *fsDev) sortSetByNlink(inoSet I.Set) []I.Ino {
 	return sortedSeq
 }
 
+// sortBySize reorders the inodes (keeping the nlink order otherwise), so that
+// the smallest (or largest) are first, and will be used as link sources.  Used
+// so that the form of the content being kept by the IgnoreTrailingNewline (or
+// IgnoreBOM) option is linked to.
+func (f *fsDev) sortBySize(sortedInos []I.Ino, largest bool) {
+	sort.SliceStable(sortedInos, func(i, j int) bool {
+		si := f.inoStatInfo[sortedInos[i]].Size
+		sj := f.inoStatInfo[sortedInos[j]].Size
+		if largest {
+			return si > sj
+		}
+		return si < sj
+	})
+}
+
+// sortByMtime reorders the inodes (keeping the nlink order otherwise), so that
+// the newest (or oldest) modified inodes are first, and will be used as link
+// sources.
+func (f *fsDev) sortByMtime(sortedInos []I.Ino, newest bool) {
+	sort.SliceStable(sortedInos, func(i, j int) bool {
+		ti := f.inoStatInfo[sortedInos[i]].Mtim
+		tj := f.inoStatInfo[sortedInos[j]].Mtim
+		if newest {
+			return ti.After(tj)
+		}
+		return ti.Before(tj)
+	})
+}
+
+// countSrcSelection records in the Results whether the first of the sorted
+// inodes (the initial link source) is the newest, oldest, and/or most linked
+// inode of the group.
+func (f *fsDev) countSrcSelection(sortedInos []I.Ino) {
+	src := f.inoStatInfo[sortedInos[0]]
+	newest, oldest, mostLinked := true, true, true
+	for _, ino := range sortedInos[1:] {
+		si := f.inoStatInfo[ino]
+		if si.Mtim.After(src.Mtim) {
+			newest = false
+		}
+		if si.Mtim.Before(src.Mtim) {
+			oldest = false
+		}
+		if si.Nlink > src.Nlink {
+			mostLinked = false
+		}
+	}
+	if newest {
+		f.Results.selectedSrc(SrcSelectedNewest)
+	}
+	if oldest {
+		f.Results.selectedSrc(SrcSelectedOldest)
+	}
+	if mostLinked {
+		f.Results.selectedSrc(SrcSelectedMostLinked)
+	}
+}
+
+// sortBySnapshotRoots reorders the inodes (keeping the nlink order otherwise),
+// so that those with a pathname outside of the SnapshotRoots are first, and
+// will be used as link sources.
+func (f *fsDev) sortBySnapshotRoots(sortedInos []I.Ino) {
+	hasLivePath := make(map[I.Ino]bool, len(sortedInos))
+	for _, ino := range sortedInos {
+		_, hasLivePath[ino] = f.livePath(ino)
+	}
+	sort.SliceStable(sortedInos, func(i, j int) bool {
+		return hasLivePath[sortedInos[i]] && !hasLivePath[sortedInos[j]]
+	})
+}
+
+// livePath returns a pathname of the inode which isn't within one of the
+// SnapshotRoots, and false if there is no such pathname.
+func (f *fsDev) livePath(ino I.Ino) (P.Pathsplit, bool) {
+	for _, p := range f.InoPaths[ino].PathsAsSlice() {
+		if !isInRoots(p.Join(), f.Options.SnapshotRoots) {
+			return p, true
+		}
+	}
+	return P.Pathsplit{}, false
+}
+
+// sortBySrcRoots reorders the inodes (keeping the previous order otherwise),
+// so that those with a pathname within the SrcRoots are first, and will be
+// used as link sources.
+func (f *fsDev) sortBySrcRoots(sortedInos []I.Ino) {
+	hasSrcPath := make(map[I.Ino]bool, len(sortedInos))
+	for _, ino := range sortedInos {
+		_, hasSrcPath[ino] = f.srcRootPath(ino)
+	}
+	sort.SliceStable(sortedInos, func(i, j int) bool {
+		return hasSrcPath[sortedInos[i]] && !hasSrcPath[sortedInos[j]]
+	})
+}
+
+// srcRootPath returns a pathname of the inode which is within one of the
+// SrcRoots, and false if there is no such pathname.
+func (f *fsDev) srcRootPath(ino I.Ino) (P.Pathsplit, bool) {
+	for _, p := range f.InoPaths[ino].PathsAsSlice() {
+		if isInRoots(p.Join(), f.Options.SrcRoots) {
+			return p, true
+		}
+	}
+	return P.Pathsplit{}, false
+}
+
+// isInRoots returns true if the pathname is within one of the roots
+func isInRoots(pathname string, roots []string) bool {
+	pathname = path.Clean(pathname)
+	for _, root := range roots {
+		root = path.Clean(root)
+		if pathname == root || strings.HasPrefix(pathname, root+"/") || root == "." {
+			return true
+		}
+	}
+	return false
+}
+
 // Reverse fromS and append to toS
 func appendReversedInos(toS []I.Ino, fromS ...I.Ino) []I.Ino {
 	for i, j := 0, len(fromS)-1; i < j; i, j = i+1, j-1 {
@@ -79,16 +199,135 @@ func appendReversedInos(toS []I.Ino, fromS ...I.Ino) []I.Ino {
 // (until the maximum nlink count is reached, at which point it proceeds to the
 // src inode with the next highest nlink count).
 func (f *fsDev) generateLinks() error {
-	for linkableSet := range f.LinkableInos.All() {
+	// Stop the set iteration when returning early
+	done := make(chan struct{})
+	defer close(done)
+	for linkableSet := range f.LinkableInos.AllUntil(done) {
+		if f.Results.HitInodeTarget {
+			break
+		}
+		if f.Options.MinDuplicateCount > 2 && f.countPaths(linkableSet) < f.Options.MinDuplicateCount {
+			f.Results.belowMinDuplicateCount()
+			for ino := range linkableSet {
+				pathname := f.InoPaths.ArbitraryPath(ino).Join()
+				f.Results.skippedInode(f.Dev, uint64(ino), pathname, UnlinkedBelowMinDups)
+			}
+			continue
+		}
 		// Sort links highest nlink to lowest
 		sortedInos := f.sortSetByNlink(linkableSet)
+		if f.Options.SrcPathPreference != MostLinked {
+			f.sortByMtime(sortedInos, f.Options.SrcPathPreference == NewestMtime)
+		}
+		if len(f.Options.SnapshotRoots) > 0 {
+			f.sortBySnapshotRoots(sortedInos)
+		}
+		if len(f.Options.SrcRoots) > 0 {
+			f.sortBySrcRoots(sortedInos)
+		}
+		if f.Options.IgnoreTrailingNewline {
+			f.sortBySize(sortedInos, f.Options.KeepTrailingNewline)
+		}
+		if f.Options.IgnoreBOM {
+			f.sortBySize(sortedInos, false)
+		}
+		if len(sortedInos) > 0 {
+			f.countSrcSelection(sortedInos)
+		}
 		if err := f.genLinksHelper(sortedInos); err != nil {
-			return err
+			if !f.Options.ContinueOnGroupError {
+				return err
+			}
+			if f.Options.DebugLevel > 0 {
+				log.Printf("\r%v  Skipping rest of group...", err)
+			}
+			f.Results.failedGroup(f.groupPaths(linkableSet))
+			continue
+		}
+		if f.Options.CanonicalStoreDir != "" && f.Options.LinkingEnabled {
+			if err := f.storeCanonical(linkableSet); err != nil {
+				if !f.Options.IgnoreLinkErrors {
+					return err
+				} else if f.Options.DebugLevel > 0 {
+					log.Printf("\r%v  Skipping...", err)
+				}
+				f.Results.skippedCanonical()
+			}
 		}
 	}
 	return nil
 }
 
+// groupPaths returns the sorted pathnames of the given inodes (ie. including
+// any that were moved to other inodes of the group by linking)
+func (f *fsDev) groupPaths(inoSet I.Set) []string {
+	var pathnames []string
+	for ino := range inoSet {
+		if fp, ok := f.InoPaths[ino]; ok {
+			for _, p := range fp.PathsAsSlice() {
+				pathnames = append(pathnames, p.Join())
+			}
+		}
+	}
+	sort.Strings(pathnames)
+	return pathnames
+}
+
+// countPaths returns the number of pathnames of the given inodes
+func (f *fsDev) countPaths(inoSet I.Set) int {
+	n := 0
+	for ino := range inoSet {
+		n += f.InoPaths[ino].CountPaths()
+	}
+	return n
+}
+
+// reachedInodeTarget returns true (and records it in the Results) once the
+// optional TargetInodeReduction has been reached.
+func (f *fsDev) reachedInodeTarget() bool {
+	t := f.Options.TargetInodeReduction
+	if t > 0 && f.Results.InodeRemovedCount >= t {
+		f.Results.HitInodeTarget = true
+	}
+	return f.Results.HitInodeTarget
+}
+
+// keepsNewlineForm returns true if linking dst to src keeps the form of the
+// content (with or without a trailing newline) chosen by KeepTrailingNewline.
+func (f *fsDev) keepsNewlineForm(src, dst *I.StatInfo) bool {
+	if src.Size == dst.Size {
+		return true
+	}
+	if f.Options.KeepTrailingNewline {
+		return src.Size == dst.Size+1
+	}
+	return dst.Size == src.Size+1
+}
+
+// addLinkGroupDigest stores the content digest of the link group with the
+// given src, if it hasn't already been computed.  Errors just leave the
+// digest empty.
+func (f *fsDev) addLinkGroupDigest(src I.PathInfo) {
+	di := devIno{f.Dev, uint64(src.Ino)}
+	if !f.Results.needsLinkGroupDigest(di) {
+		return
+	}
+	digest, err := f.contentSHA256(src)
+	if err != nil {
+		if f.Options.DebugLevel > 0 {
+			log.Printf("\rCouldn't compute digest of '%v': %v", src.Join(), err)
+		}
+		return
+	}
+	f.Results.setLinkGroupDigest(di, digest)
+}
+
+// keepsBOMForm returns true if linking dst to src keeps the form of the content
+// without a byte-order mark (with the IgnoreBOM option).
+func keepsBOMForm(src, dst *I.StatInfo) bool {
+	return src.Size == dst.Size || isBOMSizeDiff(src.Size, dst.Size)
+}
+
 // genLinksHelper operates on the set of matching inodes, sorted from highest
 // nlink count to lowest.  It selects the set of src and dst pathnames that
 // will (ideally) link all the inodes together.  It respects the maximum nlink
@@ -99,6 +338,10 @@ func (f *fsDev) generateLinks() error {
 func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 	remainingInos := make([]I.Ino, 0)
 
+	// The dst inodes with pathnames left unlinked by the "same name"
+	// restriction, which may remain as separate inodes of the group.
+	sameNameInos := make(map[I.Ino]bool)
+
 	// The remainingInos are the inodes at the far end of the sorted inode
 	// list, which were skipped over on a previous linking pass because
 	// of a restriction such as the optional "same name" linking
@@ -115,6 +358,12 @@ func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 		// the remaining inodes looking for a potential link.
 		srcIno := sortedInos[0]
 		sortedInos = sortedInos[1:]
+		// Inodes outside of the SrcRoots are never used as sources
+		if len(f.Options.SrcRoots) > 0 {
+			if _, ok := f.srcRootPath(srcIno); !ok {
+				continue
+			}
+		}
 		for len(sortedInos) > 0 {
 			dstIno := sortedInos[len(sortedInos)-1]
 			sortedInos = sortedInos[:len(sortedInos)-1]
@@ -125,18 +374,38 @@ func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 			// these two inodes are fully linked
 			sum := uint64(srcSI.Nlink) + uint64(dstSI.Nlink)
 			if sum > f.MaxNLinks {
+				pathname := f.InoPaths.ArbitraryPath(dstIno).Join()
+				f.Results.skippedInode(f.Dev, uint64(dstIno), pathname, UnlinkedMaxNlink)
 				remainingInos = append(remainingInos, dstIno)
 				remainingInos = appendReversedInos(remainingInos, sortedInos...)
 				sortedInos = make([]I.Ino, 0)
 				break
 			}
 
+			// With IgnoreTrailingNewline, only link the other form of
+			// the content to the form being kept.
+			if f.Options.IgnoreTrailingNewline && !f.keepsNewlineForm(srcSI, dstSI) {
+				remainingInos = append(remainingInos, dstIno)
+				continue
+			}
+			// Likewise with IgnoreBOM, only link the files with a
+			// byte-order mark to those without.
+			if f.Options.IgnoreBOM && !keepsBOMForm(srcSI, dstSI) {
+				remainingInos = append(remainingInos, dstIno)
+				continue
+			}
+
 			// For a given dst inode, iterate over all the paths
 			// linking to it, using the pathnames for linking,
 			// while respecting both the SameName option and the
 			// maximum src inode nlink count.
 			dstPaths := f.InoPaths.AllPaths(dstIno)
+			var sameNameSkipped bool
 			for dstPath := range dstPaths {
+				// Leave the pathnames outside of the DstRoots as is
+				if len(f.Options.DstRoots) > 0 && !isInRoots(dstPath.Join(), f.Options.DstRoots) {
+					continue
+				}
 				var srcPath P.Pathsplit
 				if f.Options.SameName {
 					// Skip to next destination inode path if dst filename
@@ -144,15 +413,37 @@ func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 					srcPaths := f.InoPaths[srcIno]
 					dstFilename := dstPath.Filename
 					if !srcPaths.HasFilename(dstFilename) {
+						sameNameSkipped = true
 						continue
 					}
 					srcPath = f.InoPaths.ArbitraryFilenamePath(srcIno, dstFilename)
 				} else {
 					srcPath = f.InoPaths.ArbitraryPath(srcIno)
+					if len(f.Options.SnapshotRoots) > 0 {
+						if p, ok := f.livePath(srcIno); ok {
+							srcPath = p
+						}
+					}
+					if len(f.Options.SrcRoots) > 0 {
+						srcPath, _ = f.srcRootPath(srcIno)
+					}
+				}
+				if len(f.Options.SrcRoots) > 0 && !isInRoots(srcPath.Join(), f.Options.SrcRoots) {
+					continue
 				}
 				srcPathInfo := I.PathInfo{Pathsplit: srcPath, StatInfo: *srcSI}
 				dstPathInfo := I.PathInfo{Pathsplit: dstPath, StatInfo: *dstSI}
 
+				if f.Options.ReportFragmentationRisk {
+					f.Results.checkFragmentationRisk(f.Dev, srcPathInfo)
+					f.Results.checkFragmentationRisk(f.Dev, dstPathInfo)
+				}
+
+				// Skip linking files held open, or in unwritable dirs
+				if f.skipsUnlinkablePair(srcPathInfo, dstPathInfo) {
+					continue
+				}
+
 				// Abort if the filesystem is found to be "active" (ie. changing)
 				if f.Options.CheckQuiescence || f.Options.LinkingEnabled {
 					modifiedErr := f.haveNotBeenModified(srcPathInfo, dstPathInfo)
@@ -161,11 +452,22 @@ func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 					}
 				}
 
+				// Skip the pair if their contents no longer match
+				if skip, cmpErr := f.skipsChangedContents(srcPathInfo, dstPathInfo); cmpErr != nil {
+					return cmpErr
+				} else if skip {
+					continue
+				}
+
 				// Perform the actual linking if requested, but abort all remaining
 				// linking if a linking error is encountered.
 				var linkingErr error
 				if f.Options.LinkingEnabled {
+					f.pacer.wait()
 					linkingErr = f.hardlinkFiles(srcPathInfo, dstPathInfo)
+					if err := f.auditLink(srcPathInfo, dstPathInfo, linkingErr); err != nil {
+						return err
+					}
 					if linkingErr != nil {
 						if !f.Options.IgnoreLinkErrors {
 							return linkingErr
@@ -177,27 +479,53 @@ func (f *fsDev) genLinksHelper(sortedInos []I.Ino) error {
 
 				if linkingErr != nil {
 					f.Results.skippedNewLink(srcPath, dstPath)
+					f.Results.skippedInode(f.Dev, uint64(dstIno), dstPath.Join(), UnlinkedLinkError)
 				} else {
-					f.Results.foundNewLink(srcPath, dstPath)
+					f.Results.foundNewLink(srcPathInfo, dstPathInfo, f.Dev)
+					if f.Options.IncludeDigestInOutput {
+						f.addLinkGroupDigest(srcPathInfo)
+					}
+					if !f.Options.LinkingEnabled {
+						f.Results.plannedLink(srcPathInfo, dstPathInfo, f.Dev)
+					}
 
 					// Update cached StatInfo information for inodes
 					srcSI.Nlink++
 					dstSI.Nlink--
+					f.Results.foundNlink(srcSI.Nlink, f.MaxNLinks)
+					if m := f.Options.NlinkWarnMargin; m > 0 && srcSI.Nlink+m >= f.MaxNLinks {
+						f.Results.foundNearNlinkLimit(f.Dev, uint64(srcIno), srcPath.Join(),
+							srcSI.Nlink, f.MaxNLinks)
+					}
 					if dstSI.Nlink == 0 {
-						f.Results.foundRemovedInode(dstSI.Size)
+						f.Results.foundRemovedInode(dstSI.Size, dstPath.Dirname, f.Dev, uint64(dstIno))
 						delete(f.inoStatInfo, dstIno)
 					}
 					f.InoPaths.MovePath(dstPath, srcIno, dstIno)
 				}
 			}
+			if f.reachedInodeTarget() {
+				return nil
+			}
 			// With SameName option, it's possible that the dstIno nLinks will not go
 			// to zero (if not all links have a matching filename), so place on the
 			// remainingInos list to allow it to (possibly) be linked with other inodes
 			fp, ok := f.InoPaths[dstIno]
 			if ok && !fp.IsEmpty() {
+				if sameNameSkipped {
+					sameNameInos[dstIno] = true
+				}
 				remainingInos = append(remainingInos, dstIno)
 			}
 		}
 	}
+
+	// Count the inodes that the "same name" restriction kept from being
+	// fully linked into another inode of the group.
+	for ino := range sameNameInos {
+		if _, ok := f.inoStatInfo[ino]; ok {
+			f.Results.splitBySameName()
+		}
+	}
 	return nil
 }
@@ -21,6 +21,10 @@
 package hardlinkable
 
 import (
+	"math/rand"
+	"strings"
+	"time"
+
 	"github.com/chadnetzer/hardlinkable/internal/inode"
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
 )
@@ -28,6 +32,8 @@ import (
 const maxCmpBufSize = 8 * minCmpBufSize // Power of two multiplier
 const minCmpBufSize = 4096
 const digestBufSize = 4096
+const quickPrefixSize = 64
+const quickTailSize = 64
 
 type status struct {
 	Options   *Options
@@ -37,6 +43,15 @@ type status struct {
 	cmpBuf2   []byte
 	digestBuf []byte
 	pool      *P.StringPool
+	rand      *rand.Rand // Used for temp link names
+	pacer     *linkPacer // Limits the link rate (shared by all devices)
+	openInos  map[devIno]bool
+
+	// The DenyDigests, in lowercase
+	deniedDigests map[string]bool
+
+	// The file contents by pathname, when analyzing in-memory files
+	memContents map[string][]byte
 }
 
 type linkableState struct {
@@ -45,6 +60,22 @@ type linkableState struct {
 }
 
 func newLinkableState(opts *Options) *linkableState {
+	var pool *P.StringPool
+	if !opts.DisablePathPool {
+		pool = P.NewPoolSize(opts.PathPoolHint)
+	}
+	rng := opts.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	var pacer *linkPacer
+	if opts.LinkRateLimit > 0 {
+		pacer = &linkPacer{interval: time.Duration(float64(time.Second) / opts.LinkRateLimit)}
+	}
+	deniedDigests := make(map[string]bool)
+	for _, d := range opts.DenyDigests {
+		deniedDigests[strings.ToLower(d)] = true
+	}
 	return &linkableState{
 		status: status{
 			Options:   opts,
@@ -52,7 +83,12 @@ func newLinkableState(opts *Options) *linkableState {
 			cmpBuf1:   make([]byte, minCmpBufSize, maxCmpBufSize),
 			cmpBuf2:   make([]byte, minCmpBufSize, maxCmpBufSize),
 			digestBuf: make([]byte, digestBufSize),
-			pool:      P.NewPool(),
+			pool:      pool,
+			rand:      rng,
+			pacer:     pacer,
+			openInos:  make(map[devIno]bool),
+
+			deniedDigests: deniedDigests,
 		},
 		fsDevs: make(map[uint64]fsDev),
 	}
@@ -62,7 +98,18 @@ func (ls *linkableState) dev(di inode.DevStatInfo, pathname string) fsDev {
 	if fsdev, ok := ls.fsDevs[di.Dev]; ok {
 		return fsdev
 	}
-	fsdev := newFSDev(ls.status, di.Dev, inode.MaxNlinkVal(pathname))
-	ls.fsDevs[di.Dev] = fsdev
+	return ls.newDev(di.Dev, inode.MaxNlinkVal(pathname))
+}
+
+// newDev adds a new fsDev for the given device.  Only the first device has
+// its inode maps presized to the ExpectedFileCount (an upper bound on the
+// inodes of all the devices), so the hint isn't allocated once per device.
+func (ls *linkableState) newDev(dev, maxNLinks uint64) fsDev {
+	var n int
+	if len(ls.fsDevs) == 0 && ls.Options.ExpectedFileCount > 0 {
+		n = int(ls.Options.ExpectedFileCount)
+	}
+	fsdev := newFSDevSize(ls.status, dev, maxNLinks, n)
+	ls.fsDevs[dev] = fsdev
 	return fsdev
 }
@@ -0,0 +1,189 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package hardlinkable
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	I "github.com/chadnetzer/hardlinkable/internal/inode"
+)
+
+// devSize identifies a class of equal size files on a device, which can only
+// be linked to each other (with the StreamingLink option).
+type devSize struct {
+	dev  uint64
+	size uint64
+}
+
+// sizeClass holds the inodes of the walked files of a size class, until all
+// the files of the class have been walked.
+type sizeClass struct {
+	fsdev     fsDev
+	remaining int64 // The counted files of the class not yet walked
+}
+
+// streamState tracks the size classes of a Run with the StreamingLink option.
+// The files of each size class are counted by a preliminary walk, so that a
+// class can be linked (and its inode maps freed) as soon as the main walk
+// reaches its last file, rather than holding all the inodes until the walk
+// completes.
+type streamState struct {
+	counts    map[devSize]int64 // The file counts of the classes not yet walked
+	classes   map[devSize]*sizeClass
+	maxNLinks map[uint64]uint64
+	devs      map[uint64]bool
+	heldInos  int64 // The inodes held by all the classes
+	numPaths  int64
+}
+
+// newStreamState performs the preliminary walk of the given dirs and files,
+// counting the link candidates of each size class.  Only the counts are kept,
+// and the walk bookkeeping (such as the excluded files) is discarded, since
+// it is gathered again by the main walk.
+func newStreamState(ls *linkableState, dirs, files []string, changedSince time.Time) (*streamState, error) {
+	st := &streamState{
+		counts:    make(map[devSize]int64),
+		classes:   make(map[devSize]*sizeClass),
+		maxNLinks: make(map[uint64]uint64),
+		devs:      make(map[uint64]bool),
+	}
+	r := newResults(ls.Options)
+	done := make(chan struct{})
+	c := matchedPathnames(*ls.Options, r, nil, done, dirs, files)
+	defer func() {
+		close(done)
+		for range c {
+		}
+	}()
+	for pe := range c {
+		if pe.err != nil {
+			return nil, pe.err
+		}
+		di, err := I.LStatInfo(pe.pathname)
+		if err != nil {
+			continue // Skipped (or returned) by the main walk
+		}
+		bypass := pe.explicit && ls.Options.ExplicitFilesBypassFilters
+		if !isLinkCandidate(di, pe.pathname, ls.Options, r, bypass) {
+			continue
+		}
+		if !changedSince.IsZero() && di.Mtim.Before(changedSince) {
+			continue
+		}
+		st.counts[devSize{di.Dev, di.Size}]++
+	}
+	return st, nil
+}
+
+// add searches the size class of the walked file for identical files, and
+// links the class once all of its counted files have been walked.  Files
+// which weren't counted (ie. created or changed since the preliminary walk)
+// leave their class to be linked by finish().
+func (st *streamState) add(ls *linkableState, di I.DevStatInfo, pathname string) error {
+	key := devSize{di.Dev, di.Size}
+	c, ok := st.classes[key]
+	if !ok {
+		n := st.counts[key]
+		delete(st.counts, key)
+		maxNLinks, ok := st.maxNLinks[di.Dev]
+		if !ok {
+			maxNLinks = I.MaxNlinkVal(pathname)
+			st.maxNLinks[di.Dev] = maxNLinks
+		}
+		c = &sizeClass{
+			fsdev:     newFSDevSize(ls.status, di.Dev, maxNLinks, int(n)),
+			remaining: n,
+		}
+		st.classes[key] = c
+		st.devs[di.Dev] = true
+	}
+
+	numInos := len(c.fsdev.inoStatInfo)
+	if err := c.fsdev.FindIdenticalFiles(di, pathname); err != nil {
+		if !ls.Options.IgnoreWalkErrors {
+			return err
+		}
+		ls.Results.SkippedFileErrCount++
+		if ls.Options.DebugLevel > 0 {
+			log.Printf("\r%v  Skipping...", err)
+		}
+	}
+	st.heldInos += int64(len(c.fsdev.inoStatInfo) - numInos)
+	ls.Results.foundPeakInodes(st.heldInos)
+
+	c.remaining--
+	if c.remaining == 0 {
+		return st.link(ls, key)
+	}
+	return nil
+}
+
+// link generates (and optionally performs) the links of the size class, and
+// then frees its inode maps.  The LockFile is only held while linking.
+func (st *streamState) link(ls *linkableState, key devSize) error {
+	c := st.classes[key]
+	delete(st.classes, key)
+	st.heldInos -= int64(len(c.fsdev.inoStatInfo))
+
+	ls.fsDevs[key.dev] = c.fsdev
+	defer delete(ls.fsDevs, key.dev)
+	st.numPaths += ls.addGatheredGroups()
+
+	unlock, err := ls.lockLinking()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return ls.generateLinks()
+}
+
+// finish completes a Run with the StreamingLink option, by linking the size
+// classes whose counted files weren't all walked (smallest size first).
+func (st *streamState) finish(ls *linkableState) error {
+	ls.Progress.Clear()
+	ls.Results.addPoolStats(ls.pool)
+	ls.Results.Phase = LinkPhase
+
+	keys := make([]devSize, 0, len(st.classes))
+	for key := range st.classes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].size != keys[j].size {
+			return keys[i].size < keys[j].size
+		}
+		return keys[i].dev < keys[j].dev
+	})
+	for _, key := range keys {
+		if err := st.link(ls, key); err != nil {
+			return err
+		}
+	}
+	ls.Results.FileCount = st.numPaths
+	ls.Results.DeviceCount = int64(len(st.devs))
+	if err := ls.checkPredictedSavings(); err != nil {
+		return err
+	}
+	ls.Results.runCompletedSuccessfully()
+
+	return nil
+}
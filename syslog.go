@@ -0,0 +1,56 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// syslogWriter is the subset of the log/syslog Writer used for the SyslogTag
+// option.
+type syslogWriter interface {
+	io.Writer
+	Err(m string) error
+	Close() error
+}
+
+// syslogRunHelper wraps runHelper, sending the start of the run, any error,
+// and the summary line to syslog when the SyslogTag option is set.
+func syslogRunHelper(dirsAndFiles []string, ls *linkableState) error {
+	if ls.Options.SyslogTag == "" {
+		return runHelper(dirsAndFiles, ls)
+	}
+	w, err := newSyslogWriter(ls.Options)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	fmt.Fprintf(w, "Run started on: %v", strings.Join(dirsAndFiles, " "))
+	err = runHelper(dirsAndFiles, ls)
+	if err != nil {
+		w.Err(fmt.Sprintf("Run error: %v", err))
+	}
+	fmt.Fprint(w, ls.Results.OneLineSummary())
+	return err
+}
@@ -0,0 +1,46 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package hardlinkable
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// newSyslogWriter connects to the local (or SyslogAddr) syslog server
+func newSyslogWriter(o *Options) (syslogWriter, error) {
+	priority := syslog.Priority(o.SyslogPriority)
+	if priority == 0 {
+		priority = syslog.LOG_USER | syslog.LOG_INFO
+	}
+	var network, raddr string
+	if o.SyslogAddr != "" {
+		parts := strings.SplitN(o.SyslogAddr, ":", 2)
+		if len(parts) == 2 {
+			network, raddr = parts[0], parts[1]
+		} else {
+			raddr = o.SyslogAddr
+		}
+	}
+	return syslog.Dial(network, raddr, priority, o.SyslogTag)
+}
@@ -0,0 +1,80 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package hardlinkable
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSyslog(t *testing.T) {
+	topdir := setUp("Syslog", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "X", "f2": "X"})
+
+	// A local syslog server stub
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Couldn't listen for syslog messages: %v", err)
+	}
+	defer conn.Close()
+
+	opts := SetupOptions(LinkingDisabled)
+	opts.SyslogTag = "hardlinkable-test"
+	opts.SyslogAddr = "udp:" + conn.LocalAddr().String()
+	result, err := Run([]string{"."}, opts)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+
+	var msgs []string
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(msgs) < 2 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Expected 2 syslog messages, got: %q (err: %v)", msgs, err)
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+	for _, msg := range msgs {
+		if !strings.Contains(msg, "hardlinkable-test") {
+			t.Errorf("Expected syslog tag in message: %q", msg)
+		}
+	}
+	if !strings.Contains(msgs[0], "Run started on: .") {
+		t.Errorf("Expected run start message, got: %q", msgs[0])
+	}
+	if !strings.Contains(msgs[1], result.OneLineSummary()) {
+		t.Errorf("Expected summary line %q, got: %q", result.OneLineSummary(), msgs[1])
+	}
+
+	opts.SyslogAddr = "bogus:nowhere"
+	if _, err := Run([]string{"."}, opts); err == nil {
+		t.Errorf("Expected error for invalid SyslogAddr")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// treeNode holds the bytes saved by linking under a directory, including its
+// subdirectories.
+type treeNode struct {
+	name     string
+	bytes    uint64
+	children map[string]*treeNode
+}
+
+func newTreeNode(name string) *treeNode {
+	return &treeNode{name: name, children: make(map[string]*treeNode)}
+}
+
+// savingsTree returns the root of a tree of the directories in which inodes
+// were removed by linking, with the saved bytes summed up the tree.
+func (r *Results) savingsTree() *treeNode {
+	root := newTreeNode("")
+	for dirname, size := range r.RemovedInodeDirBytes {
+		components := strings.Split(filepath.Clean(dirname), string(filepath.Separator))
+		if components[0] == "" {
+			components[0] = string(filepath.Separator)
+		}
+		node := root
+		node.bytes += size
+		for _, c := range components {
+			child, ok := node.children[c]
+			if !ok {
+				child = newTreeNode(c)
+				node.children[c] = child
+			}
+			child.bytes += size
+			node = child
+		}
+	}
+	return root
+}
+
+// WriteTreeReport writes an indented directory tree to w, with each directory
+// annotated by the bytes saved (or saveable) by linking the files within it
+// (including its subdirectories).  The StoreNewLinkResults option must be
+// enabled for the Run() to gather the per-directory savings.
+func (r *Results) WriteTreeReport(w io.Writer) error {
+	return writeTreeNodes(w, r.savingsTree(), 0)
+}
+
+func writeTreeNodes(w io.Writer, node *treeNode, depth int) error {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := node.children[name]
+		indent := strings.Repeat("  ", depth)
+		if _, err := fmt.Fprintf(w, "%s%s  %s\n", indent, name, Humanize(child.bytes)); err != nil {
+			return err
+		}
+		if err := writeTreeNodes(w, child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteTreeReport(t *testing.T) {
+	topdir := setUp("TreeReport", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"d/a/f1":   "X",
+		"d/a/f2":   "X",
+		"d/b/g1":   "YY",
+		"d/b/g2":   "YY",
+		"d/b/c/h1": "ZZZ",
+		"d/b/c/h2": "ZZZ",
+		"d/b/c/h3": "ZZZ",
+	})
+
+	result, err := Run([]string{"d"}, SetupOptions(LinkingDisabled))
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+
+	// Parent savings are the sum of the children's savings (plus their own)
+	root := result.savingsTree()
+	d := root.children["d"]
+	if d == nil || d.bytes != 9 {
+		t.Fatalf("Expected 9 bytes saved under 'd', got: %+v", d)
+	}
+	a, b := d.children["a"], d.children["b"]
+	if a.bytes+b.bytes != d.bytes {
+		t.Errorf("Expected 'd' savings %v to equal sum of children %v + %v", d.bytes, a.bytes, b.bytes)
+	}
+	c := b.children["c"]
+	if c.bytes != 6 || b.bytes != c.bytes+2 {
+		t.Errorf("Expected 'd/b' savings %v to equal 'd/b/c' %v plus 2", b.bytes, c.bytes)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteTreeReport(&buf); err != nil {
+		t.Fatalf("WriteTreeReport() returned error: %v", err)
+	}
+	want := strings.Join([]string{
+		"d  9 bytes",
+		"  a  1 bytes",
+		"  b  8 bytes",
+		"    c  6 bytes",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("WriteTreeReport() expected:\n%v\ngot:\n%v", want, buf.String())
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import "sort"
+
+// The reasons given for the UnlinkedInodes
+const (
+	UnlinkedSetuid         = "setuid file"
+	UnlinkedSetgid         = "setgid file"
+	UnlinkedNonPermBits    = "non-permission mode bits set"
+	UnlinkedTooSmall       = "file too small"
+	UnlinkedTooLarge       = "file too large"
+	UnlinkedTooRecent      = "file too recently modified"
+	UnlinkedNotAligned     = "file size not block aligned"
+	UnlinkedInodeMismatch  = "equal content with mismatched inode parameters"
+	UnlinkedMaxNlink       = "maximum nlink count reached"
+	UnlinkedBelowMinDups   = "below minimum duplicate count"
+	UnlinkedReadonlyDir    = "dir not writable"
+	UnlinkedOpenFile       = "file open by another process"
+	UnlinkedContentChanged = "content changed before linking"
+	UnlinkedLinkError      = "linking failed"
+	UnlinkedDeniedDigest   = "content digest denied"
+)
+
+// UnlinkedInode is an inode which was a candidate for linking, but which was
+// not linked, along with one of its pathnames, and the reason.
+type UnlinkedInode struct {
+	Ino    uint64 `json:"ino"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// UnlinkedInodes returns the inodes that were skipped for linking, sorted by
+// pathname, when the StoreUnlinkedReasons option is enabled.  Inodes that
+// were linked after being skipped (such as to a different inode) are not
+// included.  The inodes with equal content, but mismatched inode parameters,
+// are only found when AdvisoryContentGroups is also enabled.
+func (r *Results) UnlinkedInodes() []UnlinkedInode {
+	inodes := make([]UnlinkedInode, 0, len(r.unlinkedInodes))
+	for _, u := range r.unlinkedInodes {
+		inodes = append(inodes, u)
+	}
+	sort.Slice(inodes, func(i, j int) bool {
+		return inodes[i].Path < inodes[j].Path
+	})
+	return inodes
+}
+
+// skippedInode records the reason the inode wasn't linked, if it doesn't
+// already have one.
+func (r *Results) skippedInode(dev, ino uint64, pathname, reason string) {
+	if !r.Opts.StoreUnlinkedReasons {
+		return
+	}
+	if r.unlinkedInodes == nil {
+		r.unlinkedInodes = make(map[devIno]UnlinkedInode)
+	}
+	di := devIno{dev, ino}
+	if _, ok := r.unlinkedInodes[di]; !ok {
+		r.unlinkedInodes[di] = UnlinkedInode{Ino: ino, Path: pathname, Reason: reason}
+	}
+}
+
+// linkedInode removes the recorded reason for an inode which was linked
+func (r *Results) linkedInode(dev, ino uint64) {
+	delete(r.unlinkedInodes, devIno{dev, ino})
+}
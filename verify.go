@@ -0,0 +1,52 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import "fmt"
+
+// ErrUnlinkedDuplicates is returned by Verify when some of the walked files
+// could still be linked.
+type ErrUnlinkedDuplicates struct {
+	Links int64  // The new links that could be made
+	Bytes uint64 // The bytes that linking would save
+}
+
+func (e *ErrUnlinkedDuplicates) Error() string {
+	return fmt.Sprintf("found %v unlinked duplicate files (%v)", e.Links, Humanize(e.Bytes))
+}
+
+// Verify walks the given dirs and files (as Run does, but never linking), and
+// returns an *ErrUnlinkedDuplicates error if any of the files could still be
+// linked.  This allows checking that a tree remains fully linked (ie. in CI).
+// The existing links (and the linkable files) are stored in the Results.
+func Verify(dirsAndFiles []string, opts Options) (Results, error) {
+	opts.LinkingEnabled = false
+	opts.StoreExistingLinkResults = true
+	opts.StoreNewLinkResults = true
+	r, err := Run(dirsAndFiles, opts)
+	if err != nil {
+		return r, err
+	}
+	if r.NewLinkCount > 0 {
+		return r, &ErrUnlinkedDuplicates{Links: r.NewLinkCount, Bytes: r.InodeRemovedByteAmount}
+	}
+	return r, nil
+}
@@ -0,0 +1,57 @@
+// Copyright © 2018 Chad Netzer <chad.netzer@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hardlinkable
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	topdir := setUp("Verify", t)
+	defer os.RemoveAll(topdir)
+
+	name := "testname: 'Verify'"
+	m := pathContents{"f1": "X", "f2": "X", "g1": "YY", "g2": "YY"}
+	simpleFileMaker(t, m)
+	simpleLinkMaker(t, "f1", "f3")
+
+	// A partially linked tree has unlinked duplicates
+	r, err := Verify([]string{"."}, SetupOptions(LinkingEnabled))
+	ud, ok := err.(*ErrUnlinkedDuplicates)
+	if !ok {
+		t.Fatalf("%v: Expected an ErrUnlinkedDuplicates error, got: %v", name, err)
+	}
+	if ud.Links != 2 || ud.Bytes != 3 || r.Opts.LinkingEnabled {
+		t.Errorf("%v: Expected 2 unlinked duplicates of 3 bytes, got: %+v", name, ud)
+	}
+	if r.ExistingLinkCount != 1 || len(r.ExistingLinks) != 1 {
+		t.Errorf("%v: Expected the existing link to be stored, got: %v", name, r.ExistingLinks)
+	}
+	verifyInodeCounts(name, t, &r, 2, 3, 1, "f2", "g1", "g2")
+
+	// A fully linked tree verifies
+	simpleRun(name, t, SetupOptions(LinkingEnabled), 2, ".")
+	if _, err := Verify([]string{"."}, SetupOptions()); err != nil {
+		t.Errorf("%v: Expected fully linked tree to verify, got: %v", name, err)
+	}
+	verifyContents(name, t, m)
+}
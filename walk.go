@@ -21,9 +21,16 @@
 package hardlinkable
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"syscall"
 
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
 
@@ -33,66 +40,106 @@ import (
 type pathErr struct {
 	pathname string
 	err      error
+	explicit bool // Pathname was given in the files (not found by walking)
+}
+
+// ErrRootVanished is returned when a top-level directory given to Run is
+// removed while it is being walked.
+type ErrRootVanished struct {
+	Path string
+	Err  error // The underlying walk error
+}
+
+func (e *ErrRootVanished) Error() string {
+	return fmt.Sprintf("walk root '%v' vanished during walk: %v", e.Path, e.Err)
+}
+
+// errWalkStopped is returned from the walk callback to halt the walk, when
+// the receiver of the pathnames has closed the done channel.
+var errWalkStopped = errors.New("walk stopped by receiver")
+
+// walkHalted is called with the error which first halts the concurrent walks,
+// before the workers are stopped.  Allows tests to order events on the halt.
+var walkHalted = func(err error) {}
+
+// isWalkStopped returns true if the error is errWalkStopped, or wraps it (as
+// godirwalk does with the errors returned by the walk callback).
+func isWalkStopped(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+	for err != nil {
+		if err == errWalkStopped {
+			return true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// walkState holds the walk state shared by all the walked dirs, which may be
+// walked concurrently (with WalkWorkers).
+type walkState struct {
+	sync.Mutex
+	uniqueDirs  map[string]struct{}
+	seenDirInos map[devIno]string   // For WarnUnusualInodes
+	ignores     map[string][]string // For UseIgnoreFiles
+}
+
+func newWalkState() *walkState {
+	return &walkState{
+		uniqueDirs:  make(map[string]struct{}),
+		seenDirInos: make(map[devIno]string),
+		ignores:     make(map[string][]string),
+	}
 }
 
 // Return allowed pathnames through the given channel.  An empty pathname
-// indicates the walk returned before completion.
-func matchedPathnames(opts Options, r *Results, pool *P.StringPool, dirs []string, files []string) <-chan pathErr {
+// indicates the walk returned before completion.  Closing the done channel
+// stops the walk early (a nil done channel never stops it).
+func matchedPathnames(opts Options, r *Results, pool *P.StringPool, done <-chan struct{}, dirs []string, files []string) <-chan pathErr {
 	// Options is a copy to prevent being changed during walk.
 	out := make(chan pathErr)
 	go func() {
 		defer close(out)
-		uniqueDirs := make(map[string]struct{})
-		for _, dir := range dirs {
-			err := godirwalk.Walk(dir, &godirwalk.Options{
-				Unsorted: true,
-				Callback: func(osPathname string, de *godirwalk.Dirent) error {
-					if de.ModeType().IsDir() {
-						// DirCount updated here only, so doesn't race w/ other goroutines.
-						if _, ok := uniqueDirs[osPathname]; !ok {
-							dirname := pool.Intern(osPathname)
-							uniqueDirs[dirname] = struct{}{}
-
-							// Do not exclude dirs provided explicitly by the user
-							if dir != osPathname && isMatched(de.Name(), opts.DirExcludes) {
-								r.ExcludedDirCount++ // Only updated in this goroutine
-								return filepath.SkipDir
-							}
-							r.DirCount++
-						} else {
-							// Skip already walked directories
-							return filepath.SkipDir
-						}
-					} else if de.ModeType().IsRegular() {
-						if isFileIncluded(de.Name(), &opts, r) {
-							out <- pathErr{pathname: osPathname, err: nil}
-						}
-					}
-					return nil
-				},
-				ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-					r.SkippedDirErrCount++
-					if osPathname == dir {
-						if opts.IgnoreWalkErrors && opts.DebugLevel > 0 {
-							log.Printf("\r%v  Skipping...", err)
-						}
-						// Halt when we can't walk the top level directory, so
-						// that it gets reported as an error (even if we are
-						// ignoring file errors)
-						return godirwalk.Halt
-					}
-					if opts.IgnoreWalkErrors {
-						if opts.DebugLevel > 0 {
-							log.Printf("\r%v  Skipping...", err)
-						}
-						return godirwalk.SkipNode
-					}
-					return godirwalk.Halt
-				},
-			})
+
+		// send returns false if the receiver is done receiving
+		send := func(pe pathErr) bool {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+			select {
+			case out <- pe:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		ws := newWalkState()
+		if opts.WalkWorkers > 1 && len(dirs) > 1 {
+			err := walkDirsConcurrently(&opts, r, pool, ws, dirs, done, send)
+			if err == errWalkStopped {
+				return
+			}
 			if err != nil {
-				if !opts.IgnoreWalkErrors {
-					out <- pathErr{pathname: "", err: err}
+				send(pathErr{pathname: "", err: err})
+				return
+			}
+		} else {
+			for _, dir := range dirs {
+				err := walkDir(&opts, r, pool, ws, dir, done, send)
+				if err == errWalkStopped {
+					return
+				}
+				if err != nil {
+					send(pathErr{pathname: "", err: err})
 					return
 				}
 			}
@@ -100,14 +147,347 @@ func matchedPathnames(opts Options, r *Results, pool *P.StringPool, dirs []strin
 		// Also pass back some or all (depending on includes and
 		// excludes) of the passed in file pathnames.
 		for _, pathname := range files {
-			if isFileIncluded(pathname, &opts, r) {
-				out <- pathErr{pathname: pathname, err: nil}
+			if opts.ExplicitFilesBypassFilters ||
+				(!isVolatileFile(filepath.Base(pathname), &opts, r) && !isTooDeepPath(pathname, &opts, r) &&
+					isFileIncluded(pathname, pathname, &opts, r)) {
+				if !send(pathErr{pathname: pathname, err: nil, explicit: true}) {
+					return
+				}
 			}
 		}
 	}()
 	return out
 }
 
+// walkDirsConcurrently walks the dirs with up to WalkWorkers goroutines, each
+// walking one dir at a time.  The walk counts of each worker are kept in
+// their own Results, and added to r once all the walks are finished.  The
+// first error which halts a walk (or the receiver closing the done channel)
+// stops all the walks, and the error is returned once they have stopped.
+func walkDirsConcurrently(opts *Options, r *Results, pool *P.StringPool, ws *walkState, dirs []string, done <-chan struct{}, send func(pathErr) bool) error {
+	n := opts.WalkWorkers
+	if n > len(dirs) {
+		n = len(dirs)
+	}
+	dirc := make(chan string)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		firstErr error
+	)
+	halt := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			walkHalted(err)
+			close(stop)
+		})
+	}
+
+	// Stop all the workers once the receiver is done
+	finished := make(chan struct{})
+	watcherExited := make(chan struct{})
+	go func() {
+		defer close(watcherExited)
+		select {
+		case <-done:
+			halt(errWalkStopped)
+		case <-finished:
+		}
+	}()
+
+	shards := make([]*Results, n)
+	for i := 0; i < n; i++ {
+		shards[i] = &Results{Opts: *opts}
+		wg.Add(1)
+		go func(shard *Results) {
+			defer wg.Done()
+			for dir := range dirc {
+				if err := walkDir(opts, shard, pool, ws, dir, stop, send); err != nil {
+					halt(err)
+				}
+			}
+		}(shards[i])
+	}
+feed:
+	for _, dir := range dirs {
+		select {
+		case dirc <- dir:
+		case <-stop:
+			break feed
+		}
+	}
+	close(dirc)
+	wg.Wait()
+	close(finished)
+	<-watcherExited
+
+	for _, shard := range shards {
+		r.mergeWalkShard(shard)
+	}
+	return firstErr
+}
+
+// mergeWalkShard adds the walk counts, and stored excluded pathnames and
+// warnings, from the Results of a walk worker.  Only the counts the worker
+// updated are touched, since the others may be concurrently updated by the
+// receiver of the walked pathnames.
+func (r *Results) mergeWalkShard(s *Results) {
+	r.addRunStats(&s.RunStats)
+	r.ExcludedDirPaths = append(r.ExcludedDirPaths, s.ExcludedDirPaths...)
+	r.ExcludedFilePaths = append(r.ExcludedFilePaths, s.ExcludedFilePaths...)
+	r.UnusualInodeWarnings = append(r.UnusualInodeWarnings, s.UnusualInodeWarnings...)
+}
+
+// walkDir walks the given dir, sending the allowed pathnames.  Returns
+// errWalkStopped if the receiver stopped receiving (or the stop channel was
+// closed), or the error which halted the walk (unless ignoring walk errors).
+func walkDir(opts *Options, r *Results, pool *P.StringPool, ws *walkState, dir string, stop <-chan struct{}, send func(pathErr) bool) error {
+	err := godirwalk.Walk(dir, &godirwalk.Options{
+		Unsorted: true,
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			select {
+			case <-stop:
+				return errWalkStopped
+			default:
+			}
+			if de.ModeType().IsDir() {
+				// DirCount updated here only, so doesn't race w/ other goroutines.
+				ws.Lock()
+				_, seen := ws.uniqueDirs[osPathname]
+				if !seen {
+					dirname := pool.Intern(osPathname)
+					ws.uniqueDirs[dirname] = struct{}{}
+				}
+				ws.Unlock()
+				if !seen {
+					// Do not exclude dirs provided explicitly by the user
+					if dir != osPathname && isMatched(de.Name(), opts.DirExcludes) {
+						r.excludedDir(osPathname) // Only updated in this goroutine
+						return filepath.SkipDir
+					}
+					if dir != osPathname && isExcludedMountpoint(osPathname, opts.ExcludeMountpoints, dirDev) {
+						r.excludedDir(osPathname)
+						return filepath.SkipDir
+					}
+					if dir != osPathname && isFilteredDir(osPathname, opts.DirFilter) {
+						r.excludedDir(osPathname)
+						return filepath.SkipDir
+					}
+					if opts.UseIgnoreFiles {
+						if dir != osPathname && ws.isIgnored(osPathname, dir) {
+							r.excludedDir(osPathname)
+							return filepath.SkipDir
+						}
+						if patterns := readIgnoreFile(osPathname); len(patterns) > 0 {
+							ws.Lock()
+							ws.ignores[filepath.Clean(osPathname)] = patterns
+							ws.Unlock()
+						}
+					}
+					r.DirCount++
+					if opts.WarnUnusualInodes {
+						if w := ws.unusualInodeWarning(osPathname); w != "" {
+							r.foundUnusualInode(w) // Only updated in this goroutine
+						}
+					}
+				} else {
+					// Skip already walked directories
+					return filepath.SkipDir
+				}
+			} else if de.ModeType().IsRegular() {
+				if opts.UseIgnoreFiles && ws.isIgnored(osPathname, dir) {
+					r.excludedFile(osPathname)
+				} else if !isVolatileFile(de.Name(), opts, r) && !isTooDeepPath(osPathname, opts, r) &&
+					isFileIncluded(de.Name(), osPathname, opts, r) {
+					if !send(pathErr{pathname: osPathname, err: nil}) {
+						return errWalkStopped
+					}
+				}
+			} else if opts.WarnUnusualInodes && de.ModeType()&os.ModeSymlink == 0 {
+				if w := ws.unusualInodeWarning(osPathname); w != "" {
+					r.foundUnusualInode(w)
+				}
+			}
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			if isWalkStopped(err) {
+				return godirwalk.Halt
+			}
+			r.SkippedDirErrCount++
+			if osPathname == dir {
+				if opts.IgnoreWalkErrors && opts.DebugLevel > 0 {
+					log.Printf("\r%v  Skipping...", err)
+				}
+				// Halt when we can't walk the top level directory, so
+				// that it gets reported as an error (even if we are
+				// ignoring file errors)
+				return godirwalk.Halt
+			}
+			if opts.IgnoreWalkErrors {
+				if opts.DebugLevel > 0 {
+					log.Printf("\r%v  Skipping...", err)
+				}
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+	})
+	if isWalkStopped(err) {
+		return errWalkStopped
+	}
+	if err != nil {
+		if _, statErr := os.Lstat(dir); os.IsNotExist(statErr) {
+			r.RootVanishedCount++ // Only updated in this goroutine
+			err = &ErrRootVanished{Path: dir, Err: err}
+		}
+		if !opts.IgnoreWalkErrors {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIgnored calls isIgnored with the shared ignore file patterns
+func (ws *walkState) isIgnored(pathname, root string) bool {
+	ws.Lock()
+	defer ws.Unlock()
+	return isIgnored(pathname, root, ws.ignores)
+}
+
+// unusualInodeWarning calls unusualInodeWarning with the shared walked dir
+// inodes
+func (ws *walkState) unusualInodeWarning(pathname string) string {
+	ws.Lock()
+	defer ws.Unlock()
+	return unusualInodeWarning(pathname, ws.seenDirInos)
+}
+
+// unusualInodeWarning returns a warning message if the given (non-regular)
+// pathname is a special inode (device, fifo, socket, etc.) with multiple
+// links, or a directory inode that was already walked at another pathname
+// (such as a hardlinked directory).  Otherwise an empty string is returned.
+func unusualInodeWarning(pathname string, seenDirInos map[devIno]string) string {
+	fi, err := os.Lstat(pathname)
+	if err != nil {
+		return ""
+	}
+	statT, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	if fi.IsDir() {
+		di := devIno{dev: uint64(statT.Dev), ino: uint64(statT.Ino)}
+		if prevPathname, ok := seenDirInos[di]; ok {
+			return fmt.Sprintf("Directory '%v' is the same inode as '%v'", pathname, prevPathname)
+		}
+		seenDirInos[di] = pathname
+		return ""
+	}
+	if uint64(statT.Nlink) > 1 {
+		return fmt.Sprintf("Special file '%v' (%v) has %v links", pathname, fi.Mode(), statT.Nlink)
+	}
+	return ""
+}
+
+// dirDev returns the device number of the given pathname
+func dirDev(pathname string) (uint64, error) {
+	fi, err := os.Lstat(pathname)
+	if err != nil {
+		return 0, err
+	}
+	statT, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("No device info for '%v'", pathname)
+	}
+	return uint64(statT.Dev), nil
+}
+
+// isFilteredDir returns true if the given dir filter rejects the pathname.  A
+// pathname that can't be stat'ed is left to the walk to report.
+func isFilteredDir(pathname string, filter func(string, os.FileInfo) bool) bool {
+	if filter == nil {
+		return false
+	}
+	fi, err := os.Lstat(pathname)
+	if err != nil {
+		return false
+	}
+	return !filter(pathname, fi)
+}
+
+// isExcludedMountpoint returns true if the pathname is one of the given
+// mountpoints, and is on a different device than its parent directory.
+// The devOf func returns the device of a pathname.
+func isExcludedMountpoint(pathname string, mountpoints []string, devOf func(string) (uint64, error)) bool {
+	if len(mountpoints) == 0 {
+		return false
+	}
+	absPathname, err := filepath.Abs(pathname)
+	if err != nil {
+		return false
+	}
+	found := false
+	for _, m := range mountpoints {
+		if absM, err := filepath.Abs(m); err == nil && absM == absPathname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	dev, err := devOf(absPathname)
+	if err != nil {
+		return false
+	}
+	parentDev, err := devOf(filepath.Dir(absPathname))
+	if err != nil {
+		return false
+	}
+	return dev != parentDev
+}
+
+// readIgnoreFile returns the glob patterns from the IgnoreFileName file in the
+// given dir (if any).  Blank lines and lines starting with '#' are skipped.
+func readIgnoreFile(dirname string) []string {
+	b, err := ioutil.ReadFile(filepath.Join(dirname, IgnoreFileName))
+	if err != nil {
+		return nil
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns
+}
+
+// isIgnored returns true if the name of the given pathname matches one of the
+// ignore file patterns of its parent dirs, up to the walked root dir.  The
+// ignores map holds the patterns of each walked dir with an ignore file.
+func isIgnored(pathname, root string, ignores map[string][]string) bool {
+	if len(ignores) == 0 {
+		return false
+	}
+	name := filepath.Base(pathname)
+	root = filepath.Clean(root)
+	for d := filepath.Dir(filepath.Clean(pathname)); ; d = filepath.Dir(d) {
+		for _, p := range ignores[d] {
+			if matched, err := filepath.Match(p, name); matched && err == nil {
+				return true
+			}
+		}
+		if d == root || d == filepath.Dir(d) {
+			return false
+		}
+	}
+}
+
 // isMatched() returns true if name matches any of the patterns, and false
 // otherwise (or if there are no patterns).
 func isMatched(name string, pattern []string) bool {
@@ -120,12 +500,49 @@ func isMatched(name string, pattern []string) bool {
 	return false
 }
 
-// isFileIncluded returns true if the given pathname is not excluded, or is
-// specifically included by the command line options.
+// isVolatileFile returns true if the SkipVolatileFiles option is enabled, and
+// the given filename matches one of the DefaultVolatilePatterns.  The skipped
+// file is counted in the Results.
+func isVolatileFile(name string, opts *Options, r *Results) bool {
+	if !opts.SkipVolatileFiles {
+		return false
+	}
+	for _, p := range DefaultVolatilePatterns {
+		if matched, err := filepath.Match(p, name); matched && err == nil {
+			r.skippedVolatileFile() // Only updated in the walk goroutine
+			return true
+		}
+	}
+	return false
+}
+
+// isTooDeepPath returns true if the MaxPathComponents option is enabled, and
+// the given pathname has more components than allowed.  The skipped file is
+// counted in the Results.
+func isTooDeepPath(pathname string, opts *Options, r *Results) bool {
+	if opts.MaxPathComponents <= 0 {
+		return false
+	}
+	n := 0
+	for _, c := range strings.Split(filepath.Clean(pathname), string(filepath.Separator)) {
+		if c != "" && c != "." {
+			n++
+		}
+	}
+	if n > opts.MaxPathComponents {
+		r.skippedTooDeepPath() // Only updated in the walk goroutine
+		return true
+	}
+	return false
+}
+
+// isFileIncluded returns true if the given name is not excluded, or is
+// specifically included by the command line options.  The pathname is
+// recorded in the Results when it is excluded.
 //
 // Result counts are only updated in the walk goroutine, so should be safe from
 // races.
-func isFileIncluded(name string, opts *Options, r *Results) bool {
+func isFileIncluded(name, pathname string, opts *Options, r *Results) bool {
 	inc := opts.FileIncludes
 	exc := opts.FileExcludes
 	if len(exc) == 0 && len(inc) == 0 {
@@ -138,6 +555,6 @@ func isFileIncluded(name string, opts *Options, r *Results) bool {
 	if len(exc) > 0 && !isMatched(name, exc) {
 		return true
 	}
-	r.ExcludedFileCount++
+	r.excludedFile(pathname)
 	return false
 }
@@ -21,10 +21,14 @@
 package hardlinkable
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 
 	P "github.com/chadnetzer/hardlinkable/internal/pathpool"
@@ -83,7 +87,7 @@ func TestWalkFileIncludes(t *testing.T) {
 		s.Options.FileIncludes = v.in
 		s.Options.FileExcludes = v.ex
 
-		c := matchedPathnames(*s.Options, s.Results, s.pool, dirs, []string{})
+		c := matchedPathnames(*s.Options, s.Results, s.pool, nil, dirs, []string{})
 		n := 0
 		var filenames []string
 		foundMatch := false
@@ -103,3 +107,410 @@ func TestWalkFileIncludes(t *testing.T) {
 		}
 	}
 }
+
+func TestWalkStoreExcludedPaths(t *testing.T) {
+	topdir := setUp("ExcludedPaths", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"A/f1":     "X",
+		"A/f2.tmp": "X",
+		"B/f3":     "X",
+		"C/f4.tmp": "X",
+	})
+
+	s := status{}
+	s.Options = &Options{
+		FileExcludes:       []string{`\.tmp$`},
+		DirExcludes:        []string{"^B$"},
+		StoreExcludedPaths: true,
+	}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+
+	n := 0
+	for range matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"."}, []string{}) {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 included file, got: %v", n)
+	}
+
+	wantFiles := newSet("A/f2.tmp", "C/f4.tmp")
+	gotFiles := newSet(s.Results.ExcludedFilePaths...)
+	if len(gotFiles) != len(wantFiles) || len(intersection(gotFiles, wantFiles)) != len(wantFiles) {
+		t.Errorf("Expected excluded file paths %v, got: %v", wantFiles, s.Results.ExcludedFilePaths)
+	}
+	if len(s.Results.ExcludedDirPaths) != 1 || s.Results.ExcludedDirPaths[0] != "B" {
+		t.Errorf("Expected excluded dir paths [B], got: %v", s.Results.ExcludedDirPaths)
+	}
+	if s.Results.ExcludedFileCount != 2 || s.Results.ExcludedDirCount != 1 {
+		t.Errorf("Expected 2 excluded files and 1 excluded dir, got: %v %v",
+			s.Results.ExcludedFileCount, s.Results.ExcludedDirCount)
+	}
+}
+
+func TestWalkUseIgnoreFiles(t *testing.T) {
+	topdir := setUp("IgnoreFiles", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1.log":              "X",
+		"A/" + IgnoreFileName: "# Comment\n\n*.log\nskip\n",
+		"A/f2.log":            "X",
+		"A/f3":                "X",
+		"A/skip/f4":           "X",
+		"A/B/f5.log":          "X",
+		"C/f6.log":            "X",
+		"C/skip/f7":           "X",
+	})
+
+	s := status{}
+	s.Options = &Options{
+		UseIgnoreFiles:     true,
+		StoreExcludedPaths: true,
+	}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+
+	var got []string
+	for pe := range matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"."}, []string{}) {
+		got = append(got, pe.pathname)
+	}
+	want := newSet("f1.log", "A/"+IgnoreFileName, "A/f3", "C/f6.log", "C/skip/f7")
+	gotSet := newSet(got...)
+	if len(gotSet) != len(want) || len(intersection(gotSet, want)) != len(want) {
+		t.Errorf("Expected walked files %v, got: %v", want, got)
+	}
+	wantFiles := newSet("A/f2.log", "A/B/f5.log")
+	gotFiles := newSet(s.Results.ExcludedFilePaths...)
+	if len(gotFiles) != len(wantFiles) || len(intersection(gotFiles, wantFiles)) != len(wantFiles) {
+		t.Errorf("Expected excluded file paths %v, got: %v", wantFiles, s.Results.ExcludedFilePaths)
+	}
+	if len(s.Results.ExcludedDirPaths) != 1 || s.Results.ExcludedDirPaths[0] != "A/skip" {
+		t.Errorf("Expected excluded dir paths [A/skip], got: %v", s.Results.ExcludedDirPaths)
+	}
+}
+
+func TestWalkDirFilter(t *testing.T) {
+	topdir := setUp("DirFilter", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"f1":        "X",
+		"A/f2":      "X",
+		"A/skip/f3": "X",
+		"skip/f4":   "X",
+		"skip/B/f5": "X",
+	})
+
+	s := status{}
+	s.Options = &Options{
+		DirFilter: func(pathname string, info os.FileInfo) bool {
+			return !info.IsDir() || info.Name() != "skip"
+		},
+	}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+
+	var got []string
+	for pe := range matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"."}, []string{}) {
+		got = append(got, pe.pathname)
+	}
+	want := newSet("f1", "A/f2")
+	gotSet := newSet(got...)
+	if len(gotSet) != len(want) || len(intersection(gotSet, want)) != len(want) {
+		t.Errorf("Expected walked files %v, got: %v", want, got)
+	}
+	if s.Results.ExcludedDirCount != 2 {
+		t.Errorf("Expected 2 excluded dirs, got: %v", s.Results.ExcludedDirCount)
+	}
+}
+
+func TestWalkRootVanished(t *testing.T) {
+	topdir := setUp("RootVanished", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for i := 0; i < 10; i++ {
+		m[fmt.Sprintf("root/d%v/f%v", i, i)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	s := status{}
+	s.Options = &Options{}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+
+	// The walk goroutine blocks on each sent pathname, so removing the
+	// root after receiving the first one leaves the remaining dirs unwalked.
+	c := matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"root"}, []string{})
+	removed := false
+	var walkErr error
+	for pe := range c {
+		if !removed {
+			if err := os.RemoveAll("root"); err != nil {
+				t.Fatalf("Couldn't remove walk root: %v", err)
+			}
+			removed = true
+		}
+		if pe.err != nil {
+			walkErr = pe.err
+		}
+	}
+	rv, ok := walkErr.(*ErrRootVanished)
+	if !ok {
+		t.Fatalf("Expected ErrRootVanished error, got: %v", walkErr)
+	}
+	if rv.Path != "root" {
+		t.Errorf("Expected ErrRootVanished path 'root', got: %v", rv.Path)
+	}
+	if s.Results.RootVanishedCount != 1 {
+		t.Errorf("Expected RootVanishedCount 1, got: %v", s.Results.RootVanishedCount)
+	}
+}
+
+func TestWalkStoppedByReceiver(t *testing.T) {
+	topdir := setUp("WalkStopped", t)
+	defer os.RemoveAll(topdir)
+
+	const numDirs = 10
+	m := pathContents{}
+	for i := 0; i < numDirs; i++ {
+		for j := 0; j < 4; j++ {
+			m[fmt.Sprintf("d%v/f%v", i, j)] = fmt.Sprintf("%v-%v", i, j)
+		}
+	}
+	simpleFileMaker(t, m)
+
+	// Stopping the walk isn't a walk error, and halts it promptly
+	for _, ignoreErrs := range []bool{false, true} {
+		name := fmt.Sprintf("testname: 'Walk Stopped By Receiver' IgnoreWalkErrors: %v", ignoreErrs)
+		opts := SetupOptions()
+		opts.MaxFiles = 5
+		opts.IgnoreWalkErrors = ignoreErrs
+		r, err := Run([]string{"."}, opts)
+		if err != nil {
+			t.Errorf("%v: Run() returned error: %v", name, err)
+		}
+		if !r.HitFileLimit {
+			t.Errorf("%v: Expected HitFileLimit", name)
+		}
+		if r.SkippedDirErrCount != 0 {
+			t.Errorf("%v: Expected SkippedDirErrCount 0, got: %v", name, r.SkippedDirErrCount)
+		}
+		// The top dir, and the (at most) 2 dirs of the first 5 files
+		if r.DirCount > 3 {
+			t.Errorf("%v: Expected the walk to stop after 3 dirs, got: %v", name, r.DirCount)
+		}
+	}
+}
+
+func TestWalkWarnUnusualInodes(t *testing.T) {
+	topdir := setUp("UnusualInodes", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{"f1": "X"})
+	if err := syscall.Mkfifo("p1", 0644); err != nil {
+		t.Skipf("Couldn't create fifo for unusual inode test: %v", err)
+	}
+	if err := syscall.Mkfifo("p3", 0644); err != nil {
+		t.Skipf("Couldn't create fifo for unusual inode test: %v", err)
+	}
+	simpleLinkMaker(t, "p1", "p2")
+
+	for _, warn := range []bool{false, true} {
+		s := status{}
+		s.Options = &Options{WarnUnusualInodes: warn}
+		s.Results = newResults(s.Options)
+		s.pool = P.NewPool()
+
+		n := 0
+		for range matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"."}, []string{}) {
+			n++
+		}
+		if n != 1 {
+			t.Errorf("Expected only the 1 regular file to be walked, got: %v", n)
+		}
+
+		numWarnings := 0
+		if warn {
+			numWarnings = 2 // Both p1 and p2, but not p3
+		}
+		if len(s.Results.UnusualInodeWarnings) != numWarnings {
+			t.Errorf("Expected %v unusual inode warnings, got: %v",
+				numWarnings, s.Results.UnusualInodeWarnings)
+		}
+		for _, w := range s.Results.UnusualInodeWarnings {
+			if strings.Contains(w, "p3") {
+				t.Errorf("Unexpected unusual inode warning for single link fifo: %v", w)
+			}
+		}
+	}
+}
+
+func TestWalkExcludeMountpoints(t *testing.T) {
+	topdir := setUp("ExcludeMountpoints", t)
+	defer os.RemoveAll(topdir)
+
+	simpleFileMaker(t, pathContents{
+		"A/f1":   "X",
+		"A/M/f2": "X",
+		"B/f3":   "X",
+	})
+
+	// Simulate "A/M" being a mount point on another device
+	abs := func(p string) string {
+		a, _ := filepath.Abs(p)
+		return a
+	}
+	fakeDevOf := func(pathname string) (uint64, error) {
+		if strings.HasPrefix(pathname, abs("A/M")) {
+			return 2, nil
+		}
+		return 1, nil
+	}
+	mounts := []string{"A/M", "B"}
+	if !isExcludedMountpoint("A/M", mounts, fakeDevOf) {
+		t.Errorf("Expected 'A/M' to be an excluded mountpoint")
+	}
+	if !isExcludedMountpoint(abs("A/M"), mounts, fakeDevOf) {
+		t.Errorf("Expected absolute 'A/M' to be an excluded mountpoint")
+	}
+	if isExcludedMountpoint("B", mounts, fakeDevOf) {
+		t.Errorf("Expected 'B' to not be a mountpoint (same dev as parent)")
+	}
+	if isExcludedMountpoint("A", mounts, fakeDevOf) {
+		t.Errorf("Expected 'A' to not be an excluded mountpoint")
+	}
+
+	// A listed directory on the same device as its parent isn't skipped
+	s := status{}
+	s.Options = &Options{ExcludeMountpoints: mounts}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+	n := 0
+	for range matchedPathnames(*s.Options, s.Results, s.pool, nil, []string{"."}, []string{}) {
+		n++
+	}
+	if n != 3 || s.Results.ExcludedDirCount != 0 {
+		t.Errorf("Expected 3 files and no excluded dirs, got: %v %v", n, s.Results.ExcludedDirCount)
+	}
+}
+
+func TestRunWalkWorkers(t *testing.T) {
+	topdir := setUp("WalkWorkers", t)
+	defer os.RemoveAll(topdir)
+
+	// Content groups spread across several top-level dirs
+	m := pathContents{}
+	for i := 0; i < 40; i++ {
+		m[fmt.Sprintf("d%v/sub%v/f%v", i%5, i%2, i)] = strings.Repeat(string("ABCD"[i%4]), i%3+1)
+	}
+	simpleFileMaker(t, m)
+	dirs := []string{"d0", "d1", "d2", "d3", "d4"}
+
+	opts := SetupOptions(LinkingDisabled)
+	serial, err := Run(dirs, opts)
+	if err != nil {
+		t.Fatalf("Serial Run() returned error: %v", err)
+	}
+
+	opts.WalkWorkers = 4
+	parallel, err := Run(dirs, opts)
+	if err != nil {
+		t.Fatalf("Parallel Run() returned error: %v", err)
+	}
+
+	if serial.FileCount != 40 || parallel.FileCount != serial.FileCount {
+		t.Errorf("Expected FileCount 40, got: %v vs %v", serial.FileCount, parallel.FileCount)
+	}
+	verifySameRunStats("Walk Workers", t, &serial, &parallel)
+	if !reflect.DeepEqual(sortedLinkGroups(&serial), sortedLinkGroups(&parallel)) {
+		t.Errorf("LinkPaths differ: %v vs %v", serial.LinkPaths, parallel.LinkPaths)
+	}
+}
+
+func TestRunWalkWorkersStopped(t *testing.T) {
+	topdir := setUp("WalkWorkersStopped", t)
+	defer os.RemoveAll(topdir)
+
+	const numDirs = 8
+	m := pathContents{}
+	var dirs []string
+	for i := 0; i < numDirs; i++ {
+		dirs = append(dirs, fmt.Sprintf("d%v", i))
+		for j := 0; j < 10; j++ {
+			m[fmt.Sprintf("d%v/sub%v/f%v", i, j, j)] = fmt.Sprintf("%v-%v", i, j)
+		}
+	}
+	simpleFileMaker(t, m)
+
+	// Stopping the walk stops all the workers, and isn't a walk error
+	for _, ignoreErrs := range []bool{false, true} {
+		name := fmt.Sprintf("testname: 'Walk Workers Stopped' IgnoreWalkErrors: %v", ignoreErrs)
+		opts := SetupOptions()
+		opts.WalkWorkers = 4
+		opts.MaxFiles = 5
+		opts.IgnoreWalkErrors = ignoreErrs
+		r, err := Run(dirs, opts)
+		if err != nil {
+			t.Errorf("%v: Run() returned error: %v", name, err)
+		}
+		if !r.HitFileLimit {
+			t.Errorf("%v: Expected HitFileLimit", name)
+		}
+		if r.SkippedDirErrCount != 0 {
+			t.Errorf("%v: Expected SkippedDirErrCount 0, got: %v", name, r.SkippedDirErrCount)
+		}
+		// Each worker walks at most a few more dirs before stopping
+		if r.DirCount >= numDirs*11/2 {
+			t.Errorf("%v: Expected the workers to stop early, walked %v dirs", name, r.DirCount)
+		}
+	}
+}
+
+func TestWalkWorkersStopOnError(t *testing.T) {
+	topdir := setUp("WalkWorkersError", t)
+	defer os.RemoveAll(topdir)
+
+	m := pathContents{}
+	for j := 0; j < 10; j++ {
+		m[fmt.Sprintf("d0/sub%v/f%v", j, j)] = "X"
+	}
+	simpleFileMaker(t, m)
+
+	// Hold the walk of d0 in its DirFilter until the walk of the missing
+	// dir has halted the walks, so that d0 can't be finished first.
+	halted := make(chan struct{})
+	walkHalted = func(err error) { close(halted) }
+	defer func() { walkHalted = func(err error) {} }()
+
+	s := status{}
+	s.Options = &Options{
+		WalkWorkers: 2,
+		DirFilter: func(pathname string, info os.FileInfo) bool {
+			<-halted
+			return true
+		},
+	}
+	s.Results = newResults(s.Options)
+	s.pool = P.NewPool()
+
+	// The walk of the missing dir fails while d0 is being walked, which
+	// should stop the walk of d0 as well.
+	dirs := []string{"d0", "missing"}
+	c := matchedPathnames(*s.Options, s.Results, s.pool, nil, dirs, []string{})
+	var walkErr error
+	for pe := range c {
+		if pe.err != nil {
+			walkErr = pe.err
+		}
+	}
+	if _, ok := walkErr.(*ErrRootVanished); !ok {
+		t.Errorf("Expected ErrRootVanished error, got: %v", walkErr)
+	}
+	if s.Results.DirCount > 2 {
+		t.Errorf("Expected the walk of d0 to stop early, walked %v dirs", s.Results.DirCount)
+	}
+}